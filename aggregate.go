@@ -14,6 +14,10 @@
 
 package eventhorizon
 
+import (
+	"iter"
+)
+
 // Aggregate is a CQRS aggregate base to embed in domain specific aggregates.
 //
 // A domain specific aggregate is any struct that implements the Aggregate
@@ -36,6 +40,19 @@ type Aggregate interface {
 	ApplyEvents(events []Event)
 }
 
+// ApplyEventStream applies the events of an iterator to an aggregate one at a
+// time by calling ApplyEvent. It stops at the first error and returns it, after
+// the events before the error have been applied.
+func ApplyEventStream(aggregate Aggregate, events iter.Seq2[Event, error]) error {
+	for event, err := range events {
+		if err != nil {
+			return err
+		}
+		aggregate.ApplyEvent(event)
+	}
+	return nil
+}
+
 // DelegateAggregate is an implementation of Aggregate using delegation.
 //
 // This implementation is used by the DelegateDispatcher and will delegate all
@@ -102,6 +102,21 @@ func (s *DelegateAggregateSuite) Test_ApplyEvents_NoEvent(c *C) {
 	c.Assert(delegate.events, DeepEquals, []Event{})
 }
 
+func (s *DelegateAggregateSuite) Test_ApplyEventStream(c *C) {
+	delegate := &TestDelegateAggregate{
+		events: make([]Event, 0),
+	}
+	agg := NewDelegateAggregate(NewUUID(), delegate)
+	event1 := TestEvent{NewUUID(), "event1"}
+	event2 := TestEvent{NewUUID(), "event2"}
+	err := ApplyEventStream(agg, func(yield func(Event, error) bool) {
+		_ = yield(event1, nil) && yield(event2, nil)
+	})
+	c.Assert(err, IsNil)
+	c.Assert(agg.eventsLoaded, Equals, 2)
+	c.Assert(delegate.events, DeepEquals, []Event{event1, event2})
+}
+
 type ReflectAggregateSuite struct{}
 
 func (s *ReflectAggregateSuite) Test_NewReflectAggregate(c *C) {
@@ -187,3 +202,26 @@ func (s *ReflectAggregateSuite) Test_ApplyEvents_NoEvent(c *C) {
 	c.Assert(agg.eventsLoaded, Equals, 0)
 	c.Assert(mockHandler.events, DeepEquals, []Event{})
 }
+
+func (s *ReflectAggregateSuite) Test_ApplyEventStream(c *C) {
+	agg := NewReflectAggregate(NewUUID(), nil)
+	mockHandler := &MockEventHandler{
+		events: make([]Event, 0),
+	}
+	agg.handler = mockHandler
+	event1 := TestEvent{NewUUID(), "event1"}
+	event2 := TestEvent{NewUUID(), "event2"}
+	err := ApplyEventStream(agg, func(yield func(Event, error) bool) {
+		_ = yield(event1, nil) && yield(event2, nil)
+	})
+	c.Assert(err, IsNil)
+	c.Assert(agg.eventsLoaded, Equals, 2)
+	c.Assert(mockHandler.events, DeepEquals, []Event{event1, event2})
+
+	// The events before an error are applied.
+	err = ApplyEventStream(agg, func(yield func(Event, error) bool) {
+		_ = yield(event1, nil) && yield(nil, ErrNoEventStoreDefined) && yield(event2, nil)
+	})
+	c.Assert(err, Equals, ErrNoEventStoreDefined)
+	c.Assert(agg.eventsLoaded, Equals, 3)
+}
@@ -0,0 +1,166 @@
+// Copyright (c) 2014 - Max Persson <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventhorizon
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+)
+
+// Error returned when a command is not authorized. UnauthorizedErrors match it
+// with errors.Is.
+var ErrUnauthorized = errors.New("unauthorized")
+
+// UnauthorizedError is returned by Dispatch when a policy denies a command.
+type UnauthorizedError struct {
+	Reason string
+}
+
+func (e UnauthorizedError) Error() string {
+	return "unauthorized: " + e.Reason
+}
+
+// Is makes UnauthorizedError match ErrUnauthorized.
+func (e UnauthorizedError) Is(target error) bool {
+	return target == ErrUnauthorized
+}
+
+// Deny returns an UnauthorizedError for policies to deny a command with.
+func Deny(reason string) error {
+	return UnauthorizedError{reason}
+}
+
+// Principal is the identity that dispatches a command. The tenant of a
+// principal is the only tenant it can dispatch commands for with a
+// TenantDispatcher.
+type Principal struct {
+	ID     string
+	Roles  []string
+	Tenant string
+}
+
+// HasRole returns true if the principal has the role.
+func (p Principal) HasRole(role string) bool {
+	for _, r := range p.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+type principalKey struct{}
+
+// WithPrincipal returns a context carrying the principal, to dispatch commands
+// with DispatchContext.
+func WithPrincipal(ctx context.Context, principal Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// PrincipalFrom returns the principal carried by a context.
+func PrincipalFrom(ctx context.Context) (Principal, bool) {
+	principal, ok := ctx.Value(principalKey{}).(Principal)
+	return principal, ok
+}
+
+// Policy authorizes a command, with the principal in the context and the
+// aggregate loaded with its current state. Policies deny commands by returning
+// an UnauthorizedError, for example created with Deny.
+type Policy func(ctx context.Context, command Command, aggregate Aggregate) error
+
+// Authorizer authorizes commands in dispatchers with the policies registered
+// for their command types.
+//
+// Commands can also require roles with a roles tag on any field, often a blank
+// one. The principal must have at least one of the roles:
+//
+//	type DeleteInvite struct {
+//	    _ struct{} `roles:"admin,organizer"`
+//
+//	    InvitationID eventhorizon.UUID
+//	}
+type Authorizer struct {
+	policies map[reflect.Type][]Policy
+}
+
+// NewAuthorizer creates an Authorizer.
+func NewAuthorizer() *Authorizer {
+	a := &Authorizer{
+		policies: make(map[reflect.Type][]Policy),
+	}
+	return a
+}
+
+// AddPolicy adds a policy for a command type. All policies of a command type
+// must allow a command.
+func (a *Authorizer) AddPolicy(command Command, policy Policy) {
+	commandType := reflect.TypeOf(command)
+	a.policies[commandType] = append(a.policies[commandType], policy)
+}
+
+// Authorize checks the roles in the tags of a command and then its policies.
+func (a *Authorizer) Authorize(ctx context.Context, command Command, aggregate Aggregate) error {
+	if roles := commandRoles(reflect.TypeOf(command)); roles != nil {
+		principal, ok := PrincipalFrom(ctx)
+		if !ok {
+			return Deny("no principal")
+		}
+		if !hasAnyRole(principal, roles) {
+			return Deny("principal " + principal.ID + " does not have any role of " + strings.Join(roles, ", "))
+		}
+	}
+
+	for _, policy := range a.policies[reflect.TypeOf(command)] {
+		if err := policy(ctx, command, aggregate); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// commandRoles returns the roles in the roles tags of a command type.
+func commandRoles(t reflect.Type) []string {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+
+	var roles []string
+	for i := 0; i < t.NumField(); i++ {
+		tag, ok := t.Field(i).Tag.Lookup("roles")
+		if !ok {
+			continue
+		}
+		for _, role := range strings.Split(tag, ",") {
+			if role = strings.TrimSpace(role); role != "" {
+				roles = append(roles, role)
+			}
+		}
+	}
+	return roles
+}
+
+func hasAnyRole(principal Principal, roles []string) bool {
+	for _, role := range roles {
+		if principal.HasRole(role) {
+			return true
+		}
+	}
+	return false
+}
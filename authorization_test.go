@@ -0,0 +1,151 @@
+// Copyright (c) 2014 - Max Persson <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventhorizon
+
+import (
+	"context"
+	"errors"
+
+	. "gopkg.in/check.v1"
+)
+
+var _ = Suite(&AuthorizationSuite{})
+
+type AuthorizationSuite struct {
+	store      *MemoryEventStore
+	authorizer *Authorizer
+}
+
+type TestAdminCommand struct {
+	_ struct{} `roles:"admin, owner"`
+
+	TestID  UUID
+	Content string
+}
+
+func (t TestAdminCommand) AggregateID() UUID { return t.TestID }
+
+type TestOwnedAggregate struct {
+	Aggregate
+
+	owner string
+}
+
+func (t *TestOwnedAggregate) HandleCommand(command Command) ([]Event, error) {
+	switch command := command.(type) {
+	case TestCommand:
+		return []Event{TestEvent{command.TestID, command.Content}}, nil
+	case TestAdminCommand:
+		return []Event{TestEvent{command.TestID, command.Content}}, nil
+	}
+	return nil, errors.New("couldn't handle command")
+}
+
+func (t *TestOwnedAggregate) HandleEvent(event Event) {
+	if e, ok := event.(TestEvent); ok {
+		t.ApplyTestEvent(e)
+	}
+}
+
+func (t *TestOwnedAggregate) ApplyTestEvent(event TestEvent) {
+	if t.owner == "" {
+		t.owner = event.Content
+	}
+}
+
+func (t *TestOwnedAggregate) HandleTestCommand(command TestCommand) ([]Event, error) {
+	return t.HandleCommand(command)
+}
+
+// ownerPolicy allows the first command for an aggregate, which sets the owner,
+// and then only commands by the owner.
+func ownerPolicy(ctx context.Context, command Command, aggregate Aggregate) error {
+	owner := aggregate.(*TestOwnedAggregate).owner
+	principal, _ := PrincipalFrom(ctx)
+	if owner != "" && principal.ID != owner {
+		return Deny("not owner")
+	}
+	return nil
+}
+
+func (s *AuthorizationSuite) SetUpTest(c *C) {
+	s.store = NewMemoryEventStore()
+	s.authorizer = NewAuthorizer()
+	s.authorizer.AddPolicy(TestCommand{}, ownerPolicy)
+}
+
+func (s *AuthorizationSuite) Test_Principal(c *C) {
+	_, ok := PrincipalFrom(context.Background())
+	c.Assert(ok, Equals, false)
+	principal := Principal{ID: "user1", Roles: []string{"admin"}}
+	p, ok := PrincipalFrom(WithPrincipal(context.Background(), principal))
+	c.Assert(ok, Equals, true)
+	c.Assert(p, DeepEquals, principal)
+	c.Assert(p.HasRole("admin"), Equals, true)
+	c.Assert(p.HasRole("owner"), Equals, false)
+}
+
+func (s *AuthorizationSuite) Test_UnauthorizedError(c *C) {
+	err := Deny("reason")
+	c.Assert(errors.Is(err, ErrUnauthorized), Equals, true)
+	c.Assert(err, ErrorMatches, "unauthorized: reason")
+}
+
+func (s *AuthorizationSuite) Test_DelegateDispatcher_Policy(c *C) {
+	disp := NewDelegateDispatcher(s.store, &MockEventBus{})
+	disp.SetAuthorizer(s.authorizer)
+	disp.AddHandler(&TestOwnedAggregate{}, TestCommand{})
+	s.checkOwnerPolicy(c, disp)
+}
+
+func (s *AuthorizationSuite) Test_ReflectDispatcher_Policy(c *C) {
+	disp := NewReflectDispatcher(s.store, &MockEventBus{})
+	disp.SetAuthorizer(s.authorizer)
+	disp.AddHandler(&TestOwnedAggregate{}, TestCommand{})
+	s.checkOwnerPolicy(c, disp)
+}
+
+func (s *AuthorizationSuite) checkOwnerPolicy(c *C, disp ContextDispatcher) {
+	user1 := WithPrincipal(context.Background(), Principal{ID: "user1"})
+	user2 := WithPrincipal(context.Background(), Principal{ID: "user2"})
+	id := NewUUID()
+	err := disp.DispatchContext(user1, TestCommand{id, "user1"})
+	c.Assert(err, Equals, nil)
+	err = disp.DispatchContext(user1, TestCommand{id, "command2"})
+	c.Assert(err, Equals, nil)
+
+	err = disp.DispatchContext(user2, TestCommand{id, "command3"})
+	c.Assert(err, Equals, UnauthorizedError{"not owner"})
+	err = disp.Dispatch(TestCommand{id, "command4"})
+	c.Assert(errors.Is(err, ErrUnauthorized), Equals, true)
+	events, _ := s.store.Load(id)
+	c.Assert(events, HasLen, 2)
+}
+
+func (s *AuthorizationSuite) Test_Roles(c *C) {
+	disp := NewDelegateDispatcher(s.store, &MockEventBus{})
+	disp.AddHandler(&TestOwnedAggregate{}, TestAdminCommand{})
+	command := TestAdminCommand{TestID: NewUUID(), Content: "command1"}
+
+	err := disp.Dispatch(command)
+	c.Assert(err, Equals, UnauthorizedError{"no principal"})
+	ctx := WithPrincipal(context.Background(), Principal{ID: "user1", Roles: []string{"user"}})
+	err = disp.DispatchContext(ctx, command)
+	c.Assert(err, ErrorMatches, "unauthorized: principal user1 does not have any role of admin, owner")
+
+	ctx = WithPrincipal(context.Background(), Principal{ID: "user1", Roles: []string{"owner"}})
+	err = disp.DispatchContext(ctx, command)
+	c.Assert(err, Equals, nil)
+}
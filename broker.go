@@ -0,0 +1,321 @@
+// Copyright (c) 2014 - Max Persson <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventhorizon
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"sync"
+)
+
+// DefaultMaxPending is the number of unacknowledged events that a Broker
+// keeps for each subscription.
+const DefaultMaxPending = 10000
+
+// Broker relays events between RemoteEventBuses over TCP or Unix sockets.
+//
+// Every event published by a bus is delivered to all subscriptions, which are
+// named by the buses that subscribe. Events for a subscription are kept until
+// they have been acknowledged and are delivered again when a bus reconnects
+// with the subscription name. Each subscription keeps at most a maximum
+// number of pending events, dropping the oldest ones when it is full, see
+// SetMaxPending. The broker keeps its state in memory only.
+type Broker struct {
+	frames        FrameCodec
+	subscriptions map[string]*brokerSubscription
+	listeners     map[net.Listener]bool
+	conns         map[*brokerConn]bool
+	maxPending    int
+	mu            sync.Mutex
+	logger        Logger
+}
+
+// brokerSubscription is the queue of pending events of a subscription. The
+// events before sent have been written to the current connection.
+type brokerSubscription struct {
+	name    string
+	nextID  uint64
+	pending []busMessage
+	sent    int
+	conn    *brokerConn
+	wake    chan struct{}
+	mu      sync.Mutex
+}
+
+type brokerConn struct {
+	conn    net.Conn
+	frames  FrameCodec
+	writeMu sync.Mutex
+}
+
+// NewBroker creates a Broker.
+func NewBroker() *Broker {
+	b := &Broker{
+		frames:        JSONFrameCodec{},
+		subscriptions: make(map[string]*brokerSubscription),
+		listeners:     make(map[net.Listener]bool),
+		conns:         make(map[*brokerConn]bool),
+		maxPending:    DefaultMaxPending,
+		logger:        defaultLogger(),
+	}
+	return b
+}
+
+// SetFrameCodec sets the codec used to encode messages to the buses.
+func (b *Broker) SetFrameCodec(codec FrameCodec) {
+	b.frames = codec
+}
+
+// SetLogger sets the logger used to log connection errors.
+func (b *Broker) SetLogger(logger Logger) {
+	b.logger = logger
+}
+
+// SetMaxPending sets the number of unacknowledged events kept for each
+// subscription.
+func (b *Broker) SetMaxPending(max int) {
+	b.maxPending = max
+}
+
+// AddSubscription adds a subscription before its bus has connected, so that
+// no events published before that are lost.
+func (b *Broker) AddSubscription(name string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subscription(name)
+}
+
+// Serve accepts connections from buses on the listener until the broker is
+// closed.
+func (b *Broker) Serve(listener net.Listener) error {
+	b.mu.Lock()
+	b.listeners[listener] = true
+	b.mu.Unlock()
+
+	for {
+		conn, err := listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		c := &brokerConn{conn: conn, frames: b.frames}
+		b.mu.Lock()
+		b.conns[c] = true
+		b.mu.Unlock()
+		go b.handle(c)
+	}
+}
+
+// Close closes all listeners and connections of the broker.
+func (b *Broker) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for listener := range b.listeners {
+		listener.Close()
+	}
+	for c := range b.conns {
+		c.conn.Close()
+	}
+	return nil
+}
+
+func (b *Broker) handle(c *brokerConn) {
+	defer func() {
+		b.mu.Lock()
+		delete(b.conns, c)
+		b.mu.Unlock()
+		c.conn.Close()
+	}()
+
+	reader := bufio.NewReader(c.conn)
+	var hello busMessage
+	if err := readFrame(reader, b.frames, &hello); err != nil || hello.Kind != busHello {
+		return
+	}
+
+	var sub *brokerSubscription
+	if hello.Name != "" {
+		sub = b.attach(hello.Name, c)
+		done := make(chan struct{})
+		defer close(done)
+		defer b.detach(sub, c)
+		go b.deliver(sub, c, done)
+	}
+
+	for {
+		var m busMessage
+		if err := readFrame(reader, b.frames, &m); err != nil {
+			b.logger.Debug("bus disconnected from broker",
+				LogKeyError, err,
+			)
+			return
+		}
+
+		switch m.Kind {
+		case busPublish:
+			if m.Record != nil {
+				b.publish(m.Record)
+			}
+			if err := c.send(busMessage{Kind: busAck, ID: m.ID}); err != nil {
+				return
+			}
+		case busAck:
+			if sub != nil {
+				sub.ack(m.ID)
+			}
+		}
+	}
+}
+
+// attach attaches a connection to a subscription, replacing any previous
+// connection, so that all unacknowledged events are delivered again.
+func (b *Broker) attach(name string, c *brokerConn) *brokerSubscription {
+	b.mu.Lock()
+	sub := b.subscription(name)
+	b.mu.Unlock()
+
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	if sub.conn != nil {
+		sub.conn.conn.Close()
+	}
+	sub.conn = c
+	sub.sent = 0
+	sub.wake = make(chan struct{}, 1)
+	sub.wake <- struct{}{}
+	return sub
+}
+
+func (b *Broker) detach(sub *brokerSubscription, c *brokerConn) {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	if sub.conn == c {
+		sub.conn = nil
+	}
+}
+
+// deliver writes the pending events of a subscription to its connection
+// until the connection is replaced or closed.
+func (b *Broker) deliver(sub *brokerSubscription, c *brokerConn, done <-chan struct{}) {
+	sub.mu.Lock()
+	wake := sub.wake
+	sub.mu.Unlock()
+
+	for {
+		select {
+		case <-wake:
+		case <-done:
+			return
+		}
+
+		for {
+			sub.mu.Lock()
+			if sub.conn != c {
+				sub.mu.Unlock()
+				return
+			}
+			messages := append([]busMessage(nil), sub.pending[sub.sent:]...)
+			sub.sent = len(sub.pending)
+			sub.mu.Unlock()
+			if len(messages) == 0 {
+				break
+			}
+
+			for _, m := range messages {
+				if err := c.send(m); err != nil {
+					// The bus will get the events again when it reconnects.
+					c.conn.Close()
+					return
+				}
+			}
+		}
+	}
+}
+
+func (b *Broker) publish(record *EventRecord) {
+	b.mu.Lock()
+	subs := make([]*brokerSubscription, 0, len(b.subscriptions))
+	for _, sub := range b.subscriptions {
+		subs = append(subs, sub)
+	}
+	b.mu.Unlock()
+
+	for _, sub := range subs {
+		if sub.push(record, b.maxPending) {
+			b.logger.Warn("subscription full, dropping oldest event",
+				"subscription", sub.name,
+				LogKeyEventType, record.Type,
+			)
+		}
+	}
+}
+
+func (b *Broker) subscription(name string) *brokerSubscription {
+	sub, ok := b.subscriptions[name]
+	if !ok {
+		sub = &brokerSubscription{name: name}
+		b.subscriptions[name] = sub
+	}
+	return sub
+}
+
+// push adds an event to the queue and wakes its delivery, dropping the oldest
+// event if the queue is full. It returns true if an event was dropped.
+func (sub *brokerSubscription) push(record *EventRecord, limit int) bool {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+
+	sub.nextID++
+	sub.pending = append(sub.pending, busMessage{Kind: busDeliver, ID: sub.nextID, Record: record})
+	dropped := false
+	if limit > 0 && len(sub.pending) > limit {
+		sub.pending = append([]busMessage(nil), sub.pending[1:]...)
+		if sub.sent > 0 {
+			sub.sent--
+		}
+		dropped = true
+	}
+
+	if sub.wake != nil {
+		select {
+		case sub.wake <- struct{}{}:
+		default:
+		}
+	}
+	return dropped
+}
+
+func (sub *brokerSubscription) ack(id uint64) {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	for i, m := range sub.pending {
+		if m.ID == id {
+			sub.pending = append(sub.pending[:i], sub.pending[i+1:]...)
+			if i < sub.sent {
+				sub.sent--
+			}
+			return
+		}
+	}
+}
+
+func (c *brokerConn) send(m busMessage) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return writeFrame(c.conn, c.frames, m)
+}
@@ -0,0 +1,631 @@
+// Copyright (c) 2014 - Max Persson <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package cli implements command line tools for event stores.
+//
+// Eh implements the eh command, which inspects and operates on a store
+// directory of a FileRecordStore or an exported JSON Lines file without the
+// Go types of the events. Replay is a tool that needs the types of the
+// application and is built into its own binary.
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"iter"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+// Error returned when running an unknown command.
+var ErrUnknownCommand = errors.New("unknown command")
+
+// Error returned by the verify command when the store has problems.
+var ErrVerifyFailed = errors.New("verification failed")
+
+// Error returned when appending to an exported file.
+var ErrReadOnly = errors.New("exported file is read only")
+
+type command struct {
+	name  string
+	usage string
+	run   func(ctx context.Context, flags *flag.FlagSet, args []string, out io.Writer) error
+}
+
+var commands = []command{
+	{"aggregates", "list the aggregates with their number of events", runAggregates},
+	{"dump", "dump the events of an aggregate as JSON", runDump},
+	{"tail", "print the last events of the global stream", runTail},
+	{"stats", "show statistics per event type", runStats},
+	{"verify", "check that the events are well-formed", runVerify},
+	{"export", "export events as JSON Lines, optionally by aggregate or time", runExport},
+	{"import", "import events from JSON Lines into a store directory", runImport},
+	{"project", "replay events into a projector configured in a JSON file", runProject},
+}
+
+// Eh runs an eh command with its command line arguments, writing its output
+// to out. The context stops commands that follow the store.
+func Eh(ctx context.Context, args []string, out io.Writer) error {
+	if len(args) == 0 {
+		printUsage(out)
+		return fmt.Errorf("%w: no command given", ErrUnknownCommand)
+	}
+	for _, cmd := range commands {
+		if cmd.name == args[0] {
+			flags := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+			flags.SetOutput(out)
+			return cmd.run(ctx, flags, args[1:], out)
+		}
+	}
+	printUsage(out)
+	return fmt.Errorf("%w: %s", ErrUnknownCommand, args[0])
+}
+
+func printUsage(out io.Writer) {
+	fmt.Fprintf(out, "Usage: eh <command> [flags]\n\nCommands:\n")
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %s\t%s\n", cmd.name, cmd.usage)
+	}
+	w.Flush()
+}
+
+// storeFlags are the flags that select the store of a command.
+type storeFlags struct {
+	dir  *string
+	file *string
+}
+
+func addStoreFlags(flags *flag.FlagSet) storeFlags {
+	return storeFlags{
+		dir:  flags.String("store", "", "store directory"),
+		file: flags.String("file", "", "exported JSON Lines file"),
+	}
+}
+
+// open opens the selected store as a store of RawEvents. Exported files are
+// read one record at a time, like store directories.
+func (f storeFlags) open() (*eh.RawEventStore, error) {
+	path, err := f.path()
+	if err != nil {
+		return nil, err
+	}
+	if *f.dir != "" {
+		records, err := eh.NewFileRecordStore(*f.dir)
+		if err != nil {
+			return nil, err
+		}
+		return eh.NewRawEventStore(records), nil
+	}
+	return eh.NewRawEventStore(fileRecords{path}), nil
+}
+
+// path returns the JSON Lines file of the selected store.
+func (f storeFlags) path() (string, error) {
+	switch {
+	case *f.dir != "" && *f.file != "":
+		return "", errors.New("-store and -file can not both be used")
+	case *f.dir != "":
+		if _, err := os.Stat(*f.dir); err != nil {
+			return "", err
+		}
+		return filepath.Join(*f.dir, eh.FileRecordStoreName), nil
+	case *f.file != "":
+		if _, err := os.Stat(*f.file); err != nil {
+			return "", err
+		}
+		return *f.file, nil
+	}
+	return "", errors.New("-store or -file is required")
+}
+
+// importFile imports a JSON Lines file.
+func importFile(importer *eh.Importer, path string) (eh.ImportProgress, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return eh.ImportProgress{}, err
+	}
+	defer f.Close()
+	progress, err := importer.Import(f)
+	if err != nil {
+		return progress, fmt.Errorf("%s: %w", path, err)
+	}
+	return progress, nil
+}
+
+// fileRecords is a read only RecordStore of an exported JSON Lines file.
+type fileRecords struct {
+	path string
+}
+
+func (f fileRecords) AppendRecords(records []eh.EventRecord) error {
+	return ErrReadOnly
+}
+
+func (f fileRecords) LoadRecords(id eh.UUID) ([]eh.EventRecord, error) {
+	var records []eh.EventRecord
+	for record, err := range f.StreamRecords(id) {
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	if len(records) == 0 {
+		return nil, eh.ErrNoEventsFound
+	}
+	return records, nil
+}
+
+func (f fileRecords) StreamRecords(id eh.UUID) iter.Seq2[eh.EventRecord, error] {
+	return func(yield func(eh.EventRecord, error) bool) {
+		for record, err := range f.StreamAllRecords() {
+			if err != nil {
+				yield(eh.EventRecord{}, err)
+				return
+			}
+			if record.AggregateID == id && !yield(record, nil) {
+				return
+			}
+		}
+	}
+}
+
+func (f fileRecords) LoadAllRecords() ([]eh.EventRecord, error) {
+	records := make([]eh.EventRecord, 0)
+	for record, err := range f.StreamAllRecords() {
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+func (f fileRecords) StreamAllRecords() iter.Seq2[eh.EventRecord, error] {
+	var offset int64
+	return readRecords(f.path, &offset)
+}
+
+// readRecords returns an iterator that reads the complete lines of a JSON
+// Lines file from an offset, which is advanced past every read line. A
+// missing file has no records.
+func readRecords(path string, offset *int64) iter.Seq2[eh.EventRecord, error] {
+	return func(yield func(eh.EventRecord, error) bool) {
+		f, err := os.Open(path)
+		if errors.Is(err, os.ErrNotExist) {
+			return
+		} else if err != nil {
+			yield(eh.EventRecord{}, err)
+			return
+		}
+		defer f.Close()
+		if _, err := f.Seek(*offset, io.SeekStart); err != nil {
+			yield(eh.EventRecord{}, err)
+			return
+		}
+
+		reader := bufio.NewReader(f)
+		for {
+			line, err := reader.ReadBytes('\n')
+			if err == io.EOF {
+				// A line without a newline is still being written.
+				return
+			} else if err != nil {
+				yield(eh.EventRecord{}, err)
+				return
+			}
+			*offset += int64(len(line))
+			if len(bytes.TrimSpace(line)) == 0 {
+				continue
+			}
+			var record eh.EventRecord
+			if err := json.Unmarshal(line, &record); err != nil {
+				yield(eh.EventRecord{}, fmt.Errorf("%s: %w", path, err))
+				return
+			}
+			if !yield(record, nil) {
+				return
+			}
+		}
+	}
+}
+
+// recordsOf returns the records of RawEvents.
+func recordsOf(events []eh.Event) []eh.EventRecord {
+	records := make([]eh.EventRecord, len(events))
+	for i, event := range events {
+		records[i] = event.(eh.RawEvent).Record
+	}
+	return records
+}
+
+// streamRecords returns an iterator over the records of all RawEvents of a
+// store.
+func streamRecords(store *eh.RawEventStore) iter.Seq2[eh.EventRecord, error] {
+	return func(yield func(eh.EventRecord, error) bool) {
+		for event, err := range store.StreamAll() {
+			if err != nil {
+				yield(eh.EventRecord{}, err)
+				return
+			}
+			if !yield(event.(eh.RawEvent).Record, nil) {
+				return
+			}
+		}
+	}
+}
+
+// writeRecords writes records as JSON Lines.
+func writeRecords(out io.Writer, records []eh.EventRecord) error {
+	encoder := json.NewEncoder(out)
+	for _, record := range records {
+		if err := encoder.Encode(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func runAggregates(ctx context.Context, flags *flag.FlagSet, args []string, out io.Writer) error {
+	store := addStoreFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	s, err := store.open()
+	if err != nil {
+		return err
+	}
+
+	// Aggregates are listed in the order of their first event.
+	var ids []eh.UUID
+	counts := make(map[eh.UUID]int)
+	for record, err := range streamRecords(s) {
+		if err != nil {
+			return err
+		}
+		if counts[record.AggregateID] == 0 {
+			ids = append(ids, record.AggregateID)
+		}
+		counts[record.AggregateID]++
+	}
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "AGGREGATE\tEVENTS\n")
+	for _, id := range ids {
+		fmt.Fprintf(w, "%s\t%d\n", id, counts[id])
+	}
+	return w.Flush()
+}
+
+func runDump(ctx context.Context, flags *flag.FlagSet, args []string, out io.Writer) error {
+	store := addStoreFlags(flags)
+	idFlag := flags.String("id", "", "aggregate id")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	id, err := eh.ParseUUID(*idFlag)
+	if err != nil {
+		return fmt.Errorf("invalid -id: %v", err)
+	}
+	s, err := store.open()
+	if err != nil {
+		return err
+	}
+	events, err := s.Load(id)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(recordsOf(events), "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(out, "%s\n", data)
+	return err
+}
+
+func runTail(ctx context.Context, flags *flag.FlagSet, args []string, out io.Writer) error {
+	store := addStoreFlags(flags)
+	n := flags.Int("n", 10, "number of events")
+	follow := flags.Bool("f", false, "keep printing new events")
+	interval := flags.Duration("interval", time.Second, "poll interval when following")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	path, err := store.path()
+	if err != nil {
+		return err
+	}
+
+	// Keep the last events while reading the file, and follow it from where
+	// the reading ended.
+	var offset int64
+	var last []eh.EventRecord
+	for record, err := range readRecords(path, &offset) {
+		if err != nil {
+			return err
+		}
+		if last = append(last, record); len(last) > *n {
+			last = last[1:]
+		}
+	}
+	if err := writeRecords(out, last); err != nil {
+		return err
+	}
+	for *follow {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(*interval):
+		}
+		for record, err := range readRecords(path, &offset) {
+			if err != nil {
+				return err
+			}
+			if err := writeRecords(out, []eh.EventRecord{record}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+type typeStats struct {
+	events     int
+	aggregates map[eh.UUID]bool
+	versions   map[int]bool
+}
+
+func runStats(ctx context.Context, flags *flag.FlagSet, args []string, out io.Writer) error {
+	store := addStoreFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	s, err := store.open()
+	if err != nil {
+		return err
+	}
+
+	stats := make(map[string]*typeStats)
+	aggregates := make(map[eh.UUID]bool)
+	events := 0
+	for record, err := range streamRecords(s) {
+		if err != nil {
+			return err
+		}
+		events++
+		st, ok := stats[record.Type]
+		if !ok {
+			st = &typeStats{aggregates: make(map[eh.UUID]bool), versions: make(map[int]bool)}
+			stats[record.Type] = st
+		}
+		st.events++
+		st.aggregates[record.AggregateID] = true
+		st.versions[record.Version] = true
+		aggregates[record.AggregateID] = true
+	}
+	types := make([]string, 0, len(stats))
+	for name := range stats {
+		types = append(types, name)
+	}
+	sort.Strings(types)
+
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "TYPE\tEVENTS\tAGGREGATES\tVERSIONS\n")
+	for _, name := range types {
+		st := stats[name]
+		fmt.Fprintf(w, "%s\t%d\t%d\t%s\n", name, st.events, len(st.aggregates), formatVersions(st.versions))
+	}
+	fmt.Fprintf(w, "total\t%d\t%d\t\n", events, len(aggregates))
+	return w.Flush()
+}
+
+func formatVersions(versions map[int]bool) string {
+	sorted := make([]int, 0, len(versions))
+	for version := range versions {
+		sorted = append(sorted, version)
+	}
+	sort.Ints(sorted)
+	s := make([]string, len(sorted))
+	for i, version := range sorted {
+		s[i] = fmt.Sprint(version)
+	}
+	return strings.Join(s, ",")
+}
+
+// runVerify checks that every event in the global stream is well-formed, in
+// one pass. Hash chains are verified with HashChainRecordStore.Verify, which
+// hashes the same records with HashRecord.
+func runVerify(ctx context.Context, flags *flag.FlagSet, args []string, out io.Writer) error {
+	store := addStoreFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	s, err := store.open()
+	if err != nil {
+		return err
+	}
+
+	problems := 0
+	report := func(format string, args ...interface{}) {
+		fmt.Fprintf(out, format+"\n", args...)
+		problems++
+	}
+
+	aggregates := make(map[eh.UUID]bool)
+	position := 0
+	for record, err := range streamRecords(s) {
+		if err != nil {
+			return err
+		}
+		switch {
+		case len(record.AggregateID) != 16:
+			report("event %d: invalid aggregate id", position)
+		case record.Type == "":
+			report("event %d: no type", position)
+		case record.Version < 1:
+			report("event %d: invalid version %d", position, record.Version)
+		case !json.Valid(record.Data):
+			report("event %d: invalid data", position)
+		}
+		aggregates[record.AggregateID] = true
+		position++
+	}
+
+	if problems > 0 {
+		return fmt.Errorf("%w: %d problems", ErrVerifyFailed, problems)
+	}
+	fmt.Fprintf(out, "%d events in %d aggregates verified\n", position, len(aggregates))
+	return nil
+}
+
+func runExport(ctx context.Context, flags *flag.FlagSet, args []string, out io.Writer) error {
+	store := addStoreFlags(flags)
+	output := flags.String("o", "", "output file; default standard output")
+	ids := flags.String("id", "", "comma-separated aggregate ids; default all aggregates")
+	from := flags.String("from", "", "export events at or after the time, in RFC 3339 format")
+	to := flags.String("to", "", "export events before the time, in RFC 3339 format")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	var filter eh.ExportFilter
+	if *ids != "" {
+		for _, s := range strings.Split(*ids, ",") {
+			id, err := eh.ParseUUID(s)
+			if err != nil {
+				return fmt.Errorf("invalid -id %s: %v", s, err)
+			}
+			filter.AggregateIDs = append(filter.AggregateIDs, id)
+		}
+	}
+	var err error
+	if filter.From, err = parseTime(*from); err != nil {
+		return fmt.Errorf("invalid -from: %v", err)
+	}
+	if filter.To, err = parseTime(*to); err != nil {
+		return fmt.Errorf("invalid -to: %v", err)
+	}
+	s, err := store.open()
+	if err != nil {
+		return err
+	}
+
+	exporter := eh.NewExporter(s, nil)
+	if *output == "" {
+		_, err := exporter.Export(out, filter)
+		return err
+	}
+	f, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+	n, err := exporter.Export(f, filter)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "%d events exported\n", n)
+	return nil
+}
+
+// parseTime parses an optional time in RFC 3339 format.
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339Nano, s)
+}
+
+func runImport(ctx context.Context, flags *flag.FlagSet, args []string, out io.Writer) error {
+	dir := flags.String("store", "", "store directory, created if it does not exist")
+	deduplicate := flags.Bool("dedupe", false, "skip events that are already in the store")
+	verbose := flags.Bool("v", false, "print the progress")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *dir == "" || flags.NArg() != 1 {
+		return errors.New("usage: eh import -store <dir> [-dedupe] [-v] <file>")
+	}
+	recordStore, err := eh.NewFileRecordStore(*dir)
+	if err != nil {
+		return err
+	}
+
+	importer := eh.NewImporter(recordStore, nil)
+	importer.SetDeduplicate(*deduplicate)
+	if *verbose {
+		importer.SetProgress(func(p eh.ImportProgress) {
+			fmt.Fprintf(out, "%d events read\n", p.Read)
+		})
+	}
+	progress, err := importFile(importer, flags.Arg(0))
+	fmt.Fprintf(out, "%d events imported, %d duplicates skipped\n", progress.Imported, progress.Skipped)
+	return err
+}
+
+func runProject(ctx context.Context, flags *flag.FlagSet, args []string, out io.Writer) error {
+	store := addStoreFlags(flags)
+	configFile := flags.String("config", "", "projector config file")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *configFile == "" {
+		return errors.New("-config is required")
+	}
+	config, err := ReadProjectorConfig(*configFile)
+	if err != nil {
+		return err
+	}
+	s, err := store.open()
+	if err != nil {
+		return err
+	}
+
+	repository := eh.NewMemoryRepository()
+	projector := NewConfigProjector(config, repository)
+	for event, err := range s.StreamAll() {
+		if err != nil {
+			return err
+		}
+		projector.HandleEvent(event)
+	}
+
+	models, err := repository.FindAll()
+	if err != nil {
+		return err
+	}
+	sort.Slice(models, func(i, j int) bool {
+		return models[i].(map[string]interface{})["id"].(string) < models[j].(map[string]interface{})["id"].(string)
+	})
+	data, err := json.MarshalIndent(models, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(out, "%s\n", data)
+	return err
+}
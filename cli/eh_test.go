@@ -0,0 +1,279 @@
+// Copyright (c) 2014 - Max Persson <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+var _ = Suite(&EhSuite{})
+
+type EhSuite struct {
+	dir     string
+	id1     eh.UUID
+	id2     eh.UUID
+	records []eh.EventRecord
+}
+
+func (s *EhSuite) SetUpTest(c *C) {
+	s.dir = filepath.Join(c.MkDir(), "store")
+	store, err := eh.NewFileRecordStore(s.dir)
+	c.Assert(err, IsNil)
+	s.id1, _ = eh.ParseUUID("6ba7b814-9dad-11d1-80b4-00c04fd430c8")
+	s.id2, _ = eh.ParseUUID("6ba7b814-9dad-11d1-80b4-00c04fd430c9")
+	s.records = []eh.EventRecord{
+		{AggregateID: s.id1, Type: "InviteCreated", Version: 1, Data: []byte(`{"Name":"Athena","Address":{"City":"Lund"}}`)},
+		{AggregateID: s.id2, Type: "InviteCreated", Version: 2, Data: []byte(`{"Name":"Zeus","Address":{"City":"Malmö"}}`)},
+		{AggregateID: s.id1, Type: "InviteAccepted", Version: 1, Data: []byte(`{}`)},
+		{AggregateID: s.id2, Type: "InviteDeleted", Version: 1, Data: []byte(`{}`)},
+	}
+	c.Assert(store.AppendRecords(s.records), IsNil)
+}
+
+func (s *EhSuite) run(c *C, args ...string) string {
+	var out bytes.Buffer
+	c.Assert(Eh(context.Background(), args, &out), IsNil)
+	return out.String()
+}
+
+func (s *EhSuite) Test_Run_UnknownCommand(c *C) {
+	var out bytes.Buffer
+	err := Eh(context.Background(), []string{"unknown"}, &out)
+	c.Assert(errors.Is(err, ErrUnknownCommand), Equals, true)
+	c.Assert(out.String(), Matches, "(?s)Usage: eh <command> .*  aggregates  .*")
+	err = Eh(context.Background(), nil, &out)
+	c.Assert(errors.Is(err, ErrUnknownCommand), Equals, true)
+	err = Eh(context.Background(), []string{"stats"}, &out)
+	c.Assert(err, ErrorMatches, "-store or -file is required")
+}
+
+func (s *EhSuite) Test_Aggregates(c *C) {
+	c.Assert(s.run(c, "aggregates", "-store", s.dir), Equals, ""+
+		"AGGREGATE                             EVENTS\n"+
+		"6ba7b814-9dad-11d1-80b4-00c04fd430c8  2\n"+
+		"6ba7b814-9dad-11d1-80b4-00c04fd430c9  2\n")
+}
+
+func (s *EhSuite) Test_Dump(c *C) {
+	c.Assert(s.run(c, "dump", "-store", s.dir, "-id", s.id1.String()), Equals, `[
+  {
+    "aggregate_id": "6ba7b814-9dad-11d1-80b4-00c04fd430c8",
+    "type": "InviteCreated",
+    "version": 1,
+    "data": {
+      "Name": "Athena",
+      "Address": {
+        "City": "Lund"
+      }
+    }
+  },
+  {
+    "aggregate_id": "6ba7b814-9dad-11d1-80b4-00c04fd430c8",
+    "type": "InviteAccepted",
+    "version": 1,
+    "data": {}
+  }
+]
+`)
+}
+
+func (s *EhSuite) Test_Tail(c *C) {
+	out := s.run(c, "tail", "-store", s.dir, "-n", "2")
+	c.Assert(out, Equals, ""+
+		`{"aggregate_id":"6ba7b814-9dad-11d1-80b4-00c04fd430c8","type":"InviteAccepted","version":1,"data":{}}`+"\n"+
+		`{"aggregate_id":"6ba7b814-9dad-11d1-80b4-00c04fd430c9","type":"InviteDeleted","version":1,"data":{}}`+"\n")
+
+	// Following stops with the context.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var buf bytes.Buffer
+	err := Eh(ctx, []string{"tail", "-store", s.dir, "-n", "1", "-f", "-interval", "1ms"}, &buf)
+	c.Assert(err, IsNil)
+	c.Assert(strings.Count(buf.String(), "\n"), Equals, 1)
+}
+
+// syncBuffer is a buffer that commands can write to while it is read.
+type syncBuffer struct {
+	buf bytes.Buffer
+	mu  sync.Mutex
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func (s *EhSuite) Test_Tail_Follow(c *C) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var out syncBuffer
+	done := make(chan error)
+	go func() {
+		done <- Eh(ctx, []string{"tail", "-store", s.dir, "-n", "1", "-f", "-interval", "1ms"}, &out)
+	}()
+
+	// Only the appended events are printed while following.
+	for i := 0; i < 100 && out.String() == ""; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	store, _ := eh.NewFileRecordStore(s.dir)
+	store.AppendRecords([]eh.EventRecord{{AggregateID: s.id1, Type: "InviteDeleted", Version: 1, Data: []byte(`{}`)}})
+	for i := 0; i < 100 && strings.Count(out.String(), "\n") < 2; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	c.Assert(<-done, IsNil)
+	c.Assert(out.String(), Equals, ""+
+		`{"aggregate_id":"6ba7b814-9dad-11d1-80b4-00c04fd430c9","type":"InviteDeleted","version":1,"data":{}}`+"\n"+
+		`{"aggregate_id":"6ba7b814-9dad-11d1-80b4-00c04fd430c8","type":"InviteDeleted","version":1,"data":{}}`+"\n")
+}
+
+func (s *EhSuite) Test_File(c *C) {
+	file := filepath.Join(c.MkDir(), "events.jsonl")
+	s.run(c, "export", "-store", s.dir, "-o", file)
+	c.Assert(s.run(c, "aggregates", "-file", file), Equals, s.run(c, "aggregates", "-store", s.dir))
+	c.Assert(s.run(c, "tail", "-file", file, "-n", "1"), Equals, s.run(c, "tail", "-store", s.dir, "-n", "1"))
+	c.Assert(s.run(c, "verify", "-file", file), Equals, "4 events in 2 aggregates verified\n")
+
+	var out bytes.Buffer
+	err := Eh(context.Background(), []string{"stats", "-file", filepath.Join(c.MkDir(), "missing.jsonl")}, &out)
+	c.Assert(errors.Is(err, os.ErrNotExist), Equals, true)
+}
+
+func (s *EhSuite) Test_Stats(c *C) {
+	c.Assert(s.run(c, "stats", "-store", s.dir), Equals, ""+
+		"TYPE            EVENTS  AGGREGATES  VERSIONS\n"+
+		"InviteAccepted  1       1           1\n"+
+		"InviteCreated   2       2           1,2\n"+
+		"InviteDeleted   1       1           1\n"+
+		"total           4       2           \n")
+}
+
+func (s *EhSuite) Test_Verify(c *C) {
+	c.Assert(s.run(c, "verify", "-store", s.dir), Equals, "4 events in 2 aggregates verified\n")
+
+	store, _ := eh.NewFileRecordStore(s.dir)
+	store.AppendRecords([]eh.EventRecord{{AggregateID: s.id1, Type: "", Version: 0, Data: []byte(`{}`)}})
+	var out bytes.Buffer
+	err := Eh(context.Background(), []string{"verify", "-store", s.dir}, &out)
+	c.Assert(errors.Is(err, ErrVerifyFailed), Equals, true)
+	c.Assert(err, ErrorMatches, "verification failed: 1 problems")
+	c.Assert(out.String(), Equals, "event 4: no type\n")
+}
+
+func (s *EhSuite) Test_ExportImport(c *C) {
+	file := filepath.Join(c.MkDir(), "events.jsonl")
+	c.Assert(s.run(c, "export", "-store", s.dir, "-o", file), Equals, "4 events exported\n")
+	exported, err := os.ReadFile(filepath.Join(s.dir, eh.FileRecordStoreName))
+	c.Assert(err, IsNil)
+	data, err := os.ReadFile(file)
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, string(exported))
+	c.Assert(s.run(c, "export", "-file", file), Equals, string(exported))
+
+	dir := filepath.Join(c.MkDir(), "imported")
+	c.Assert(s.run(c, "import", "-store", dir, file), Equals, "4 events imported, 0 duplicates skipped\n")
+	c.Assert(s.run(c, "import", "-store", dir, "-dedupe", file), Equals, "0 events imported, 4 duplicates skipped\n")
+	store, err := eh.NewFileRecordStore(dir)
+	c.Assert(err, IsNil)
+	records, err := store.LoadAllRecords()
+	c.Assert(err, IsNil)
+	c.Assert(records, DeepEquals, s.records)
+
+	invalid := filepath.Join(c.MkDir(), "invalid.jsonl")
+	c.Assert(os.WriteFile(invalid, []byte("{}\n"), 0644), IsNil)
+	var out bytes.Buffer
+	err = Eh(context.Background(), []string{"import", "-store", dir, invalid}, &out)
+	c.Assert(errors.Is(err, eh.ErrInvalidRecord), Equals, true)
+	c.Assert(err, ErrorMatches, ".*invalid.jsonl: line 1: .*")
+}
+
+func (s *EhSuite) Test_Export_Filter(c *C) {
+	c.Assert(s.run(c, "export", "-store", s.dir, "-id", s.id2.String()), Equals, ""+
+		`{"aggregate_id":"6ba7b814-9dad-11d1-80b4-00c04fd430c9","type":"InviteCreated","version":2,"data":{"Name":"Zeus","Address":{"City":"Malmö"}}}`+"\n"+
+		`{"aggregate_id":"6ba7b814-9dad-11d1-80b4-00c04fd430c9","type":"InviteDeleted","version":1,"data":{}}`+"\n")
+
+	var out bytes.Buffer
+	err := Eh(context.Background(), []string{"export", "-store", s.dir, "-from", "yesterday"}, &out)
+	c.Assert(err, ErrorMatches, "invalid -from: .*")
+	err = Eh(context.Background(), []string{"export", "-store", s.dir, "-id", "abc"}, &out)
+	c.Assert(err, ErrorMatches, "invalid -id abc: .*")
+}
+
+func (s *EhSuite) Test_Project(c *C) {
+	config := filepath.Join(c.MkDir(), "config.json")
+	err := os.WriteFile(config, []byte(`{
+		"events": {
+			"InviteCreated": {"set": {"name": "Name", "city": "Address.City"}, "values": {"status": "open"}},
+			"InviteAccepted": {"values": {"status": "accepted"}}
+		}
+	}`), 0600)
+	c.Assert(err, IsNil)
+	c.Assert(s.run(c, "project", "-store", s.dir, "-config", config), Equals, `[
+  {
+    "city": "Lund",
+    "id": "6ba7b814-9dad-11d1-80b4-00c04fd430c8",
+    "name": "Athena",
+    "status": "accepted"
+  },
+  {
+    "city": "Malmö",
+    "id": "6ba7b814-9dad-11d1-80b4-00c04fd430c9",
+    "name": "Zeus",
+    "status": "open"
+  }
+]
+`)
+
+	// The id of the models can not be changed.
+	err = os.WriteFile(config, []byte(`{"events": {"InviteCreated": {"values": {"id": 7}}}}`), 0600)
+	c.Assert(err, IsNil)
+	c.Assert(s.run(c, "project", "-store", s.dir, "-config", config), Equals, `[
+  {
+    "id": "6ba7b814-9dad-11d1-80b4-00c04fd430c8"
+  },
+  {
+    "id": "6ba7b814-9dad-11d1-80b4-00c04fd430c9"
+  }
+]
+`)
+
+	// Removed models are not projected.
+	err = os.WriteFile(config, []byte(`{"events": {"InviteCreated": {}, "InviteDeleted": {"remove": true}}}`), 0600)
+	c.Assert(err, IsNil)
+	c.Assert(s.run(c, "project", "-store", s.dir, "-config", config), Equals, `[
+  {
+    "id": "6ba7b814-9dad-11d1-80b4-00c04fd430c8"
+  }
+]
+`)
+}
@@ -0,0 +1,136 @@
+// Copyright (c) 2014 - Max Persson <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+// ProjectorConfig configures a ConfigProjector, which projects events into
+// read models without Go code. It is read from JSON like:
+//
+//	{
+//	  "events": {
+//	    "InviteCreated": {"set": {"name": "Name", "age": "Age"}, "values": {"status": "open"}},
+//	    "InviteAccepted": {"values": {"status": "accepted"}},
+//	    "InviteDeleted": {"remove": true}
+//	  }
+//	}
+type ProjectorConfig struct {
+	// Events are the rules for each event type name. Other events are
+	// ignored.
+	Events map[string]ProjectionRule `json:"events"`
+}
+
+// ProjectionRule is how an event type changes the read model of its
+// aggregate.
+type ProjectionRule struct {
+	// Set sets model fields to event data fields. Fields of nested objects
+	// in the data are named by their path, like "Address.City".
+	Set map[string]string `json:"set"`
+
+	// Values sets model fields to constant values.
+	Values map[string]interface{} `json:"values"`
+
+	// Remove removes the read model.
+	Remove bool `json:"remove"`
+}
+
+// ReadProjectorConfig reads a ProjectorConfig from a JSON file.
+func ReadProjectorConfig(path string) (ProjectorConfig, error) {
+	var config ProjectorConfig
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return config, err
+	}
+	err = json.Unmarshal(data, &config)
+	return config, err
+}
+
+// ConfigProjector is an EventHandler that projects RawEvents into read models
+// by the rules of a ProjectorConfig. The read models are maps with the
+// aggregate ID as "id", which rules can not change, saved in a Repository.
+type ConfigProjector struct {
+	config     ProjectorConfig
+	repository eh.Repository
+}
+
+// NewConfigProjector creates a ConfigProjector that saves its read models in
+// the repository.
+func NewConfigProjector(config ProjectorConfig, repository eh.Repository) *ConfigProjector {
+	p := &ConfigProjector{
+		config:     config,
+		repository: repository,
+	}
+	return p
+}
+
+// HandleEvent applies the rule of the event type to the read model of the
+// aggregate, creating it if it does not exist.
+func (p *ConfigProjector) HandleEvent(event eh.Event) {
+	raw, ok := event.(eh.RawEvent)
+	if !ok {
+		return
+	}
+	rule, ok := p.config.Events[raw.Record.Type]
+	if !ok {
+		return
+	}
+	id := raw.Record.AggregateID
+	if rule.Remove {
+		p.repository.Remove(id)
+		return
+	}
+
+	// Copy the model to not change a model kept by the repository.
+	model := make(map[string]interface{})
+	if found, err := p.repository.Find(id); err == nil {
+		for k, v := range found.(map[string]interface{}) {
+			model[k] = v
+		}
+	}
+
+	var data map[string]interface{}
+	json.Unmarshal(raw.Record.Data, &data)
+	for field, path := range rule.Set {
+		if value, ok := lookup(data, path); ok {
+			model[field] = value
+		}
+	}
+	for field, value := range rule.Values {
+		model[field] = value
+	}
+	model["id"] = id.String()
+	p.repository.Save(id, model)
+}
+
+// lookup returns the value at a dotted path in decoded JSON.
+func lookup(data map[string]interface{}, path string) (interface{}, bool) {
+	var value interface{} = data
+	for _, key := range strings.Split(path, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if value, ok = object[key]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
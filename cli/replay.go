@@ -0,0 +1,119 @@
+// Copyright (c) 2014 - Max Persson <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"time"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+// Replay runs the replay tool with the command line arguments, printing the
+// state of an aggregate at a version or time, or with -diff how its state
+// changed event by event up to it. It needs the Go types of the aggregates, so
+// it is built into the binary of the application:
+//
+//	func main() {
+//		replayer := eventhorizon.NewReplayer(store)
+//		replayer.RegisterAggregate(&InvitationAggregate{})
+//		if err := cli.Replay(replayer, os.Args[1:], os.Stdout); err != nil {
+//			fmt.Fprintln(os.Stderr, err)
+//			os.Exit(1)
+//		}
+//	}
+func Replay(replayer *eh.Replayer, args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("replay", flag.ContinueOnError)
+	flags.SetOutput(out)
+	name := flags.String("aggregate", "", "aggregate type name")
+	idFlag := flags.String("id", "", "aggregate id")
+	version := flags.Int("version", -1, "replay up to the version; default the latest")
+	timeFlag := flags.String("time", "", "replay up to the time, in RFC 3339 format, which needs timestamped events")
+	diff := flags.Bool("diff", false, "print the changes of every event")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *name == "" {
+		return fmt.Errorf("-aggregate is required, registered aggregates: %v", replayer.Aggregates())
+	}
+	id, err := eh.ParseUUID(*idFlag)
+	if err != nil {
+		return fmt.Errorf("invalid -id: %v", err)
+	}
+	var until time.Time
+	if *timeFlag != "" {
+		if *version >= 0 {
+			return errors.New("-version and -time can not both be used")
+		}
+		if until, err = time.Parse(time.RFC3339Nano, *timeFlag); err != nil {
+			return fmt.Errorf("invalid -time: %v", err)
+		}
+	}
+
+	if *diff {
+		for step, err := range replayer.History(*name, id) {
+			if err != nil {
+				return err
+			}
+			if *version >= 0 && step.Version > *version {
+				break
+			}
+			timestamp, ok := eh.TimestampOf(step.Event)
+			if !until.IsZero() {
+				if !ok {
+					return fmt.Errorf("%w: %T", eh.ErrNoTimestamp, step.Event)
+				}
+				if timestamp.After(until) {
+					break
+				}
+			}
+			printStep(out, step, timestamp)
+		}
+		return nil
+	}
+
+	var aggregate interface{}
+	switch {
+	case !until.IsZero():
+		aggregate, err = replayer.ReplayTime(*name, id, until)
+	case *version >= 0:
+		aggregate, err = replayer.ReplayVersion(*name, id, *version)
+	default:
+		aggregate, err = replayer.Replay(*name, id)
+	}
+	if err != nil {
+		return err
+	}
+	for _, field := range eh.StateFields(aggregate) {
+		fmt.Fprintf(out, "%s: %s\n", field.Name, field.Value)
+	}
+	return nil
+}
+
+// printStep prints the event of a step and the changes it made.
+func printStep(out io.Writer, step eh.ReplayStep, timestamp time.Time) {
+	fmt.Fprintf(out, "version %d: %T", step.Version, step.Event)
+	if !timestamp.IsZero() {
+		fmt.Fprintf(out, " at %s", timestamp.Format(time.RFC3339Nano))
+	}
+	fmt.Fprintln(out)
+	for _, change := range step.Changes {
+		fmt.Fprintf(out, "  %s: %s -> %s\n", change.Field, change.Old, change.New)
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright (c) 2014 - Max Persson <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+// Hook up gocheck into the "go test" runner.
+func Test(t *testing.T) { TestingT(t) }
+
+var _ = Suite(&ReplaySuite{})
+
+type ReplaySuite struct {
+	store    *eh.MemoryEventStore
+	replayer *eh.Replayer
+	id       eh.UUID
+}
+
+type Renamed struct {
+	eh.Metadata
+
+	ID   eh.UUID
+	Name string
+}
+
+func (e Renamed) AggregateID() eh.UUID { return e.ID }
+
+type Item struct {
+	eh.Aggregate
+
+	name string
+}
+
+func (i *Item) ApplyRenamed(event Renamed) {
+	i.name = event.Name
+}
+
+func (s *ReplaySuite) SetUpTest(c *C) {
+	store := eh.NewMemoryEventStore()
+	s.store = store
+	s.replayer = eh.NewReplayer(store)
+	c.Assert(s.replayer.RegisterAggregate(&Item{}), IsNil)
+	s.id = eh.NewUUID()
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	for i, name := range []string{"first", "second"} {
+		event := eh.WithMetadata(Renamed{ID: s.id, Name: name},
+			eh.MetadataTimestamp, start.Add(time.Duration(i)*time.Hour).Format(time.RFC3339Nano))
+		c.Assert(store.Append([]eh.Event{event}), IsNil)
+	}
+}
+
+func (s *ReplaySuite) replay(c *C, args ...string) string {
+	var out bytes.Buffer
+	args = append([]string{"-aggregate", "Item", "-id", s.id.String()}, args...)
+	c.Assert(Replay(s.replayer, args, &out), IsNil)
+	return out.String()
+}
+
+func (s *ReplaySuite) Test_Replay(c *C) {
+	c.Assert(s.replay(c), Equals, "name: second\n")
+	c.Assert(s.replay(c, "-version", "1"), Equals, "name: first\n")
+	c.Assert(s.replay(c, "-time", "2024-01-01T12:30:00Z"), Equals, "name: first\n")
+}
+
+func (s *ReplaySuite) Test_Replay_Diff(c *C) {
+	c.Assert(s.replay(c, "-diff"), Equals, ""+
+		"version 1: cli.Renamed at 2024-01-01T12:00:00Z\n"+
+		"  name:  -> first\n"+
+		"version 2: cli.Renamed at 2024-01-01T13:00:00Z\n"+
+		"  name: first -> second\n")
+	c.Assert(s.replay(c, "-diff", "-time", "2024-01-01T12:30:00Z"), Equals, ""+
+		"version 1: cli.Renamed at 2024-01-01T12:00:00Z\n"+
+		"  name:  -> first\n")
+	c.Assert(s.replay(c, "-diff", "-version", "1"), Equals, ""+
+		"version 1: cli.Renamed at 2024-01-01T12:00:00Z\n"+
+		"  name:  -> first\n")
+}
+
+func (s *ReplaySuite) Test_Replay_NoTimestamp(c *C) {
+	c.Assert(s.store.Append([]eh.Event{Renamed{ID: s.id, Name: "third"}}), IsNil)
+	c.Assert(s.replay(c, "-diff", "-version", "3"), Matches, "(?s).*version 3: cli.Renamed\n  name: second -> third\n")
+
+	// Events without a timestamp can not be replayed to a time, with or
+	// without -diff.
+	for _, args := range [][]string{{"-time", "2024-01-01T14:00:00Z"}, {"-diff", "-time", "2024-01-01T14:00:00Z"}} {
+		var out bytes.Buffer
+		err := Replay(s.replayer, append([]string{"-aggregate", "Item", "-id", s.id.String()}, args...), &out)
+		c.Assert(errors.Is(err, eh.ErrNoTimestamp), Equals, true, Commentf("%v", args))
+	}
+}
+
+func (s *ReplaySuite) Test_Replay_Errors(c *C) {
+	var out bytes.Buffer
+	err := Replay(s.replayer, []string{"-id", s.id.String()}, &out)
+	c.Assert(err, ErrorMatches, `-aggregate is required, registered aggregates: \[Item\]`)
+	err = Replay(s.replayer, []string{"-aggregate", "Item", "-id", "x"}, &out)
+	c.Assert(err, ErrorMatches, "invalid -id: .*")
+	err = Replay(s.replayer, []string{"-aggregate", "Item", "-id", s.id.String(), "-version", "3"}, &out)
+	c.Assert(err, ErrorMatches, "version not found: 3 of 2")
+	err = Replay(s.replayer, []string{"-aggregate", "Item", "-id", s.id.String(), "-version", "1", "-time", "2024-01-01T12:30:00Z"}, &out)
+	c.Assert(err, ErrorMatches, "-version and -time can not both be used")
+}
@@ -0,0 +1,50 @@
+// Copyright (c) 2014 - Max Persson <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Command eh inspects and operates on event stores: a store directory of a
+// FileRecordStore or an exported JSON Lines file.
+//
+//	eh aggregates -store ./data
+//	eh dump -store ./data -id 6ba7b814-9dad-11d1-80b4-00c04fd430c8
+//	eh tail -f -file events.jsonl
+//	eh project -store ./data -config invitations.json
+//
+// Run eh without arguments for a list of commands, and eh <command> -h for
+// the flags of a command.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+
+	"github.com/looplab/eventhorizon/cli"
+)
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	err := cli.Eh(ctx, os.Args[1:], os.Stdout)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "eh: %v\n", err)
+		os.Exit(1)
+	}
+}
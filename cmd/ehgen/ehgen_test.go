@@ -0,0 +1,185 @@
+// Copyright (c) 2014 - Max Persson <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+var _ = Suite(&GeneratorSuite{})
+
+type GeneratorSuite struct{}
+
+const exampleDir = "../../examples/generated"
+
+// writePackage writes the files of a package to a new directory.
+func writePackage(c *C, files map[string]string) string {
+	dir := c.MkDir()
+	for name, src := range files {
+		err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0644)
+		c.Assert(err, IsNil)
+	}
+	return dir
+}
+
+func (s *GeneratorSuite) Test_Generate_Example(c *C) {
+	expected, err := os.ReadFile(filepath.Join(exampleDir, "eh_handlers.go"))
+	c.Assert(err, IsNil)
+
+	src, err := generate(exampleDir, []string{"GuestListProjector", "InvitationAggregate", "InvitationProjector"})
+	c.Assert(err, IsNil)
+	c.Assert(string(src), Equals, string(expected))
+
+	src, err = generate(exampleDir, nil)
+	c.Assert(err, IsNil)
+	c.Assert(string(src), Equals, string(expected))
+}
+
+func (s *GeneratorSuite) Test_Generate_QualifiedTypes(c *C) {
+	dir := writePackage(c, map[string]string{
+		"domain.go": `package domain
+
+import (
+	eh "github.com/looplab/eventhorizon"
+	ev "example.com/events"
+)
+
+type Ticket struct {
+	eh.Aggregate
+}
+
+func (t *Ticket) HandleOpen(command *ev.Open) ([]eh.Event, error) { return nil, nil }
+func (t *Ticket) ApplyOpened(event ev.Opened)                      {}
+func (t *Ticket) HandleOpened(event ev.Opened)                     {}
+func (t *Ticket) HandleClose(command ev.Close) error               { return nil }
+`,
+	})
+
+	src, err := generate(dir, nil)
+	c.Assert(err, IsNil)
+	c.Assert(string(src), Equals, `// Code generated by ehgen; DO NOT EDIT.
+
+package domain
+
+import (
+	ev "example.com/events"
+	eh "github.com/looplab/eventhorizon"
+)
+
+// HandleCommand routes commands to the Handle methods of Ticket.
+func (t *Ticket) HandleCommand(command eh.Command) ([]eh.Event, error) {
+	switch command := command.(type) {
+	case *ev.Open:
+		return t.HandleOpen(command)
+	}
+	return nil, eh.ErrHandlerNotFound
+}
+
+// HandleEvent routes events to the Apply methods of Ticket.
+func (t *Ticket) HandleEvent(event eh.Event) {
+	switch event := event.(type) {
+	case ev.Opened:
+		t.ApplyOpened(event)
+	}
+}
+
+// AddTicketHandlers adds Ticket as the handler of its commands.
+func AddTicketHandlers(dispatcher *eh.DelegateDispatcher) error {
+	commands := []eh.Command{
+		*new(*ev.Open),
+	}
+	for _, command := range commands {
+		if err := dispatcher.AddHandler(&Ticket{}, command); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+`)
+}
+
+func (s *GeneratorSuite) Test_Generate_Errors(c *C) {
+	_, err := generate(exampleDir, []string{"LoggerSubscriber"})
+	c.Assert(err, ErrorMatches, "type LoggerSubscriber has no handling methods")
+	_, err = generate(exampleDir, []string{"Missing"})
+	c.Assert(err, ErrorMatches, "type Missing has no handling methods")
+	_, err = generate("../../examples/delegation", nil)
+	c.Assert(err, ErrorMatches, "no types with handling methods in ../../examples/delegation")
+	_, err = generate(c.MkDir(), nil)
+	c.Assert(err, ErrorMatches, "no Go files in .*")
+
+	dir := writePackage(c, map[string]string{
+		"projector.go": `package domain
+
+import "github.com/looplab/eventhorizon"
+
+type Created struct{}
+
+type Projector struct{}
+
+func (p *Projector) HandleCreated(event Created)           {}
+func (p *Projector) HandleEvent(event eventhorizon.Event) {}
+`,
+	})
+	_, err = generate(dir, nil)
+	c.Assert(err, ErrorMatches, "type Projector already has a HandleEvent method")
+
+	dir = writePackage(c, map[string]string{
+		"projector.go": `package domain
+
+type Created struct{}
+
+type Projector struct{}
+
+func (p *Projector) HandleCreated(event Created) {}
+
+func AddProjectorSubscribers() {}
+`,
+	})
+	_, err = generate(dir, nil)
+	c.Assert(err, ErrorMatches, "function AddProjectorSubscribers already exists")
+}
+
+func (s *GeneratorSuite) Test_Generate_SkipsGeneratedFiles(c *C) {
+	dir := writePackage(c, map[string]string{
+		"projector.go": `package domain
+
+type Created struct{}
+
+type projector struct{}
+
+func (p projector) HandleCreated(event Created) {}
+`,
+	})
+	src, err := generate(dir, nil)
+	c.Assert(err, IsNil)
+	c.Assert(string(src), Matches, `(?s).*func addProjectorSubscribers\(bus \*eventhorizon.HandlerEventBus, p \*projector\) {
+	bus.AddSubscriber\(p, Created{}\)
+}
+`)
+
+	// Generating again must ignore the previously generated methods.
+	err = os.WriteFile(filepath.Join(dir, "eh_handlers.go"), src, 0644)
+	c.Assert(err, IsNil)
+	again, err := generate(dir, nil)
+	c.Assert(err, IsNil)
+	c.Assert(string(again), Equals, string(src))
+}
@@ -0,0 +1,410 @@
+// Copyright (c) 2014 - Max Persson <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ehPath is the import path of Event Horizon.
+const ehPath = "github.com/looplab/eventhorizon"
+
+// handlerMethod is a method handling a single command or event type.
+type handlerMethod struct {
+	Method string
+	Type   string
+	Zero   string
+}
+
+// handlerType is a type with handling methods.
+type handlerType struct {
+	Name     string
+	Commands []handlerMethod
+	Applies  []handlerMethod
+	Events   []handlerMethod
+	methods  map[string]bool
+}
+
+func (t *handlerType) isAggregate() bool {
+	return len(t.Commands) > 0
+}
+
+// parsedPackage is the result of parsing the files of a package.
+type parsedPackage struct {
+	name    string
+	types   map[string]*handlerType
+	structs map[string]bool
+	funcs   map[string]bool
+	imports map[string]string // name to path
+	ehName  string
+}
+
+// generate parses the Go package in dir and returns the routing code for the
+// named types, or for all types with handling methods if none are named.
+func generate(dir string, typeNames []string) ([]byte, error) {
+	pkg, err := parsePackage(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var selected []*handlerType
+	if len(typeNames) == 0 {
+		for _, t := range pkg.types {
+			if len(t.Commands) > 0 || len(t.Events) > 0 {
+				selected = append(selected, t)
+			}
+		}
+		if len(selected) == 0 {
+			return nil, fmt.Errorf("no types with handling methods in %s", dir)
+		}
+	} else {
+		for _, name := range typeNames {
+			t, ok := pkg.types[name]
+			if !ok || (len(t.Commands) == 0 && len(t.Events) == 0) {
+				return nil, fmt.Errorf("type %s has no handling methods", name)
+			}
+			selected = append(selected, t)
+		}
+	}
+	sort.Slice(selected, func(i, j int) bool {
+		return selected[i].Name < selected[j].Name
+	})
+
+	for _, t := range selected {
+		names := []string{"HandleEvent"}
+		if t.isAggregate() {
+			names = append(names, "HandleCommand")
+		}
+		for _, name := range names {
+			if t.methods[name] {
+				return nil, fmt.Errorf("type %s already has a %s method", t.Name, name)
+			}
+		}
+		if pkg.funcs[registerFunc(t)] {
+			return nil, fmt.Errorf("function %s already exists", registerFunc(t))
+		}
+	}
+
+	return pkg.write(selected)
+}
+
+// parsePackage parses the non-test and non-generated files in dir.
+func parsePackage(dir string) (*parsedPackage, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	pkg := &parsedPackage{
+		types:   make(map[string]*handlerType),
+		structs: make(map[string]bool),
+		funcs:   make(map[string]bool),
+		imports: make(map[string]string),
+	}
+	fset := token.NewFileSet()
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".go") || strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		file, err := parser.ParseFile(fset, filepath.Join(dir, name), nil, parser.ParseComments)
+		if err != nil {
+			return nil, err
+		}
+		if ast.IsGenerated(file) {
+			continue
+		}
+		if pkg.name == "" {
+			pkg.name = file.Name.Name
+		} else if pkg.name != file.Name.Name {
+			return nil, fmt.Errorf("multiple packages in %s: %s and %s", dir, pkg.name, file.Name.Name)
+		}
+		if err := pkg.addFile(file); err != nil {
+			return nil, err
+		}
+	}
+	if pkg.name == "" {
+		return nil, fmt.Errorf("no Go files in %s", dir)
+	}
+	if pkg.ehName == "" {
+		pkg.ehName = path.Base(ehPath)
+	}
+
+	for _, t := range pkg.types {
+		for _, methods := range [][]handlerMethod{t.Commands, t.Applies, t.Events} {
+			for i, m := range methods {
+				if pkg.structs[m.Type] {
+					methods[i].Zero = m.Type + "{}"
+				} else {
+					methods[i].Zero = "*new(" + m.Type + ")"
+				}
+			}
+			sort.Slice(methods, func(i, j int) bool {
+				return methods[i].Method < methods[j].Method
+			})
+		}
+	}
+	return pkg, nil
+}
+
+// addFile adds the types, methods and imports of a file.
+func (p *parsedPackage) addFile(file *ast.File) error {
+	imports := make(map[string]string)
+	for _, spec := range file.Imports {
+		importPath := strings.Trim(spec.Path.Value, `"`)
+		name := path.Base(importPath)
+		if spec.Name != nil {
+			name = spec.Name.Name
+		}
+		imports[name] = importPath
+		if importPath == ehPath && p.ehName == "" {
+			p.ehName = name
+		}
+	}
+
+	for _, decl := range file.Decls {
+		switch decl := decl.(type) {
+		case *ast.GenDecl:
+			for _, spec := range decl.Specs {
+				if spec, ok := spec.(*ast.TypeSpec); ok {
+					if _, ok := spec.Type.(*ast.StructType); ok && spec.TypeParams == nil {
+						p.structs[spec.Name.Name] = true
+					}
+				}
+			}
+		case *ast.FuncDecl:
+			if decl.Recv == nil {
+				p.funcs[decl.Name.Name] = true
+				continue
+			}
+			if err := p.addMethod(decl, imports); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// addMethod adds a method, classifying it as a command, apply or event
+// handling method by its name and signature.
+func (p *parsedPackage) addMethod(decl *ast.FuncDecl, imports map[string]string) error {
+	recv := decl.Recv.List[0].Type
+	if star, ok := recv.(*ast.StarExpr); ok {
+		recv = star.X
+	}
+	ident, ok := recv.(*ast.Ident)
+	if !ok {
+		// Generic receivers are not supported.
+		return nil
+	}
+	t, ok := p.types[ident.Name]
+	if !ok {
+		t = &handlerType{Name: ident.Name, methods: make(map[string]bool)}
+		p.types[ident.Name] = t
+	}
+	name := decl.Name.Name
+	t.methods[name] = true
+
+	params := decl.Type.Params.List
+	if len(params) != 1 || len(params[0].Names) > 1 {
+		return nil
+	}
+	typeName, pkgName := baseTypeName(params[0].Type)
+	if typeName == "" || (imports[pkgName] == ehPath && (typeName == "Command" || typeName == "Event")) {
+		return nil
+	}
+
+	var list *[]handlerMethod
+	switch {
+	case name == "Handle"+typeName && p.returnsEvents(decl.Type.Results, imports):
+		list = &t.Commands
+	case name == "Handle"+typeName && decl.Type.Results == nil:
+		list = &t.Events
+	case name == "Apply"+typeName && decl.Type.Results == nil:
+		list = &t.Applies
+	default:
+		return nil
+	}
+
+	if pkgName != "" {
+		importPath, ok := imports[pkgName]
+		if !ok {
+			return fmt.Errorf("%s.%s: unknown package %s", t.Name, name, pkgName)
+		}
+		if other, ok := p.imports[pkgName]; ok && other != importPath {
+			return fmt.Errorf("%s.%s: package name %s is used for both %s and %s",
+				t.Name, name, pkgName, other, importPath)
+		}
+		p.imports[pkgName] = importPath
+	}
+	*list = append(*list, handlerMethod{
+		Method: name,
+		Type:   types.ExprString(params[0].Type),
+	})
+	return nil
+}
+
+// returnsEvents checks if results are ([]eventhorizon.Event, error).
+func (p *parsedPackage) returnsEvents(results *ast.FieldList, imports map[string]string) bool {
+	if results == nil || len(results.List) != 2 {
+		return false
+	}
+	events, ok := results.List[0].Type.(*ast.ArrayType)
+	if !ok || events.Len != nil {
+		return false
+	}
+	sel, ok := events.Elt.(*ast.SelectorExpr)
+	if !ok || sel.Sel.Name != "Event" {
+		return false
+	}
+	if x, ok := sel.X.(*ast.Ident); !ok || imports[x.Name] != ehPath {
+		return false
+	}
+	err, ok := results.List[1].Type.(*ast.Ident)
+	return ok && err.Name == "error"
+}
+
+// baseTypeName returns the name and package name of a possibly qualified
+// type or pointer to a type.
+func baseTypeName(expr ast.Expr) (string, string) {
+	switch expr := expr.(type) {
+	case *ast.Ident:
+		return expr.Name, ""
+	case *ast.StarExpr:
+		return baseTypeName(expr.X)
+	case *ast.SelectorExpr:
+		if x, ok := expr.X.(*ast.Ident); ok {
+			return expr.Sel.Name, x.Name
+		}
+	}
+	return "", ""
+}
+
+// registerFunc returns the name of the registration function of a type.
+func registerFunc(t *handlerType) string {
+	suffix := "Subscribers"
+	if t.isAggregate() {
+		suffix = "Handlers"
+	}
+	if ast.IsExported(t.Name) {
+		return "Add" + t.Name + suffix
+	}
+	r, size := utf8.DecodeRuneInString(t.Name)
+	return "add" + string(unicode.ToUpper(r)) + t.Name[size:] + suffix
+}
+
+// receiverName returns the receiver name used for a type.
+func receiverName(t *handlerType) string {
+	r, _ := utf8.DecodeRuneInString(t.Name)
+	return string(unicode.ToLower(r))
+}
+
+// write writes the routing code for types.
+func (p *parsedPackage) write(selected []*handlerType) ([]byte, error) {
+	eh := p.ehName
+	imports := map[string]string{eh: ehPath}
+	for name, importPath := range p.imports {
+		if name == eh && importPath != ehPath {
+			return nil, fmt.Errorf("package name %s is used for both %s and %s", name, ehPath, importPath)
+		}
+		imports[name] = importPath
+	}
+	names := make([]string, 0, len(imports))
+	for name := range imports {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return imports[names[i]] < imports[names[j]]
+	})
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "// Code generated by ehgen; DO NOT EDIT.\n\n")
+	fmt.Fprintf(&b, "package %s\n\nimport (\n", p.name)
+	for _, name := range names {
+		if name == path.Base(imports[name]) {
+			fmt.Fprintf(&b, "\t%q\n", imports[name])
+		} else {
+			fmt.Fprintf(&b, "\t%s %q\n", name, imports[name])
+		}
+	}
+	fmt.Fprintf(&b, ")\n")
+
+	for _, t := range selected {
+		r := receiverName(t)
+		if t.isAggregate() {
+			fmt.Fprintf(&b, "\n// HandleCommand routes commands to the Handle methods of %s.\n", t.Name)
+			fmt.Fprintf(&b, "func (%s *%s) HandleCommand(command %s.Command) ([]%s.Event, error) {\n", r, t.Name, eh, eh)
+			fmt.Fprintf(&b, "switch command := command.(type) {\n")
+			for _, m := range t.Commands {
+				fmt.Fprintf(&b, "case %s:\nreturn %s.%s(command)\n", m.Type, r, m.Method)
+			}
+			fmt.Fprintf(&b, "}\nreturn nil, %s.ErrHandlerNotFound\n}\n", eh)
+			writeHandleEvent(&b, eh, t, "Apply", t.Applies)
+
+			fmt.Fprintf(&b, "\n// %s adds %s as the handler of its commands.\n", registerFunc(t), t.Name)
+			fmt.Fprintf(&b, "func %s(dispatcher *%s.DelegateDispatcher) error {\n", registerFunc(t), eh)
+			fmt.Fprintf(&b, "commands := []%s.Command{\n", eh)
+			for _, m := range t.Commands {
+				fmt.Fprintf(&b, "%s,\n", m.Zero)
+			}
+			fmt.Fprintf(&b, "}\nfor _, command := range commands {\n")
+			fmt.Fprintf(&b, "if err := dispatcher.AddHandler(&%s{}, command); err != nil {\nreturn err\n}\n}\nreturn nil\n}\n", t.Name)
+		} else {
+			writeHandleEvent(&b, eh, t, "Handle", t.Events)
+
+			fmt.Fprintf(&b, "\n// %s subscribes %s to the events it handles.\n", registerFunc(t), r)
+			fmt.Fprintf(&b, "func %s(bus *%s.HandlerEventBus, %s *%s) {\n", registerFunc(t), eh, r, t.Name)
+			for _, m := range t.Events {
+				fmt.Fprintf(&b, "bus.AddSubscriber(%s, %s)\n", r, m.Zero)
+			}
+			fmt.Fprintf(&b, "}\n")
+		}
+	}
+
+	src, err := format.Source(b.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("could not format generated code: %v", err)
+	}
+	return src, nil
+}
+
+// writeHandleEvent writes a HandleEvent method routing events to methods.
+func writeHandleEvent(b *bytes.Buffer, eh string, t *handlerType, prefix string, methods []handlerMethod) {
+	r := receiverName(t)
+	fmt.Fprintf(b, "\n// HandleEvent routes events to the %s methods of %s.\n", prefix, t.Name)
+	if len(methods) == 0 {
+		fmt.Fprintf(b, "func (%s *%s) HandleEvent(event %s.Event) {}\n", r, t.Name, eh)
+		return
+	}
+	fmt.Fprintf(b, "func (%s *%s) HandleEvent(event %s.Event) {\n", r, t.Name, eh)
+	fmt.Fprintf(b, "switch event := event.(type) {\n")
+	for _, m := range methods {
+		fmt.Fprintf(b, "case %s:\n%s.%s(event)\n", m.Type, r, m.Method)
+	}
+	fmt.Fprintf(b, "}\n}\n")
+}
@@ -0,0 +1,75 @@
+// Copyright (c) 2014 - Max Persson <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Command ehgen generates static event and command routing for aggregates
+// and projectors, replacing the reflection used by ReflectEventHandler,
+// ReflectDispatcher.AddAllHandlers and HandlerEventBus.AddAllSubscribers.
+//
+// It is used with go generate:
+//
+//	//go:generate go run github.com/looplab/eventhorizon/cmd/ehgen -type InvitationAggregate,InvitationProjector
+//
+// Aggregates are types with command handling methods on the form
+// HandleMyCommand(MyCommand) ([]Event, error). They get a HandleCommand
+// method that routes commands to them, a HandleEvent method that routes
+// events to their ApplyMyEvent(MyEvent) methods and an AddXHandlers function
+// that adds them to a DelegateDispatcher.
+//
+// Projectors are other types with event handling methods on the form
+// HandleMyEvent(MyEvent). They get a HandleEvent method that routes events
+// to them and an AddXSubscribers function that adds them to a
+// HandlerEventBus.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func main() {
+	typeNames := flag.String("type", "", "comma-separated list of type names; default all types with handling methods")
+	output := flag.String("output", "", "output file name; default <dir>/eh_handlers.go")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: ehgen [flags] [directory]\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	dir := "."
+	if args := flag.Args(); len(args) > 0 {
+		dir = args[0]
+	}
+	var types []string
+	if *typeNames != "" {
+		types = strings.Split(*typeNames, ",")
+	}
+
+	src, err := generate(dir, types)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ehgen: %v\n", err)
+		os.Exit(1)
+	}
+
+	path := *output
+	if path == "" {
+		path = filepath.Join(dir, "eh_handlers.go")
+	}
+	if err := os.WriteFile(path, src, 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "ehgen: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,236 @@
+// Copyright (c) 2014 - Max Persson <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventhorizon
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"path"
+	"reflect"
+)
+
+// Error returned when a command name is not registered with the gateway.
+var ErrUnknownCommand = errors.New("unknown command")
+
+// DefaultMaxCommandSize is the default largest request body, in bytes, that
+// a CommandGateway reads.
+const DefaultMaxCommandSize = 1 << 20
+
+// CommandGateway is a http.Handler that decodes JSON commands and dispatches
+// them.
+//
+// A command is posted either to a path ending with its registered name with
+// the command as body, or to any other path in an envelope:
+//
+//	{"type": "CreateInvite", "command": {"InvitationID": "...", "Name": "..."}}
+//
+// Errors are returned with a status code and a body like:
+//
+//	{"error": {"code": "missing_field", "message": "...", "field": "Name"}}
+type CommandGateway struct {
+	dispatcher Dispatcher
+	commands   map[string]reflect.Type
+	statuses   []errorStatus
+	maxSize    int64
+}
+
+type errorStatus struct {
+	err    error
+	status int
+	code   string
+}
+
+// GatewayError is the body of an error response from the CommandGateway.
+type GatewayError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Field   string `json:"field,omitempty"`
+}
+
+type commandEnvelope struct {
+	Type    string          `json:"type"`
+	Command json.RawMessage `json:"command"`
+}
+
+// NewCommandGateway creates a CommandGateway. If the dispatcher has a Commands
+// method, like DelegateDispatcher and ReflectDispatcher, all its commands are
+// registered by their type names.
+func NewCommandGateway(dispatcher Dispatcher) *CommandGateway {
+	g := &CommandGateway{
+		dispatcher: dispatcher,
+		commands:   make(map[string]reflect.Type),
+		statuses:   make([]errorStatus, 0),
+		maxSize:    DefaultMaxCommandSize,
+	}
+
+	if d, ok := dispatcher.(interface{ Commands() []Command }); ok {
+		for _, command := range d.Commands() {
+			g.AddCommand(reflect.TypeOf(command).Name(), command)
+		}
+	}
+	return g
+}
+
+// AddCommand registers a command type by name.
+func (g *CommandGateway) AddCommand(name string, command Command) {
+	g.commands[name] = reflect.TypeOf(command)
+}
+
+// SetMaxCommandSize sets the largest request body, in bytes, that is read.
+// Larger requests are rejected with status 413 Request Entity Too Large.
+func (g *CommandGateway) SetMaxCommandSize(size int64) {
+	g.maxSize = size
+}
+
+// AddErrorStatus maps errors from dispatching that match err, as by errors.Is,
+// to a status code and error code. Unmapped errors are treated as the command
+// being rejected by the domain, with status 409 Conflict, except failures of
+// the event store, which are internal errors with status 500.
+func (g *CommandGateway) AddErrorStatus(err error, status int, code string) {
+	g.statuses = append(g.statuses, errorStatus{err, status, code})
+}
+
+// ServeHTTP decodes and dispatches a command. Responds with 204 No Content if
+// the command was handled.
+func (g *CommandGateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeGatewayError(w, http.StatusMethodNotAllowed,
+			GatewayError{Code: "method_not_allowed", Message: "only POST is allowed"})
+		return
+	}
+
+	command, err := g.decodeCommand(w, r)
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		writeGatewayError(w, http.StatusRequestEntityTooLarge,
+			GatewayError{Code: "request_too_large", Message: err.Error()})
+		return
+	} else if err == ErrUnknownCommand {
+		writeGatewayError(w, http.StatusNotFound,
+			GatewayError{Code: "unknown_command", Message: err.Error()})
+		return
+	} else if err != nil {
+		writeGatewayError(w, http.StatusBadRequest,
+			GatewayError{Code: "invalid_json", Message: err.Error()})
+		return
+	}
+
+	if err := checkCommand(command); err != nil {
+		g.writeError(w, err)
+		return
+	}
+
+	if err := g.dispatch(r, command); err != nil {
+		g.writeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// dispatch dispatches a command with the context of the request, which can
+// carry a principal set by an authenticating middleware.
+func (g *CommandGateway) dispatch(r *http.Request, command Command) error {
+	if d, ok := g.dispatcher.(ContextDispatcher); ok {
+		return d.DispatchContext(r.Context(), command)
+	}
+	return g.dispatcher.Dispatch(command)
+}
+
+func (g *CommandGateway) decodeCommand(w http.ResponseWriter, r *http.Request) (Command, error) {
+	var body bytes.Buffer
+	if _, err := body.ReadFrom(http.MaxBytesReader(w, r.Body, g.maxSize)); err != nil {
+		return nil, err
+	}
+	data := body.Bytes()
+
+	commandType, ok := g.commands[path.Base(r.URL.Path)]
+	if !ok {
+		var envelope commandEnvelope
+		if err := json.Unmarshal(data, &envelope); err != nil {
+			return nil, err
+		}
+		if commandType, ok = g.commands[envelope.Type]; !ok {
+			return nil, ErrUnknownCommand
+		}
+		data = envelope.Command
+	}
+
+	command := reflect.New(commandType)
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(command.Interface()); err != nil {
+		return nil, err
+	}
+	return command.Elem().Interface().(Command), nil
+}
+
+func (g *CommandGateway) writeError(w http.ResponseWriter, err error) {
+	var fieldErr CommandFieldError
+	switch {
+	case errors.As(err, &fieldErr):
+		writeGatewayError(w, http.StatusUnprocessableEntity,
+			GatewayError{Code: "missing_field", Message: err.Error(), Field: fieldErr.Field})
+		return
+	case errors.Is(err, ErrHandlerNotFound):
+		writeGatewayError(w, http.StatusNotFound,
+			GatewayError{Code: "handler_not_found", Message: err.Error()})
+		return
+	case errors.Is(err, ErrAggregateNotFound):
+		writeGatewayError(w, http.StatusNotFound,
+			GatewayError{Code: "aggregate_not_found", Message: err.Error()})
+		return
+	case errors.Is(err, ErrAggregateAlreadyExists):
+		writeGatewayError(w, http.StatusConflict,
+			GatewayError{Code: "aggregate_already_exists", Message: err.Error()})
+		return
+	case errors.Is(err, ErrAggregateClosed):
+		writeGatewayError(w, http.StatusGone,
+			GatewayError{Code: "aggregate_closed", Message: err.Error()})
+		return
+	case errors.Is(err, ErrUnauthorized):
+		writeGatewayError(w, http.StatusForbidden,
+			GatewayError{Code: "unauthorized", Message: err.Error()})
+		return
+	}
+
+	for _, s := range g.statuses {
+		if errors.Is(err, s.err) {
+			writeGatewayError(w, s.status, GatewayError{Code: s.code, Message: err.Error()})
+			return
+		}
+	}
+
+	// The errors of the infrastructure are not for the client.
+	if isEventStoreError(err) {
+		writeGatewayError(w, http.StatusInternalServerError,
+			GatewayError{Code: "internal_error", Message: "internal error"})
+		return
+	}
+
+	writeGatewayError(w, http.StatusConflict,
+		GatewayError{Code: "command_rejected", Message: err.Error()})
+}
+
+func writeGatewayError(w http.ResponseWriter, status int, gatewayErr GatewayError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(struct {
+		Error GatewayError `json:"error"`
+	}{gatewayErr})
+}
@@ -0,0 +1,216 @@
+// Copyright (c) 2014 - Max Persson <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventhorizon
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+var _ = Suite(&CommandGatewaySuite{})
+
+type CommandGatewaySuite struct {
+	store   *MockEventStore
+	disp    *DelegateDispatcher
+	gateway *CommandGateway
+}
+
+var errTestUnavailable = errors.New("unavailable")
+
+type TestGatewayAggregate struct {
+	Aggregate
+}
+
+func (t *TestGatewayAggregate) HandleCommand(command Command) ([]Event, error) {
+	switch command := command.(type) {
+	case TestCommand:
+		switch command.Content {
+		case "error":
+			return nil, errors.New("command error")
+		case "unavailable":
+			return nil, errTestUnavailable
+		}
+		return []Event{TestEvent{command.TestID, command.Content}}, nil
+	}
+	return nil, errors.New("couldn't handle command")
+}
+
+func (t *TestGatewayAggregate) HandleEvent(event Event) {}
+
+func (s *CommandGatewaySuite) SetUpTest(c *C) {
+	s.store = &MockEventStore{}
+	s.disp = NewDelegateDispatcher(s.store, &MockEventBus{})
+	s.disp.AddHandler(&TestGatewayAggregate{}, TestCommand{})
+	s.gateway = NewCommandGateway(s.disp)
+	s.gateway.AddErrorStatus(errTestUnavailable, http.StatusServiceUnavailable, "unavailable")
+}
+
+func (s *CommandGatewaySuite) post(path, body string) (*httptest.ResponseRecorder, GatewayError) {
+	r := httptest.NewRequest("POST", path, strings.NewReader(body))
+	w := httptest.NewRecorder()
+	s.gateway.ServeHTTP(w, r)
+
+	var response struct {
+		Error GatewayError `json:"error"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	return w, response.Error
+}
+
+func (s *CommandGatewaySuite) Test_NewCommandGateway(c *C) {
+	c.Assert(s.gateway.commands, HasLen, 1)
+	c.Assert(s.disp.Commands(), DeepEquals, []Command{TestCommand{}})
+}
+
+func (s *CommandGatewaySuite) Test_Route(c *C) {
+	id := NewUUID()
+	w, _ := s.post("/commands/TestCommand",
+		`{"TestID": "`+id.String()+`", "Content": "command1"}`)
+	c.Assert(w.Code, Equals, http.StatusNoContent)
+	c.Assert(s.store.events, DeepEquals, []Event{TestEvent{id, "command1"}})
+}
+
+func (s *CommandGatewaySuite) Test_Envelope(c *C) {
+	id := NewUUID()
+	w, _ := s.post("/commands",
+		`{"type": "TestCommand", "command": {"TestID": "`+id.String()+`", "Content": "command1"}}`)
+	c.Assert(w.Code, Equals, http.StatusNoContent)
+	c.Assert(s.store.events, DeepEquals, []Event{TestEvent{id, "command1"}})
+}
+
+func (s *CommandGatewaySuite) Test_MethodNotAllowed(c *C) {
+	r := httptest.NewRequest("GET", "/commands/TestCommand", nil)
+	w := httptest.NewRecorder()
+	s.gateway.ServeHTTP(w, r)
+	c.Assert(w.Code, Equals, http.StatusMethodNotAllowed)
+	c.Assert(w.Header().Get("Allow"), Equals, "POST")
+}
+
+func (s *CommandGatewaySuite) Test_UnknownCommand(c *C) {
+	w, err := s.post("/commands", `{"type": "Unknown", "command": {}}`)
+	c.Assert(w.Code, Equals, http.StatusNotFound)
+	c.Assert(err.Code, Equals, "unknown_command")
+}
+
+func (s *CommandGatewaySuite) Test_InvalidJSON(c *C) {
+	w, err := s.post("/commands/TestCommand", `{"TestID": "invalid"}`)
+	c.Assert(w.Code, Equals, http.StatusBadRequest)
+	c.Assert(err.Code, Equals, "invalid_json")
+	c.Assert(err.Message, Matches, "invalid UUID in JSON.*")
+
+	w, err = s.post("/commands/TestCommand", `{"Unknown": 1}`)
+	c.Assert(w.Code, Equals, http.StatusBadRequest)
+	c.Assert(err.Code, Equals, "invalid_json")
+}
+
+func (s *CommandGatewaySuite) Test_MissingField(c *C) {
+	w, err := s.post("/commands/TestCommand", `{"TestID": "`+NewUUID().String()+`"}`)
+	c.Assert(w.Code, Equals, http.StatusUnprocessableEntity)
+	c.Assert(w.Header().Get("Content-Type"), Equals, "application/json")
+	c.Assert(err, Equals, GatewayError{"missing_field", "missing field: Content", "Content"})
+	c.Assert(len(s.store.events), Equals, 0)
+}
+
+func (s *CommandGatewaySuite) Test_HandlerNotFound(c *C) {
+	s.gateway.AddCommand("TestCommandOther", TestCommandOther{})
+	w, err := s.post("/commands/TestCommandOther",
+		`{"TestID": "`+NewUUID().String()+`", "Content": "command1"}`)
+	c.Assert(w.Code, Equals, http.StatusNotFound)
+	c.Assert(err.Code, Equals, "handler_not_found")
+}
+
+func (s *CommandGatewaySuite) Test_DomainError(c *C) {
+	w, err := s.post("/commands/TestCommand",
+		`{"TestID": "`+NewUUID().String()+`", "Content": "error"}`)
+	c.Assert(w.Code, Equals, http.StatusConflict)
+	c.Assert(err, Equals, GatewayError{Code: "command_rejected", Message: "command error"})
+}
+
+func (s *CommandGatewaySuite) Test_EventStoreError(c *C) {
+	disp := NewDelegateDispatcher(&ErrorEventStore{errors.New("disk full")}, &MockEventBus{})
+	disp.AddHandler(&TestGatewayAggregate{}, TestCommand{})
+	s.gateway = NewCommandGateway(disp)
+	w, err := s.post("/commands/TestCommand",
+		`{"TestID": "`+NewUUID().String()+`", "Content": "command1"}`)
+	c.Assert(w.Code, Equals, http.StatusInternalServerError)
+	c.Assert(err, Equals, GatewayError{Code: "internal_error", Message: "internal error"})
+}
+
+func (s *CommandGatewaySuite) Test_TooLarge(c *C) {
+	s.gateway.SetMaxCommandSize(64)
+	w, err := s.post("/commands/TestCommand",
+		`{"TestID": "`+NewUUID().String()+`", "Content": "`+strings.Repeat("x", 64)+`"}`)
+	c.Assert(w.Code, Equals, http.StatusRequestEntityTooLarge)
+	c.Assert(err.Code, Equals, "request_too_large")
+	c.Assert(len(s.store.events), Equals, 0)
+}
+
+func (s *CommandGatewaySuite) Test_MappedError(c *C) {
+	w, err := s.post("/commands/TestCommand",
+		`{"TestID": "`+NewUUID().String()+`", "Content": "unavailable"}`)
+	c.Assert(w.Code, Equals, http.StatusServiceUnavailable)
+	c.Assert(err.Code, Equals, "unavailable")
+}
+
+func (s *CommandGatewaySuite) Test_Unauthorized(c *C) {
+	authorizer := NewAuthorizer()
+	authorizer.AddPolicy(TestCommand{}, func(ctx context.Context, command Command, aggregate Aggregate) error {
+		if _, ok := PrincipalFrom(ctx); !ok {
+			return Deny("no principal")
+		}
+		return nil
+	})
+	s.disp.SetAuthorizer(authorizer)
+
+	body := `{"TestID": "` + NewUUID().String() + `", "Content": "command1"}`
+	w, gatewayErr := s.post("/commands/TestCommand", body)
+	c.Assert(w.Code, Equals, http.StatusForbidden)
+	c.Assert(gatewayErr.Code, Equals, "unauthorized")
+
+	r := httptest.NewRequest("POST", "/commands/TestCommand", strings.NewReader(body))
+	r = r.WithContext(WithPrincipal(r.Context(), Principal{ID: "user1"}))
+	w = httptest.NewRecorder()
+	s.gateway.ServeHTTP(w, r)
+	c.Assert(w.Code, Equals, http.StatusNoContent)
+}
+
+func (s *CommandGatewaySuite) Test_AggregateLifecycle(c *C) {
+	disp := NewDelegateDispatcher(NewMemoryEventStore(), &MockEventBus{})
+	disp.AddHandler(&TestLifecycleAggregate{}, TestCreateCommand{})
+	disp.AddHandler(&TestLifecycleAggregate{}, TestExistingCommand{})
+	disp.AddHandler(&TestLifecycleAggregate{}, TestCloseCommand{})
+	s.gateway = NewCommandGateway(disp)
+	id := `{"TestID": "` + NewUUID().String() + `"}`
+	existing := `{"TestID": "` + NewUUID().String() + `", "Content": "command1"}`
+
+	w, gatewayErr := s.post("/TestExistingCommand", existing)
+	c.Assert(w.Code, Equals, http.StatusNotFound)
+	c.Assert(gatewayErr.Code, Equals, "aggregate_not_found")
+	w, _ = s.post("/TestCreateCommand", id)
+	c.Assert(w.Code, Equals, http.StatusNoContent)
+	w, gatewayErr = s.post("/TestCreateCommand", id)
+	c.Assert(w.Code, Equals, http.StatusConflict)
+	c.Assert(gatewayErr.Code, Equals, "aggregate_already_exists")
+	s.post("/TestCloseCommand", id)
+	w, gatewayErr = s.post("/TestCreateCommand", id)
+	c.Assert(w.Code, Equals, http.StatusGone)
+	c.Assert(gatewayErr.Code, Equals, "aggregate_closed")
+}
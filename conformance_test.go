@@ -0,0 +1,221 @@
+// Copyright (c) 2014 - Max Persson <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventhorizon_test
+
+import (
+	"net"
+	"os"
+
+	. "gopkg.in/check.v1"
+
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/testing"
+)
+
+// The conformance suites are registered with the gocheck runner of the
+// internal tests.
+
+func conformanceCodec() *eh.EventCodec {
+	codec := eh.NewEventCodec()
+	codec.RegisterEvent(testing.ConformanceEvent{}, 1)
+	codec.RegisterEvent(testing.ConformanceOtherEvent{}, 1)
+	return codec
+}
+
+type MemoryEventStoreSuite struct{ testing.EventStoreSuite }
+
+var _ = Suite(&MemoryEventStoreSuite{testing.EventStoreSuite{
+	NewEventStore: func() eh.EventStore { return eh.NewMemoryEventStore() },
+}})
+
+type CodecEventStoreSuite struct{ testing.EventStoreSuite }
+
+var _ = Suite(&CodecEventStoreSuite{testing.EventStoreSuite{
+	NewEventStore: func() eh.EventStore {
+		return eh.NewCodecEventStore(eh.NewMemoryRecordStore(), conformanceCodec())
+	},
+}})
+
+type CryptoEventStoreSuite struct{ testing.EventStoreSuite }
+
+var _ = Suite(&CryptoEventStoreSuite{testing.EventStoreSuite{
+	NewEventStore: func() eh.EventStore {
+		codec := conformanceCodec()
+		records := eh.NewCryptoRecordStore(eh.NewMemoryRecordStore(), codec, eh.NewMemoryKeyStore())
+		return eh.NewCodecEventStore(records, codec)
+	},
+}})
+
+// fileEventStore is a CodecEventStore over a FileRecordStore in a temporary
+// directory.
+type fileEventStore struct {
+	*eh.CodecEventStore
+	dir string
+}
+
+func (s *fileEventStore) Close() {
+	os.RemoveAll(s.dir)
+}
+
+type FileEventStoreSuite struct{ testing.EventStoreSuite }
+
+var _ = Suite(&FileEventStoreSuite{testing.EventStoreSuite{
+	NewEventStore: func() eh.EventStore {
+		dir, err := os.MkdirTemp("", "eventhorizon")
+		if err != nil {
+			panic(err)
+		}
+		records, err := eh.NewFileRecordStore(dir)
+		if err != nil {
+			panic(err)
+		}
+		return &fileEventStore{eh.NewCodecEventStore(records, conformanceCodec()), dir}
+	},
+	Volume: 1000,
+}})
+
+type HashChainEventStoreSuite struct{ testing.EventStoreSuite }
+
+var _ = Suite(&HashChainEventStoreSuite{testing.EventStoreSuite{
+	NewEventStore: func() eh.EventStore { return eh.NewHashChainEventStore(eh.NewMemoryEventStore()) },
+}})
+
+type HashChainRecordStoreSuite struct{ testing.EventStoreSuite }
+
+var _ = Suite(&HashChainRecordStoreSuite{testing.EventStoreSuite{
+	NewEventStore: func() eh.EventStore {
+		records := eh.NewHashChainRecordStore(eh.NewMemoryRecordStore())
+		return eh.NewCodecEventStore(records, conformanceCodec())
+	},
+}})
+
+type TraceEventStoreSuite struct{ testing.EventStoreSuite }
+
+var _ = Suite(&TraceEventStoreSuite{testing.EventStoreSuite{
+	NewEventStore: func() eh.EventStore {
+		store := eh.NewTraceEventStore(eh.NewMemoryEventStore())
+		store.StartTracing()
+		return store
+	},
+}})
+
+type MetricsEventStoreSuite struct{ testing.EventStoreSuite }
+
+var _ = Suite(&MetricsEventStoreSuite{testing.EventStoreSuite{
+	NewEventStore: func() eh.EventStore {
+		return eh.NewMetricsEventStore(eh.NewMemoryEventStore(), eh.NewPrometheusMetrics())
+	},
+}})
+
+type MemoryOutboxStoreSuite struct{ testing.EventStoreSuite }
+
+var _ = Suite(&MemoryOutboxStoreSuite{testing.EventStoreSuite{
+	NewEventStore: func() eh.EventStore { return eh.NewMemoryOutboxStore() },
+}})
+
+// fileOutboxStore is a FileOutboxStore in a temporary directory.
+type fileOutboxStore struct {
+	*eh.FileOutboxStore
+	dir string
+}
+
+func (s *fileOutboxStore) Close() {
+	os.RemoveAll(s.dir)
+}
+
+type FileOutboxStoreSuite struct{ testing.EventStoreSuite }
+
+var _ = Suite(&FileOutboxStoreSuite{testing.EventStoreSuite{
+	NewEventStore: func() eh.EventStore {
+		dir, err := os.MkdirTemp("", "eventhorizon")
+		if err != nil {
+			panic(err)
+		}
+		store, err := eh.NewFileOutboxStore(dir, conformanceCodec())
+		if err != nil {
+			panic(err)
+		}
+		return &fileOutboxStore{store, dir}
+	},
+}})
+
+type MultiTenantEventStoreSuite struct{ testing.EventStoreSuite }
+
+var _ = Suite(&MultiTenantEventStoreSuite{testing.EventStoreSuite{
+	NewEventStore: func() eh.EventStore {
+		store := eh.NewMultiTenantEventStore(func(string) eh.EventStore {
+			return eh.NewMemoryEventStore()
+		})
+		return store.ForTenant("tenant")
+	},
+}})
+
+type HandlerEventBusSuite struct{ testing.EventBusSuite }
+
+var _ = Suite(&HandlerEventBusSuite{testing.EventBusSuite{
+	NewEventBus: func() testing.SubscribableEventBus { return eh.NewHandlerEventBus() },
+}})
+
+// remoteConformanceBus is a RemoteEventBus with its own broker.
+type remoteConformanceBus struct {
+	*eh.RemoteEventBus
+	broker   *eh.Broker
+	listener net.Listener
+}
+
+func newRemoteConformanceBus() testing.SubscribableEventBus {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	broker := eh.NewBroker()
+	// Keep events published before the bus has connected.
+	broker.AddSubscription("conformance")
+	go broker.Serve(listener)
+
+	bus := eh.NewRemoteEventBus("tcp", listener.Addr().String(), "conformance", conformanceCodec())
+	bus.Start()
+	return &remoteConformanceBus{bus, broker, listener}
+}
+
+func (b *remoteConformanceBus) Close() {
+	b.RemoteEventBus.Close()
+	b.broker.Close()
+	b.listener.Close()
+}
+
+type RemoteEventBusConformanceSuite struct{ testing.EventBusSuite }
+
+var _ = Suite(&RemoteEventBusConformanceSuite{testing.EventBusSuite{
+	NewEventBus: newRemoteConformanceBus,
+	Volume:      1000,
+}})
+
+type MemoryRepositorySuite struct{ testing.RepositorySuite }
+
+var _ = Suite(&MemoryRepositorySuite{testing.RepositorySuite{
+	NewRepository: func() eh.Repository { return eh.NewMemoryRepository() },
+}})
+
+type MultiTenantRepositorySuite struct{ testing.RepositorySuite }
+
+var _ = Suite(&MultiTenantRepositorySuite{testing.RepositorySuite{
+	NewRepository: func() eh.Repository {
+		repository := eh.NewMultiTenantRepository(func(string) eh.Repository {
+			return eh.NewMemoryRepository()
+		})
+		return repository.ForTenant("tenant")
+	},
+}})
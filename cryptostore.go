@@ -0,0 +1,326 @@
+// Copyright (c) 2014 - Max Persson <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventhorizon
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"iter"
+	"reflect"
+	"strings"
+)
+
+// Redacted is the value that encrypted string fields decode to when the key
+// of their data subject has been destroyed. Fields of other kinds decode to
+// their zero value.
+const Redacted = "[redacted]"
+
+// Error returned when an encrypted field can not be decrypted.
+var ErrDecryptionFailed = errors.New("could not decrypt field")
+
+// CryptoRecordStore wraps a RecordStore and encrypts personal data in records.
+//
+// Event fields tagged with `eh:"pii"` are encrypted with the key of their
+// data subject before being stored, and transparently decrypted when loaded.
+// The data subject is the aggregate of the event, or the value of a UUID field
+// tagged with `eh:"subject"` if there is one. Forgetting a subject destroys
+// its key, after which its encrypted fields are loaded as redacted.
+type CryptoRecordStore struct {
+	recordStore RecordStore
+	codec       *EventCodec
+	keyStore    KeyStore
+}
+
+// encryptedField is the stored form of an encrypted field value.
+type encryptedField struct {
+	Subject UUID   `json:"eh_subject"`
+	Kind    string `json:"eh_kind"`
+	Data    []byte `json:"eh_pii"`
+}
+
+// NewCryptoRecordStore creates a new CryptoRecordStore. The codec is used to
+// find the tagged fields of the event types.
+func NewCryptoRecordStore(recordStore RecordStore, codec *EventCodec, keyStore KeyStore) *CryptoRecordStore {
+	s := &CryptoRecordStore{
+		recordStore: recordStore,
+		codec:       codec,
+		keyStore:    keyStore,
+	}
+	return s
+}
+
+// AppendRecords encrypts the personal data of the records and appends them
+// to the base store.
+func (s *CryptoRecordStore) AppendRecords(records []EventRecord) error {
+	encrypted := make([]EventRecord, len(records))
+	for i, record := range records {
+		r, err := s.encrypt(record)
+		if err != nil {
+			return err
+		}
+		encrypted[i] = r
+	}
+	return s.recordStore.AppendRecords(encrypted)
+}
+
+// LoadRecords loads the records for the aggregate id from the base store and
+// decrypts their personal data.
+func (s *CryptoRecordStore) LoadRecords(id UUID) ([]EventRecord, error) {
+	records, err := s.recordStore.LoadRecords(id)
+	if err != nil {
+		return nil, err
+	}
+	return s.decryptAll(records)
+}
+
+// StreamRecords streams the records for the aggregate id from the base store
+// and decrypts their personal data one at a time.
+func (s *CryptoRecordStore) StreamRecords(id UUID) iter.Seq2[EventRecord, error] {
+	return func(yield func(EventRecord, error) bool) {
+		for record, err := range streamAggregateRecords(s.recordStore, id) {
+			if err == nil {
+				record, err = s.decrypt(record)
+			}
+			if err != nil {
+				yield(EventRecord{}, err)
+				return
+			}
+			if !yield(record, nil) {
+				return
+			}
+		}
+	}
+}
+
+// LoadAllRecords loads all records from the base store and decrypts their
+// personal data.
+func (s *CryptoRecordStore) LoadAllRecords() ([]EventRecord, error) {
+	records, err := s.recordStore.LoadAllRecords()
+	if err != nil {
+		return nil, err
+	}
+	return s.decryptAll(records)
+}
+
+// Forget destroys the key of a data subject, making all its personal data in
+// the store unreadable.
+func (s *CryptoRecordStore) Forget(subject UUID) error {
+	return s.keyStore.DeleteKey(subject)
+}
+
+func (s *CryptoRecordStore) encrypt(record EventRecord) (EventRecord, error) {
+	t, ok := s.codec.goType(record.Type)
+	if !ok {
+		return record, nil
+	}
+
+	piiFields, subjectField := taggedFields(t)
+	if len(piiFields) == 0 {
+		return record, nil
+	}
+
+	var data map[string]json.RawMessage
+	if err := json.Unmarshal(record.Data, &data); err != nil {
+		return record, err
+	}
+
+	subject := record.AggregateID
+	if value, ok := data[subjectField]; ok {
+		if err := json.Unmarshal(value, &subject); err != nil {
+			return record, err
+		}
+	}
+
+	key, err := s.keyStore.CreateKey(subject)
+	if err != nil {
+		return record, err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return record, err
+	}
+
+	for _, name := range piiFields {
+		value, ok := data[name]
+		if !ok {
+			continue
+		}
+
+		nonce := make([]byte, gcm.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return record, err
+		}
+		field := encryptedField{
+			Subject: subject,
+			Kind:    jsonKind(value),
+			Data:    gcm.Seal(nonce, nonce, value, nil),
+		}
+		if data[name], err = json.Marshal(field); err != nil {
+			return record, err
+		}
+	}
+
+	if record.Data, err = json.Marshal(data); err != nil {
+		return record, err
+	}
+	return record, nil
+}
+
+func (s *CryptoRecordStore) decryptAll(records []EventRecord) ([]EventRecord, error) {
+	decrypted := make([]EventRecord, len(records))
+	for i, record := range records {
+		r, err := s.decrypt(record)
+		if err != nil {
+			return nil, err
+		}
+		decrypted[i] = r
+	}
+	return decrypted, nil
+}
+
+func (s *CryptoRecordStore) decrypt(record EventRecord) (EventRecord, error) {
+	// Encrypted fields are self describing, which makes it possible to
+	// decrypt records of old versions that are not yet upcasted.
+	if !bytes.Contains(record.Data, []byte(`"eh_pii"`)) {
+		return record, nil
+	}
+
+	var data map[string]json.RawMessage
+	if err := json.Unmarshal(record.Data, &data); err != nil {
+		return record, err
+	}
+
+	for name, value := range data {
+		if len(value) == 0 || value[0] != '{' {
+			continue
+		}
+		var field encryptedField
+		if err := json.Unmarshal(value, &field); err != nil || field.Data == nil {
+			continue
+		}
+
+		plain, err := s.decryptField(field)
+		if err != nil {
+			return record, err
+		}
+		data[name] = plain
+	}
+
+	var err error
+	if record.Data, err = json.Marshal(data); err != nil {
+		return record, err
+	}
+	return record, nil
+}
+
+func (s *CryptoRecordStore) decryptField(field encryptedField) (json.RawMessage, error) {
+	key, err := s.keyStore.Key(field.Subject)
+	if err == ErrKeyNotFound {
+		return redacted(field.Kind), nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(field.Data) < gcm.NonceSize() {
+		return nil, ErrDecryptionFailed
+	}
+	nonce, ciphertext := field.Data[:gcm.NonceSize()], field.Data[gcm.NonceSize():]
+	plain, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, ErrDecryptionFailed
+	}
+	return plain, nil
+}
+
+// taggedFields returns the JSON names of the fields tagged as personal data
+// and of the field tagged as data subject, if any.
+func taggedFields(t reflect.Type) ([]string, string) {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil, ""
+	}
+
+	var piiFields []string
+	var subjectField string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue // Skip private field.
+		}
+
+		switch field.Tag.Get("eh") {
+		case "pii":
+			piiFields = append(piiFields, jsonFieldName(field))
+		case "subject":
+			subjectField = jsonFieldName(field)
+		}
+	}
+	return piiFields, subjectField
+}
+
+func jsonFieldName(field reflect.StructField) string {
+	name := strings.Split(field.Tag.Get("json"), ",")[0]
+	if name == "" || name == "-" {
+		return field.Name
+	}
+	return name
+}
+
+func jsonKind(value json.RawMessage) string {
+	value = bytes.TrimSpace(value)
+	if len(value) == 0 {
+		return "null"
+	}
+
+	switch value[0] {
+	case '"':
+		return "string"
+	case 't', 'f':
+		return "bool"
+	case 'n', '{', '[':
+		return "null"
+	}
+	return "number"
+}
+
+func redacted(kind string) json.RawMessage {
+	switch kind {
+	case "string":
+		return json.RawMessage(`"` + Redacted + `"`)
+	case "bool":
+		return json.RawMessage("false")
+	case "number":
+		return json.RawMessage("0")
+	}
+	return json.RawMessage("null")
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
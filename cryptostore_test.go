@@ -0,0 +1,124 @@
+// Copyright (c) 2014 - Max Persson <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventhorizon
+
+import (
+	"bytes"
+
+	. "gopkg.in/check.v1"
+)
+
+var _ = Suite(&CryptoRecordStoreSuite{})
+
+type CryptoRecordStoreSuite struct {
+	baseStore *MemoryRecordStore
+	keyStore  *MemoryKeyStore
+	store     *CryptoRecordStore
+	events    *CodecEventStore
+}
+
+type TestPersonEvent struct {
+	TestID   UUID
+	Name     string `eh:"pii"`
+	Age      int    `eh:"pii"`
+	Verified bool   `eh:"pii"`
+	Content  string
+}
+
+func (t TestPersonEvent) AggregateID() UUID { return t.TestID }
+
+type TestGuardianEvent struct {
+	TestID   UUID
+	PersonID UUID   `eh:"subject"`
+	Name     string `json:"name" eh:"pii"`
+}
+
+func (t TestGuardianEvent) AggregateID() UUID { return t.TestID }
+
+func (s *CryptoRecordStoreSuite) SetUpTest(c *C) {
+	codec := NewEventCodec()
+	codec.RegisterEvent(TestEvent{}, 1)
+	codec.RegisterEvent(TestPersonEvent{}, 1)
+	codec.RegisterEvent(TestGuardianEvent{}, 1)
+	s.baseStore = NewMemoryRecordStore()
+	s.keyStore = NewMemoryKeyStore()
+	s.store = NewCryptoRecordStore(s.baseStore, codec, s.keyStore)
+	s.events = NewCodecEventStore(s.store, codec)
+}
+
+func (s *CryptoRecordStoreSuite) Test_Append_Encrypted(c *C) {
+	event1 := TestPersonEvent{NewUUID(), "Athena", 42, true, "event1"}
+	err := s.events.Append([]Event{event1})
+	c.Assert(err, Equals, nil)
+	c.Assert(len(s.baseStore.records), Equals, 1)
+	data := s.baseStore.records[0].Data
+	c.Assert(bytes.Contains(data, []byte("Athena")), Equals, false)
+	c.Assert(bytes.Contains(data, []byte(`"Age":42`)), Equals, false)
+	c.Assert(bytes.Contains(data, []byte("event1")), Equals, true)
+}
+
+func (s *CryptoRecordStoreSuite) Test_Append_NoPersonalData(c *C) {
+	event1 := TestEvent{NewUUID(), "event1"}
+	s.events.Append([]Event{event1})
+	_, err := s.keyStore.Key(event1.TestID)
+	c.Assert(err, Equals, ErrKeyNotFound)
+}
+
+func (s *CryptoRecordStoreSuite) Test_Load_Decrypted(c *C) {
+	event1 := TestPersonEvent{NewUUID(), "Athena", 42, true, "event1"}
+	s.events.Append([]Event{event1})
+	events, err := s.events.Load(event1.TestID)
+	c.Assert(err, Equals, nil)
+	c.Assert(events, DeepEquals, []Event{event1})
+	events, err = s.events.LoadAll()
+	c.Assert(err, Equals, nil)
+	c.Assert(events, DeepEquals, []Event{event1})
+}
+
+func (s *CryptoRecordStoreSuite) Test_Forget(c *C) {
+	event1 := TestPersonEvent{NewUUID(), "Athena", 42, true, "event1"}
+	event2 := TestPersonEvent{NewUUID(), "Hades", 43, true, "event2"}
+	s.events.Append([]Event{event1, event2})
+	err := s.store.Forget(event1.TestID)
+	c.Assert(err, Equals, nil)
+	events, err := s.events.LoadAll()
+	c.Assert(err, Equals, nil)
+	c.Assert(events, DeepEquals, []Event{
+		TestPersonEvent{event1.TestID, Redacted, 0, false, "event1"},
+		event2,
+	})
+}
+
+func (s *CryptoRecordStoreSuite) Test_Forget_SubjectField(c *C) {
+	personID := NewUUID()
+	event1 := TestGuardianEvent{NewUUID(), personID, "Zeus"}
+	s.events.Append([]Event{event1})
+	c.Assert(bytes.Contains(s.baseStore.records[0].Data, []byte(`"name"`)), Equals, true)
+	events, _ := s.events.Load(event1.TestID)
+	c.Assert(events, DeepEquals, []Event{event1})
+
+	s.store.Forget(personID)
+	events, err := s.events.Load(event1.TestID)
+	c.Assert(err, Equals, nil)
+	c.Assert(events, DeepEquals, []Event{TestGuardianEvent{event1.TestID, personID, Redacted}})
+}
+
+func (s *CryptoRecordStoreSuite) Test_Load_WrongKey(c *C) {
+	event1 := TestPersonEvent{NewUUID(), "Athena", 42, true, "event1"}
+	s.events.Append([]Event{event1})
+	s.keyStore.keys[event1.TestID] = make([]byte, 32)
+	_, err := s.events.Load(event1.TestID)
+	c.Assert(err, Equals, ErrDecryptionFailed)
+}
@@ -15,8 +15,10 @@
 package eventhorizon
 
 import (
+	"context"
 	"errors"
 	"reflect"
+	"sort"
 	"strings"
 	"time"
 )
@@ -33,6 +35,37 @@ func (c CommandFieldError) Error() string {
 	return "missing field: " + c.Field
 }
 
+// EventStoreError is returned by the dispatchers when the event store fails
+// to load or append the events of a command, to tell failures of the
+// infrastructure apart from commands that were rejected.
+type EventStoreError struct {
+	Err error
+}
+
+func (e EventStoreError) Error() string {
+	return e.Err.Error()
+}
+
+func (e EventStoreError) Unwrap() error {
+	return e.Err
+}
+
+// eventStoreError wraps an error of the event store in an EventStoreError,
+// unless it rejects a command of another tenant.
+func eventStoreError(err error) error {
+	if errors.Is(err, ErrCrossTenant) {
+		return err
+	}
+	return EventStoreError{err}
+}
+
+// isEventStoreError returns true if the error is a failure of the event
+// store.
+func isEventStoreError(err error) bool {
+	var storeErr EventStoreError
+	return errors.As(err, &storeErr)
+}
+
 // Dispatcher is an interface defining a command and event dispatcher.
 //
 // The dispatch process is as follows:
@@ -47,12 +80,26 @@ type Dispatcher interface {
 	Dispatch(Command) error
 }
 
+// ContextDispatcher is a Dispatcher that can dispatch commands with a context,
+// which carries the principal that authorization policies use.
+type ContextDispatcher interface {
+	Dispatcher
+
+	// DispatchContext dispatches a command with a context.
+	DispatchContext(context.Context, Command) error
+}
+
 // DelegateDispatcher is a dispatcher that dispatches commands and publishes events
 // based on method names.
 type DelegateDispatcher struct {
 	eventStore      EventStore
 	eventBus        EventBus
 	commandHandlers map[reflect.Type]reflect.Type
+	logger          Logger
+	metrics         Metrics
+	tracer          Tracer
+	outbox          *OutboxRelay
+	authorizer      *Authorizer
 }
 
 // NewDelegateDispatcher creates a dispatcher and associates it with an event store.
@@ -61,13 +108,60 @@ func NewDelegateDispatcher(store EventStore, bus EventBus) *DelegateDispatcher {
 		eventStore:      store,
 		eventBus:        bus,
 		commandHandlers: make(map[reflect.Type]reflect.Type),
+		logger:          defaultLogger(),
+		metrics:         nopMetrics{},
+		tracer:          nopTracer{},
+		authorizer:      NewAuthorizer(),
 	}
 	return d
 }
 
+// SetLogger sets the logger used to log dispatched commands.
+func (d *DelegateDispatcher) SetLogger(logger Logger) {
+	d.logger = logger
+}
+
+// SetMetrics sets the metrics used to record dispatched commands.
+func (d *DelegateDispatcher) SetMetrics(metrics Metrics) {
+	d.metrics = metrics
+}
+
+// SetTracer sets the tracer used to trace the phases of handling commands.
+func (d *DelegateDispatcher) SetTracer(tracer Tracer) {
+	d.tracer = tracer
+}
+
+// SetOutboxRelay sets the dispatcher in outbox mode. The event store must be
+// the OutboxStore of the relay, which Validate checks, and events are
+// published by the relay after they have been appended instead of directly on
+// the event bus.
+func (d *DelegateDispatcher) SetOutboxRelay(relay *OutboxRelay) {
+	d.outbox = relay
+}
+
+// SetAuthorizer sets the authorizer of commands. Commands are authorized after
+// the aggregate has been loaded, so that policies can depend on its state.
+func (d *DelegateDispatcher) SetAuthorizer(authorizer *Authorizer) {
+	d.authorizer = authorizer
+}
+
 // Dispatch dispatches a command to the registered command handler.
 // Returns ErrHandlerNotFound if no handler could be found.
 func (d *DelegateDispatcher) Dispatch(command Command) error {
+	return d.DispatchContext(context.Background(), command)
+}
+
+// DispatchContext dispatches a command with a context to the registered
+// command handler. Returns ErrHandlerNotFound if no handler could be found.
+func (d *DelegateDispatcher) DispatchContext(ctx context.Context, command Command) error {
+	start := time.Now()
+	err := d.dispatch(ctx, command)
+	logDispatch(d.logger, command, start, err)
+	recordDispatch(d.metrics, command, start, err)
+	return err
+}
+
+func (d *DelegateDispatcher) dispatch(ctx context.Context, command Command) error {
 	err := checkCommand(command)
 	if err != nil {
 		return err
@@ -75,46 +169,102 @@ func (d *DelegateDispatcher) Dispatch(command Command) error {
 
 	commandType := reflect.TypeOf(command)
 	if aggregateType, ok := d.commandHandlers[commandType]; ok {
-		return d.handleCommand(aggregateType, command)
+		return d.handleCommand(ctx, aggregateType, command)
 	}
 	return ErrHandlerNotFound
 }
 
-// AddHandler adds a handler for a command.
-func (d *DelegateDispatcher) AddHandler(handler CommandHandler, command Command) {
+// AddHandler adds a handler for a command. The handler must be a pointer to a
+// struct that embeds Aggregate and also handles events, and the command must
+// be a struct. An error is returned if they are not, or if the command already
+// has a handler.
+func (d *DelegateDispatcher) AddHandler(handler CommandHandler, command Command) error {
+	if err := checkCommandType(handler, command); err != nil {
+		return err
+	}
+	if err := checkAggregateType(handler, command); err != nil {
+		return err
+	}
+	if _, ok := handler.(EventHandler); !ok {
+		return handlerError(handler, command, ErrInvalidHandler, "does not implement EventHandler")
+	}
+
 	// Check for already existing handler.
 	commandType := reflect.TypeOf(command)
 	if _, ok := d.commandHandlers[commandType]; ok {
-		// TODO: Error here
-		return
+		return handlerError(handler, command, ErrHandlerAlreadyAdded, "command already has a handler")
 	}
 
 	// Add aggregate type to command type.
 	aggregateBaseType := reflect.ValueOf(handler).Elem().Type()
 	d.commandHandlers[commandType] = aggregateBaseType
+	return nil
 }
 
-func (d *DelegateDispatcher) handleCommand(aggregateType reflect.Type, command Command) error {
+// MustAddHandler is like AddHandler but panics if the handler can not be
+// added.
+func (d *DelegateDispatcher) MustAddHandler(handler CommandHandler, command Command) {
+	if err := d.AddHandler(handler, command); err != nil {
+		panic(err)
+	}
+}
+
+// Validate checks the configuration of the dispatcher. It should be called
+// at startup, after adding all handlers.
+func (d *DelegateDispatcher) Validate() error {
+	return validateDispatcher(d.eventStore, d.eventBus, d.outbox, d.Commands())
+}
+
+// Commands returns the zero value of every command with a handler.
+func (d *DelegateDispatcher) Commands() []Command {
+	types := make([]reflect.Type, 0, len(d.commandHandlers))
+	for commandType := range d.commandHandlers {
+		types = append(types, commandType)
+	}
+	return zeroCommands(types)
+}
+
+func (d *DelegateDispatcher) handleCommand(ctx context.Context, aggregateType reflect.Type, command Command) (err error) {
+	commandSpan := startCommandSpan(d.tracer, command)
+	defer func() { endSpan(commandSpan, err) }()
+	sc := commandSpan.SpanContext()
+
 	// Create aggregate from it's type
 	aggregate := d.createAggregate(command.AggregateID(), aggregateType)
 
-	// Load aggregate events
-	events, _ := d.eventStore.Load(aggregate.AggregateID())
-	aggregate.ApplyEvents(events)
+	// Load and apply aggregate events
+	if err := loadAggregate(d.tracer, sc, d.eventStore, aggregate, command); err != nil {
+		return err
+	}
+
+	// Authorize command
+	if err := d.authorizer.Authorize(ctx, command, aggregate); err != nil {
+		return err
+	}
 
 	// Call handler, keep events
+	span := d.tracer.Start(sc, SpanHandleCommand)
 	resultEvents, err := aggregate.(CommandHandler).HandleCommand(command)
+	endSpan(span, err)
 	if err != nil {
 		return err
 	}
+	resultEvents = withTraceContext(resultEvents, sc)
+	resultEvents = withTenant(resultEvents, TenantOf(command))
+	resultEvents = withTimestamp(resultEvents, time.Now())
 
 	// Store events
-	d.eventStore.Append(resultEvents)
+	span = d.tracer.Start(sc, SpanAppend)
+	err = d.eventStore.Append(resultEvents)
+	endSpan(span, err)
+	if err != nil {
+		return eventStoreError(err)
+	}
 
 	// Publish events
-	for _, event := range resultEvents {
-		d.eventBus.PublishEvent(event)
-	}
+	span = d.tracer.Start(sc, SpanPublish)
+	publishEvents(d.eventBus, d.outbox, d.logger, resultEvents)
+	span.End()
 
 	return nil
 }
@@ -134,6 +284,11 @@ type ReflectDispatcher struct {
 	eventStore      EventStore
 	eventBus        EventBus
 	commandHandlers map[reflect.Type]handler
+	logger          Logger
+	metrics         Metrics
+	tracer          Tracer
+	outbox          *OutboxRelay
+	authorizer      *Authorizer
 }
 
 type handler struct {
@@ -147,13 +302,61 @@ func NewReflectDispatcher(store EventStore, bus EventBus) *ReflectDispatcher {
 		eventStore:      store,
 		eventBus:        bus,
 		commandHandlers: make(map[reflect.Type]handler),
+		logger:          defaultLogger(),
+		metrics:         nopMetrics{},
+		tracer:          nopTracer{},
+		authorizer:      NewAuthorizer(),
 	}
 	return d
 }
 
+// SetLogger sets the logger used to log dispatched commands and events
+// without handlers in the aggregates.
+func (d *ReflectDispatcher) SetLogger(logger Logger) {
+	d.logger = logger
+}
+
+// SetMetrics sets the metrics used to record dispatched commands.
+func (d *ReflectDispatcher) SetMetrics(metrics Metrics) {
+	d.metrics = metrics
+}
+
+// SetTracer sets the tracer used to trace the phases of handling commands.
+func (d *ReflectDispatcher) SetTracer(tracer Tracer) {
+	d.tracer = tracer
+}
+
+// SetOutboxRelay sets the dispatcher in outbox mode. The event store must be
+// the OutboxStore of the relay, which Validate checks, and events are
+// published by the relay after they have been appended instead of directly on
+// the event bus.
+func (d *ReflectDispatcher) SetOutboxRelay(relay *OutboxRelay) {
+	d.outbox = relay
+}
+
+// SetAuthorizer sets the authorizer of commands. Commands are authorized after
+// the aggregate has been loaded, so that policies can depend on its state.
+func (d *ReflectDispatcher) SetAuthorizer(authorizer *Authorizer) {
+	d.authorizer = authorizer
+}
+
 // Dispatch dispatches a command to the registered command handler.
 // Returns ErrHandlerNotFound if no handler could be found.
 func (d *ReflectDispatcher) Dispatch(command Command) error {
+	return d.DispatchContext(context.Background(), command)
+}
+
+// DispatchContext dispatches a command with a context to the registered
+// command handler. Returns ErrHandlerNotFound if no handler could be found.
+func (d *ReflectDispatcher) DispatchContext(ctx context.Context, command Command) error {
+	start := time.Now()
+	err := d.dispatch(ctx, command)
+	logDispatch(d.logger, command, start, err)
+	recordDispatch(d.metrics, command, start, err)
+	return err
+}
+
+func (d *ReflectDispatcher) dispatch(ctx context.Context, command Command) error {
 	err := checkCommand(command)
 	if err != nil {
 		return err
@@ -161,7 +364,7 @@ func (d *ReflectDispatcher) Dispatch(command Command) error {
 
 	commandType := reflect.TypeOf(command)
 	if handler, ok := d.commandHandlers[commandType]; ok {
-		return d.handleCommand(handler.sourceType, handler.method, command)
+		return d.handleCommand(ctx, handler.sourceType, handler.method, command)
 	}
 	return ErrHandlerNotFound
 }
@@ -169,29 +372,29 @@ func (d *ReflectDispatcher) Dispatch(command Command) error {
 // AddHandler adds an aggregate as a handler for a command.
 //
 // Handling methods are defined in code by:
-//   func (source *MySource) HandleMyCommand(c MyCommand).
+//   func (source *MySource) HandleMyCommand(c MyCommand) ([]Event, error).
 // When getting the type of this methods by reflection the signature
 // is as following:
-//   func HandleMyCommand(source *MySource, c MyCommand).
-// Only add method that has the correct type.
-func (d *ReflectDispatcher) AddHandler(source interface{}, command Command) {
-	// Check for already existing handler.
-	commandType := reflect.TypeOf(command)
-	if _, ok := d.commandHandlers[commandType]; ok {
-		// TODO: Error here
-		return
+//   func HandleMyCommand(source *MySource, c MyCommand) ([]Event, error).
+// An error is returned if the source has no method with this signature, if
+// it does not embed Aggregate, if the command is not a struct or if the
+// command already has a handler.
+func (d *ReflectDispatcher) AddHandler(source interface{}, command Command) error {
+	if err := checkCommandType(source, command); err != nil {
+		return err
 	}
-
-	// Check for method existance.
-	sourceType := reflect.TypeOf(source)
-	method, ok := sourceType.MethodByName("Handle" + commandType.Name())
-	if !ok {
-		return
+	if err := checkAggregateType(source, command); err != nil {
+		return err
+	}
+	method, err := checkHandlerMethod(source, command)
+	if err != nil {
+		return err
 	}
 
-	// Check method signature.
-	if method.Type.NumIn() != 2 || commandType != method.Type.In(1) {
-		return
+	// Check for already existing handler.
+	commandType := reflect.TypeOf(command)
+	if _, ok := d.commandHandlers[commandType]; ok {
+		return handlerError(source, command, ErrHandlerAlreadyAdded, "command already has a handler")
 	}
 
 	sourceBaseType := reflect.ValueOf(source).Elem().Type()
@@ -201,11 +404,25 @@ func (d *ReflectDispatcher) AddHandler(source interface{}, command Command) {
 		sourceType: sourceBaseType,
 		method:     method,
 	}
+	return nil
+}
+
+// MustAddHandler is like AddHandler but panics if the handler can not be
+// added.
+func (d *ReflectDispatcher) MustAddHandler(source interface{}, command Command) {
+	if err := d.AddHandler(source, command); err != nil {
+		panic(err)
+	}
 }
 
 // AddAllHandlers scans an aggregate for command handling methods and adds
-// it for every event it can handle.
-func (d *ReflectDispatcher) AddAllHandlers(source interface{}) {
+// it for every command it can handle. The errors of all handlers that could
+// not be added are returned.
+func (d *ReflectDispatcher) AddAllHandlers(source interface{}) error {
+	if err := checkAggregateType(source, nil); err != nil {
+		return err
+	}
+	var errs []error
 	sourceType := reflect.TypeOf(source)
 	for i := 0; i < sourceType.NumMethod(); i++ {
 		method := sourceType.Method(i)
@@ -218,55 +435,178 @@ func (d *ReflectDispatcher) AddAllHandlers(source interface{}) {
 			// Only accept methods wich takes an acctual command type.
 			commandType := method.Type.In(1)
 			if command, ok := reflect.Zero(commandType).Interface().(Command); ok {
-				d.AddHandler(source, command)
+				if err := d.AddHandler(source, command); err != nil {
+					errs = append(errs, err)
+				}
 			}
 		}
 	}
+	return errors.Join(errs...)
 }
 
-func (d *ReflectDispatcher) handleCommand(sourceType reflect.Type, method reflect.Method, command Command) error {
+// Validate checks the configuration of the dispatcher. It should be called
+// at startup, after adding all handlers.
+func (d *ReflectDispatcher) Validate() error {
+	return validateDispatcher(d.eventStore, d.eventBus, d.outbox, d.Commands())
+}
+
+// Commands returns the zero value of every command with a handler.
+func (d *ReflectDispatcher) Commands() []Command {
+	types := make([]reflect.Type, 0, len(d.commandHandlers))
+	for commandType := range d.commandHandlers {
+		types = append(types, commandType)
+	}
+	return zeroCommands(types)
+}
+
+func (d *ReflectDispatcher) handleCommand(ctx context.Context, sourceType reflect.Type, method reflect.Method, command Command) (err error) {
+	commandSpan := startCommandSpan(d.tracer, command)
+	defer func() { endSpan(commandSpan, err) }()
+	sc := commandSpan.SpanContext()
+
 	// Create aggregate from source type
 	aggregate := d.createAggregate(command.AggregateID(), sourceType)
 
-	// Load aggregate events
-	events, _ := d.eventStore.Load(aggregate.AggregateID())
-	aggregate.ApplyEvents(events)
+	// Load and apply aggregate events
+	if err := loadAggregate(d.tracer, sc, d.eventStore, aggregate, command); err != nil {
+		return err
+	}
+
+	// Authorize command
+	if err := d.authorizer.Authorize(ctx, command, aggregate); err != nil {
+		return err
+	}
 
 	// Call handler, keep events
+	span := d.tracer.Start(sc, SpanHandleCommand)
 	sourceValue := reflect.ValueOf(aggregate)
 	commandValue := reflect.ValueOf(command)
 	values := method.Func.Call([]reflect.Value{sourceValue, commandValue})
 
-	err := values[1].Interface()
-	if err != nil {
-		return err.(error)
+	if handlerErr := values[1].Interface(); handlerErr != nil {
+		endSpan(span, handlerErr.(error))
+		return handlerErr.(error)
 	}
+	span.End()
 
 	eventsValue := values[0]
 	resultEvents := make([]Event, eventsValue.Len())
 	for i := 0; i < eventsValue.Len(); i++ {
 		resultEvents[i] = eventsValue.Index(i).Interface().(Event)
 	}
+	resultEvents = withTraceContext(resultEvents, sc)
+	resultEvents = withTenant(resultEvents, TenantOf(command))
+	resultEvents = withTimestamp(resultEvents, time.Now())
 
 	// Store events
-	d.eventStore.Append(resultEvents)
+	span = d.tracer.Start(sc, SpanAppend)
+	err = d.eventStore.Append(resultEvents)
+	endSpan(span, err)
+	if err != nil {
+		return eventStoreError(err)
+	}
 
 	// Publish events
-	for _, event := range resultEvents {
-		d.eventBus.PublishEvent(event)
-	}
+	span = d.tracer.Start(sc, SpanPublish)
+	publishEvents(d.eventBus, d.outbox, d.logger, resultEvents)
+	span.End()
 
 	return nil
 }
 
 func (d *ReflectDispatcher) createAggregate(id UUID, sourceType reflect.Type) Aggregate {
 	sourceObj := reflect.New(sourceType)
-	aggregateValue := reflect.ValueOf(NewReflectAggregate(id, sourceObj.Interface()))
+	reflectAggregate := NewReflectAggregate(id, sourceObj.Interface())
+	if handler, ok := reflectAggregate.handler.(*ReflectEventHandler); ok {
+		handler.SetLogger(d.logger)
+	}
+	aggregateValue := reflect.ValueOf(reflectAggregate)
 	sourceObj.Elem().FieldByName("Aggregate").Set(aggregateValue)
 	aggregate := sourceObj.Interface().(Aggregate)
 	return aggregate
 }
 
+// zeroCommands returns the zero values of command types, sorted by type name.
+func zeroCommands(types []reflect.Type) []Command {
+	sort.Slice(types, func(i, j int) bool {
+		return types[i].String() < types[j].String()
+	})
+	commands := make([]Command, len(types))
+	for i, t := range types {
+		commands[i] = reflect.Zero(t).Interface().(Command)
+	}
+	return commands
+}
+
+// startCommandSpan starts the span of handling a command, continuing the trace
+// of the command if it has one.
+func startCommandSpan(tracer Tracer, command Command) Span {
+	span := tracer.Start(SpanContextOf(command), SpanCommand)
+	span.SetAttribute(LogKeyAggregateID, command.AggregateID().String())
+	span.SetAttribute(LogKeyCommandType, reflect.TypeOf(command).String())
+	return span
+}
+
+// loadAggregate streams the events of an aggregate from the event store and
+// applies them one at a time, checking the lifecycle of the command. Reading
+// and applying are interleaved, so their times are recorded as attributes of
+// the load span and its child apply span.
+func loadAggregate(tracer Tracer, sc SpanContext, eventStore EventStore, aggregate Aggregate, command Command) error {
+	span := tracer.Start(sc, SpanLoad)
+	applySpan := tracer.Start(span.SpanContext(), SpanApply)
+	start := time.Now()
+	var read time.Duration
+	check := &lifecycleCheck{command: command}
+	var loadErr error
+	events := timeEvents(StreamEvents(eventStore, aggregate.AggregateID()), &read)
+	err := ApplyEventStream(aggregate, func(yield func(Event, error) bool) {
+		for event, err := range events {
+			if err != nil {
+				loadErr = err
+			} else {
+				err = check.event(event)
+			}
+			if !yield(event, err) || err != nil {
+				return
+			}
+		}
+	})
+	apply := (time.Since(start) - read).String()
+	applySpan.SetAttribute(AttributeApplyDuration, apply)
+	applySpan.End()
+	span.SetAttribute(AttributeReadDuration, read.String())
+	span.SetAttribute(AttributeApplyDuration, apply)
+	endSpan(span, loadErr)
+	if loadErr != nil {
+		return eventStoreError(loadErr)
+	} else if err != nil {
+		return err
+	}
+	return check.done()
+}
+
+// logDispatch logs the result of dispatching a command.
+func logDispatch(logger Logger, command Command, start time.Time, err error) {
+	args := []any{
+		LogKeyAggregateID, command.AggregateID().String(),
+		LogKeyCommandType, reflect.TypeOf(command).String(),
+		LogKeyDuration, time.Since(start),
+	}
+
+	// Rejected commands are part of the normal operation, failures of the
+	// event store are not.
+	switch {
+	case err == nil:
+		logger.Debug("command dispatched", args...)
+	case err == ErrHandlerNotFound:
+		logger.Warn("no handler found for command", args...)
+	case isEventStoreError(err):
+		logger.Warn("command failed", append(args, LogKeyError, err)...)
+	default:
+		logger.Debug("command rejected", append(args, LogKeyError, err)...)
+	}
+}
+
 func checkCommand(c Command) error {
 	st := reflect.TypeOf(c)
 	sv := reflect.ValueOf(c)
@@ -277,8 +617,8 @@ func checkCommand(c Command) error {
 		}
 
 		tag := field.Tag.Get("eh")
-		if tag == "optional" {
-			continue // Optional field.
+		if tag == "optional" || field.Type == metadataType {
+			continue // Optional field or metadata.
 		}
 
 		if isZero(sv.Field(i)) {
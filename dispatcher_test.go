@@ -15,13 +15,14 @@
 package eventhorizon
 
 import (
+	"errors"
 	"fmt"
 	"reflect"
 	"time"
 
 	. "gopkg.in/check.v1"
 
-	t "github.com/looplab/eventhorizon/testing"
+	t "github.com/looplab/eventhorizon/internal/checkers"
 )
 
 var _ = Suite(&DelegateDispatcherSuite{})
@@ -99,15 +100,65 @@ func (s *DelegateDispatcherSuite) Test_Dispatch_ErrorInHandler(c *C) {
 	c.Assert(dispatchedDelegateCommand, Equals, commandError)
 }
 
+func (s *DelegateDispatcherSuite) Test_Dispatch_StreamError(c *C) {
+	id := NewUUID()
+	store := &MockStreamEventStore{err: errors.New("stream error")}
+	store.events = []Event{TestEvent{id, "event1"}}
+	disp := NewDelegateDispatcher(store, s.bus)
+	disp.AddHandler(&TestDelegateDispatcherAggregate{}, TestCommand{})
+	dispatchedDelegateCommand = nil
+	err := disp.Dispatch(TestCommand{id, "command1"})
+	c.Assert(err, ErrorMatches, "stream error")
+	c.Assert(store.loaded, Equals, id)
+	c.Assert(dispatchedDelegateCommand, Equals, nil)
+	c.Assert(s.bus.events, HasLen, 0)
+}
+
 func (s *DelegateDispatcherSuite) Test_Dispatch_NoHandlers(c *C) {
 	command1 := TestCommand{NewUUID(), "command1"}
 	err := s.disp.Dispatch(command1)
 	c.Assert(err, ErrorMatches, "no handlers for command")
 }
 
+func (s *DelegateDispatcherSuite) Test_Dispatch_Logging(c *C) {
+	logger := &MockLogger{}
+	s.disp.SetLogger(logger)
+	command1 := TestCommand{NewUUID(), "command1"}
+	s.disp.Dispatch(command1)
+	s.disp.AddHandler(&TestDelegateDispatcherAggregate{}, TestCommand{})
+	s.disp.Dispatch(command1)
+	s.disp.Dispatch(TestCommand{NewUUID(), "error"})
+	c.Assert(len(logger.records), Equals, 3)
+	c.Assert(logger.records[0].level, Equals, "warn")
+	c.Assert(logger.records[0].msg, Equals, "no handler found for command")
+	c.Assert(logger.records[1].level, Equals, "debug")
+	c.Assert(logger.records[1].msg, Equals, "command dispatched")
+	c.Assert(logger.records[1].fields[LogKeyAggregateID], Equals, command1.TestID.String())
+	c.Assert(logger.records[1].fields[LogKeyCommandType], Equals, "eventhorizon.TestCommand")
+	c.Assert(logger.records[1].fields, t.HasKey, LogKeyDuration)
+	c.Assert(logger.records[2].level, Equals, "debug")
+	c.Assert(logger.records[2].msg, Equals, "command rejected")
+	c.Assert(logger.records[2].fields[LogKeyError], ErrorMatches, "command error")
+}
+
+func (s *DelegateDispatcherSuite) Test_Dispatch_EventStoreError(c *C) {
+	logger := &MockLogger{}
+	storeErr := errors.New("append error")
+	disp := NewDelegateDispatcher(&ErrorEventStore{storeErr}, s.bus)
+	disp.SetLogger(logger)
+	disp.AddHandler(&TestDelegateDispatcherAggregate{}, TestCommand{})
+	err := disp.Dispatch(TestCommand{NewUUID(), "command1"})
+	c.Assert(err, DeepEquals, EventStoreError{storeErr})
+	c.Assert(errors.Is(err, storeErr), Equals, true)
+	c.Assert(len(logger.records), Equals, 1)
+	c.Assert(logger.records[0].level, Equals, "warn")
+	c.Assert(logger.records[0].msg, Equals, "command failed")
+}
+
 func (s *DelegateDispatcherSuite) Test_AddHandler_Simple(c *C) {
 	aggregate := &TestDelegateDispatcherAggregate{}
-	s.disp.AddHandler(aggregate, TestCommand{})
+	err := s.disp.AddHandler(aggregate, TestCommand{})
+	c.Assert(err, IsNil)
 	c.Assert(len(s.disp.commandHandlers), Equals, 1)
 	commandType := reflect.TypeOf(TestCommand{})
 	c.Assert(s.disp.commandHandlers, t.HasKey, commandType)
@@ -117,9 +168,13 @@ func (s *DelegateDispatcherSuite) Test_AddHandler_Simple(c *C) {
 
 func (s *DelegateDispatcherSuite) Test_AddHandler_Duplicate(c *C) {
 	aggregate := &TestDelegateDispatcherAggregate{}
-	s.disp.AddHandler(aggregate, TestCommand{})
+	err := s.disp.AddHandler(aggregate, TestCommand{})
+	c.Assert(err, IsNil)
 	aggregate2 := &TestDelegateDispatcherAggregate{}
-	s.disp.AddHandler(aggregate2, TestCommand{})
+	err = s.disp.AddHandler(aggregate2, TestCommand{})
+	c.Assert(errors.Is(err, ErrHandlerAlreadyAdded), Equals, true)
+	c.Assert(err, ErrorMatches, "handler already added: \\*eventhorizon.TestDelegateDispatcherAggregate "+
+		"for eventhorizon.TestCommand: command already has a handler")
 	c.Assert(len(s.disp.commandHandlers), Equals, 1)
 	commandType := reflect.TypeOf(TestCommand{})
 	c.Assert(s.disp.commandHandlers, t.HasKey, commandType)
@@ -127,6 +182,56 @@ func (s *DelegateDispatcherSuite) Test_AddHandler_Duplicate(c *C) {
 	c.Assert(s.disp.commandHandlers[commandType], Equals, aggregateBaseType)
 }
 
+type TestDelegateNoAggregate struct{}
+
+func (t *TestDelegateNoAggregate) HandleCommand(command Command) ([]Event, error) {
+	return nil, nil
+}
+
+func (t *TestDelegateNoAggregate) HandleEvent(event Event) {}
+
+func (s *DelegateDispatcherSuite) Test_AddHandler_NoAggregateField(c *C) {
+	err := s.disp.AddHandler(&TestDelegateNoAggregate{}, TestCommand{})
+	c.Assert(errors.Is(err, ErrInvalidHandler), Equals, true)
+	c.Assert(err, ErrorMatches, ".*: no embedded Aggregate field")
+	c.Assert(len(s.disp.commandHandlers), Equals, 0)
+}
+
+func (s *DelegateDispatcherSuite) Test_AddHandler_NilCommand(c *C) {
+	err := s.disp.AddHandler(&TestDelegateDispatcherAggregate{}, nil)
+	c.Assert(errors.Is(err, ErrInvalidHandler), Equals, true)
+	c.Assert(err, ErrorMatches, ".*: command is nil")
+	c.Assert(len(s.disp.commandHandlers), Equals, 0)
+}
+
+func (s *DelegateDispatcherSuite) Test_AddHandler_PointerCommand(c *C) {
+	err := s.disp.AddHandler(&TestDelegateDispatcherAggregate{}, &TestCommand{})
+	c.Assert(errors.Is(err, ErrInvalidHandler), Equals, true)
+	c.Assert(err, ErrorMatches, ".* for \\*eventhorizon.TestCommand: command is not a struct")
+	c.Assert(len(s.disp.commandHandlers), Equals, 0)
+}
+
+func (s *DelegateDispatcherSuite) Test_MustAddHandler(c *C) {
+	s.disp.MustAddHandler(&TestDelegateDispatcherAggregate{}, TestCommand{})
+	c.Assert(len(s.disp.commandHandlers), Equals, 1)
+	c.Assert(func() {
+		s.disp.MustAddHandler(&TestDelegateDispatcherAggregate{}, TestCommand{})
+	}, PanicMatches, "handler already added: .*")
+}
+
+func (s *DelegateDispatcherSuite) Test_Validate(c *C) {
+	err := s.disp.Validate()
+	c.Assert(err, Equals, ErrNoHandlers)
+	s.disp.MustAddHandler(&TestDelegateDispatcherAggregate{}, TestCommand{})
+	c.Assert(s.disp.Validate(), IsNil)
+
+	disp := NewDelegateDispatcher(nil, nil)
+	err = disp.Validate()
+	c.Assert(errors.Is(err, ErrNoEventStoreDefined), Equals, true)
+	c.Assert(errors.Is(err, ErrNoEventBusDefined), Equals, true)
+	c.Assert(errors.Is(err, ErrNoHandlers), Equals, true)
+}
+
 type TestGlobalSubscriberDelegateDispatcher struct {
 	handledEvent Event
 }
@@ -236,7 +341,7 @@ func (t *TestSource) HandleTestCommand(command TestCommand) ([]Event, error) {
 	return []Event{TestEvent{command.TestID, command.Content}}, nil
 }
 
-func (t *TestSource) HandleCommandOther2(command TestCommandOther2, invalidParam string) ([]Event, error) {
+func (t *TestSource) HandleTestCommandOther2(command TestCommandOther2, invalidParam string) ([]Event, error) {
 	return nil, nil
 }
 
@@ -293,48 +398,134 @@ func (s *ReflectDispatcherSuite) Test_Dispatch_NoHandlers(c *C) {
 
 func (s *ReflectDispatcherSuite) Test_AddHandler_Simple(c *C) {
 	source := &TestSource{}
-	s.disp.AddHandler(source, TestCommand{})
+	err := s.disp.AddHandler(source, TestCommand{})
+	c.Assert(err, IsNil)
 	c.Assert(len(s.disp.commandHandlers), Equals, 1)
 	commandType := reflect.TypeOf(TestCommand{})
 	c.Assert(s.disp.commandHandlers, t.HasKey, commandType)
 	sourceType := reflect.ValueOf(source).Elem().Type()
-	method, _ := reflect.TypeOf(source).MethodByName("HandleTestCommand")
-	sourceHandler := handler{
-		sourceType: sourceType,
-		method:     method,
-	}
-	c.Assert(s.disp.commandHandlers[commandType], Equals, sourceHandler)
+	c.Assert(s.disp.commandHandlers[commandType].sourceType, Equals, sourceType)
+	c.Assert(s.disp.commandHandlers[commandType].method.Name, Equals, "HandleTestCommand")
 }
 
 func (s *ReflectDispatcherSuite) Test_AddHandler_Duplicate(c *C) {
 	source := &TestSource{}
-	s.disp.AddHandler(source, TestCommand{})
+	err := s.disp.AddHandler(source, TestCommand{})
+	c.Assert(err, IsNil)
 	source2 := &TestSource{}
-	s.disp.AddHandler(source2, TestCommand{})
+	err = s.disp.AddHandler(source2, TestCommand{})
+	c.Assert(errors.Is(err, ErrHandlerAlreadyAdded), Equals, true)
+	c.Assert(err, ErrorMatches, "handler already added: \\*eventhorizon.TestSource "+
+		"for eventhorizon.TestCommand: command already has a handler")
 	c.Assert(len(s.disp.commandHandlers), Equals, 1)
 	commandType := reflect.TypeOf(TestCommand{})
 	c.Assert(s.disp.commandHandlers, t.HasKey, commandType)
 	sourceType := reflect.ValueOf(source).Elem().Type()
-	method, _ := reflect.TypeOf(source).MethodByName("HandleTestCommand")
-	sourceHandler := handler{
-		sourceType: sourceType,
-		method:     method,
-	}
-	c.Assert(s.disp.commandHandlers[commandType], Equals, sourceHandler)
+	c.Assert(s.disp.commandHandlers[commandType].sourceType, Equals, sourceType)
+	c.Assert(s.disp.commandHandlers[commandType].method.Name, Equals, "HandleTestCommand")
 }
 
 func (s *ReflectDispatcherSuite) Test_AddHandler_MissingMethod(c *C) {
 	source := &TestSource{}
-	s.disp.AddHandler(source, TestCommandOther{})
+	err := s.disp.AddHandler(source, TestCommandOther{})
+	c.Assert(errors.Is(err, ErrInvalidHandler), Equals, true)
+	c.Assert(err, ErrorMatches, ".*: no method HandleTestCommandOther")
 	c.Assert(len(s.disp.commandHandlers), Equals, 0)
 }
 
 func (s *ReflectDispatcherSuite) Test_AddHandler_IncorrectMethod(c *C) {
 	source := &TestSource{}
-	s.disp.AddHandler(source, TestCommandOther2{})
+	err := s.disp.AddHandler(source, TestCommandOther2{})
+	c.Assert(errors.Is(err, ErrInvalidHandler), Equals, true)
+	c.Assert(err, ErrorMatches, ".*: method HandleTestCommandOther2 must take only a eventhorizon.TestCommandOther2")
 	c.Assert(len(s.disp.commandHandlers), Equals, 0)
 }
 
+type TestInvalidSource struct {
+	Aggregate
+}
+
+func (t *TestInvalidSource) HandleTestCommandOther(command TestCommandOther) error {
+	return nil
+}
+
+func (s *ReflectDispatcherSuite) Test_AddHandler_IncorrectReturn(c *C) {
+	err := s.disp.AddHandler(&TestInvalidSource{}, TestCommandOther{})
+	c.Assert(errors.Is(err, ErrInvalidHandler), Equals, true)
+	c.Assert(err, ErrorMatches, ".*: method HandleTestCommandOther must return \\(\\[\\]Event, error\\)")
+	c.Assert(len(s.disp.commandHandlers), Equals, 0)
+}
+
+type TestNoAggregateSource struct{}
+
+func (t *TestNoAggregateSource) HandleTestCommand(command TestCommand) ([]Event, error) {
+	return nil, nil
+}
+
+func (s *ReflectDispatcherSuite) Test_AddHandler_NoAggregateField(c *C) {
+	err := s.disp.AddHandler(&TestNoAggregateSource{}, TestCommand{})
+	c.Assert(errors.Is(err, ErrInvalidHandler), Equals, true)
+	c.Assert(err, ErrorMatches, ".*: no embedded Aggregate field")
+	err = s.disp.AddHandler(TestSource{}, TestCommand{})
+	c.Assert(err, ErrorMatches, ".*: not a pointer to a struct")
+	c.Assert(len(s.disp.commandHandlers), Equals, 0)
+}
+
+func (s *ReflectDispatcherSuite) Test_AddHandler_PointerCommand(c *C) {
+	err := s.disp.AddHandler(&TestSource{}, &TestCommand{})
+	c.Assert(errors.Is(err, ErrInvalidHandler), Equals, true)
+	c.Assert(err, ErrorMatches, ".* for \\*eventhorizon.TestCommand: command is not a struct")
+	c.Assert(len(s.disp.commandHandlers), Equals, 0)
+}
+
+func (s *ReflectDispatcherSuite) Test_AddAllHandlers(c *C) {
+	err := s.disp.AddAllHandlers(&TestSource{})
+	c.Assert(err, IsNil)
+	c.Assert(len(s.disp.commandHandlers), Equals, 1)
+	c.Assert(s.disp.commandHandlers, t.HasKey, reflect.TypeOf(TestCommand{}))
+
+	err = s.disp.AddAllHandlers(&TestInvalidSource{})
+	c.Assert(errors.Is(err, ErrInvalidHandler), Equals, true)
+	err = s.disp.AddAllHandlers(&TestNoAggregateSource{})
+	c.Assert(err, ErrorMatches, "invalid handler: \\*eventhorizon.TestNoAggregateSource: no embedded Aggregate field")
+	c.Assert(len(s.disp.commandHandlers), Equals, 1)
+}
+
+func (s *ReflectDispatcherSuite) Test_MustAddHandler(c *C) {
+	s.disp.MustAddHandler(&TestSource{}, TestCommand{})
+	c.Assert(len(s.disp.commandHandlers), Equals, 1)
+	c.Assert(func() {
+		s.disp.MustAddHandler(&TestSource{}, TestCommandOther{})
+	}, PanicMatches, "invalid handler: .*")
+}
+
+type TestInvalidTagCommand struct {
+	_ struct{} `aggregate:"close"`
+
+	TestID UUID
+}
+
+func (t TestInvalidTagCommand) AggregateID() UUID { return t.TestID }
+
+type TestInvalidTagSource struct {
+	Aggregate
+}
+
+func (t *TestInvalidTagSource) HandleTestInvalidTagCommand(command TestInvalidTagCommand) ([]Event, error) {
+	return nil, nil
+}
+
+func (s *ReflectDispatcherSuite) Test_Validate(c *C) {
+	err := s.disp.Validate()
+	c.Assert(err, Equals, ErrNoHandlers)
+	s.disp.MustAddHandler(&TestSource{}, TestCommand{})
+	c.Assert(s.disp.Validate(), IsNil)
+
+	s.disp.MustAddHandler(&TestInvalidTagSource{}, TestInvalidTagCommand{})
+	err = s.disp.Validate()
+	c.Assert(err, ErrorMatches, "invalid handler: eventhorizon.TestInvalidTagCommand has unknown aggregate tag \"close\"")
+}
+
 type TestGlobalSubscriber struct {
 	handledEvent Event
 }
@@ -17,6 +17,7 @@ package eventhorizon
 import (
 	"reflect"
 	"strings"
+	"time"
 )
 
 // EventBus is an interface defining an event bus for distributing events.
@@ -30,6 +31,9 @@ type EventBus interface {
 type HandlerEventBus struct {
 	eventSubscribers  map[reflect.Type][]EventHandler
 	globalSubscribers []EventHandler
+	logger            Logger
+	metrics           Metrics
+	tracer            Tracer
 }
 
 // NewHandlerEventBus creates a HandlerEventBus.
@@ -37,23 +41,76 @@ func NewHandlerEventBus() *HandlerEventBus {
 	b := &HandlerEventBus{
 		eventSubscribers:  make(map[reflect.Type][]EventHandler),
 		globalSubscribers: make([]EventHandler, 0),
+		logger:            defaultLogger(),
+		metrics:           nopMetrics{},
+		tracer:            nopTracer{},
 	}
 	return b
 }
 
+// SetLogger sets the logger used to log published events.
+func (b *HandlerEventBus) SetLogger(logger Logger) {
+	b.logger = logger
+}
+
+// SetMetrics sets the metrics used to record published events.
+func (b *HandlerEventBus) SetMetrics(metrics Metrics) {
+	b.metrics = metrics
+}
+
+// SetTracer sets the tracer used to trace each subscriber handling an event.
+// The spans continue the trace in the metadata of the event.
+func (b *HandlerEventBus) SetTracer(tracer Tracer) {
+	b.tracer = tracer
+}
+
 // PublishEvent publishes an event to all subscribers capable of handling it.
 func (b *HandlerEventBus) PublishEvent(event Event) {
+	start := time.Now()
+	sc := SpanContextOf(event)
+
 	// Publish to specific subscribers.
 	eventType := reflect.TypeOf(event)
-	if subscribers, ok := b.eventSubscribers[eventType]; ok {
-		for _, subscriber := range subscribers {
-			subscriber.HandleEvent(event)
-		}
+	subscribers := b.eventSubscribers[eventType]
+	for _, subscriber := range subscribers {
+		b.handleEvent(sc, subscriber, event)
 	}
 
 	// Publish to global subscribers.
 	for _, subscriber := range b.globalSubscribers {
-		subscriber.HandleEvent(event)
+		b.handleEvent(sc, subscriber, event)
+	}
+
+	duration := time.Since(start)
+	b.logger.Debug("event published",
+		LogKeyAggregateID, event.AggregateID().String(),
+		LogKeyEventType, eventType.String(),
+		LogKeySubscribers, len(subscribers)+len(b.globalSubscribers),
+		LogKeyDuration, duration,
+	)
+
+	labels := Labels{LogKeyEventType: eventType.String()}
+	b.metrics.IncCounter(MetricEventsPublished, labels)
+	b.metrics.ObserveHistogram(MetricPublishDuration, duration.Seconds(), labels)
+}
+
+// handleEvent lets a subscriber handle an event, and records the duration
+// and the lag from the timestamp of the event until it was handled.
+func (b *HandlerEventBus) handleEvent(sc SpanContext, subscriber EventHandler, event Event) {
+	eventType := reflect.TypeOf(event).String()
+	handlerType := reflect.TypeOf(subscriber).String()
+	span := b.tracer.Start(sc, SpanHandleEvent)
+	span.SetAttribute(LogKeyEventType, eventType)
+	span.SetAttribute(LogKeyHandlerType, handlerType)
+	start := time.Now()
+	subscriber.HandleEvent(event)
+	end := time.Now()
+	span.End()
+
+	labels := Labels{LogKeyEventType: eventType, LogKeyHandlerType: handlerType}
+	b.metrics.ObserveHistogram(MetricHandleDuration, end.Sub(start).Seconds(), labels)
+	if timestamp, ok := TimestampOf(event); ok {
+		b.metrics.ObserveHistogram(MetricSubscriberLag, end.Sub(timestamp).Seconds(), labels)
 	}
 }
 
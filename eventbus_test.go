@@ -19,7 +19,7 @@ import (
 
 	. "gopkg.in/check.v1"
 
-	t "github.com/looplab/eventhorizon/testing"
+	t "github.com/looplab/eventhorizon/internal/checkers"
 )
 
 var _ = Suite(&HandlerEventBusSuite{})
@@ -85,6 +85,22 @@ func (s *HandlerEventBusSuite) Test_PublishEvent_NoGlobalHandler(c *C) {
 	c.Assert(handler.event, Equals, event1)
 }
 
+func (s *HandlerEventBusSuite) Test_PublishEvent_Logging(c *C) {
+	logger := &MockLogger{}
+	s.bus.SetLogger(logger)
+	handler := &TestHandlerEventBus{}
+	s.bus.eventSubscribers[reflect.TypeOf(TestEvent{})] = []EventHandler{handler}
+	event1 := TestEvent{NewUUID(), "event1"}
+	s.bus.PublishEvent(event1)
+	c.Assert(len(logger.records), Equals, 1)
+	c.Assert(logger.records[0].level, Equals, "debug")
+	c.Assert(logger.records[0].msg, Equals, "event published")
+	c.Assert(logger.records[0].fields[LogKeyAggregateID], Equals, event1.TestID.String())
+	c.Assert(logger.records[0].fields[LogKeyEventType], Equals, "eventhorizon.TestEvent")
+	c.Assert(logger.records[0].fields[LogKeySubscribers], Equals, 1)
+	c.Assert(logger.records[0].fields, t.HasKey, LogKeyDuration)
+}
+
 func (s *HandlerEventBusSuite) Test_AddSubscriber(c *C) {
 	handler := &TestHandlerEventBus{}
 	s.bus.AddSubscriber(handler, TestEvent{})
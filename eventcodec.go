@@ -0,0 +1,234 @@
+// Copyright (c) 2014 - Max Persson <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventhorizon
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// Error returned when an event type has not been registered with a codec.
+var ErrEventNotRegistered = errors.New("event type not registered")
+
+// Error returned when an event type is registered with the name of another
+// registered type.
+var ErrEventAlreadyRegistered = errors.New("event type already registered")
+
+// Error returned when a stored record can not be upcasted to the current
+// version of its event type.
+var ErrUpcasterNotFound = errors.New("no upcaster for event version")
+
+// Error returned when a chain of upcasters does not terminate.
+var ErrUpcasterLoop = errors.New("upcaster chain does not terminate")
+
+// maxUpcasts limits the number of upcasting steps for a single record.
+const maxUpcasts = 100
+
+// EventRecord is the serialized form of an event as persisted in a store.
+//
+// Type is the registered name of the event type and Version the schema
+// version of the event that the record was encoded with. Data contains the
+// event fields encoded as a JSON object.
+type EventRecord struct {
+	AggregateID UUID            `json:"aggregate_id"`
+	Type        string          `json:"type"`
+	Version     int             `json:"version"`
+	Data        json.RawMessage `json:"data"`
+}
+
+// Upcaster transforms a record from an older schema version to a newer one.
+//
+// An upcaster is registered for a type name and version, and must return
+// records that has either a higher version or another type name. Returning
+// several records splits an event, returning none drops it.
+type Upcaster func(EventRecord) ([]EventRecord, error)
+
+// EventCodec encodes events to records and decodes records back to events.
+//
+// Every event type that should be stored must be registered with its current
+// schema version. Records of older versions are transformed by the chain of
+// upcasters registered for the type before they are decoded.
+type EventCodec struct {
+	types     map[string]eventType
+	names     map[reflect.Type]string
+	upcasters map[upcasterKey]Upcaster
+}
+
+type eventType struct {
+	eventType reflect.Type
+	version   int
+}
+
+type upcasterKey struct {
+	name    string
+	version int
+}
+
+// NewEventCodec creates a new EventCodec.
+func NewEventCodec() *EventCodec {
+	c := &EventCodec{
+		types:     make(map[string]eventType),
+		names:     make(map[reflect.Type]string),
+		upcasters: make(map[upcasterKey]Upcaster),
+	}
+	return c
+}
+
+// RegisterEvent registers an event type with its current schema version. The
+// name of the Go type, without its package, is used as type name in the
+// records; registering another type with the same name returns
+// ErrEventAlreadyRegistered. Registering a type again updates its version.
+func (c *EventCodec) RegisterEvent(event Event, version int) error {
+	t := reflect.TypeOf(event)
+	name := eventTypeName(t)
+	if registered, ok := c.types[name]; ok && registered.eventType != t {
+		return fmt.Errorf("%w: %s", ErrEventAlreadyRegistered, name)
+	}
+	c.types[name] = eventType{t, version}
+	c.names[t] = name
+	return nil
+}
+
+// AddUpcaster adds an upcaster for records of a type name and version.
+func (c *EventCodec) AddUpcaster(name string, version int, upcaster Upcaster) {
+	c.upcasters[upcasterKey{name, version}] = upcaster
+}
+
+// TypeName returns the registered type name of an event.
+// Returns ErrEventNotRegistered if the event type is not registered.
+func (c *EventCodec) TypeName(event Event) (string, error) {
+	if name, ok := c.names[reflect.TypeOf(event)]; ok {
+		return name, nil
+	}
+	return "", ErrEventNotRegistered
+}
+
+// Encode encodes an event to a record with the current schema version.
+// Returns ErrEventNotRegistered if the event type is not registered.
+func (c *EventCodec) Encode(event Event) (EventRecord, error) {
+	name, err := c.TypeName(event)
+	if err != nil {
+		return EventRecord{}, fmt.Errorf("%w: %T", err, event)
+	}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		return EventRecord{}, err
+	}
+
+	return EventRecord{
+		AggregateID: event.AggregateID(),
+		Type:        name,
+		Version:     c.types[name].version,
+		Data:        data,
+	}, nil
+}
+
+// Decode decodes a record to events, upcasting it to the current version of
+// its type first. An upcasted record can result in any number of events.
+func (c *EventCodec) Decode(record EventRecord) ([]Event, error) {
+	records, err := c.Upcast(record)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make([]Event, 0, len(records))
+	for _, r := range records {
+		event, err := c.decode(r)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+// Upcast applies the chain of upcasters to a record until all resulting
+// records are of the current version of their type.
+func (c *EventCodec) Upcast(record EventRecord) ([]EventRecord, error) {
+	return c.upcast(record, 0)
+}
+
+func (c *EventCodec) upcast(record EventRecord, depth int) ([]EventRecord, error) {
+	if t, ok := c.types[record.Type]; ok && t.version == record.Version {
+		return []EventRecord{record}, nil
+	}
+
+	if depth >= maxUpcasts {
+		return nil, fmt.Errorf("%w: %s v%d", ErrUpcasterLoop, record.Type, record.Version)
+	}
+
+	upcaster, ok := c.upcasters[upcasterKey{record.Type, record.Version}]
+	if !ok {
+		if _, ok := c.types[record.Type]; !ok {
+			return nil, fmt.Errorf("%w: %s", ErrEventNotRegistered, record.Type)
+		}
+		return nil, fmt.Errorf("%w: %s v%d", ErrUpcasterNotFound, record.Type, record.Version)
+	}
+
+	upcasted, err := upcaster(record)
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]EventRecord, 0, len(upcasted))
+	for _, r := range upcasted {
+		if r.AggregateID == "" {
+			r.AggregateID = record.AggregateID
+		}
+		result, err := c.upcast(r, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, result...)
+	}
+	return records, nil
+}
+
+// goType returns the Go type registered for a type name.
+func (c *EventCodec) goType(name string) (reflect.Type, bool) {
+	t, ok := c.types[name]
+	return t.eventType, ok
+}
+
+func (c *EventCodec) decode(record EventRecord) (Event, error) {
+	t := c.types[record.Type].eventType
+
+	var value reflect.Value
+	if t.Kind() == reflect.Ptr {
+		value = reflect.New(t.Elem())
+		if err := json.Unmarshal(record.Data, value.Interface()); err != nil {
+			return nil, err
+		}
+	} else {
+		ptr := reflect.New(t)
+		if err := json.Unmarshal(record.Data, ptr.Interface()); err != nil {
+			return nil, err
+		}
+		value = ptr.Elem()
+	}
+
+	return value.Interface().(Event), nil
+}
+
+// eventTypeName returns the name of an event type, without any pointer.
+func eventTypeName(t reflect.Type) string {
+	if t.Kind() == reflect.Ptr {
+		return t.Elem().Name()
+	}
+	return t.Name()
+}
@@ -0,0 +1,168 @@
+// Copyright (c) 2014 - Max Persson <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventhorizon
+
+import (
+	"encoding/json"
+	"errors"
+
+	. "gopkg.in/check.v1"
+)
+
+var _ = Suite(&EventCodecSuite{})
+
+type EventCodecSuite struct {
+	codec *EventCodec
+}
+
+func (s *EventCodecSuite) SetUpTest(c *C) {
+	s.codec = NewEventCodec()
+	s.codec.RegisterEvent(TestEvent{}, 2)
+	s.codec.RegisterEvent(TestEventOther{}, 1)
+
+	// Version 1 of TestEvent had the field Content named Text.
+	s.codec.AddUpcaster("TestEvent", 1, func(r EventRecord) ([]EventRecord, error) {
+		var data map[string]interface{}
+		if err := json.Unmarshal(r.Data, &data); err != nil {
+			return nil, err
+		}
+		data["Content"] = data["Text"]
+		delete(data, "Text")
+		r.Data, _ = json.Marshal(data)
+		r.Version = 2
+		return []EventRecord{r}, nil
+	})
+
+	// TestEventCombined was split into TestEvent v1 and TestEventOther.
+	s.codec.AddUpcaster("TestEventCombined", 1, func(r EventRecord) ([]EventRecord, error) {
+		var data map[string]interface{}
+		if err := json.Unmarshal(r.Data, &data); err != nil {
+			return nil, err
+		}
+		first, _ := json.Marshal(map[string]interface{}{
+			"TestID": data["TestID"],
+			"Text":   data["First"],
+		})
+		second, _ := json.Marshal(map[string]interface{}{
+			"TestID":  data["TestID"],
+			"Content": data["Second"],
+		})
+		return []EventRecord{
+			{Type: "TestEvent", Version: 1, Data: first},
+			{Type: "TestEventOther", Version: 1, Data: second},
+		}, nil
+	})
+}
+
+func (s *EventCodecSuite) Test_Encode(c *C) {
+	event1 := TestEvent{NewUUID(), "event1"}
+	record, err := s.codec.Encode(event1)
+	c.Assert(err, Equals, nil)
+	c.Assert(record.AggregateID, Equals, event1.TestID)
+	c.Assert(record.Type, Equals, "TestEvent")
+	c.Assert(record.Version, Equals, 2)
+}
+
+func (s *EventCodecSuite) Test_Encode_NotRegistered(c *C) {
+	event1 := &TestEventOther2{NewUUID()}
+	_, err := s.codec.Encode(event1)
+	c.Assert(err, ErrorMatches, "event type not registered: .*TestEventOther2")
+}
+
+func (s *EventCodecSuite) Test_RegisterEvent_NameCollision(c *C) {
+	// A type from another scope with the same name as a registered type.
+	type TestEvent struct {
+		TestEventOther
+	}
+	err := s.codec.RegisterEvent(TestEvent{}, 1)
+	c.Assert(errors.Is(err, ErrEventAlreadyRegistered), Equals, true)
+	c.Assert(s.codec.RegisterEvent(&TestEventOther{}, 1), ErrorMatches,
+		"event type already registered: TestEventOther")
+
+	// Registering the same type again updates the version.
+	c.Assert(s.codec.RegisterEvent(TestEventOther{}, 2), Equals, nil)
+	record, err := s.codec.Encode(TestEventOther{NewUUID(), "event1"})
+	c.Assert(err, Equals, nil)
+	c.Assert(record.Version, Equals, 2)
+}
+
+func (s *EventCodecSuite) Test_Decode_CurrentVersion(c *C) {
+	event1 := TestEvent{NewUUID(), "event1"}
+	record, _ := s.codec.Encode(event1)
+	events, err := s.codec.Decode(record)
+	c.Assert(err, Equals, nil)
+	c.Assert(events, DeepEquals, []Event{event1})
+}
+
+func (s *EventCodecSuite) Test_Decode_Pointer(c *C) {
+	s.codec.RegisterEvent(&TestEventOther2{}, 1)
+	event1 := &TestEventOther2{NewUUID()}
+	record, _ := s.codec.Encode(event1)
+	events, err := s.codec.Decode(record)
+	c.Assert(err, Equals, nil)
+	c.Assert(events, DeepEquals, []Event{event1})
+}
+
+func (s *EventCodecSuite) Test_Decode_Upcast(c *C) {
+	id := NewUUID()
+	data, _ := json.Marshal(map[string]interface{}{"TestID": id, "Text": "event1"})
+	record := EventRecord{id, "TestEvent", 1, data}
+	events, err := s.codec.Decode(record)
+	c.Assert(err, Equals, nil)
+	c.Assert(events, DeepEquals, []Event{TestEvent{id, "event1"}})
+}
+
+func (s *EventCodecSuite) Test_Decode_Split(c *C) {
+	id := NewUUID()
+	data, _ := json.Marshal(map[string]interface{}{
+		"TestID": id,
+		"First":  "event1",
+		"Second": "event2",
+	})
+	record := EventRecord{id, "TestEventCombined", 1, data}
+	events, err := s.codec.Decode(record)
+	c.Assert(err, Equals, nil)
+	c.Assert(events, DeepEquals, []Event{
+		TestEvent{id, "event1"},
+		TestEventOther{id, "event2"},
+	})
+}
+
+func (s *EventCodecSuite) Test_Decode_NoUpcaster(c *C) {
+	record := EventRecord{NewUUID(), "TestEventOther", 0, []byte("{}")}
+	_, err := s.codec.Decode(record)
+	c.Assert(err, ErrorMatches, "no upcaster for event version: TestEventOther v0")
+}
+
+func (s *EventCodecSuite) Test_Decode_NotRegistered(c *C) {
+	record := EventRecord{NewUUID(), "Unknown", 1, []byte("{}")}
+	_, err := s.codec.Decode(record)
+	c.Assert(err, ErrorMatches, "event type not registered: Unknown")
+}
+
+func (s *EventCodecSuite) Test_Decode_UpcasterLoop(c *C) {
+	s.codec.AddUpcaster("TestEventOther", 0, func(r EventRecord) ([]EventRecord, error) {
+		return []EventRecord{r}, nil
+	})
+	record := EventRecord{NewUUID(), "TestEventOther", 0, []byte("{}")}
+	_, err := s.codec.Decode(record)
+	c.Assert(err, ErrorMatches, "upcaster chain does not terminate: TestEventOther v0")
+}
+
+type TestEventOther2 struct {
+	TestID UUID
+}
+
+func (t *TestEventOther2) AggregateID() UUID { return t.TestID }
@@ -0,0 +1,347 @@
+// Copyright (c) 2014 - Max Persson <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventhorizon
+
+import (
+	"encoding/json"
+	"fmt"
+	"iter"
+	"net/http"
+	"reflect"
+	"strconv"
+	"sync"
+)
+
+// DefaultFeedBufferSize is the number of events buffered for each client of
+// an EventFeed.
+const DefaultFeedBufferSize = 256
+
+// EventFeed is an EventHandler that streams published events to HTTP clients
+// as Server-Sent Events. It should be added as a global subscriber of the
+// event bus that the dispatcher publishes to.
+//
+// Clients can filter the feed by event type and aggregate ID:
+//
+//	GET /?type=InviteAccepted&type=InviteDeclined&aggregate_id={id}
+//
+// Every event is sent with its position in the global event stream of the
+// event store as id, which is the sequence that the store added to its
+// metadata, see SequenceOf. Events without a sequence are counted by the feed
+// instead, which only matches the store if it has a single publisher that
+// publishes in the order of the store. A client that reconnects with a
+// Last-Event-ID header gets the events it has missed replayed from the store
+// before the live events, seeking to its last event if the store is a
+// SequenceStreamer.
+//
+// Each client has a buffer of events. A client that is too slow to keep its
+// buffer from filling up is disconnected instead of blocking the event bus, and
+// will catch up by replaying from its last event when it reconnects.
+type EventFeed struct {
+	store      GlobalEventStore
+	position   int
+	clients    map[*feedClient]bool
+	clientsMu  sync.Mutex
+	bufferSize int
+	logger     Logger
+}
+
+type feedClient struct {
+	events  chan feedEvent
+	dropped chan struct{}
+	filter  feedFilter
+	live    bool
+}
+
+type feedEvent struct {
+	position int
+	event    Event
+}
+
+type feedFilter struct {
+	types        map[string]bool
+	aggregateIDs map[UUID]bool
+}
+
+// NewEventFeed creates an EventFeed that replays missed events from a store.
+// The position of the feed starts at the end of the events in the store.
+func NewEventFeed(store GlobalEventStore) (*EventFeed, error) {
+	position := 0
+	for event, err := range streamAllEvents(store) {
+		if err != nil {
+			return nil, err
+		}
+		position = feedPosition(event, position+1)
+	}
+
+	f := &EventFeed{
+		store:      store,
+		position:   position,
+		clients:    make(map[*feedClient]bool),
+		bufferSize: DefaultFeedBufferSize,
+		logger:     defaultLogger(),
+	}
+	return f, nil
+}
+
+// SetBufferSize sets the number of events buffered for each new client.
+func (f *EventFeed) SetBufferSize(size int) {
+	f.bufferSize = size
+}
+
+// SetLogger sets the logger used to log disconnected clients.
+func (f *EventFeed) SetLogger(logger Logger) {
+	f.logger = logger
+}
+
+// HandleEvent sends an event to all clients with a matching filter. Clients
+// with a full buffer are disconnected.
+func (f *EventFeed) HandleEvent(event Event) {
+	f.clientsMu.Lock()
+	defer f.clientsMu.Unlock()
+
+	fe := feedEvent{feedPosition(event, f.position+1), event}
+	if fe.position > f.position {
+		f.position = fe.position
+	}
+	for client := range f.clients {
+		if !client.live || !client.filter.match(event) {
+			continue
+		}
+		select {
+		case client.events <- fe:
+		default:
+			close(client.dropped)
+			delete(f.clients, client)
+			f.logger.Warn("event feed client too slow, disconnecting",
+				LogKeyEventType, reflect.TypeOf(event).String(),
+			)
+		}
+	}
+}
+
+// ServeHTTP streams events to the client until it disconnects.
+func (f *EventFeed) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		writeGatewayError(w, http.StatusMethodNotAllowed,
+			GatewayError{Code: "method_not_allowed", Message: "only GET is allowed"})
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeGatewayError(w, http.StatusInternalServerError,
+			GatewayError{Code: "internal_error", Message: "streaming is not supported"})
+		return
+	}
+
+	filter, err := parseFeedFilter(r)
+	if err != nil {
+		writeGatewayError(w, http.StatusBadRequest,
+			GatewayError{Code: "invalid_id", Message: err.Error(), Field: "aggregate_id"})
+		return
+	}
+
+	lastID := -1
+	if header := r.Header.Get("Last-Event-ID"); header != "" {
+		lastID, err = strconv.Atoi(header)
+		if err != nil || lastID < 0 {
+			writeGatewayError(w, http.StatusBadRequest,
+				GatewayError{Code: "invalid_last_event_id", Message: "Last-Event-ID must be a positive integer"})
+			return
+		}
+	}
+
+	// A client that replays is live once the replay has reached the live
+	// events, so that a long replay does not fill up its buffer.
+	client := f.subscribe(filter, lastID < 0)
+	defer f.unsubscribe(client)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, ": connected\n\n")
+
+	// Events published out of order can be both replayed and live, and are
+	// only sent once.
+	replayed := lastID
+	if lastID >= 0 {
+		if replayed, err = f.replay(w, client, lastID); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-client.dropped:
+			return
+		case fe := <-client.events:
+			if fe.position <= replayed {
+				continue
+			}
+			if err := writeFeedEvent(w, fe); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// replay sends the events after a position in the store to a client, until
+// the replay reaches the position of the feed, and then makes the client live.
+// It returns the position of the last replayed event.
+func (f *EventFeed) replay(w http.ResponseWriter, client *feedClient, after int) (int, error) {
+	for {
+		position := after
+		for fe, err := range f.storedEvents(after) {
+			if err != nil {
+				f.logger.Error("could not replay events to event feed client",
+					LogKeyError, err,
+				)
+				return position, err
+			}
+			position = fe.position
+			if !client.filter.match(fe.event) {
+				continue
+			}
+			if err := writeFeedEvent(w, fe); err != nil {
+				return position, err
+			}
+		}
+
+		// The store can be behind the feed if events are published that
+		// are not in it, which should not keep the client replaying.
+		f.clientsMu.Lock()
+		if position >= f.position || position == after {
+			client.live = true
+			f.clientsMu.Unlock()
+			return position, nil
+		}
+		f.clientsMu.Unlock()
+		after = position
+	}
+}
+
+// storedEvents streams the events after a position from the store, seeking
+// to it if the store is a SequenceStreamer.
+func (f *EventFeed) storedEvents(after int) iter.Seq2[feedEvent, error] {
+	return func(yield func(feedEvent, error) bool) {
+		if streamer, ok := f.store.(SequenceStreamer); ok {
+			index := after
+			for event, err := range streamer.StreamAfter(after) {
+				if err != nil {
+					yield(feedEvent{}, err)
+					return
+				}
+				index++
+				if !yield(feedEvent{feedPosition(event, index), event}, nil) {
+					return
+				}
+			}
+			return
+		}
+
+		index := 0
+		for event, err := range streamAllEvents(f.store) {
+			if err != nil {
+				yield(feedEvent{}, err)
+				return
+			}
+			index++
+			fe := feedEvent{feedPosition(event, index), event}
+			if fe.position <= after {
+				continue
+			}
+			if !yield(fe, nil) {
+				return
+			}
+		}
+	}
+}
+
+// feedPosition returns the sequence of an event, or the position that it is
+// counted at if it has none.
+func feedPosition(event Event, counted int) int {
+	if sequence, ok := SequenceOf(event); ok {
+		return sequence
+	}
+	return counted
+}
+
+// subscribe adds a client, which is sent the live events if it is live.
+func (f *EventFeed) subscribe(filter feedFilter, live bool) *feedClient {
+	client := &feedClient{
+		events:  make(chan feedEvent, f.bufferSize),
+		dropped: make(chan struct{}),
+		filter:  filter,
+		live:    live,
+	}
+
+	f.clientsMu.Lock()
+	defer f.clientsMu.Unlock()
+	f.clients[client] = true
+	return client
+}
+
+func (f *EventFeed) unsubscribe(client *feedClient) {
+	f.clientsMu.Lock()
+	defer f.clientsMu.Unlock()
+	delete(f.clients, client)
+}
+
+func parseFeedFilter(r *http.Request) (feedFilter, error) {
+	filter := feedFilter{}
+	query := r.URL.Query()
+	if types := query["type"]; len(types) > 0 {
+		filter.types = make(map[string]bool)
+		for _, t := range types {
+			filter.types[t] = true
+		}
+	}
+	if ids := query["aggregate_id"]; len(ids) > 0 {
+		filter.aggregateIDs = make(map[UUID]bool)
+		for _, rawID := range ids {
+			id, err := ParseUUID(rawID)
+			if err != nil {
+				return feedFilter{}, err
+			}
+			filter.aggregateIDs[id] = true
+		}
+	}
+	return filter, nil
+}
+
+func (f feedFilter) match(event Event) bool {
+	if f.types != nil && !f.types[eventTypeName(reflect.TypeOf(event))] {
+		return false
+	}
+	if f.aggregateIDs != nil && !f.aggregateIDs[event.AggregateID()] {
+		return false
+	}
+	return true
+}
+
+func writeFeedEvent(w http.ResponseWriter, fe feedEvent) error {
+	data, err := json.Marshal(fe.event)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n",
+		fe.position, eventTypeName(reflect.TypeOf(fe.event)), data)
+	return err
+}
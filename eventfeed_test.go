@@ -0,0 +1,243 @@
+// Copyright (c) 2014 - Max Persson <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventhorizon
+
+import (
+	"bufio"
+	"context"
+	"iter"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+var _ = Suite(&EventFeedSuite{})
+
+type EventFeedSuite struct {
+	store  *MemoryEventStore
+	feed   *EventFeed
+	server *httptest.Server
+}
+
+func (s *EventFeedSuite) SetUpTest(c *C) {
+	s.store = NewMemoryEventStore()
+	var err error
+	s.feed, err = NewEventFeed(s.store)
+	c.Assert(err, Equals, nil)
+	s.server = httptest.NewServer(s.feed)
+}
+
+func (s *EventFeedSuite) TearDownTest(c *C) {
+	s.server.Close()
+}
+
+// connect connects to the feed and reads until the client is subscribed.
+func (s *EventFeedSuite) connect(c *C, query, lastID string) (*bufio.Reader, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	r, _ := http.NewRequestWithContext(ctx, "GET", s.server.URL+query, nil)
+	if lastID != "" {
+		r.Header.Set("Last-Event-ID", lastID)
+	}
+	resp, err := http.DefaultClient.Do(r)
+	c.Assert(err, Equals, nil)
+	c.Assert(resp.StatusCode, Equals, http.StatusOK)
+	c.Assert(resp.Header.Get("Content-Type"), Equals, "text/event-stream")
+	reader := bufio.NewReader(resp.Body)
+	c.Assert(readFeedMessage(c, reader), Equals, ": connected\n")
+	return reader, cancel
+}
+
+func (s *EventFeedSuite) publish(events ...Event) {
+	s.store.Append(events)
+	for _, event := range events {
+		s.feed.HandleEvent(event)
+	}
+}
+
+func readFeedMessage(c *C, reader *bufio.Reader) string {
+	message := ""
+	for {
+		line, err := reader.ReadString('\n')
+		c.Assert(err, Equals, nil)
+		if line == "\n" {
+			return message
+		}
+		message += line
+	}
+}
+
+func (s *EventFeedSuite) Test_Live(c *C) {
+	reader, cancel := s.connect(c, "/", "")
+	defer cancel()
+
+	event1 := TestEvent{NewUUID(), "event1"}
+	s.publish(event1)
+	c.Assert(readFeedMessage(c, reader), Equals,
+		"id: 1\nevent: TestEvent\ndata: {\"TestID\":\""+event1.TestID.String()+"\",\"Content\":\"event1\"}\n")
+}
+
+func (s *EventFeedSuite) Test_Filter(c *C) {
+	id := NewUUID()
+	reader, cancel := s.connect(c, "/?type=TestEvent&aggregate_id="+id.String(), "")
+	defer cancel()
+
+	s.publish(
+		TestEventOther{id, "event1"},
+		TestEvent{NewUUID(), "event2"},
+		TestEvent{id, "event3"},
+	)
+	message := readFeedMessage(c, reader)
+	c.Assert(strings.HasPrefix(message, "id: 3\nevent: TestEvent\n"), Equals, true)
+	c.Assert(message, Matches, `(?s).*"event3".*`)
+}
+
+func (s *EventFeedSuite) Test_Filter_InvalidID(c *C) {
+	resp, err := http.Get(s.server.URL + "/?aggregate_id=invalid")
+	c.Assert(err, Equals, nil)
+	c.Assert(resp.StatusCode, Equals, http.StatusBadRequest)
+}
+
+func (s *EventFeedSuite) Test_Replay(c *C) {
+	id := NewUUID()
+	s.publish(
+		TestEvent{id, "event1"},
+		TestEvent{NewUUID(), "event2"},
+		TestEvent{id, "event3"},
+	)
+
+	reader, cancel := s.connect(c, "/?aggregate_id="+id.String(), "1")
+	defer cancel()
+	c.Assert(readFeedMessage(c, reader), Matches, `(?s)id: 3\n.*"event3".*`)
+
+	s.publish(TestEvent{id, "event4"})
+	c.Assert(readFeedMessage(c, reader), Matches, `(?s)id: 4\n.*"event4".*`)
+}
+
+// seekingEventStore is a MemoryEventStore that records the positions it is
+// streamed after, and can publish events while it is streamed.
+type seekingEventStore struct {
+	*MemoryEventStore
+	after   []int
+	publish func()
+}
+
+func (s *seekingEventStore) StreamAfter(position int) iter.Seq2[Event, error] {
+	s.after = append(s.after, position)
+	events := s.MemoryEventStore.StreamAfter(position)
+	return func(yield func(Event, error) bool) {
+		for event, err := range events {
+			if !yield(event, err) {
+				return
+			}
+			if s.publish != nil {
+				publish := s.publish
+				s.publish = nil
+				publish()
+			}
+		}
+	}
+}
+
+func (s *EventFeedSuite) Test_Replay_Seek(c *C) {
+	store := &seekingEventStore{MemoryEventStore: s.store}
+	var err error
+	s.feed, err = NewEventFeed(store)
+	c.Assert(err, Equals, nil)
+	s.server.Close()
+	s.server = httptest.NewServer(s.feed)
+	s.feed.SetBufferSize(1)
+	for i := 0; i < 5; i++ {
+		s.publish(TestEvent{NewUUID(), "event"})
+	}
+
+	// Events published during the replay are replayed, and do not fill up
+	// the buffer of the client.
+	store.publish = func() {
+		s.publish(
+			TestEvent{NewUUID(), "event6"},
+			TestEvent{NewUUID(), "event7"},
+			TestEvent{NewUUID(), "event8"},
+		)
+	}
+	reader, cancel := s.connect(c, "/", "3")
+	defer cancel()
+	for _, id := range []string{"4", "5", "6", "7", "8"} {
+		c.Assert(readFeedMessage(c, reader), Matches, `(?s)id: `+id+`\n.*`)
+	}
+	c.Assert(store.after, DeepEquals, []int{3, 5})
+
+	s.publish(TestEvent{NewUUID(), "event9"})
+	c.Assert(readFeedMessage(c, reader), Matches, `(?s)id: 9\n.*"event9".*`)
+}
+
+func (s *EventFeedSuite) Test_OutOfOrder(c *C) {
+	// Events are published in another order than they were appended.
+	eventA := []Event{TestTracedEvent{TestID: NewUUID(), Content: "a"}}
+	eventB := []Event{TestTracedEvent{TestID: NewUUID(), Content: "b"}}
+	s.store.Append(eventA)
+	s.store.Append(eventB)
+	reader, cancel := s.connect(c, "/", "")
+	defer cancel()
+	s.feed.HandleEvent(eventB[0])
+	c.Assert(readFeedMessage(c, reader), Matches, `(?s)id: 2\n.*"b".*`)
+
+	// A client that reconnects after b gets a replayed once.
+	replayReader, replayCancel := s.connect(c, "/", "0")
+	defer replayCancel()
+	c.Assert(readFeedMessage(c, replayReader), Matches, `(?s)id: 1\n.*"a".*`)
+	c.Assert(readFeedMessage(c, replayReader), Matches, `(?s)id: 2\n.*"b".*`)
+
+	s.feed.HandleEvent(eventA[0])
+	c.Assert(readFeedMessage(c, reader), Matches, `(?s)id: 1\n.*"a".*`)
+	s.publish(TestTracedEvent{TestID: NewUUID(), Content: "c"})
+	c.Assert(readFeedMessage(c, reader), Matches, `(?s)id: 3\n.*"c".*`)
+	c.Assert(readFeedMessage(c, replayReader), Matches, `(?s)id: 3\n.*"c".*`)
+}
+
+func (s *EventFeedSuite) Test_Replay_StartsAtStore(c *C) {
+	s.store.Append([]Event{TestEvent{NewUUID(), "event1"}})
+	feed, err := NewEventFeed(s.store)
+	c.Assert(err, Equals, nil)
+	c.Assert(feed.position, Equals, 1)
+}
+
+func (s *EventFeedSuite) Test_Replay_InvalidLastEventID(c *C) {
+	r, _ := http.NewRequest("GET", s.server.URL, nil)
+	r.Header.Set("Last-Event-ID", "x")
+	resp, err := http.DefaultClient.Do(r)
+	c.Assert(err, Equals, nil)
+	c.Assert(resp.StatusCode, Equals, http.StatusBadRequest)
+}
+
+func (s *EventFeedSuite) Test_SlowClient(c *C) {
+	logger := &MockLogger{}
+	s.feed.SetLogger(logger)
+	s.feed.SetBufferSize(1)
+	client := s.feed.subscribe(feedFilter{}, true)
+	s.feed.HandleEvent(TestEvent{NewUUID(), "event1"})
+	s.feed.HandleEvent(TestEvent{NewUUID(), "event2"})
+	<-client.dropped
+	c.Assert(len(s.feed.clients), Equals, 0)
+	c.Assert(len(client.events), Equals, 1)
+	c.Assert(logger.records[0].level, Equals, "warn")
+}
+
+func (s *EventFeedSuite) Test_MethodNotAllowed(c *C) {
+	resp, err := http.Post(s.server.URL, "text/plain", nil)
+	c.Assert(err, Equals, nil)
+	c.Assert(resp.StatusCode, Equals, http.StatusMethodNotAllowed)
+}
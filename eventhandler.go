@@ -15,7 +15,6 @@
 package eventhorizon
 
 import (
-	"log"
 	"reflect"
 	"strings"
 )
@@ -43,6 +42,7 @@ type handlersMap map[reflect.Type]reflect.Method
 type ReflectEventHandler struct {
 	source   interface{}
 	handlers handlersMap
+	logger   Logger
 }
 
 func init() {
@@ -53,41 +53,47 @@ func init() {
 // events based on method names.
 func NewReflectEventHandler(source interface{}, methodPrefix string) *ReflectEventHandler {
 	if source == nil {
-		return &ReflectEventHandler{}
+		return &ReflectEventHandler{logger: defaultLogger()}
 	}
 
 	if methodPrefix == "" {
-		return &ReflectEventHandler{}
+		return &ReflectEventHandler{logger: defaultLogger()}
 	}
 
 	var handlers handlersMap
 	sourceType := reflect.TypeOf(source)
 	if value, ok := cache[cacheItem{sourceType, methodPrefix}]; ok {
 		handlers = value
-		// log.Printf("load from cache: %s", sourceType)
 	} else {
 		handlers = createEventHandlersForType(sourceType, methodPrefix)
 		cache[cacheItem{sourceType, methodPrefix}] = handlers
-		// log.Printf("write to cache: %s", sourceType)
 	}
 
 	return &ReflectEventHandler{
 		source:   source,
 		handlers: handlers,
+		logger:   defaultLogger(),
 	}
 }
 
+// SetLogger sets the logger used to report events without handlers.
+func (h *ReflectEventHandler) SetLogger(logger Logger) {
+	h.logger = logger
+}
+
 // HandleEvent handles an event by routing it to the handler method of the source.
 func (h *ReflectEventHandler) HandleEvent(event Event) {
-	// log.Printf("Routing %+v", event)
 	// TODO: Add error return.
 
 	eventType := reflect.TypeOf(event)
 	if handler, ok := h.handlers[eventType]; ok {
 		h.handleEvent(handler, event)
 	} else {
-		sourceType := reflect.TypeOf(h.source)
-		log.Printf("No handler found for event: %v in %v", eventType.String(), sourceType.String())
+		h.logger.Warn("no handler found for event",
+			LogKeyAggregateID, event.AggregateID().String(),
+			LogKeyEventType, eventType.String(),
+			LogKeyHandlerType, reflect.TypeOf(h.source).String(),
+		)
 	}
 }
 
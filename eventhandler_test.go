@@ -99,9 +99,16 @@ func (s *ReflectEventHandlerSuite) Test_HandleEvent_NoHandler(c *C) {
 		events: make([]Event, 0),
 	}
 	handler := NewReflectEventHandler(source, "Handle")
+	logger := &MockLogger{}
+	handler.SetLogger(logger)
 	eventOther := TestEventOther{NewUUID(), "eventOther"}
 	handler.HandleEvent(eventOther)
 	c.Assert(len(source.events), Equals, 0)
+	c.Assert(len(logger.records), Equals, 1)
+	c.Assert(logger.records[0].level, Equals, "warn")
+	c.Assert(logger.records[0].msg, Equals, "no handler found for event")
+	c.Assert(logger.records[0].fields[LogKeyEventType], Equals, "eventhorizon.TestEventOther")
+	c.Assert(logger.records[0].fields[LogKeyHandlerType], Equals, "*eventhorizon.TestAggregate")
 }
 
 func (s *ReflectEventHandlerSuite) Benchmark_NewMethodHandler(c *C) {
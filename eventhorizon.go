@@ -33,6 +33,8 @@ type Command interface {
 // (CustomerMoved vs CustomerAddressCorrected).
 //
 // The event should contain all the data needed when applying/handling it.
+// Fields with personal data can be tagged with `eh:"pii"` to be encrypted
+// when stored by a CryptoRecordStore.
 type Event interface {
 	AggregateID() UUID
 }
@@ -15,6 +15,7 @@
 package eventhorizon
 
 import (
+	"iter"
 	"testing"
 
 	. "gopkg.in/check.v1"
@@ -98,8 +99,9 @@ type MockEventStore struct {
 	loaded UUID
 }
 
-func (m *MockEventStore) Append(events []Event) {
+func (m *MockEventStore) Append(events []Event) error {
 	m.events = append(m.events, events...)
+	return nil
 }
 
 func (m *MockEventStore) Load(id UUID) ([]Event, error) {
@@ -107,6 +109,25 @@ func (m *MockEventStore) Load(id UUID) ([]Event, error) {
 	return m.events, nil
 }
 
+type MockStreamEventStore struct {
+	MockEventStore
+	err error
+}
+
+func (m *MockStreamEventStore) Stream(id UUID) iter.Seq2[Event, error] {
+	m.loaded = id
+	return func(yield func(Event, error) bool) {
+		for _, event := range m.events {
+			if !yield(event, nil) {
+				return
+			}
+		}
+		if m.err != nil {
+			yield(nil, m.err)
+		}
+	}
+}
+
 type MockEventBus struct {
 	events []Event
 }
@@ -114,3 +135,45 @@ type MockEventBus struct {
 func (m *MockEventBus) PublishEvent(event Event) {
 	m.events = append(m.events, event)
 }
+
+type MockLogger struct {
+	records []MockLogRecord
+}
+
+type MockLogRecord struct {
+	level  string
+	msg    string
+	fields map[string]interface{}
+}
+
+func (m *MockLogger) Debug(msg string, args ...any) { m.log("debug", msg, args) }
+func (m *MockLogger) Info(msg string, args ...any)  { m.log("info", msg, args) }
+func (m *MockLogger) Warn(msg string, args ...any)  { m.log("warn", msg, args) }
+func (m *MockLogger) Error(msg string, args ...any) { m.log("error", msg, args) }
+
+func (m *MockLogger) log(level, msg string, args []any) {
+	fields := make(map[string]interface{})
+	for i := 0; i+1 < len(args); i += 2 {
+		fields[args[i].(string)] = args[i+1]
+	}
+	m.records = append(m.records, MockLogRecord{level, msg, fields})
+}
+
+type MockMetrics struct {
+	counters   map[string][]Labels
+	histograms map[string][]Labels
+}
+
+func (m *MockMetrics) IncCounter(name string, labels Labels) {
+	if m.counters == nil {
+		m.counters = make(map[string][]Labels)
+	}
+	m.counters[name] = append(m.counters[name], labels)
+}
+
+func (m *MockMetrics) ObserveHistogram(name string, value float64, labels Labels) {
+	if m.histograms == nil {
+		m.histograms = make(map[string][]Labels)
+	}
+	m.histograms[name] = append(m.histograms[name], labels)
+}
@@ -16,6 +16,11 @@ package eventhorizon
 
 import (
 	"errors"
+	"iter"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
 )
 
 // Error returned when no events are found.
@@ -24,56 +29,273 @@ var ErrNoEventsFound = errors.New("could not find events")
 // Error returned if no event store has been defined.
 var ErrNoEventStoreDefined = errors.New("no event store defined")
 
+// MetadataSequence is the metadata key of the position of an event in the
+// global stream of its event store, starting at 1. Event stores that keep a
+// global order, like MemoryEventStore, add it when the event is appended.
+const MetadataSequence = "sequence"
+
+// SequenceOf returns the position of an event in the global stream of its
+// event store, if the store has added it to the metadata.
+func SequenceOf(event Event) (int, bool) {
+	sequence, err := strconv.Atoi(MetadataOf(event)[MetadataSequence])
+	if err != nil || sequence < 1 {
+		return 0, false
+	}
+	return sequence, true
+}
+
 // EventStore is an interface for an event sourcing event store.
 type EventStore interface {
 	// Append appends all events in the event stream to the store.
-	Append([]Event)
+	Append([]Event) error
 
 	// Load loads all events for the aggregate id from the store.
 	Load(UUID) ([]Event, error)
 }
 
-// MemoryEventStore implements EventStore as an in memory structure.
+// GlobalEventStore is an EventStore that can also load the events of all
+// aggregates in the order they were appended.
+type GlobalEventStore interface {
+	EventStore
+
+	// LoadAll loads all events from the store in the order they were appended.
+	LoadAll() ([]Event, error)
+}
+
+// EventStreamer is a GlobalEventStore that can also stream the events of all
+// aggregates, without loading all of them into memory.
+type EventStreamer interface {
+	GlobalEventStore
+
+	// StreamAll returns an iterator over all events in the order they were
+	// appended. The iteration stops after an error.
+	StreamAll() iter.Seq2[Event, error]
+}
+
+// SequenceStreamer is an EventStreamer that can also stream the events after a
+// position in the global stream, without streaming the events before it.
+type SequenceStreamer interface {
+	EventStreamer
+
+	// StreamAfter returns an iterator over the events after the position, in
+	// the order they were appended. The position of the first event is 1, as
+	// its sequence, see SequenceOf. The iteration stops after an error.
+	StreamAfter(position int) iter.Seq2[Event, error]
+}
+
+// AggregateStreamer is an EventStore that can also stream the events of an
+// aggregate, without loading all of them into memory.
+type AggregateStreamer interface {
+	EventStore
+
+	// Stream returns an iterator over the events for the aggregate id. An
+	// aggregate without events has an empty stream. The iteration stops after
+	// an error.
+	Stream(UUID) iter.Seq2[Event, error]
+}
+
+// StreamEvents streams the events for the aggregate id from an event store,
+// or loads all of them if it is not an AggregateStreamer. An aggregate without
+// events has an empty stream.
+func StreamEvents(eventStore EventStore, id UUID) iter.Seq2[Event, error] {
+	if streamer, ok := eventStore.(AggregateStreamer); ok {
+		return streamer.Stream(id)
+	}
+	return func(yield func(Event, error) bool) {
+		events, err := eventStore.Load(id)
+		if errors.Is(err, ErrNoEventsFound) {
+			return
+		} else if err != nil {
+			yield(nil, err)
+			return
+		}
+		for _, event := range events {
+			if !yield(event, nil) {
+				return
+			}
+		}
+	}
+}
+
+// timeEvents returns an iterator over the events of another iterator that adds
+// the time spent producing the events, but not consuming them, to elapsed.
+func timeEvents(events iter.Seq2[Event, error], elapsed *time.Duration) iter.Seq2[Event, error] {
+	return func(yield func(Event, error) bool) {
+		start := time.Now()
+		for event, err := range events {
+			*elapsed += time.Since(start)
+			if !yield(event, err) {
+				return
+			}
+			start = time.Now()
+		}
+		*elapsed += time.Since(start)
+	}
+}
+
+// loadAllEvents loads all events of an event store, if it is a
+// GlobalEventStore. Returns ErrNoEventStoreDefined for a nil store and
+// ErrLoadAllNotSupported for other stores.
+func loadAllEvents(eventStore EventStore) ([]Event, error) {
+	switch store := eventStore.(type) {
+	case nil:
+		return nil, ErrNoEventStoreDefined
+	case GlobalEventStore:
+		return store.LoadAll()
+	}
+	return nil, ErrLoadAllNotSupported
+}
+
+// streamAllEvents streams all events of an event store in the order they were
+// appended, if it is an EventStreamer, or loads them if it is a
+// GlobalEventStore. Other stores yield ErrLoadAllNotSupported, and a nil store
+// ErrNoEventStoreDefined.
+func streamAllEvents(eventStore EventStore) iter.Seq2[Event, error] {
+	switch store := eventStore.(type) {
+	case nil:
+		return func(yield func(Event, error) bool) {
+			yield(nil, ErrNoEventStoreDefined)
+		}
+	case EventStreamer:
+		return store.StreamAll()
+	case GlobalEventStore:
+		return func(yield func(Event, error) bool) {
+			events, err := store.LoadAll()
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			for _, event := range events {
+				if !yield(event, nil) {
+					return
+				}
+			}
+		}
+	}
+	return func(yield func(Event, error) bool) {
+		yield(nil, ErrLoadAllNotSupported)
+	}
+}
+
+// MemoryEventStore implements EventStore as an in memory structure. It is
+// safe for concurrent use.
 type MemoryEventStore struct {
 	events map[UUID][]Event
+	all    []Event
+	logger Logger
+	mu     sync.RWMutex
 }
 
 // NewMemoryEventStore creates a new MemoryEventStore.
 func NewMemoryEventStore() *MemoryEventStore {
 	s := &MemoryEventStore{
 		events: make(map[UUID][]Event),
+		logger: defaultLogger(),
 	}
 	return s
 }
 
-// Append appends all events in the event stream to the memory store.
-func (s *MemoryEventStore) Append(events []Event) {
-	for _, event := range events {
+// SetLogger sets the logger used to log appended events.
+func (s *MemoryEventStore) SetLogger(logger Logger) {
+	s.logger = logger
+}
+
+// Append appends all events in the event stream to the memory store. Events
+// with metadata get their sequence added, also in the slice, so that it is
+// published with them.
+func (s *MemoryEventStore) Append(events []Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, event := range events {
+		event = WithMetadata(event, MetadataSequence, strconv.Itoa(len(s.all)+1))
+		events[i] = event
 		id := event.AggregateID()
 		if _, ok := s.events[id]; !ok {
 			s.events[id] = make([]Event, 0)
 		}
-		// log.Printf("event store: appending %#v", event)
 		s.events[id] = append(s.events[id], event)
+		s.all = append(s.all, event)
+		s.logger.Debug("event appended",
+			LogKeyAggregateID, id.String(),
+			LogKeyEventType, reflect.TypeOf(event).String(),
+		)
 	}
+	return nil
 }
 
 // Load loads all events for the aggregate id from the memory store.
 // Returns ErrNoEventsFound if no events can be found.
 func (s *MemoryEventStore) Load(id UUID) ([]Event, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	if events, ok := s.events[id]; ok {
-		// log.Printf("event store: loaded %#v", events)
-		return events, nil
+		return append([]Event(nil), events...), nil
 	}
 
 	return nil, ErrNoEventsFound
 }
 
+// Stream returns an iterator over the events for the aggregate id that are in
+// the memory store when it is called.
+func (s *MemoryEventStore) Stream(id UUID) iter.Seq2[Event, error] {
+	s.mu.RLock()
+	// Events are only appended, so the slice can be read without the lock.
+	events := s.events[id]
+	s.mu.RUnlock()
+	return func(yield func(Event, error) bool) {
+		for _, event := range events {
+			if !yield(event, nil) {
+				return
+			}
+		}
+	}
+}
+
+// LoadAll loads all events from the memory store in the order they were
+// appended.
+func (s *MemoryEventStore) LoadAll() ([]Event, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]Event(nil), s.all...), nil
+}
+
+// StreamAll returns an iterator over the events that are in the memory store
+// when it is called.
+func (s *MemoryEventStore) StreamAll() iter.Seq2[Event, error] {
+	s.mu.RLock()
+	// Events are only appended, so the slice can be read without the lock.
+	all := s.all
+	s.mu.RUnlock()
+	return func(yield func(Event, error) bool) {
+		for _, event := range all {
+			if !yield(event, nil) {
+				return
+			}
+		}
+	}
+}
+
+// StreamAfter streams the events after a position from the memory store.
+func (s *MemoryEventStore) StreamAfter(position int) iter.Seq2[Event, error] {
+	s.mu.RLock()
+	// Events are only appended, so the slice can be read without the lock.
+	all := s.all[min(max(position, 0), len(s.all)):]
+	s.mu.RUnlock()
+	return func(yield func(Event, error) bool) {
+		for _, event := range all {
+			if !yield(event, nil) {
+				return
+			}
+		}
+	}
+}
+
 // TraceEventStore wraps an EventStore and adds debug tracing.
 type TraceEventStore struct {
 	eventStore EventStore
 	tracing    bool
 	trace      []Event
+	mu         sync.Mutex
 }
 
 // NewTraceEventStore creates a new TraceEventStore.
@@ -86,14 +308,20 @@ func NewTraceEventStore(eventStore EventStore) *TraceEventStore {
 }
 
 // Append appends all events to the base store and trace them if enabled.
-func (s *TraceEventStore) Append(events []Event) {
+// Events are not traced if the base store returns an error.
+func (s *TraceEventStore) Append(events []Event) error {
 	if s.eventStore != nil {
-		s.eventStore.Append(events)
+		if err := s.eventStore.Append(events); err != nil {
+			return err
+		}
 	}
 
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	if s.tracing {
 		s.trace = append(s.trace, events...)
 	}
+	return nil
 }
 
 // Load loads all events for the aggregate id from the base store.
@@ -106,22 +334,53 @@ func (s *TraceEventStore) Load(id UUID) ([]Event, error) {
 	return nil, ErrNoEventStoreDefined
 }
 
+// LoadAll loads all events from the base store, which must be a
+// GlobalEventStore.
+func (s *TraceEventStore) LoadAll() ([]Event, error) {
+	return loadAllEvents(s.eventStore)
+}
+
+// StreamAll streams all events from the base store, which must be a
+// GlobalEventStore.
+func (s *TraceEventStore) StreamAll() iter.Seq2[Event, error] {
+	return streamAllEvents(s.eventStore)
+}
+
+// Stream streams the events for the aggregate id from the base store.
+// Returns ErrNoEventStoreDefined if no event store could be found.
+func (s *TraceEventStore) Stream(id UUID) iter.Seq2[Event, error] {
+	if s.eventStore != nil {
+		return StreamEvents(s.eventStore, id)
+	}
+	return func(yield func(Event, error) bool) {
+		yield(nil, ErrNoEventStoreDefined)
+	}
+}
+
 // StartTracing starts the tracing of events.
 func (s *TraceEventStore) StartTracing() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	s.tracing = true
 }
 
 // StopTracing stops the tracing of events.
 func (s *TraceEventStore) StopTracing() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	s.tracing = false
 }
 
 // GetTrace returns the events that happened during the tracing.
 func (s *TraceEventStore) GetTrace() []Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	return s.trace
 }
 
 // ResetTrace resets the trace.
 func (s *TraceEventStore) ResetTrace() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	s.trace = make([]Event, 0)
 }
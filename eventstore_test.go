@@ -15,6 +15,8 @@
 package eventhorizon
 
 import (
+	"time"
+
 	. "gopkg.in/check.v1"
 )
 
@@ -69,6 +71,29 @@ func (s *MemoryEventStoreSuite) Test_Append_DifferentAggregates(c *C) {
 	c.Assert(s.store.events[event3.TestID][0], Equals, event3)
 }
 
+func (s *MemoryEventStoreSuite) Test_LoadAll(c *C) {
+	event1 := TestEvent{NewUUID(), "event1"}
+	event2 := TestEvent{NewUUID(), "event2"}
+	event3 := TestEvent{event1.TestID, "event3"}
+	s.store.Append([]Event{event1, event2})
+	s.store.Append([]Event{event3})
+	events, err := s.store.LoadAll()
+	c.Assert(err, Equals, nil)
+	c.Assert(events, DeepEquals, []Event{event1, event2, event3})
+}
+
+func (s *MemoryEventStoreSuite) Test_Append_Logging(c *C) {
+	logger := &MockLogger{}
+	s.store.SetLogger(logger)
+	event1 := TestEvent{NewUUID(), "event1"}
+	s.store.Append([]Event{event1})
+	c.Assert(len(logger.records), Equals, 1)
+	c.Assert(logger.records[0].level, Equals, "debug")
+	c.Assert(logger.records[0].msg, Equals, "event appended")
+	c.Assert(logger.records[0].fields[LogKeyAggregateID], Equals, event1.TestID.String())
+	c.Assert(logger.records[0].fields[LogKeyEventType], Equals, "eventhorizon.TestEvent")
+}
+
 func (s *MemoryEventStoreSuite) Test_Load_NoEvents(c *C) {
 	events, err := s.store.Load(NewUUID())
 	c.Assert(err, ErrorMatches, "could not find events")
@@ -92,6 +117,76 @@ func (s *MemoryEventStoreSuite) Test_Load_TwoEvents(c *C) {
 	c.Assert(events, DeepEquals, []Event{event1, event2})
 }
 
+func (s *MemoryEventStoreSuite) Test_Stream(c *C) {
+	event1 := TestEvent{NewUUID(), "event1"}
+	event2 := TestEvent{event1.TestID, "event2"}
+	s.store.Append([]Event{event1, TestEvent{NewUUID(), "other"}, event2})
+
+	var events []Event
+	for event, err := range s.store.Stream(event1.TestID) {
+		c.Assert(err, Equals, nil)
+		events = append(events, event)
+		// Events appended during the iteration are not streamed.
+		s.store.Append([]Event{event})
+	}
+	c.Assert(events, DeepEquals, []Event{event1, event2})
+
+	for range s.store.Stream(NewUUID()) {
+		c.Fatal("streamed events of an aggregate without events")
+	}
+}
+
+func (s *MemoryEventStoreSuite) Test_StreamAfter(c *C) {
+	event1 := TestEvent{NewUUID(), "event1"}
+	event2 := TestEvent{NewUUID(), "event2"}
+	event3 := TestEvent{event1.TestID, "event3"}
+	s.store.Append([]Event{event1, event2, event3})
+
+	var events []Event
+	for event, err := range s.store.StreamAfter(1) {
+		c.Assert(err, Equals, nil)
+		events = append(events, event)
+	}
+	c.Assert(events, DeepEquals, []Event{event2, event3})
+
+	for range s.store.StreamAfter(3) {
+		c.Fatal("streamed events after the last event")
+	}
+	for range s.store.StreamAfter(10) {
+		c.Fatal("streamed events after the last event")
+	}
+}
+
+func (s *MemoryEventStoreSuite) Test_StreamEvents_Load(c *C) {
+	event1 := TestEvent{NewUUID(), "event1"}
+	store := &MockEventStore{events: []Event{event1}}
+	var events []Event
+	for event, err := range StreamEvents(store, event1.TestID) {
+		c.Assert(err, Equals, nil)
+		events = append(events, event)
+	}
+	c.Assert(events, DeepEquals, []Event{event1})
+	c.Assert(store.loaded, Equals, event1.TestID)
+
+	// Aggregates without events have empty streams, and errors are yielded.
+	for range StreamEvents(&TraceEventStore{eventStore: s.store}, NewUUID()) {
+		c.Fatal("streamed events of an aggregate without events")
+	}
+	for _, err := range StreamEvents(&TraceEventStore{}, NewUUID()) {
+		c.Assert(err, Equals, ErrNoEventStoreDefined)
+	}
+}
+
+func (s *MemoryEventStoreSuite) Test_TimeEvents(c *C) {
+	event1 := TestEvent{NewUUID(), "event1"}
+	s.store.Append([]Event{event1, TestEvent{event1.TestID, "event2"}})
+	var elapsed time.Duration
+	for range timeEvents(s.store.Stream(event1.TestID), &elapsed) {
+		time.Sleep(20 * time.Millisecond)
+	}
+	c.Assert(elapsed < 20*time.Millisecond, Equals, true)
+}
+
 func (s *MemoryEventStoreSuite) Test_Load_DifferentAggregates(c *C) {
 	event1 := TestEvent{NewUUID(), "event1"}
 	event3 := TestEvent{NewUUID(), "event3"}
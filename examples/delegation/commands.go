@@ -19,6 +19,8 @@ import (
 )
 
 type CreateInvite struct {
+	_ struct{} `aggregate:"create"`
+
 	InvitationID eventhorizon.UUID
 	Name         string
 	Age          int `eh:"optional"`
@@ -29,6 +31,8 @@ func (c CreateInvite) AggregateID() eventhorizon.UUID {
 }
 
 type AcceptInvite struct {
+	_ struct{} `aggregate:"existing"`
+
 	InvitationID eventhorizon.UUID
 }
 
@@ -37,6 +41,8 @@ func (c AcceptInvite) AggregateID() eventhorizon.UUID {
 }
 
 type DeclineInvite struct {
+	_ struct{} `aggregate:"existing"`
+
 	InvitationID eventhorizon.UUID
 }
 
@@ -30,9 +30,12 @@ func main() {
 	disp := eventhorizon.NewDelegateDispatcher(eventStore, eventBus)
 
 	// Register the domain aggregates with the dispather.
-	disp.AddHandler(&InvitationAggregate{}, CreateInvite{})
-	disp.AddHandler(&InvitationAggregate{}, AcceptInvite{})
-	disp.AddHandler(&InvitationAggregate{}, DeclineInvite{})
+	disp.MustAddHandler(&InvitationAggregate{}, CreateInvite{})
+	disp.MustAddHandler(&InvitationAggregate{}, AcceptInvite{})
+	disp.MustAddHandler(&InvitationAggregate{}, DeclineInvite{})
+	if err := disp.Validate(); err != nil {
+		log.Fatal(err)
+	}
 
 	// Create and register a read model for individual invitations.
 	invitationRepository := eventhorizon.NewMemoryRepository()
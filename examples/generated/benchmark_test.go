@@ -0,0 +1,114 @@
+// Copyright (c) 2014 - Max Persson <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/looplab/eventhorizon"
+)
+
+// The benchmarks compare the generated routing with the reflection used by
+// ReflectDispatcher, ReflectEventHandler and HandlerEventBus.AddAllSubscribers.
+
+type nopEventBus struct{}
+
+func (nopEventBus) PublishEvent(eventhorizon.Event) {}
+
+func benchmarkDispatch(b *testing.B, disp eventhorizon.Dispatcher) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		id := eventhorizon.NewUUID()
+		if err := disp.Dispatch(CreateInvite{InvitationID: id, Name: "Athena", Age: 42}); err != nil {
+			b.Fatal(err)
+		}
+		if err := disp.Dispatch(AcceptInvite{InvitationID: id}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDispatch(b *testing.B) {
+	b.Run("reflect", func(b *testing.B) {
+		disp := eventhorizon.NewReflectDispatcher(eventhorizon.NewMemoryEventStore(), nopEventBus{})
+		if err := disp.AddAllHandlers(&InvitationAggregate{}); err != nil {
+			b.Fatal(err)
+		}
+		benchmarkDispatch(b, disp)
+	})
+	b.Run("generated", func(b *testing.B) {
+		disp := eventhorizon.NewDelegateDispatcher(eventhorizon.NewMemoryEventStore(), nopEventBus{})
+		if err := AddInvitationAggregateHandlers(disp); err != nil {
+			b.Fatal(err)
+		}
+		benchmarkDispatch(b, disp)
+	})
+}
+
+func benchmarkHandleEvent(b *testing.B, handler eventhorizon.EventHandler, event eventhorizon.Event) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		handler.HandleEvent(event)
+	}
+}
+
+func BenchmarkApplyEvent(b *testing.B) {
+	event := InviteCreated{eventhorizon.NewUUID(), "Athena", 42}
+	b.Run("reflect", func(b *testing.B) {
+		handler := eventhorizon.NewReflectEventHandler(&InvitationAggregate{}, "Apply")
+		benchmarkHandleEvent(b, handler, event)
+	})
+	b.Run("generated", func(b *testing.B) {
+		benchmarkHandleEvent(b, &InvitationAggregate{}, event)
+	})
+}
+
+func BenchmarkProjectEvent(b *testing.B) {
+	event := InviteCreated{eventhorizon.NewUUID(), "Athena", 42}
+	b.Run("reflect", func(b *testing.B) {
+		projector := NewInvitationProjector(eventhorizon.NewMemoryRepository())
+		handler := eventhorizon.NewReflectEventHandler(projector, "Handle")
+		benchmarkHandleEvent(b, handler, event)
+	})
+	b.Run("generated", func(b *testing.B) {
+		projector := NewInvitationProjector(eventhorizon.NewMemoryRepository())
+		benchmarkHandleEvent(b, projector, event)
+	})
+}
+
+func BenchmarkRegister(b *testing.B) {
+	b.Run("reflect", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			disp := eventhorizon.NewReflectDispatcher(eventhorizon.NewMemoryEventStore(), nopEventBus{})
+			if err := disp.AddAllHandlers(&InvitationAggregate{}); err != nil {
+				b.Fatal(err)
+			}
+			bus := eventhorizon.NewHandlerEventBus()
+			bus.AddAllSubscribers(NewInvitationProjector(nil))
+		}
+	})
+	b.Run("generated", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			disp := eventhorizon.NewDelegateDispatcher(eventhorizon.NewMemoryEventStore(), nopEventBus{})
+			if err := AddInvitationAggregateHandlers(disp); err != nil {
+				b.Fatal(err)
+			}
+			bus := eventhorizon.NewHandlerEventBus()
+			AddInvitationProjectorSubscribers(bus, NewInvitationProjector(nil))
+		}
+	})
+}
@@ -0,0 +1,85 @@
+// Code generated by ehgen; DO NOT EDIT.
+
+package main
+
+import (
+	"github.com/looplab/eventhorizon"
+)
+
+// HandleEvent routes events to the Handle methods of GuestListProjector.
+func (g *GuestListProjector) HandleEvent(event eventhorizon.Event) {
+	switch event := event.(type) {
+	case InviteAccepted:
+		g.HandleInviteAccepted(event)
+	case InviteCreated:
+		g.HandleInviteCreated(event)
+	case InviteDeclined:
+		g.HandleInviteDeclined(event)
+	}
+}
+
+// AddGuestListProjectorSubscribers subscribes g to the events it handles.
+func AddGuestListProjectorSubscribers(bus *eventhorizon.HandlerEventBus, g *GuestListProjector) {
+	bus.AddSubscriber(g, InviteAccepted{})
+	bus.AddSubscriber(g, InviteCreated{})
+	bus.AddSubscriber(g, InviteDeclined{})
+}
+
+// HandleCommand routes commands to the Handle methods of InvitationAggregate.
+func (i *InvitationAggregate) HandleCommand(command eventhorizon.Command) ([]eventhorizon.Event, error) {
+	switch command := command.(type) {
+	case AcceptInvite:
+		return i.HandleAcceptInvite(command)
+	case CreateInvite:
+		return i.HandleCreateInvite(command)
+	case DeclineInvite:
+		return i.HandleDeclineInvite(command)
+	}
+	return nil, eventhorizon.ErrHandlerNotFound
+}
+
+// HandleEvent routes events to the Apply methods of InvitationAggregate.
+func (i *InvitationAggregate) HandleEvent(event eventhorizon.Event) {
+	switch event := event.(type) {
+	case InviteAccepted:
+		i.ApplyInviteAccepted(event)
+	case InviteCreated:
+		i.ApplyInviteCreated(event)
+	case InviteDeclined:
+		i.ApplyInviteDeclined(event)
+	}
+}
+
+// AddInvitationAggregateHandlers adds InvitationAggregate as the handler of its commands.
+func AddInvitationAggregateHandlers(dispatcher *eventhorizon.DelegateDispatcher) error {
+	commands := []eventhorizon.Command{
+		AcceptInvite{},
+		CreateInvite{},
+		DeclineInvite{},
+	}
+	for _, command := range commands {
+		if err := dispatcher.AddHandler(&InvitationAggregate{}, command); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// HandleEvent routes events to the Handle methods of InvitationProjector.
+func (i *InvitationProjector) HandleEvent(event eventhorizon.Event) {
+	switch event := event.(type) {
+	case InviteAccepted:
+		i.HandleInviteAccepted(event)
+	case InviteCreated:
+		i.HandleInviteCreated(event)
+	case InviteDeclined:
+		i.HandleInviteDeclined(event)
+	}
+}
+
+// AddInvitationProjectorSubscribers subscribes i to the events it handles.
+func AddInvitationProjectorSubscribers(bus *eventhorizon.HandlerEventBus, i *InvitationProjector) {
+	bus.AddSubscriber(i, InviteAccepted{})
+	bus.AddSubscriber(i, InviteCreated{})
+	bus.AddSubscriber(i, InviteDeclined{})
+}
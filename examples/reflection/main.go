@@ -30,7 +30,12 @@ func main() {
 	disp := eventhorizon.NewReflectDispatcher(eventStore, eventBus)
 
 	// Register the domain aggregates with the dispather.
-	disp.AddAllHandlers(&InvitationAggregate{})
+	if err := disp.AddAllHandlers(&InvitationAggregate{}); err != nil {
+		log.Fatal(err)
+	}
+	if err := disp.Validate(); err != nil {
+		log.Fatal(err)
+	}
 
 	// Create and register a read model for individual invitations.
 	invitationRepository := eventhorizon.NewMemoryRepository()
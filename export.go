@@ -0,0 +1,389 @@
+// Copyright (c) 2014 - Max Persson <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventhorizon
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"time"
+)
+
+// Error returned when an imported record is invalid.
+var ErrInvalidRecord = errors.New("invalid record")
+
+// Error returned when deduplicating an import and a record differs from the
+// stored record at the same position in its aggregate.
+var ErrImportConflict = errors.New("record conflicts with stored record")
+
+// DefaultImportBatchSize is the default number of records that an Importer
+// appends at a time.
+const DefaultImportBatchSize = 1000
+
+// progressInterval is the number of events between progress reports of an
+// Exporter.
+const progressInterval = 1000
+
+// ExportedRecord is a line of the JSON Lines format of Exporter and Importer:
+// the record of an event with its metadata, if it has any, for readers of the
+// export that do not decode the data.
+type ExportedRecord struct {
+	EventRecord
+
+	Metadata Metadata `json:"metadata,omitempty"`
+}
+
+// ExportFilter selects the events to export. The zero value selects all
+// events.
+type ExportFilter struct {
+	// AggregateIDs selects the events of aggregates, which are exported one
+	// aggregate at a time in the order of the IDs.
+	AggregateIDs []UUID
+
+	// From and To select events with a timestamp at or after From and before
+	// To. Events without a timestamp are left out if either is set.
+	From time.Time
+	To   time.Time
+}
+
+// Exporter exports events from an event store as JSON Lines, one event at a
+// time. All events are streamed if the store is an EventStreamer, otherwise
+// loaded with LoadAll.
+type Exporter struct {
+	eventStore EventStore
+	codec      *EventCodec
+	progress   func(exported int)
+}
+
+// NewExporter creates an Exporter. The codec encodes the events to records,
+// and can be nil if the events are RawEvents.
+func NewExporter(store EventStore, codec *EventCodec) *Exporter {
+	e := &Exporter{
+		eventStore: store,
+		codec:      codec,
+	}
+	return e
+}
+
+// SetProgress sets a function that is called with the number of exported
+// events regularly and when the export is done.
+func (e *Exporter) SetProgress(progress func(exported int)) {
+	e.progress = progress
+}
+
+// Export writes the events selected by the filter to w and returns the number
+// of exported events.
+func (e *Exporter) Export(w io.Writer, filter ExportFilter) (int, error) {
+	buf := bufio.NewWriter(w)
+	encoder := json.NewEncoder(buf)
+	exported := 0
+	for event, err := range e.events(filter) {
+		if err != nil {
+			return exported, err
+		}
+		record, err := e.encode(event)
+		if err != nil {
+			return exported, err
+		}
+		exportedRecord := ExportedRecord{record, recordMetadata(record)}
+		if !filter.matchTime(exportedRecord.Metadata) {
+			continue
+		}
+		if err := encoder.Encode(exportedRecord); err != nil {
+			return exported, err
+		}
+		exported++
+		if e.progress != nil && exported%progressInterval == 0 {
+			e.progress(exported)
+		}
+	}
+	if err := buf.Flush(); err != nil {
+		return exported, err
+	}
+	if e.progress != nil {
+		e.progress(exported)
+	}
+	return exported, nil
+}
+
+// events returns an iterator over the events of the aggregates of the
+// filter, or all events.
+func (e *Exporter) events(filter ExportFilter) iter.Seq2[Event, error] {
+	if len(filter.AggregateIDs) > 0 {
+		return e.aggregateEvents(filter.AggregateIDs)
+	}
+	return streamAllEvents(e.eventStore)
+}
+
+// aggregateEvents returns an iterator that streams the events of one
+// aggregate at a time.
+func (e *Exporter) aggregateEvents(ids []UUID) iter.Seq2[Event, error] {
+	return func(yield func(Event, error) bool) {
+		for _, id := range ids {
+			for event, err := range StreamEvents(e.eventStore, id) {
+				if !yield(event, err) || err != nil {
+					return
+				}
+			}
+		}
+	}
+}
+
+// encode encodes an event to a record, with the codec unless it is a
+// RawEvent.
+func (e *Exporter) encode(event Event) (EventRecord, error) {
+	if raw, ok := event.(RawEvent); ok {
+		return raw.Record, nil
+	}
+	if e.codec == nil {
+		return EventRecord{}, fmt.Errorf("%w: %T", ErrEventNotRegistered, event)
+	}
+	return e.codec.Encode(event)
+}
+
+// matchTime returns true if the timestamp in the metadata is in the time
+// window of the filter.
+func (f ExportFilter) matchTime(metadata Metadata) bool {
+	if f.From.IsZero() && f.To.IsZero() {
+		return true
+	}
+	t, ok := metadataTimestamp(metadata)
+	if !ok {
+		return false
+	}
+	return !t.Before(f.From) && (f.To.IsZero() || t.Before(f.To))
+}
+
+// recordMetadata returns the metadata embedded in the data of a record.
+func recordMetadata(record EventRecord) Metadata {
+	var data struct {
+		Metadata Metadata
+	}
+	json.Unmarshal(record.Data, &data)
+	return data.Metadata
+}
+
+// ImportProgress is the progress of an import.
+type ImportProgress struct {
+	// Read is the number of records read.
+	Read int
+	// Imported is the number of records appended to the store.
+	Imported int
+	// Skipped is the number of records that were already stored.
+	Skipped int
+}
+
+// Importer imports records in the JSON Lines format of Exporter into a
+// RecordStore. Records are read and appended in batches, in the order and
+// with the versions they have in the input, so inputs of any size can be
+// imported.
+//
+// Every record is validated before it is appended. An invalid record stops
+// the import, after the batches before it have been appended.
+type Importer struct {
+	recordStore RecordStore
+	codec       *EventCodec
+	deduplicate bool
+	batchSize   int
+	progress    func(ImportProgress)
+}
+
+// importStream is the hashes of the stored records of an aggregate and the
+// position in it during a deduplicated import.
+type importStream struct {
+	stored   [][sha256.Size]byte
+	position int
+}
+
+// NewImporter creates an Importer. If the codec is not nil, every record must
+// decode with it.
+func NewImporter(recordStore RecordStore, codec *EventCodec) *Importer {
+	i := &Importer{
+		recordStore: recordStore,
+		codec:       codec,
+		batchSize:   DefaultImportBatchSize,
+	}
+	return i
+}
+
+// SetDeduplicate enables skipping of records that are already stored. The
+// records of an aggregate are compared by their position in the aggregate,
+// which makes it safe to import the same input again, for example to resume
+// an import. A record that differs from the stored record at its position
+// stops the import with ErrImportConflict. The stored records are hashed by
+// aggregate with one pass over the record store when an import starts, and
+// the hashes are kept during the import, which takes 32 bytes per stored
+// record, or about 320 MB for 10 million records.
+func (i *Importer) SetDeduplicate(deduplicate bool) {
+	i.deduplicate = deduplicate
+}
+
+// SetBatchSize sets the number of records appended at a time.
+func (i *Importer) SetBatchSize(size int) {
+	i.batchSize = size
+}
+
+// SetProgress sets a function that is called with the progress after every
+// batch.
+func (i *Importer) SetProgress(progress func(ImportProgress)) {
+	i.progress = progress
+}
+
+// Import reads records from r and appends them to the record store.
+func (i *Importer) Import(r io.Reader) (ImportProgress, error) {
+	var progress ImportProgress
+	batch := make([]EventRecord, 0, i.batchSize)
+	flush := func() error {
+		if len(batch) > 0 {
+			if err := i.recordStore.AppendRecords(batch); err != nil {
+				return err
+			}
+			progress.Imported += len(batch)
+			batch = batch[:0]
+		}
+		if i.progress != nil {
+			i.progress(progress)
+		}
+		return nil
+	}
+
+	var streams map[UUID]*importStream
+	if i.deduplicate {
+		var err error
+		if streams, err = i.storedStreams(); err != nil {
+			return progress, err
+		}
+	}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, maxRecordSize)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var exported ExportedRecord
+		if err := json.Unmarshal(scanner.Bytes(), &exported); err != nil {
+			return progress, fmt.Errorf("line %d: %w: %v", line, ErrInvalidRecord, err)
+		}
+		record := exported.EventRecord
+		if err := i.validate(record); err != nil {
+			return progress, fmt.Errorf("line %d: %w", line, err)
+		}
+		progress.Read++
+
+		if i.deduplicate {
+			stored, err := i.stored(streams, record)
+			if err != nil {
+				return progress, fmt.Errorf("line %d: %w", line, err)
+			}
+			if stored {
+				progress.Skipped++
+				continue
+			}
+		}
+
+		batch = append(batch, record)
+		if len(batch) >= i.batchSize {
+			if err := flush(); err != nil {
+				return progress, err
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return progress, err
+	}
+	return progress, flush()
+}
+
+// validate checks that a record can be stored and decoded. The version is the
+// schema version of the event type, which the codec checks by decoding.
+func (i *Importer) validate(record EventRecord) error {
+	switch {
+	case len(record.AggregateID) != 16:
+		return fmt.Errorf("%w: no aggregate id", ErrInvalidRecord)
+	case record.Type == "":
+		return fmt.Errorf("%w: no type", ErrInvalidRecord)
+	case record.Version < 1:
+		return fmt.Errorf("%w: invalid version %d", ErrInvalidRecord, record.Version)
+	case !json.Valid(record.Data):
+		return fmt.Errorf("%w: invalid data", ErrInvalidRecord)
+	}
+	if i.codec != nil {
+		if _, err := i.codec.Decode(record); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidRecord, err)
+		}
+	}
+	return nil
+}
+
+// storedStreams indexes the stored records by aggregate, with one pass over
+// the record store.
+func (i *Importer) storedStreams() (map[UUID]*importStream, error) {
+	streams := make(map[UUID]*importStream)
+	for record, err := range streamRecords(i.recordStore) {
+		if err != nil {
+			return nil, err
+		}
+		stream, ok := streams[record.AggregateID]
+		if !ok {
+			stream = &importStream{}
+			streams[record.AggregateID] = stream
+		}
+		stream.stored = append(stream.stored, HashRecord(record))
+	}
+	return streams, nil
+}
+
+// stored returns true if a record is already stored at its position in its
+// aggregate.
+func (i *Importer) stored(streams map[UUID]*importStream, record EventRecord) (bool, error) {
+	stream, ok := streams[record.AggregateID]
+	if !ok {
+		stream = &importStream{}
+		streams[record.AggregateID] = stream
+	}
+	if stream.position >= len(stream.stored) {
+		stream.stored = nil
+		return false, nil
+	}
+
+	stored := stream.stored[stream.position]
+	stream.position++
+	if stored != HashRecord(record) {
+		return false, fmt.Errorf("%w: %s at position %d in %s", ErrImportConflict,
+			record.Type, stream.position-1, record.AggregateID)
+	}
+	return true, nil
+}
+
+// HashRecord hashes a record, ignoring the formatting of its data. Records
+// with equal hashes have the same aggregate, type, version and data.
+func HashRecord(record EventRecord) [sha256.Size]byte {
+	var data bytes.Buffer
+	if json.Compact(&data, record.Data) != nil {
+		data.Reset()
+		data.Write(record.Data)
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "%s %s %d %d ", record.AggregateID, record.Type, record.Version, data.Len())
+	h.Write(data.Bytes())
+	var sum [sha256.Size]byte
+	h.Sum(sum[:0])
+	return sum
+}
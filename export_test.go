@@ -0,0 +1,267 @@
+// Copyright (c) 2014 - Max Persson <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventhorizon
+
+import (
+	"bytes"
+	"errors"
+	"iter"
+	"strings"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+var _ = Suite(&ExportSuite{})
+
+type ExportSuite struct {
+	codec *EventCodec
+	store *CodecEventStore
+	id1   UUID
+	id2   UUID
+	start time.Time
+}
+
+func (s *ExportSuite) SetUpTest(c *C) {
+	s.codec = NewEventCodec()
+	s.codec.RegisterEvent(TestTracedEvent{}, 1)
+	s.codec.RegisterEvent(TestEvent{}, 2)
+	s.store = NewCodecEventStore(NewMemoryRecordStore(), s.codec)
+	s.id1 = NewUUID()
+	s.id2 = NewUUID()
+	s.start = time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	for i, id := range []UUID{s.id1, s.id2, s.id1} {
+		event := TestTracedEvent{TestID: id, Content: "event"}
+		events := withTimestamp([]Event{event}, s.start.Add(time.Duration(i)*time.Hour))
+		c.Assert(s.store.Append(events), IsNil)
+	}
+	c.Assert(s.store.Append([]Event{TestEvent{s.id2, "untimed"}}), IsNil)
+}
+
+func (s *ExportSuite) export(c *C, filter ExportFilter) []string {
+	var buf bytes.Buffer
+	n, err := NewExporter(s.store, s.codec).Export(&buf, filter)
+	c.Assert(err, IsNil)
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if buf.Len() == 0 {
+		lines = nil
+	}
+	c.Assert(lines, HasLen, n)
+	return lines
+}
+
+func (s *ExportSuite) Test_Export(c *C) {
+	lines := s.export(c, ExportFilter{})
+	c.Assert(lines, HasLen, 4)
+	c.Assert(lines[0], Equals, `{"aggregate_id":"`+s.id1.String()+`","type":"TestTracedEvent","version":1,`+
+		`"data":{"Metadata":{"timestamp":"2024-01-01T12:00:00Z"},"TestID":"`+s.id1.String()+`","Content":"event"},`+
+		`"metadata":{"timestamp":"2024-01-01T12:00:00Z"}}`)
+	c.Assert(lines[3], Equals, `{"aggregate_id":"`+s.id2.String()+`","type":"TestEvent","version":2,`+
+		`"data":{"TestID":"`+s.id2.String()+`","Content":"untimed"}}`)
+}
+
+func (s *ExportSuite) Test_Export_Filter(c *C) {
+	lines := s.export(c, ExportFilter{AggregateIDs: []UUID{s.id2, NewUUID()}})
+	c.Assert(lines, HasLen, 2)
+	c.Assert(lines[0], Matches, `.*"timestamp":"2024-01-01T13:00:00Z".*`)
+	c.Assert(lines[1], Matches, `.*"untimed".*`)
+
+	lines = s.export(c, ExportFilter{From: s.start.Add(time.Hour), To: s.start.Add(2 * time.Hour)})
+	c.Assert(lines, HasLen, 1)
+	c.Assert(lines[0], Matches, `.*"timestamp":"2024-01-01T13:00:00Z".*`)
+	lines = s.export(c, ExportFilter{From: s.start.Add(time.Hour)})
+	c.Assert(lines, HasLen, 2)
+	lines = s.export(c, ExportFilter{To: s.start})
+	c.Assert(lines, HasLen, 0)
+}
+
+func (s *ExportSuite) Test_Export_Errors(c *C) {
+	var buf bytes.Buffer
+	_, err := NewExporter(&MockEventStore{}, s.codec).Export(&buf, ExportFilter{})
+	c.Assert(err, Equals, ErrLoadAllNotSupported)
+
+	store := NewMemoryEventStore()
+	store.Append([]Event{TestEvent{NewUUID(), "event1"}})
+	_, err = NewExporter(store, nil).Export(&buf, ExportFilter{})
+	c.Assert(errors.Is(err, ErrEventNotRegistered), Equals, true)
+}
+
+func (s *ExportSuite) Test_Export_Progress(c *C) {
+	store := NewMemoryEventStore()
+	for i := 0; i < 2500; i++ {
+		store.Append([]Event{TestEvent{NewUUID(), "event"}})
+	}
+	var progress []int
+	exporter := NewExporter(store, s.codec)
+	exporter.SetProgress(func(exported int) { progress = append(progress, exported) })
+	var buf bytes.Buffer
+	n, err := exporter.Export(&buf, ExportFilter{})
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 2500)
+	c.Assert(progress, DeepEquals, []int{1000, 2000, 2500})
+}
+
+func (s *ExportSuite) Test_Import(c *C) {
+	// Records keep the versions they were exported with.
+	source := NewMemoryRecordStore()
+	source.AppendRecords([]EventRecord{
+		{s.id1, "TestEvent", 1, []byte(`{"TestID":"` + s.id1.String() + `","Text":"old"}`)},
+		{s.id2, "TestEvent", 2, []byte(`{"TestID":"` + s.id2.String() + `","Content":"new"}`)},
+	})
+	var buf bytes.Buffer
+	_, err := NewExporter(NewRawEventStore(source), nil).Export(&buf, ExportFilter{})
+	c.Assert(err, IsNil)
+
+	target := NewMemoryRecordStore()
+	importer := NewImporter(target, nil)
+	var progress []ImportProgress
+	importer.SetProgress(func(p ImportProgress) { progress = append(progress, p) })
+	result, err := importer.Import(&buf)
+	c.Assert(err, IsNil)
+	c.Assert(result, Equals, ImportProgress{Read: 2, Imported: 2})
+	c.Assert(progress, DeepEquals, []ImportProgress{result})
+	c.Assert(target.records, DeepEquals, source.records)
+}
+
+func (s *ExportSuite) Test_Import_Batches(c *C) {
+	var buf bytes.Buffer
+	_, err := NewExporter(s.store, s.codec).Export(&buf, ExportFilter{})
+	c.Assert(err, IsNil)
+
+	target := NewMemoryRecordStore()
+	importer := NewImporter(target, s.codec)
+	importer.SetBatchSize(3)
+	var progress []ImportProgress
+	importer.SetProgress(func(p ImportProgress) { progress = append(progress, p) })
+	_, err = importer.Import(&buf)
+	c.Assert(err, IsNil)
+	c.Assert(progress, DeepEquals, []ImportProgress{{3, 3, 0}, {4, 4, 0}})
+
+	events, err := NewCodecEventStore(target, s.codec).LoadAll()
+	c.Assert(err, IsNil)
+	expected, _ := s.store.LoadAll()
+	c.Assert(events, DeepEquals, expected)
+}
+
+func (s *ExportSuite) Test_Import_Invalid(c *C) {
+	id := NewUUID().String()
+	for _, t := range []struct {
+		line string
+		err  string
+	}{
+		{`{"aggregate_id":`, "line 2: invalid record: unexpected end of JSON input"},
+		{`{"aggregate_id":"x","type":"TestEvent","version":1,"data":{}}`, "line 2: invalid record: invalid UUID in JSON, x: .*"},
+		{`{"type":"TestEvent","version":1,"data":{}}`, "line 2: invalid record: no aggregate id"},
+		{`{"aggregate_id":"` + id + `","version":1,"data":{}}`, "line 2: invalid record: no type"},
+		{`{"aggregate_id":"` + id + `","type":"TestEvent","data":{}}`, "line 2: invalid record: invalid version 0"},
+		{`{"aggregate_id":"` + id + `","type":"TestEvent","version":1}`, "line 2: invalid record: invalid data"},
+		{`{"aggregate_id":"` + id + `","type":"Unknown","version":1,"data":{}}`, "line 2: invalid record: event type not registered: Unknown"},
+	} {
+		input := `{"aggregate_id":"` + id + `","type":"TestEvent","version":2,"data":{}}` + "\n" + t.line + "\n"
+		target := NewMemoryRecordStore()
+		importer := NewImporter(target, s.codec)
+		result, err := importer.Import(strings.NewReader(input))
+		c.Assert(errors.Is(err, ErrInvalidRecord), Equals, true, Commentf("line: %s", t.line))
+		c.Assert(err, ErrorMatches, t.err)
+		c.Assert(result, Equals, ImportProgress{Read: 1})
+	}
+}
+
+func (s *ExportSuite) Test_Import_Deduplicate(c *C) {
+	var buf bytes.Buffer
+	_, err := NewExporter(s.store, s.codec).Export(&buf, ExportFilter{})
+	c.Assert(err, IsNil)
+	input := buf.String()
+	lines := strings.SplitAfter(input, "\n")
+
+	// Resume an import that stopped after two records.
+	target := NewMemoryRecordStore()
+	importer := NewImporter(target, s.codec)
+	importer.SetDeduplicate(true)
+	_, err = importer.Import(strings.NewReader(lines[0] + lines[1]))
+	c.Assert(err, IsNil)
+	result, err := importer.Import(strings.NewReader(input))
+	c.Assert(err, IsNil)
+	c.Assert(result, Equals, ImportProgress{Read: 4, Imported: 2, Skipped: 2})
+	result, err = importer.Import(strings.NewReader(input))
+	c.Assert(err, IsNil)
+	c.Assert(result, Equals, ImportProgress{Read: 4, Imported: 0, Skipped: 4})
+	events, _ := NewCodecEventStore(target, s.codec).LoadAll()
+	expected, _ := s.store.LoadAll()
+	c.Assert(events, DeepEquals, expected)
+
+	// A different record at a stored position is a conflict.
+	conflict := `{"aggregate_id":"` + s.id1.String() + `","type":"TestEvent","version":2,"data":{}}` + "\n"
+	_, err = importer.Import(strings.NewReader(conflict))
+	c.Assert(errors.Is(err, ErrImportConflict), Equals, true)
+	c.Assert(err, ErrorMatches, "line 1: record conflicts with stored record: TestEvent at position 0 in .*")
+}
+
+// CountingRecordStore counts the reads of a MemoryRecordStore.
+type CountingRecordStore struct {
+	*MemoryRecordStore
+	loads   int
+	streams int
+}
+
+func (s *CountingRecordStore) LoadRecords(id UUID) ([]EventRecord, error) {
+	s.loads++
+	return s.MemoryRecordStore.LoadRecords(id)
+}
+
+func (s *CountingRecordStore) StreamAllRecords() iter.Seq2[EventRecord, error] {
+	s.streams++
+	return s.MemoryRecordStore.StreamAllRecords()
+}
+
+func (s *ExportSuite) Test_Import_Deduplicate_Index(c *C) {
+	var buf bytes.Buffer
+	_, err := NewExporter(s.store, s.codec).Export(&buf, ExportFilter{})
+	c.Assert(err, IsNil)
+	target := &CountingRecordStore{MemoryRecordStore: NewMemoryRecordStore()}
+	importer := NewImporter(target, s.codec)
+	importer.SetDeduplicate(true)
+	_, err = importer.Import(strings.NewReader(buf.String()))
+	c.Assert(err, IsNil)
+
+	// The stored records are read once per import.
+	result, err := importer.Import(strings.NewReader(buf.String()))
+	c.Assert(err, IsNil)
+	c.Assert(result.Skipped, Equals, 4)
+	c.Assert(target.loads, Equals, 0)
+	c.Assert(target.streams, Equals, 2)
+}
+
+func (s *ExportSuite) Test_StreamAll(c *C) {
+	expected, err := s.store.LoadAll()
+	c.Assert(err, IsNil)
+	var events []Event
+	for event, err := range s.store.StreamAll() {
+		c.Assert(err, IsNil)
+		events = append(events, event)
+	}
+	c.Assert(events, DeepEquals, expected)
+
+	store := NewMemoryEventStore()
+	store.Append(expected)
+	events = nil
+	for event, err := range store.StreamAll() {
+		c.Assert(err, IsNil)
+		events = append(events, event)
+		// Events appended during the iteration are not streamed.
+		store.Append([]Event{TestEvent{NewUUID(), "event"}})
+	}
+	c.Assert(events, DeepEquals, expected)
+}
@@ -0,0 +1,173 @@
+// Copyright (c) 2014 - Max Persson <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventhorizon
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"iter"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// FileRecordStoreName is the name of the file in the directory of a
+// FileRecordStore that the records are stored in.
+const FileRecordStoreName = "events.jsonl"
+
+// maxRecordSize is the largest record that can be read from a file.
+const maxRecordSize = 64 * 1024 * 1024
+
+// FileRecordStore implements RecordStore with the records of all aggregates
+// appended to a file in a directory, one JSON object per line. Loading reads
+// the whole file, so it is intended for tools, tests and small stores. It is
+// safe for concurrent use within a process.
+type FileRecordStore struct {
+	path string
+	mu   sync.RWMutex
+}
+
+// NewFileRecordStore creates a new FileRecordStore, creating the directory if
+// it does not exist.
+func NewFileRecordStore(dir string) (*FileRecordStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	s := &FileRecordStore{
+		path: filepath.Join(dir, FileRecordStoreName),
+	}
+	return s, nil
+}
+
+// AppendRecords appends records to the file with a single write.
+func (s *FileRecordStore) AppendRecords(records []EventRecord) error {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, record := range records {
+		if err := encoder.Encode(record); err != nil {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// LoadRecords loads all records for the aggregate id from the file.
+// Returns ErrNoEventsFound if no records can be found.
+func (s *FileRecordStore) LoadRecords(id UUID) ([]EventRecord, error) {
+	var records []EventRecord
+	for record, err := range s.StreamRecords(id) {
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	if len(records) == 0 {
+		return nil, ErrNoEventsFound
+	}
+	return records, nil
+}
+
+// StreamRecords returns an iterator that reads the records for the aggregate
+// id from the file one at a time.
+func (s *FileRecordStore) StreamRecords(id UUID) iter.Seq2[EventRecord, error] {
+	return func(yield func(EventRecord, error) bool) {
+		for record, err := range s.StreamAllRecords() {
+			if err != nil {
+				yield(EventRecord{}, err)
+				return
+			}
+			if record.AggregateID == id && !yield(record, nil) {
+				return
+			}
+		}
+	}
+}
+
+// LoadAllRecords loads all records from the file.
+func (s *FileRecordStore) LoadAllRecords() ([]EventRecord, error) {
+	records := make([]EventRecord, 0)
+	for record, err := range s.StreamAllRecords() {
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// StreamAllRecords returns an iterator that reads the records from the file
+// one at a time. Records appended during the iteration are not read. A
+// missing file has no records.
+func (s *FileRecordStore) StreamAllRecords() iter.Seq2[EventRecord, error] {
+	return func(yield func(EventRecord, error) bool) {
+		// Appends are single writes under the lock, so the file ends with a
+		// complete record at the size it has while locked.
+		s.mu.RLock()
+		f, err := os.Open(s.path)
+		var size int64
+		if err == nil {
+			var info os.FileInfo
+			if info, err = f.Stat(); err == nil {
+				size = info.Size()
+			}
+		}
+		s.mu.RUnlock()
+		if os.IsNotExist(err) {
+			return
+		} else if err != nil {
+			if f != nil {
+				f.Close()
+			}
+			yield(EventRecord{}, err)
+			return
+		}
+		defer f.Close()
+
+		scanner := bufio.NewScanner(io.LimitReader(f, size))
+		scanner.Buffer(nil, maxRecordSize)
+		for line := 1; scanner.Scan(); line++ {
+			var record EventRecord
+			if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+				yield(EventRecord{}, fmt.Errorf("%s:%d: %w", s.path, line, err))
+				return
+			}
+			if !yield(record, nil) {
+				return
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			yield(EventRecord{}, err)
+		}
+	}
+}
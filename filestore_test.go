@@ -0,0 +1,101 @@
+// Copyright (c) 2014 - Max Persson <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventhorizon
+
+import (
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+)
+
+var _ = Suite(&FileRecordStoreSuite{})
+
+type FileRecordStoreSuite struct {
+	dir   string
+	store *FileRecordStore
+}
+
+func (s *FileRecordStoreSuite) SetUpTest(c *C) {
+	s.dir = filepath.Join(c.MkDir(), "store")
+	var err error
+	s.store, err = NewFileRecordStore(s.dir)
+	c.Assert(err, Equals, nil)
+}
+
+func (s *FileRecordStoreSuite) Test_LoadRecords_NoRecords(c *C) {
+	records, err := s.store.LoadRecords(NewUUID())
+	c.Assert(err, Equals, ErrNoEventsFound)
+	c.Assert(records, DeepEquals, []EventRecord(nil))
+	records, err = s.store.LoadAllRecords()
+	c.Assert(err, Equals, nil)
+	c.Assert(records, HasLen, 0)
+}
+
+func (s *FileRecordStoreSuite) Test_AppendRecords(c *C) {
+	record1 := EventRecord{NewUUID(), "record1", 1, []byte(`{"Content":"event1"}`)}
+	record2 := EventRecord{NewUUID(), "record2", 2, []byte(`{"Content":"event2"}`)}
+	record3 := EventRecord{record1.AggregateID, "record3", 1, []byte(`{}`)}
+	c.Assert(s.store.AppendRecords([]EventRecord{record1, record2}), Equals, nil)
+	c.Assert(s.store.AppendRecords([]EventRecord{record3}), Equals, nil)
+
+	records, err := s.store.LoadRecords(record1.AggregateID)
+	c.Assert(err, Equals, nil)
+	c.Assert(records, DeepEquals, []EventRecord{record1, record3})
+
+	// The records are kept by a new store for the same directory.
+	store, err := NewFileRecordStore(s.dir)
+	c.Assert(err, Equals, nil)
+	records, err = store.LoadAllRecords()
+	c.Assert(err, Equals, nil)
+	c.Assert(records, DeepEquals, []EventRecord{record1, record2, record3})
+
+	data, err := os.ReadFile(filepath.Join(s.dir, FileRecordStoreName))
+	c.Assert(err, Equals, nil)
+	c.Assert(string(data), Matches, `(\{"aggregate_id":"[0-9a-f-]+","type":"record[123]","version":[12],"data":\{.*\}\}\n){3}`)
+}
+
+func (s *FileRecordStoreSuite) Test_LoadAllRecords_Corrupt(c *C) {
+	record := EventRecord{NewUUID(), "record1", 1, []byte(`{}`)}
+	c.Assert(s.store.AppendRecords([]EventRecord{record}), Equals, nil)
+	f, err := os.OpenFile(filepath.Join(s.dir, FileRecordStoreName), os.O_WRONLY|os.O_APPEND, 0600)
+	c.Assert(err, Equals, nil)
+	f.WriteString("{\"aggregate_id\":\n")
+	f.Close()
+
+	_, err = s.store.LoadAllRecords()
+	c.Assert(err, ErrorMatches, ".*/events.jsonl:2: unexpected end of JSON input")
+}
+
+func (s *FileRecordStoreSuite) Test_StreamAllRecords(c *C) {
+	record1 := EventRecord{NewUUID(), "record1", 1, []byte(`{}`)}
+	record2 := EventRecord{NewUUID(), "record2", 1, []byte(`{}`)}
+	c.Assert(s.store.AppendRecords([]EventRecord{record1, record2}), Equals, nil)
+
+	var records []EventRecord
+	for record, err := range s.store.StreamAllRecords() {
+		c.Assert(err, Equals, nil)
+		records = append(records, record)
+		// Records appended during the iteration are not streamed.
+		c.Assert(s.store.AppendRecords([]EventRecord{record}), Equals, nil)
+	}
+	c.Assert(records, DeepEquals, []EventRecord{record1, record2})
+
+	// The iteration can be stopped.
+	for record := range s.store.StreamAllRecords() {
+		c.Assert(record, DeepEquals, record1)
+		break
+	}
+}
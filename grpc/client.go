@@ -0,0 +1,180 @@
+// Copyright (c) 2014 - Max Persson <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grpc
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"reflect"
+	"strings"
+
+	gogrpc "google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+// Client is a ContextDispatcher that dispatches commands with a remote Server,
+// and an EventBus that publishes the events streamed from the Server to its
+// local subscribers.
+type Client struct {
+	*eh.HandlerEventBus
+
+	conn  gogrpc.ClientConnInterface
+	codec *eh.EventCodec
+}
+
+// NewClient creates a Client on a connection to a Server. The codec is used to
+// decode the streamed events and must have the event types registered.
+func NewClient(conn gogrpc.ClientConnInterface, codec *eh.EventCodec) *Client {
+	c := &Client{
+		HandlerEventBus: eh.NewHandlerEventBus(),
+		conn:            conn,
+		codec:           codec,
+	}
+	return c
+}
+
+// Dispatch dispatches a command with the Server, see DispatchContext.
+func (c *Client) Dispatch(command eh.Command) error {
+	return c.DispatchContext(context.Background(), command)
+}
+
+// DispatchContext dispatches a command with the Server, with the deadline and
+// cancellation of the context. The command is sent with its type name, which
+// must be registered with the Server. The principal of the context and the
+// trace context of the command are sent in the metadata of the call.
+//
+// Errors for missing fields and denied commands are returned as
+// CommandFieldError and UnauthorizedError, and the errors of dispatchers for
+// missing handlers, tenants and the lifecycle of aggregates as their sentinel
+// errors. Other errors are returned as gRPC status errors.
+func (c *Client) DispatchContext(ctx context.Context, command eh.Command) error {
+	data, err := json.Marshal(command)
+	if err != nil {
+		return err
+	}
+
+	var md []string
+	if principal, ok := eh.PrincipalFrom(ctx); ok {
+		md = append(md, principalIDKey, principal.ID)
+		for _, role := range principal.Roles {
+			md = append(md, principalRolesKey, role)
+		}
+		if principal.Tenant != "" {
+			md = append(md, principalTenantKey, principal.Tenant)
+		}
+	}
+	if sc := eh.SpanContextOf(command); sc.IsValid() {
+		md = append(md, traceParentKey, sc.TraceParent())
+	}
+	if len(md) > 0 {
+		ctx = metadata.AppendToOutgoingContext(ctx, md...)
+	}
+
+	req := &commandRequest{
+		Type: reflect.TypeOf(command).Name(),
+		Data: data,
+	}
+	err = c.conn.Invoke(ctx, dispatchMethod, req, &commandReply{},
+		gogrpc.ForceCodec(codec{}))
+	return dispatchError(err)
+}
+
+// Subscribe streams events from the Server and publishes them to the local
+// subscribers until the context is canceled. The stream can be filtered by
+// event type names and aggregate IDs; empty filters match all events.
+func (c *Client) Subscribe(ctx context.Context, types []string, aggregateIDs []eh.UUID) error {
+	req := &subscribeRequest{Types: types}
+	for _, id := range aggregateIDs {
+		req.AggregateIDs = append(req.AggregateIDs, id.String())
+	}
+
+	stream, err := c.conn.NewStream(ctx, &serviceDesc.Streams[0], subscribeMethod,
+		gogrpc.ForceCodec(codec{}))
+	if err != nil {
+		return err
+	}
+	if err := stream.SendMsg(req); err != nil {
+		return err
+	}
+	if err := stream.CloseSend(); err != nil {
+		return err
+	}
+
+	for {
+		m := &eventMessage{}
+		if err := stream.RecvMsg(m); err == io.EOF || ctx.Err() != nil {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		id, err := eh.ParseUUID(m.AggregateID)
+		if err != nil {
+			return err
+		}
+		events, err := c.codec.Decode(eh.EventRecord{
+			AggregateID: id,
+			Type:        m.Type,
+			Version:     m.Version,
+			Data:        m.Data,
+		})
+		if err != nil {
+			return err
+		}
+		for _, event := range events {
+			c.PublishEvent(event)
+		}
+	}
+}
+
+// sentinelErrors are the errors of dispatchers that are recognized by their
+// messages.
+var sentinelErrors = []error{
+	eh.ErrHandlerNotFound,
+	eh.ErrAggregateNotFound,
+	eh.ErrAggregateAlreadyExists,
+	eh.ErrAggregateClosed,
+	eh.ErrCrossTenant,
+}
+
+func dispatchError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	st, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+	for _, sentinel := range sentinelErrors {
+		if st.Message() == sentinel.Error() {
+			return sentinel
+		}
+	}
+	switch st.Code() {
+	case codes.PermissionDenied:
+		return eh.UnauthorizedError{Reason: strings.TrimPrefix(st.Message(), "unauthorized: ")}
+	case codes.InvalidArgument:
+		if field, ok := strings.CutPrefix(st.Message(), "missing field: "); ok {
+			return eh.CommandFieldError{Field: field}
+		}
+	}
+	return err
+}
@@ -0,0 +1,298 @@
+// Copyright (c) 2014 - Max Persson <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	gogrpc "google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	. "gopkg.in/check.v1"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+// Hook up gocheck into the "go test" runner.
+func Test(t *testing.T) { TestingT(t) }
+
+var _ = Suite(&GRPCSuite{})
+
+type GRPCSuite struct {
+	bus        *eh.HandlerEventBus
+	dispatcher *eh.DelegateDispatcher
+	server     *Server
+	grpcServer *gogrpc.Server
+	conn       *gogrpc.ClientConn
+	client     *Client
+}
+
+type TestItemAggregate struct {
+	eh.Aggregate
+}
+
+func (a *TestItemAggregate) HandleCommand(command eh.Command) ([]eh.Event, error) {
+	switch command := command.(type) {
+	case CreateItem:
+		return []eh.Event{ItemCreated{command.ItemID, command.Name}}, nil
+	case RemoveItem:
+		return nil, errors.New("items can not be removed")
+	case TraceItem:
+		return nil, nil
+	}
+	return nil, nil
+}
+
+func (a *TestItemAggregate) HandleEvent(event eh.Event) {}
+
+type CreateItem struct {
+	ItemID eh.UUID
+	Name   string
+}
+
+func (c CreateItem) AggregateID() eh.UUID { return c.ItemID }
+
+type RemoveItem struct {
+	ItemID eh.UUID
+}
+
+func (c RemoveItem) AggregateID() eh.UUID { return c.ItemID }
+
+type RenameItem struct {
+	ItemID eh.UUID
+}
+
+func (c RenameItem) AggregateID() eh.UUID { return c.ItemID }
+
+// TraceItem is a command that does not send its metadata in its data.
+type TraceItem struct {
+	eh.Metadata `json:"-"`
+	ItemID      eh.UUID
+}
+
+func (c TraceItem) AggregateID() eh.UUID { return c.ItemID }
+
+// errorDispatcher is a Dispatcher that fails with an error.
+type errorDispatcher struct {
+	err error
+}
+
+func (d *errorDispatcher) Dispatch(command eh.Command) error { return d.err }
+
+type ItemCreated struct {
+	ItemID eh.UUID
+	Name   string
+}
+
+func (e ItemCreated) AggregateID() eh.UUID { return e.ItemID }
+
+type ItemEvents struct {
+	events chan eh.Event
+}
+
+func (h *ItemEvents) HandleEvent(event eh.Event) {
+	h.events <- event
+}
+
+func (s *GRPCSuite) SetUpTest(c *C) {
+	s.bus = eh.NewHandlerEventBus()
+	s.dispatcher = eh.NewDelegateDispatcher(eh.NewMemoryEventStore(), s.bus)
+	s.dispatcher.AddHandler(&TestItemAggregate{}, CreateItem{})
+	s.dispatcher.AddHandler(&TestItemAggregate{}, RemoveItem{})
+	s.dispatcher.AddHandler(&TestItemAggregate{}, TraceItem{})
+	codec := eh.NewEventCodec()
+	codec.RegisterEvent(ItemCreated{}, 1)
+
+	s.server = NewServer(s.dispatcher, codec)
+	s.bus.AddGlobalSubscriber(s.server)
+	s.grpcServer = gogrpc.NewServer(ServerOption())
+	s.server.Register(s.grpcServer)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, Equals, nil)
+	go s.grpcServer.Serve(listener)
+
+	s.conn, err = gogrpc.NewClient(listener.Addr().String(),
+		gogrpc.WithTransportCredentials(insecure.NewCredentials()))
+	c.Assert(err, Equals, nil)
+	s.client = NewClient(s.conn, codec)
+}
+
+func (s *GRPCSuite) TearDownTest(c *C) {
+	s.conn.Close()
+	s.grpcServer.Stop()
+}
+
+// subscribe subscribes the client and waits for the server to add it.
+func (s *GRPCSuite) subscribe(c *C, types []string, ids []eh.UUID) (*ItemEvents, context.CancelFunc) {
+	handler := &ItemEvents{make(chan eh.Event, 10)}
+	s.client.AddGlobalSubscriber(handler)
+	ctx, cancel := context.WithCancel(context.Background())
+	go s.client.Subscribe(ctx, types, ids)
+
+	for i := 0; i < 100; i++ {
+		s.server.subscribersMu.Lock()
+		n := len(s.server.subscribers)
+		s.server.subscribersMu.Unlock()
+		if n > 0 {
+			return handler, cancel
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Fatal("client did not subscribe")
+	return nil, nil
+}
+
+func (s *GRPCSuite) Test_Dispatch(c *C) {
+	handler := &ItemEvents{make(chan eh.Event, 10)}
+	s.bus.AddGlobalSubscriber(handler)
+	command := CreateItem{eh.NewUUID(), "item1"}
+	err := s.client.Dispatch(command)
+	c.Assert(err, Equals, nil)
+	c.Assert(<-handler.events, Equals, ItemCreated{command.ItemID, "item1"})
+}
+
+func (s *GRPCSuite) Test_Dispatch_Errors(c *C) {
+	err := s.client.Dispatch(CreateItem{ItemID: eh.NewUUID()})
+	c.Assert(err, Equals, eh.CommandFieldError{Field: "Name"})
+
+	err = s.client.Dispatch(RemoveItem{eh.NewUUID()})
+	c.Assert(err, ErrorMatches, ".*FailedPrecondition.*items can not be removed")
+
+	err = s.client.Dispatch(RenameItem{eh.NewUUID()})
+	c.Assert(err, ErrorMatches, ".*Unimplemented.*unknown command.*")
+}
+
+func (s *GRPCSuite) Test_Dispatch_Unauthorized(c *C) {
+	authorizer := eh.NewAuthorizer()
+	authorizer.AddPolicy(CreateItem{}, func(ctx context.Context, command eh.Command, aggregate eh.Aggregate) error {
+		return eh.Deny("read only")
+	})
+	s.dispatcher.SetAuthorizer(authorizer)
+	err := s.client.Dispatch(CreateItem{eh.NewUUID(), "item1"})
+	c.Assert(err, Equals, eh.UnauthorizedError{Reason: "read only"})
+}
+
+func (s *GRPCSuite) Test_Dispatch_InternalErrors(c *C) {
+	codec := eh.NewEventCodec()
+	data := []byte(`{"ItemID":"` + eh.NewUUID().String() + `","Name":"item1"}`)
+	for _, t := range []struct {
+		err     error
+		code    codes.Code
+		message string
+		client  error
+	}{
+		{eh.EventStoreError{Err: errors.New("disk full: /var/lib/eh")}, codes.Internal, "internal error", nil},
+		{fmt.Errorf("could not load: %w", eh.ErrAggregateClosed), codes.FailedPrecondition, "aggregate is closed", eh.ErrAggregateClosed},
+		{eh.ErrCrossTenant, codes.PermissionDenied, "cross-tenant access", eh.ErrCrossTenant},
+	} {
+		server := NewServer(&errorDispatcher{t.err}, codec)
+		server.AddCommand("CreateItem", CreateItem{})
+		_, err := server.dispatch(context.Background(), &commandRequest{Type: "CreateItem", Data: data})
+		st, _ := status.FromError(err)
+		c.Assert(st.Code(), Equals, t.code)
+		c.Assert(st.Message(), Equals, t.message)
+		if t.client != nil {
+			c.Assert(dispatchError(err), Equals, t.client)
+		}
+	}
+}
+
+func (s *GRPCSuite) Test_DispatchContext_Metadata(c *C) {
+	var principal eh.Principal
+	authorizer := eh.NewAuthorizer()
+	authorizer.AddPolicy(TraceItem{}, func(ctx context.Context, command eh.Command, aggregate eh.Aggregate) error {
+		principal, _ = eh.PrincipalFrom(ctx)
+		return nil
+	})
+	s.dispatcher.SetAuthorizer(authorizer)
+	tracer := &eh.MemoryTracer{}
+	s.dispatcher.SetTracer(tracer)
+
+	parent := eh.SpanContext{TraceID: [16]byte{1}, SpanID: [8]byte{2}}
+	command := TraceItem{eh.Metadata{eh.MetadataTraceParent: parent.TraceParent()}, eh.NewUUID()}
+	ctx := eh.WithPrincipal(context.Background(), eh.Principal{ID: "user1", Roles: []string{"a", "b"}, Tenant: "tenant1"})
+
+	// The principal is only used by servers that trust their clients.
+	c.Assert(s.client.DispatchContext(ctx, command), Equals, nil)
+	c.Assert(principal, DeepEquals, eh.Principal{})
+	spans := tracer.GetSpans()
+	c.Assert(spans[len(spans)-1].Parent, Equals, parent)
+
+	s.server.SetPrincipalMetadata(true)
+	c.Assert(s.client.DispatchContext(ctx, command), Equals, nil)
+	c.Assert(principal, DeepEquals, eh.Principal{ID: "user1", Roles: []string{"a", "b"}, Tenant: "tenant1"})
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	err := s.client.DispatchContext(canceled, command)
+	st, _ := status.FromError(err)
+	c.Assert(st.Code(), Equals, codes.Canceled)
+}
+
+func (s *GRPCSuite) Test_Subscribe(c *C) {
+	handler, cancel := s.subscribe(c, nil, nil)
+	defer cancel()
+
+	event := ItemCreated{eh.NewUUID(), "item1"}
+	s.bus.PublishEvent(event)
+	select {
+	case e := <-handler.events:
+		c.Assert(e, Equals, event)
+	case <-time.After(time.Second):
+		c.Fatal("no event received")
+	}
+}
+
+func (s *GRPCSuite) Test_Subscribe_Filter(c *C) {
+	id := eh.NewUUID()
+	handler, cancel := s.subscribe(c, []string{"ItemCreated"}, []eh.UUID{id})
+	defer cancel()
+
+	s.bus.PublishEvent(ItemCreated{eh.NewUUID(), "item1"})
+	s.bus.PublishEvent(ItemCreated{id, "item2"})
+	c.Assert(<-handler.events, Equals, ItemCreated{id, "item2"})
+}
+
+func (s *GRPCSuite) Test_Subscribe_SlowSubscriber(c *C) {
+	s.server.SetBufferSize(0)
+	sub := &subscriber{events: make(chan *eventMessage), dropped: make(chan struct{})}
+	s.server.subscribers[sub] = true
+	s.server.HandleEvent(ItemCreated{eh.NewUUID(), "item1"})
+	<-sub.dropped
+	c.Assert(len(s.server.subscribers), Equals, 0)
+}
+
+func (s *GRPCSuite) Test_Messages(c *C) {
+	m := &eventMessage{Type: "ItemCreated", Version: 2, AggregateID: "id", Data: []byte("{}")}
+	var decoded eventMessage
+	err := codec{}.Unmarshal(m.marshal(), &decoded)
+	c.Assert(err, Equals, nil)
+	c.Assert(decoded, DeepEquals, *m)
+
+	req := &subscribeRequest{Types: []string{"a", "b"}, AggregateIDs: []string{"c"}}
+	var decodedReq subscribeRequest
+	err = codec{}.Unmarshal(req.marshal(), &decodedReq)
+	c.Assert(err, Equals, nil)
+	c.Assert(decodedReq, DeepEquals, *req)
+
+	err = codec{}.Unmarshal([]byte{0xff}, &decodedReq)
+	c.Assert(err, Not(Equals), nil)
+}
@@ -0,0 +1,217 @@
+// Copyright (c) 2014 - Max Persson <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grpc
+
+import (
+	"fmt"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+// The messages of eventhorizon.proto, encoded with the protobuf wire format
+// so that clients in other languages can be generated from the proto file.
+
+type commandRequest struct {
+	Type string
+	Data []byte
+}
+
+type commandReply struct{}
+
+type subscribeRequest struct {
+	Types        []string
+	AggregateIDs []string
+}
+
+type eventMessage struct {
+	Type        string
+	Version     int
+	AggregateID string
+	Data        []byte
+}
+
+type message interface {
+	marshal() []byte
+	unmarshal([]byte) error
+}
+
+func (m *commandRequest) marshal() []byte {
+	var b []byte
+	b = appendString(b, 1, m.Type)
+	b = appendBytes(b, 2, m.Data)
+	return b
+}
+
+func (m *commandRequest) unmarshal(b []byte) error {
+	return unmarshalFields(b, func(num protowire.Number, typ protowire.Type, b []byte) int {
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			return consumeString(b, &m.Type)
+		case num == 2 && typ == protowire.BytesType:
+			return consumeBytes(b, &m.Data)
+		}
+		return skipField
+	})
+}
+
+func (m *commandReply) marshal() []byte {
+	return nil
+}
+
+func (m *commandReply) unmarshal(b []byte) error {
+	return unmarshalFields(b, func(protowire.Number, protowire.Type, []byte) int {
+		return skipField
+	})
+}
+
+func (m *subscribeRequest) marshal() []byte {
+	var b []byte
+	for _, t := range m.Types {
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendString(b, t)
+	}
+	for _, id := range m.AggregateIDs {
+		b = protowire.AppendTag(b, 2, protowire.BytesType)
+		b = protowire.AppendString(b, id)
+	}
+	return b
+}
+
+func (m *subscribeRequest) unmarshal(b []byte) error {
+	return unmarshalFields(b, func(num protowire.Number, typ protowire.Type, b []byte) int {
+		var value string
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			n := consumeString(b, &value)
+			m.Types = append(m.Types, value)
+			return n
+		case num == 2 && typ == protowire.BytesType:
+			n := consumeString(b, &value)
+			m.AggregateIDs = append(m.AggregateIDs, value)
+			return n
+		}
+		return skipField
+	})
+}
+
+func (m *eventMessage) marshal() []byte {
+	var b []byte
+	b = appendString(b, 1, m.Type)
+	if m.Version != 0 {
+		b = protowire.AppendTag(b, 2, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(m.Version))
+	}
+	b = appendString(b, 3, m.AggregateID)
+	b = appendBytes(b, 4, m.Data)
+	return b
+}
+
+func (m *eventMessage) unmarshal(b []byte) error {
+	return unmarshalFields(b, func(num protowire.Number, typ protowire.Type, b []byte) int {
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			return consumeString(b, &m.Type)
+		case num == 2 && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			m.Version = int(int64(v))
+			return n
+		case num == 3 && typ == protowire.BytesType:
+			return consumeString(b, &m.AggregateID)
+		case num == 4 && typ == protowire.BytesType:
+			return consumeBytes(b, &m.Data)
+		}
+		return skipField
+	})
+}
+
+// skipField is returned by field functions for fields that are not known.
+const skipField = 0
+
+func unmarshalFields(b []byte, field func(protowire.Number, protowire.Type, []byte) int) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		n = field(num, typ, b)
+		if n == skipField {
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
+func appendString(b []byte, num protowire.Number, v string) []byte {
+	if v == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, v)
+}
+
+func appendBytes(b []byte, num protowire.Number, v []byte) []byte {
+	if len(v) == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, v)
+}
+
+func consumeString(b []byte, v *string) int {
+	s, n := protowire.ConsumeString(b)
+	*v = s
+	return n
+}
+
+func consumeBytes(b []byte, v *[]byte) int {
+	data, n := protowire.ConsumeBytes(b)
+	*v = append([]byte(nil), data...)
+	return n
+}
+
+// codec marshals the messages of the service and falls back to protobuf for
+// other messages, so it can be used on servers with other services.
+type codec struct{}
+
+func (codec) Marshal(v interface{}) ([]byte, error) {
+	switch m := v.(type) {
+	case message:
+		return m.marshal(), nil
+	case proto.Message:
+		return proto.Marshal(m)
+	}
+	return nil, fmt.Errorf("grpc: cannot marshal %T", v)
+}
+
+func (codec) Unmarshal(data []byte, v interface{}) error {
+	switch m := v.(type) {
+	case message:
+		return m.unmarshal(data)
+	case proto.Message:
+		return proto.Unmarshal(data, m)
+	}
+	return fmt.Errorf("grpc: cannot unmarshal %T", v)
+}
+
+func (codec) Name() string {
+	return "proto"
+}
@@ -0,0 +1,349 @@
+// Copyright (c) 2014 - Max Persson <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package grpc provides a gRPC transport for dispatching commands and
+// subscribing to events across process boundaries, as defined by
+// eventhorizon.proto.
+package grpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"reflect"
+	"sync"
+
+	gogrpc "google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+// DefaultBufferSize is the number of events buffered for each subscriber of a
+// Server.
+const DefaultBufferSize = 256
+
+// The keys of the gRPC metadata that the Client sends the principal of the
+// context and the trace context of the command with.
+const (
+	principalIDKey     = "eh-principal-id"
+	principalRolesKey  = "eh-principal-roles"
+	principalTenantKey = "eh-principal-tenant"
+	traceParentKey     = "traceparent"
+)
+
+const (
+	serviceName     = "eventhorizon.EventHorizon"
+	dispatchMethod  = "/" + serviceName + "/Dispatch"
+	subscribeMethod = "/" + serviceName + "/Subscribe"
+)
+
+// Server serves the EventHorizon gRPC service. Commands are decoded and
+// dispatched with a dispatcher and events are encoded with an event codec and
+// streamed to subscribers. The Server should be added as a global subscriber
+// of the event bus that the dispatcher publishes to.
+//
+// A subscriber that is too slow to keep its buffer from filling up gets its
+// stream ended with codes.ResourceExhausted instead of blocking the event bus.
+type Server struct {
+	dispatcher    eh.Dispatcher
+	codec         *eh.EventCodec
+	commands      map[string]reflect.Type
+	subscribers   map[*subscriber]bool
+	subscribersMu sync.Mutex
+	bufferSize    int
+	principals    bool
+	logger        eh.Logger
+}
+
+type subscriber struct {
+	events       chan *eventMessage
+	dropped      chan struct{}
+	types        map[string]bool
+	aggregateIDs map[string]bool
+}
+
+// eventHorizonServer is the handler type of the service.
+type eventHorizonServer interface {
+	dispatch(context.Context, *commandRequest) (*commandReply, error)
+	subscribe(*subscribeRequest, gogrpc.ServerStream) error
+}
+
+var serviceDesc = gogrpc.ServiceDesc{
+	ServiceName: serviceName,
+	HandlerType: (*eventHorizonServer)(nil),
+	Methods: []gogrpc.MethodDesc{
+		{MethodName: "Dispatch", Handler: dispatchHandler},
+	},
+	Streams: []gogrpc.StreamDesc{
+		{StreamName: "Subscribe", Handler: subscribeHandler, ServerStreams: true},
+	},
+	Metadata: "eventhorizon.proto",
+}
+
+// NewServer creates a Server. If the dispatcher has a Commands method, like
+// DelegateDispatcher and ReflectDispatcher, all its commands are registered by
+// their type names.
+func NewServer(dispatcher eh.Dispatcher, codec *eh.EventCodec) *Server {
+	s := &Server{
+		dispatcher:  dispatcher,
+		codec:       codec,
+		commands:    make(map[string]reflect.Type),
+		subscribers: make(map[*subscriber]bool),
+		bufferSize:  DefaultBufferSize,
+		logger:      slog.Default(),
+	}
+
+	if d, ok := dispatcher.(interface{ Commands() []eh.Command }); ok {
+		for _, command := range d.Commands() {
+			s.AddCommand(reflect.TypeOf(command).Name(), command)
+		}
+	}
+	return s
+}
+
+// ServerOption returns the option that gRPC servers serving a Server must be
+// created with. Messages of other services are still encoded as protobuf.
+func ServerOption() gogrpc.ServerOption {
+	return gogrpc.ForceServerCodec(codec{})
+}
+
+// Register registers the service on a gRPC server.
+func (s *Server) Register(registrar gogrpc.ServiceRegistrar) {
+	registrar.RegisterService(&serviceDesc, s)
+}
+
+// AddCommand registers a command type by name.
+func (s *Server) AddCommand(name string, command eh.Command) {
+	s.commands[name] = reflect.TypeOf(command)
+}
+
+// SetBufferSize sets the number of events buffered for each new subscriber.
+func (s *Server) SetBufferSize(size int) {
+	s.bufferSize = size
+}
+
+// SetPrincipalMetadata sets if the principal that a Client sends in the
+// metadata of a call is used for calls without a principal in their context.
+// It must only be enabled for servers that only accept calls from trusted
+// clients, as the principal is not authenticated.
+func (s *Server) SetPrincipalMetadata(enabled bool) {
+	s.principals = enabled
+}
+
+// SetLogger sets the logger used to log events that could not be streamed.
+func (s *Server) SetLogger(logger eh.Logger) {
+	s.logger = logger
+}
+
+// HandleEvent encodes an event and sends it to all subscribers with a matching
+// filter. Subscribers with a full buffer are dropped.
+func (s *Server) HandleEvent(event eh.Event) {
+	record, err := s.codec.Encode(event)
+	if err != nil {
+		s.logger.Error("could not encode event for subscribers",
+			eh.LogKeyEventType, reflect.TypeOf(event).String(),
+			eh.LogKeyError, err,
+		)
+		return
+	}
+	m := &eventMessage{
+		Type:        record.Type,
+		Version:     record.Version,
+		AggregateID: record.AggregateID.String(),
+		Data:        record.Data,
+	}
+
+	s.subscribersMu.Lock()
+	defer s.subscribersMu.Unlock()
+	for sub := range s.subscribers {
+		if !sub.match(m) {
+			continue
+		}
+		select {
+		case sub.events <- m:
+		default:
+			close(sub.dropped)
+			delete(s.subscribers, sub)
+			s.logger.Warn("subscriber too slow, dropping",
+				eh.LogKeyEventType, record.Type,
+			)
+		}
+	}
+}
+
+func (s *Server) dispatch(ctx context.Context, req *commandRequest) (*commandReply, error) {
+	commandType, ok := s.commands[req.Type]
+	if !ok {
+		return nil, status.Errorf(codes.Unimplemented, "%s: %s", eh.ErrUnknownCommand, req.Type)
+	}
+
+	command := reflect.New(commandType)
+	decoder := json.NewDecoder(bytes.NewReader(req.Data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(command.Interface()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	ctx, cmd := s.withMetadata(ctx, command.Elem().Interface().(eh.Command))
+	err := s.dispatchCommand(ctx, cmd)
+	var fieldErr eh.CommandFieldError
+	var storeErr eh.EventStoreError
+	switch {
+	case err == nil:
+		return &commandReply{}, nil
+	case errors.As(err, &fieldErr):
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, eh.ErrHandlerNotFound), errors.Is(err, eh.ErrAggregateNotFound):
+		return nil, status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, eh.ErrAggregateAlreadyExists):
+		return nil, status.Error(codes.AlreadyExists, err.Error())
+	case errors.Is(err, eh.ErrAggregateClosed):
+		return nil, status.Error(codes.FailedPrecondition, eh.ErrAggregateClosed.Error())
+	case errors.Is(err, eh.ErrCrossTenant):
+		return nil, status.Error(codes.PermissionDenied, eh.ErrCrossTenant.Error())
+	case errors.Is(err, eh.ErrUnauthorized):
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	case errors.As(err, &storeErr):
+		// The errors of the infrastructure are not for the client.
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	return nil, status.Error(codes.FailedPrecondition, err.Error())
+}
+
+// withMetadata adds the principal and trace context in the metadata of a call
+// to the context and command, if they do not already have them.
+func (s *Server) withMetadata(ctx context.Context, command eh.Command) (context.Context, eh.Command) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ctx, command
+	}
+
+	if ids := md.Get(principalIDKey); s.principals && len(ids) > 0 {
+		if _, ok := eh.PrincipalFrom(ctx); !ok {
+			principal := eh.Principal{
+				ID:    ids[0],
+				Roles: md.Get(principalRolesKey),
+			}
+			if tenants := md.Get(principalTenantKey); len(tenants) > 0 {
+				principal.Tenant = tenants[0]
+			}
+			ctx = eh.WithPrincipal(ctx, principal)
+		}
+	}
+
+	if traceParents := md.Get(traceParentKey); len(traceParents) > 0 &&
+		!eh.SpanContextOf(command).IsValid() {
+		if _, err := eh.ParseTraceParent(traceParents[0]); err == nil {
+			command = eh.WithMetadata(command, eh.MetadataTraceParent, traceParents[0])
+		}
+	}
+	return ctx, command
+}
+
+// dispatchCommand dispatches a command with the context of the call, which can
+// carry a principal set by an authenticating interceptor.
+func (s *Server) dispatchCommand(ctx context.Context, command eh.Command) error {
+	if d, ok := s.dispatcher.(eh.ContextDispatcher); ok {
+		return d.DispatchContext(ctx, command)
+	}
+	return s.dispatcher.Dispatch(command)
+}
+
+func (s *Server) subscribe(req *subscribeRequest, stream gogrpc.ServerStream) error {
+	sub := &subscriber{
+		events:  make(chan *eventMessage, s.bufferSize),
+		dropped: make(chan struct{}),
+	}
+	if len(req.Types) > 0 {
+		sub.types = make(map[string]bool)
+		for _, t := range req.Types {
+			sub.types[t] = true
+		}
+	}
+	if len(req.AggregateIDs) > 0 {
+		sub.aggregateIDs = make(map[string]bool)
+		for _, rawID := range req.AggregateIDs {
+			id, err := eh.ParseUUID(rawID)
+			if err != nil {
+				return status.Error(codes.InvalidArgument, err.Error())
+			}
+			sub.aggregateIDs[id.String()] = true
+		}
+	}
+
+	s.subscribersMu.Lock()
+	s.subscribers[sub] = true
+	s.subscribersMu.Unlock()
+	defer func() {
+		s.subscribersMu.Lock()
+		delete(s.subscribers, sub)
+		s.subscribersMu.Unlock()
+	}()
+
+	// Let the client know that it is subscribed.
+	if err := stream.SendHeader(nil); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case <-sub.dropped:
+			return status.Error(codes.ResourceExhausted, "subscriber too slow")
+		case m := <-sub.events:
+			if err := stream.SendMsg(m); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (s *subscriber) match(m *eventMessage) bool {
+	if s.types != nil && !s.types[m.Type] {
+		return false
+	}
+	if s.aggregateIDs != nil && !s.aggregateIDs[m.AggregateID] {
+		return false
+	}
+	return true
+}
+
+func dispatchHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor gogrpc.UnaryServerInterceptor) (interface{}, error) {
+	req := &commandRequest{}
+	if err := dec(req); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(eventHorizonServer).dispatch(ctx, req)
+	}
+	info := &gogrpc.UnaryServerInfo{Server: srv, FullMethod: dispatchMethod}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(eventHorizonServer).dispatch(ctx, req.(*commandRequest))
+	}
+	return interceptor(ctx, req, info, handler)
+}
+
+func subscribeHandler(srv interface{}, stream gogrpc.ServerStream) error {
+	req := &subscribeRequest{}
+	if err := stream.RecvMsg(req); err != nil {
+		return err
+	}
+	return srv.(eventHorizonServer).subscribe(req, stream)
+}
@@ -0,0 +1,685 @@
+// Copyright (c) 2014 - Max Persson <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventhorizon
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"iter"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+)
+
+// Error returned when a checkpoint signature is invalid.
+var ErrInvalidCheckpoint = errors.New("invalid checkpoint signature")
+
+// ChainError is returned by Verify when the hash chain is broken.
+type ChainError struct {
+	// Position is the global position of the first broken link.
+	Position int
+	// AggregateID and Index identifies the event in its stream.
+	AggregateID UUID
+	Index       int
+	Reason      string
+}
+
+func (e ChainError) Error() string {
+	return fmt.Sprintf("broken hash chain at position %d (%s:%d): %s",
+		e.Position, e.AggregateID, e.Index, e.Reason)
+}
+
+// ChainLink is a link in the hash chain for one appended event.
+//
+// StreamHash chains the event to the previous event of the same aggregate and
+// Hash chains it to the previous event in the whole store.
+type ChainLink struct {
+	AggregateID UUID
+	Index       int
+	EventHash   []byte
+	StreamHash  []byte
+	Hash        []byte
+}
+
+// Checkpoint is a signed global hash at a position in the chain.
+type Checkpoint struct {
+	Position  int
+	Hash      []byte
+	Signature []byte
+}
+
+// ChainStore persists the links and checkpoints of a hash chain, so that the
+// chain outlives the process and can be verified against the events later.
+type ChainStore interface {
+	// AppendChain appends links and the checkpoints signed at them.
+	AppendChain([]ChainLink, []Checkpoint) error
+
+	// LoadChain loads all links and checkpoints in the order they were
+	// appended.
+	LoadChain() ([]ChainLink, []Checkpoint, error)
+}
+
+// FileChainStoreName is the name of the file in the directory of a
+// FileChainStore that the chain is stored in.
+const FileChainStoreName = "chain.jsonl"
+
+// FileChainStore implements ChainStore with the links and checkpoints
+// appended to a file in a directory, one JSON object per line. It can share
+// the directory of a FileRecordStore, to keep the chain next to the events.
+type FileChainStore struct {
+	path string
+	mu   sync.Mutex
+}
+
+// chainEntry is a line of a FileChainStore.
+type chainEntry struct {
+	Link       *ChainLink  `json:"link,omitempty"`
+	Checkpoint *Checkpoint `json:"checkpoint,omitempty"`
+}
+
+// NewFileChainStore creates a new FileChainStore, creating the directory if
+// it does not exist.
+func NewFileChainStore(dir string) (*FileChainStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	s := &FileChainStore{
+		path: filepath.Join(dir, FileChainStoreName),
+	}
+	return s, nil
+}
+
+// AppendChain appends links and checkpoints to the file with a single write.
+func (s *FileChainStore) AppendChain(links []ChainLink, checkpoints []Checkpoint) error {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for i := range links {
+		if err := encoder.Encode(chainEntry{Link: &links[i]}); err != nil {
+			return err
+		}
+	}
+	for i := range checkpoints {
+		if err := encoder.Encode(chainEntry{Checkpoint: &checkpoints[i]}); err != nil {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// LoadChain loads the links and checkpoints from the file. A missing file has
+// an empty chain.
+func (s *FileChainStore) LoadChain() ([]ChainLink, []Checkpoint, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	links := make([]ChainLink, 0)
+	checkpoints := make([]Checkpoint, 0)
+	f, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return links, checkpoints, nil
+	} else if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		var entry chainEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, nil, fmt.Errorf("%s:%d: %w", s.path, line, err)
+		}
+		if entry.Link != nil {
+			links = append(links, *entry.Link)
+		}
+		if entry.Checkpoint != nil {
+			checkpoints = append(checkpoints, *entry.Checkpoint)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+	return links, checkpoints, nil
+}
+
+// hashChain is the chain of links and checkpoints of the stored records, that
+// is shared by HashChainEventStore and HashChainRecordStore.
+type hashChain struct {
+	chainStore  ChainStore
+	links       []ChainLink
+	streams     map[UUID][]int
+	signingKey  ed25519.PrivateKey
+	interval    int
+	checkpoints []Checkpoint
+	mu          sync.Mutex
+}
+
+func newHashChain() *hashChain {
+	c := &hashChain{
+		links:       make([]ChainLink, 0),
+		streams:     make(map[UUID][]int),
+		checkpoints: make([]Checkpoint, 0),
+	}
+	return c
+}
+
+// load continues the chain that is stored in a ChainStore.
+func (c *hashChain) load(chainStore ChainStore) error {
+	links, checkpoints, err := chainStore.LoadChain()
+	if err != nil {
+		return err
+	}
+
+	c.chainStore = chainStore
+	for i, link := range links {
+		c.streams[link.AggregateID] = append(c.streams[link.AggregateID], i)
+	}
+	c.links = append(c.links, links...)
+	c.checkpoints = append(c.checkpoints, checkpoints...)
+	return nil
+}
+
+// SetSigningKey enables signed checkpoints for every interval appended events.
+func (c *hashChain) SetSigningKey(key ed25519.PrivateKey, interval int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.signingKey = key
+	c.interval = interval
+}
+
+// appendRecords chains the records after they are appended by the store
+// function, which is called with the chain locked.
+func (c *hashChain) appendRecords(records []EventRecord, store func() error) error {
+	// Hash before appending to not leave the chain behind on errors.
+	hashes := make([][]byte, len(records))
+	for i, record := range records {
+		hashes[i] = hashRecord(record)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := store(); err != nil {
+		return err
+	}
+
+	// The links are only kept when they are persisted, so that events that
+	// were appended without them are found by Verify.
+	links, checkpoints := len(c.links), len(c.checkpoints)
+	for i, record := range records {
+		c.addLink(record.AggregateID, hashes[i])
+	}
+	if c.chainStore != nil {
+		if err := c.chainStore.AppendChain(c.links[links:], c.checkpoints[checkpoints:]); err != nil {
+			c.removeLinks(links, checkpoints)
+			return err
+		}
+	}
+	return nil
+}
+
+// verify checks all records in the order they were appended, and then the
+// records of each chained aggregate.
+func (c *hashChain) verify(all iter.Seq2[EventRecord, error], stream func(UUID) iter.Seq2[EventRecord, error]) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.verifyAll(all); err != nil {
+		return err
+	}
+	for _, link := range c.links {
+		if link.Index == 0 {
+			if err := c.verifyStream(link.AggregateID, stream(link.AggregateID)); err != nil {
+				return err
+			}
+		}
+	}
+
+	if c.signingKey != nil {
+		return c.verifyCheckpoints(c.signingKey.Public().(ed25519.PublicKey))
+	}
+	return nil
+}
+
+// verifyAll checks all records of the base store against the links in order.
+func (c *hashChain) verifyAll(records iter.Seq2[EventRecord, error]) error {
+	var prevHash []byte
+	indexes := make(map[UUID]int)
+	position := 0
+	for record, err := range records {
+		if err != nil {
+			return err
+		}
+		id := record.AggregateID
+		index := indexes[id]
+		indexes[id]++
+		if position >= len(c.links) {
+			return ChainError{position, id, index, "unchained event"}
+		}
+		link := c.links[position]
+		if link.AggregateID != id || link.Index != index {
+			return ChainError{position, id, index, "unchained event"}
+		}
+
+		eventHash := hashRecord(record)
+		if !bytes.Equal(eventHash, link.EventHash) {
+			return ChainError{position, id, index, "event hash mismatch"}
+		}
+		hash := chainHash(prevHash, eventHash)
+		if !bytes.Equal(hash, link.Hash) {
+			return ChainError{position, id, index, "hash mismatch"}
+		}
+		prevHash = hash
+		position++
+	}
+	if position < len(c.links) {
+		link := c.links[position]
+		return ChainError{position, link.AggregateID, link.Index, "missing event"}
+	}
+	return nil
+}
+
+// verifyStream checks the records of an aggregate against its links.
+func (c *hashChain) verifyStream(id UUID, records iter.Seq2[EventRecord, error]) error {
+	stream := c.streams[id]
+	var prevStreamHash []byte
+	index := 0
+	for record, err := range records {
+		if err != nil {
+			return err
+		}
+		if index >= len(stream) {
+			return ChainError{len(c.links), id, index, "unchained event"}
+		}
+		position := stream[index]
+		link := c.links[position]
+
+		eventHash := hashRecord(record)
+		if !bytes.Equal(eventHash, link.EventHash) {
+			return ChainError{position, id, index, "event hash mismatch"}
+		}
+		streamHash := chainHash(prevStreamHash, eventHash)
+		if !bytes.Equal(streamHash, link.StreamHash) {
+			return ChainError{position, id, index, "stream hash mismatch"}
+		}
+		prevStreamHash = streamHash
+		index++
+	}
+	if index < len(stream) {
+		return ChainError{stream[index], id, index, "missing event"}
+	}
+	return nil
+}
+
+// VerifyCheckpoints verifies the checkpoint signatures with a public key and
+// that they match the chain.
+func (c *hashChain) VerifyCheckpoints(key ed25519.PublicKey) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.verifyCheckpoints(key)
+}
+
+func (c *hashChain) verifyCheckpoints(key ed25519.PublicKey) error {
+	for _, checkpoint := range c.checkpoints {
+		if checkpoint.Position >= len(c.links) ||
+			!bytes.Equal(checkpoint.Hash, c.links[checkpoint.Position].Hash) ||
+			!ed25519.Verify(key, checkpoint.Hash, checkpoint.Signature) {
+			return ErrInvalidCheckpoint
+		}
+	}
+	return nil
+}
+
+// GetLinks returns a copy of the links of the chain in the order they were
+// appended.
+func (c *hashChain) GetLinks() []ChainLink {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]ChainLink(nil), c.links...)
+}
+
+// GetCheckpoints returns a copy of the signed checkpoints.
+func (c *hashChain) GetCheckpoints() []Checkpoint {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Checkpoint(nil), c.checkpoints...)
+}
+
+func (c *hashChain) addLink(id UUID, eventHash []byte) {
+	var prevHash, prevStreamHash []byte
+	if len(c.links) > 0 {
+		prevHash = c.links[len(c.links)-1].Hash
+	}
+	stream := c.streams[id]
+	if len(stream) > 0 {
+		prevStreamHash = c.links[stream[len(stream)-1]].StreamHash
+	}
+
+	link := ChainLink{
+		AggregateID: id,
+		Index:       len(stream),
+		EventHash:   eventHash,
+		StreamHash:  chainHash(prevStreamHash, eventHash),
+		Hash:        chainHash(prevHash, eventHash),
+	}
+	c.streams[id] = append(stream, len(c.links))
+	c.links = append(c.links, link)
+
+	if c.signingKey != nil && c.interval > 0 && len(c.links)%c.interval == 0 {
+		c.checkpoints = append(c.checkpoints, Checkpoint{
+			Position:  len(c.links) - 1,
+			Hash:      link.Hash,
+			Signature: ed25519.Sign(c.signingKey, link.Hash),
+		})
+	}
+}
+
+// removeLinks removes the links and checkpoints after the given lengths.
+func (c *hashChain) removeLinks(links, checkpoints int) {
+	for _, link := range c.links[links:] {
+		stream := c.streams[link.AggregateID]
+		if len(stream) == 1 {
+			delete(c.streams, link.AggregateID)
+		} else {
+			c.streams[link.AggregateID] = stream[:len(stream)-1]
+		}
+	}
+	c.links = c.links[:links]
+	c.checkpoints = c.checkpoints[:checkpoints]
+}
+
+// HashChainEventStore wraps an EventStore and makes it tamper-evident.
+//
+// Each appended event is chained with a cryptographic hash of the previous
+// event, both per aggregate and globally. Verify walks the base store and
+// reports the first event that does not match its link. Optionally the
+// global hash is signed with Ed25519 at regular intervals. Appends are
+// serialized to keep the chain in the order of the base store, which must be
+// the only writer of the base store.
+//
+// Events are hashed as the records they are stored as. For a CodecEventStore
+// the stored records are hashed with their type name, version and data, so
+// that upcasters and renamed Go types do not break the chain; records that
+// are encrypted by a CryptoRecordStore should be chained below it with a
+// HashChainRecordStore, to also verify after Forget. Events of other stores
+// are hashed as records of their Go type name and JSON.
+//
+// The chain is kept in memory unless a ChainStore is used, see
+// NewPersistentHashChainEventStore.
+type HashChainEventStore struct {
+	*hashChain
+	eventStore EventStore
+}
+
+// NewHashChainEventStore creates a new HashChainEventStore.
+func NewHashChainEventStore(eventStore EventStore) *HashChainEventStore {
+	s := &HashChainEventStore{
+		hashChain:  newHashChain(),
+		eventStore: eventStore,
+	}
+	return s
+}
+
+// NewPersistentHashChainEventStore creates a new HashChainEventStore that
+// persists its chain in a ChainStore, and continues the chain that is already
+// stored in it.
+func NewPersistentHashChainEventStore(eventStore EventStore, chainStore ChainStore) (*HashChainEventStore, error) {
+	s := NewHashChainEventStore(eventStore)
+	if err := s.load(chainStore); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Append appends all events to the base store and chains them.
+// Returns ErrNoEventStoreDefined if no event store could be found.
+func (s *HashChainEventStore) Append(events []Event) error {
+	if s.eventStore == nil {
+		return ErrNoEventStoreDefined
+	}
+
+	records := make([]EventRecord, len(events))
+	for i, event := range events {
+		record, err := s.record(event)
+		if err != nil {
+			return err
+		}
+		records[i] = record
+	}
+	return s.appendRecords(records, func() error {
+		return s.eventStore.Append(events)
+	})
+}
+
+// Load loads all events for the aggregate id from the base store.
+// Returns ErrNoEventStoreDefined if no event store could be found.
+func (s *HashChainEventStore) Load(id UUID) ([]Event, error) {
+	if s.eventStore != nil {
+		return s.eventStore.Load(id)
+	}
+
+	return nil, ErrNoEventStoreDefined
+}
+
+// LoadAll loads all events from the base store, which must be a
+// GlobalEventStore.
+func (s *HashChainEventStore) LoadAll() ([]Event, error) {
+	return loadAllEvents(s.eventStore)
+}
+
+// StreamAll streams all events from the base store, which must be a
+// GlobalEventStore.
+func (s *HashChainEventStore) StreamAll() iter.Seq2[Event, error] {
+	return streamAllEvents(s.eventStore)
+}
+
+// Stream streams the events for the aggregate id from the base store.
+// Returns ErrNoEventStoreDefined if no event store could be found.
+func (s *HashChainEventStore) Stream(id UUID) iter.Seq2[Event, error] {
+	if s.eventStore != nil {
+		return StreamEvents(s.eventStore, id)
+	}
+	return func(yield func(Event, error) bool) {
+		yield(nil, ErrNoEventStoreDefined)
+	}
+}
+
+// Verify walks the base store and checks every event against the chain.
+// Returns a ChainError for the first broken link, or ErrInvalidCheckpoint if
+// signing is enabled and a checkpoint does not verify. The base store must be
+// a GlobalEventStore, so that events without a link are found in all
+// aggregates; ErrLoadAllNotSupported is returned otherwise.
+//
+// All events are first checked in the order they were appended, and then the
+// events of each chained aggregate as they are loaded by the dispatchers.
+func (s *HashChainEventStore) Verify() error {
+	if s.eventStore == nil {
+		return ErrNoEventStoreDefined
+	}
+
+	if codecStore, ok := s.eventStore.(*CodecEventStore); ok {
+		return s.verify(streamRecords(codecStore.recordStore), func(id UUID) iter.Seq2[EventRecord, error] {
+			return streamAggregateRecords(codecStore.recordStore, id)
+		})
+	}
+	return s.verify(s.records(streamAllEvents(s.eventStore)), func(id UUID) iter.Seq2[EventRecord, error] {
+		return s.records(StreamEvents(s.eventStore, id))
+	})
+}
+
+// record returns the record that an event is stored as in the base store.
+func (s *HashChainEventStore) record(event Event) (EventRecord, error) {
+	if raw, ok := event.(RawEvent); ok {
+		return raw.Record, nil
+	}
+	if codecStore, ok := s.eventStore.(*CodecEventStore); ok {
+		return codecStore.codec.Encode(event)
+	}
+
+	// The sequence that the base store may add is left out, as the position
+	// of the event is covered by the chain.
+	data, err := json.Marshal(withoutSequence(event))
+	if err != nil {
+		return EventRecord{}, err
+	}
+	record := EventRecord{
+		AggregateID: event.AggregateID(),
+		Type:        eventTypeName(reflect.TypeOf(event)),
+		Data:        data,
+	}
+	return record, nil
+}
+
+// records returns the records of a stream of events of the base store.
+func (s *HashChainEventStore) records(events iter.Seq2[Event, error]) iter.Seq2[EventRecord, error] {
+	return func(yield func(EventRecord, error) bool) {
+		for event, err := range events {
+			if err != nil {
+				yield(EventRecord{}, err)
+				return
+			}
+			record, err := s.record(event)
+			if !yield(record, err) || err != nil {
+				return
+			}
+		}
+	}
+}
+
+// HashChainRecordStore wraps a RecordStore and makes it tamper-evident, like
+// HashChainEventStore but with the chain over the records as they are stored.
+//
+// Placed below a CryptoRecordStore it chains the encrypted records, which
+// keeps the chain intact when personal data is forgotten.
+type HashChainRecordStore struct {
+	*hashChain
+	recordStore RecordStore
+}
+
+// NewHashChainRecordStore creates a new HashChainRecordStore.
+func NewHashChainRecordStore(recordStore RecordStore) *HashChainRecordStore {
+	s := &HashChainRecordStore{
+		hashChain:   newHashChain(),
+		recordStore: recordStore,
+	}
+	return s
+}
+
+// NewPersistentHashChainRecordStore creates a new HashChainRecordStore that
+// persists its chain in a ChainStore, and continues the chain that is already
+// stored in it.
+func NewPersistentHashChainRecordStore(recordStore RecordStore, chainStore ChainStore) (*HashChainRecordStore, error) {
+	s := NewHashChainRecordStore(recordStore)
+	if err := s.load(chainStore); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// AppendRecords appends the records to the base store and chains them.
+func (s *HashChainRecordStore) AppendRecords(records []EventRecord) error {
+	return s.appendRecords(records, func() error {
+		return s.recordStore.AppendRecords(records)
+	})
+}
+
+// LoadRecords loads all records for the aggregate id from the base store.
+func (s *HashChainRecordStore) LoadRecords(id UUID) ([]EventRecord, error) {
+	return s.recordStore.LoadRecords(id)
+}
+
+// StreamRecords streams the records for the aggregate id from the base store.
+func (s *HashChainRecordStore) StreamRecords(id UUID) iter.Seq2[EventRecord, error] {
+	return streamAggregateRecords(s.recordStore, id)
+}
+
+// LoadAllRecords loads all records from the base store.
+func (s *HashChainRecordStore) LoadAllRecords() ([]EventRecord, error) {
+	return s.recordStore.LoadAllRecords()
+}
+
+// StreamAllRecords streams all records from the base store.
+func (s *HashChainRecordStore) StreamAllRecords() iter.Seq2[EventRecord, error] {
+	return streamRecords(s.recordStore)
+}
+
+// Verify walks the base store and checks every record against the chain, as
+// HashChainEventStore.Verify.
+func (s *HashChainRecordStore) Verify() error {
+	return s.verify(streamRecords(s.recordStore), func(id UUID) iter.Seq2[EventRecord, error] {
+		return streamAggregateRecords(s.recordStore, id)
+	})
+}
+
+// hashRecord hashes a stored record with its type name, version and data.
+func hashRecord(record EventRecord) []byte {
+	hash := HashRecord(record)
+	return hash[:]
+}
+
+// withoutSequence returns a copy of the event value without the sequence in
+// its metadata, or the event if it has no sequence.
+func withoutSequence(event Event) interface{} {
+	metadata := MetadataOf(event)
+	if _, ok := metadata[MetadataSequence]; !ok {
+		return event
+	}
+
+	v := reflect.ValueOf(event)
+	if v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	copy := reflect.New(v.Type()).Elem()
+	copy.Set(v)
+	index, _ := metadataIndex(v.Type())
+	// Metadata with only the sequence was nil before it was added.
+	var stripped Metadata
+	for k, value := range metadata {
+		if k != MetadataSequence {
+			if stripped == nil {
+				stripped = make(Metadata)
+			}
+			stripped[k] = value
+		}
+	}
+	copy.Field(index).Set(reflect.ValueOf(stripped))
+	return copy.Interface()
+}
+
+func chainHash(prev, eventHash []byte) []byte {
+	h := sha256.New()
+	h.Write(prev)
+	h.Write(eventHash)
+	return h.Sum(nil)
+}
@@ -0,0 +1,260 @@
+// Copyright (c) 2014 - Max Persson <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventhorizon
+
+import (
+	"crypto/ed25519"
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+)
+
+var _ = Suite(&HashChainEventStoreSuite{})
+
+type HashChainEventStoreSuite struct {
+	baseStore *MemoryEventStore
+	store     *HashChainEventStore
+}
+
+func (s *HashChainEventStoreSuite) SetUpTest(c *C) {
+	s.baseStore = NewMemoryEventStore()
+	s.store = NewHashChainEventStore(s.baseStore)
+}
+
+func (s *HashChainEventStoreSuite) Test_NewHashChainEventStore(c *C) {
+	baseStore := NewMemoryEventStore()
+	store := NewHashChainEventStore(baseStore)
+	c.Assert(store, Not(Equals), nil)
+	c.Assert(store.eventStore, Equals, baseStore)
+	c.Assert(len(store.links), Equals, 0)
+}
+
+func (s *HashChainEventStoreSuite) Test_Append_NoBaseStore(c *C) {
+	store := NewHashChainEventStore(nil)
+	err := store.Append([]Event{TestEvent{NewUUID(), "event1"}})
+	c.Assert(err, Equals, ErrNoEventStoreDefined)
+	c.Assert(len(store.links), Equals, 0)
+}
+
+func (s *HashChainEventStoreSuite) Test_Append_Chained(c *C) {
+	event1 := TestEvent{NewUUID(), "event1"}
+	event2 := TestEvent{NewUUID(), "event2"}
+	event3 := TestEvent{event1.TestID, "event3"}
+	err := s.store.Append([]Event{event1, event2, event3})
+	c.Assert(err, Equals, nil)
+	links := s.store.GetLinks()
+	c.Assert(len(links), Equals, 3)
+	c.Assert(links[2].AggregateID, Equals, event1.TestID)
+	c.Assert(links[2].Index, Equals, 1)
+	c.Assert(links[2].Hash, DeepEquals, chainHash(links[1].Hash, links[2].EventHash))
+	c.Assert(links[2].StreamHash, DeepEquals, chainHash(links[0].StreamHash, links[2].EventHash))
+
+	events, err := s.store.Load(event1.TestID)
+	c.Assert(err, Equals, nil)
+	c.Assert(events, DeepEquals, []Event{event1, event3})
+}
+
+func (s *HashChainEventStoreSuite) Test_Verify_Intact(c *C) {
+	event1 := TestEvent{NewUUID(), "event1"}
+	event2 := TestEvent{NewUUID(), "event2"}
+	s.store.Append([]Event{event1, event2})
+	s.store.Append([]Event{TestEvent{event1.TestID, "event3"}})
+	c.Assert(s.store.Verify(), Equals, nil)
+}
+
+func (s *HashChainEventStoreSuite) Test_Verify_Altered(c *C) {
+	event1 := TestEvent{NewUUID(), "event1"}
+	event2 := TestEvent{NewUUID(), "event2"}
+	event3 := TestEvent{event1.TestID, "event3"}
+	s.store.Append([]Event{event1, event2, event3})
+	s.baseStore.events[event1.TestID][1] = TestEvent{event1.TestID, "altered"}
+	err := s.store.Verify()
+	c.Assert(err, DeepEquals, ChainError{2, event1.TestID, 1, "event hash mismatch"})
+	c.Assert(err, ErrorMatches, "broken hash chain at position 2 .*: event hash mismatch")
+}
+
+func (s *HashChainEventStoreSuite) Test_Verify_Removed(c *C) {
+	event1 := TestEvent{NewUUID(), "event1"}
+	event2 := TestEvent{event1.TestID, "event2"}
+	s.store.Append([]Event{event1, event2})
+	s.baseStore.events[event1.TestID] = s.baseStore.events[event1.TestID][1:]
+	err := s.store.Verify()
+	c.Assert(err, DeepEquals, ChainError{0, event1.TestID, 0, "event hash mismatch"})
+}
+
+func (s *HashChainEventStoreSuite) Test_Verify_Inserted(c *C) {
+	event1 := TestEvent{NewUUID(), "event1"}
+	s.store.Append([]Event{event1})
+	s.baseStore.Append([]Event{TestEvent{event1.TestID, "inserted"}})
+	err := s.store.Verify()
+	c.Assert(err, DeepEquals, ChainError{1, event1.TestID, 1, "unchained event"})
+}
+
+func (s *HashChainEventStoreSuite) Test_Verify_Sequenced(c *C) {
+	event1 := TestTracedEvent{TestID: NewUUID(), Content: "event1"}
+	event2 := &TestTracedEvent{TestID: NewUUID(), Content: "event2"}
+	event2.Metadata = Metadata{MetadataTraceParent: "parent"}
+	c.Assert(s.store.Append([]Event{event1, event2}), Equals, nil)
+	events, _ := s.baseStore.LoadAll()
+	sequence, _ := SequenceOf(events[1])
+	c.Assert(sequence, Equals, 2)
+	c.Assert(s.store.Verify(), Equals, nil)
+}
+
+func (s *HashChainEventStoreSuite) Test_Verify_NewAggregate(c *C) {
+	event1 := TestEvent{NewUUID(), "event1"}
+	s.store.Append([]Event{event1})
+	forged := TestEvent{NewUUID(), "forged"}
+	s.baseStore.Append([]Event{forged})
+	err := s.store.Verify()
+	c.Assert(err, DeepEquals, ChainError{1, forged.TestID, 0, "unchained event"})
+}
+
+func (s *HashChainEventStoreSuite) Test_Verify_NotGlobal(c *C) {
+	store := NewHashChainEventStore(&MockEventStore{})
+	c.Assert(store.Verify(), Equals, ErrLoadAllNotSupported)
+}
+
+func (s *HashChainEventStoreSuite) Test_Verify_AlteredLink(c *C) {
+	event1 := TestEvent{NewUUID(), "event1"}
+	event2 := TestEvent{event1.TestID, "event2"}
+	s.store.Append([]Event{event1, event2})
+	s.store.links[0].Hash = make([]byte, 32)
+	err := s.store.Verify()
+	c.Assert(err, DeepEquals, ChainError{0, event1.TestID, 0, "hash mismatch"})
+}
+
+func (s *HashChainEventStoreSuite) Test_Checkpoints(c *C) {
+	public, private, _ := ed25519.GenerateKey(nil)
+	s.store.SetSigningKey(private, 2)
+	id := NewUUID()
+	for i := 0; i < 5; i++ {
+		s.store.Append([]Event{TestEvent{id, "event"}})
+	}
+	checkpoints := s.store.GetCheckpoints()
+	c.Assert(len(checkpoints), Equals, 2)
+	c.Assert(checkpoints[1].Position, Equals, 3)
+	c.Assert(s.store.Verify(), Equals, nil)
+	c.Assert(s.store.VerifyCheckpoints(public), Equals, nil)
+
+	other, _, _ := ed25519.GenerateKey(nil)
+	c.Assert(s.store.VerifyCheckpoints(other), Equals, ErrInvalidCheckpoint)
+
+	s.store.checkpoints[0].Signature[0] ^= 0xff
+	c.Assert(s.store.Verify(), Equals, ErrInvalidCheckpoint)
+}
+
+func (s *HashChainEventStoreSuite) Test_GetLinks_Copy(c *C) {
+	public, private, _ := ed25519.GenerateKey(nil)
+	s.store.SetSigningKey(private, 1)
+	s.store.Append([]Event{TestEvent{NewUUID(), "event1"}})
+	s.store.GetLinks()[0].Hash = nil
+	s.store.GetCheckpoints()[0].Position = 1
+	c.Assert(s.store.Verify(), Equals, nil)
+	c.Assert(s.store.VerifyCheckpoints(public), Equals, nil)
+}
+
+func (s *HashChainEventStoreSuite) Test_Persistent(c *C) {
+	public, private, _ := ed25519.GenerateKey(nil)
+	chainStore, err := NewFileChainStore(c.MkDir())
+	c.Assert(err, Equals, nil)
+	store, err := NewPersistentHashChainEventStore(s.baseStore, chainStore)
+	c.Assert(err, Equals, nil)
+	store.SetSigningKey(private, 2)
+	event1 := TestEvent{NewUUID(), "event1"}
+	event2 := TestEvent{NewUUID(), "event2"}
+	c.Assert(store.Append([]Event{event1, event2}), Equals, nil)
+
+	// A new store continues the persisted chain.
+	store, err = NewPersistentHashChainEventStore(s.baseStore, chainStore)
+	c.Assert(err, Equals, nil)
+	c.Assert(len(store.GetLinks()), Equals, 2)
+	c.Assert(len(store.GetCheckpoints()), Equals, 1)
+	c.Assert(store.VerifyCheckpoints(public), Equals, nil)
+	c.Assert(store.Append([]Event{TestEvent{event1.TestID, "event3"}}), Equals, nil)
+	c.Assert(store.Verify(), Equals, nil)
+
+	// Events appended to the base store without the chain are found.
+	store, err = NewPersistentHashChainEventStore(s.baseStore, chainStore)
+	c.Assert(err, Equals, nil)
+	c.Assert(store.Verify(), Equals, nil)
+	forged := TestEvent{NewUUID(), "forged"}
+	s.baseStore.Append([]Event{forged})
+	c.Assert(store.Verify(), DeepEquals, ChainError{3, forged.TestID, 0, "unchained event"})
+}
+
+func (s *HashChainEventStoreSuite) Test_Persistent_AppendError(c *C) {
+	dir := c.MkDir()
+	chainStore, err := NewFileChainStore(dir)
+	c.Assert(err, Equals, nil)
+	store, err := NewPersistentHashChainEventStore(s.baseStore, chainStore)
+	c.Assert(err, Equals, nil)
+	c.Assert(os.Mkdir(filepath.Join(dir, FileChainStoreName), 0700), Equals, nil)
+	event1 := TestEvent{NewUUID(), "event1"}
+	c.Assert(store.Append([]Event{event1}), Not(Equals), nil)
+	c.Assert(len(store.GetLinks()), Equals, 0)
+	c.Assert(store.Verify(), DeepEquals, ChainError{0, event1.TestID, 0, "unchained event"})
+}
+
+func (s *HashChainEventStoreSuite) Test_Verify_Upcasted(c *C) {
+	records := NewMemoryRecordStore()
+	chainStore, err := NewFileChainStore(c.MkDir())
+	c.Assert(err, Equals, nil)
+	codec := NewEventCodec()
+	codec.RegisterEvent(TestEvent{}, 1)
+	store, err := NewPersistentHashChainEventStore(NewCodecEventStore(records, codec), chainStore)
+	c.Assert(err, Equals, nil)
+	event1 := TestEvent{NewUUID(), "event1"}
+	c.Assert(store.Append([]Event{event1}), Equals, nil)
+
+	// A new version of the event type that upcasts the stored records does
+	// not change what was chained.
+	codec = NewEventCodec()
+	codec.RegisterEvent(TestEvent{}, 2)
+	codec.AddUpcaster("TestEvent", 1, func(r EventRecord) ([]EventRecord, error) {
+		r.Data = []byte(`{"TestID":"` + r.AggregateID.String() + `","Content":"upcasted"}`)
+		r.Version = 2
+		return []EventRecord{r}, nil
+	})
+	store, err = NewPersistentHashChainEventStore(NewCodecEventStore(records, codec), chainStore)
+	c.Assert(err, Equals, nil)
+	c.Assert(store.Verify(), Equals, nil)
+	c.Assert(store.Append([]Event{TestEvent{event1.TestID, "event2"}}), Equals, nil)
+	c.Assert(store.Verify(), Equals, nil)
+
+	records.records[0].Data = []byte(`{"TestID":"` + event1.TestID.String() + `","Content":"altered"}`)
+	c.Assert(store.Verify(), DeepEquals, ChainError{0, event1.TestID, 0, "event hash mismatch"})
+}
+
+func (s *HashChainEventStoreSuite) Test_RecordStore_Forget(c *C) {
+	codec := NewEventCodec()
+	codec.RegisterEvent(TestPersonEvent{}, 1)
+	baseStore := NewMemoryRecordStore()
+	chained := NewHashChainRecordStore(baseStore)
+	crypto := NewCryptoRecordStore(chained, codec, NewMemoryKeyStore())
+	store := NewCodecEventStore(crypto, codec)
+	event1 := TestPersonEvent{NewUUID(), "Athena", 42, true, "event1"}
+	event2 := TestPersonEvent{NewUUID(), "Hades", 43, true, "event2"}
+	c.Assert(store.Append([]Event{event1, event2}), Equals, nil)
+	c.Assert(len(chained.GetLinks()), Equals, 2)
+	c.Assert(chained.Verify(), Equals, nil)
+
+	c.Assert(crypto.Forget(event1.TestID), Equals, nil)
+	c.Assert(chained.Verify(), Equals, nil)
+
+	baseStore.records[1].Data = []byte(`{}`)
+	c.Assert(chained.Verify(), DeepEquals, ChainError{1, event2.TestID, 0, "event hash mismatch"})
+}
@@ -0,0 +1,188 @@
+// Copyright (c) 2014 - Max Persson <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventhorizon
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Error returned when no key can be found for a subject.
+var ErrKeyNotFound = errors.New("could not find key")
+
+// keySize is the size of generated keys, suitable for AES-256.
+const keySize = 32
+
+// KeyStore is a storage for encryption keys of data subjects.
+type KeyStore interface {
+	// CreateKey returns the key for the subject, creating one if needed.
+	CreateKey(UUID) ([]byte, error)
+
+	// Key returns the key for the subject.
+	Key(UUID) ([]byte, error)
+
+	// DeleteKey destroys the key for the subject.
+	DeleteKey(UUID) error
+}
+
+// MemoryKeyStore implements KeyStore as an in memory structure. It is safe
+// for concurrent use.
+type MemoryKeyStore struct {
+	keys map[UUID][]byte
+	mu   sync.Mutex
+}
+
+// NewMemoryKeyStore creates a new MemoryKeyStore.
+func NewMemoryKeyStore() *MemoryKeyStore {
+	s := &MemoryKeyStore{
+		keys: make(map[UUID][]byte),
+	}
+	return s
+}
+
+// CreateKey returns the key for the subject, creating one if needed.
+func (s *MemoryKeyStore) CreateKey(id UUID) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if key, ok := s.keys[id]; ok {
+		return key, nil
+	}
+
+	key, err := newKey()
+	if err != nil {
+		return nil, err
+	}
+	s.keys[id] = key
+	return key, nil
+}
+
+// Key returns the key for the subject.
+// Returns ErrKeyNotFound if no key can be found.
+func (s *MemoryKeyStore) Key(id UUID) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if key, ok := s.keys[id]; ok {
+		return key, nil
+	}
+	return nil, ErrKeyNotFound
+}
+
+// DeleteKey destroys the key for the subject.
+// Returns ErrKeyNotFound if no key can be found.
+func (s *MemoryKeyStore) DeleteKey(id UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.keys[id]; ok {
+		delete(s.keys, id)
+		return nil
+	}
+	return ErrKeyNotFound
+}
+
+// FileKeyStore implements KeyStore with one file per subject in a directory.
+type FileKeyStore struct {
+	dir string
+}
+
+// NewFileKeyStore creates a new FileKeyStore, creating the directory if it
+// does not exist.
+func NewFileKeyStore(dir string) (*FileKeyStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	s := &FileKeyStore{
+		dir: dir,
+	}
+	return s, nil
+}
+
+// CreateKey returns the key for the subject, creating one if needed. The key
+// is written to a temporary file that is linked into place, so that readers
+// never see a partial key and concurrent calls agree on a single key.
+func (s *FileKeyStore) CreateKey(id UUID) ([]byte, error) {
+	key, err := s.Key(id)
+	if err != ErrKeyNotFound {
+		return key, err
+	}
+
+	key, err = newKey()
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.CreateTemp(s.dir, "*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString(hex.EncodeToString(key)); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+
+	// Linking fails if another call created the key first, which is then
+	// used instead.
+	if err := os.Link(f.Name(), s.path(id)); os.IsExist(err) {
+		return s.Key(id)
+	} else if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// Key returns the key for the subject.
+// Returns ErrKeyNotFound if no key can be found.
+func (s *FileKeyStore) Key(id UUID) ([]byte, error) {
+	data, err := os.ReadFile(s.path(id))
+	if os.IsNotExist(err) {
+		return nil, ErrKeyNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return hex.DecodeString(string(data))
+}
+
+// DeleteKey destroys the key for the subject by removing its file.
+// Returns ErrKeyNotFound if no key can be found.
+func (s *FileKeyStore) DeleteKey(id UUID) error {
+	err := os.Remove(s.path(id))
+	if os.IsNotExist(err) {
+		return ErrKeyNotFound
+	}
+	return err
+}
+
+func (s *FileKeyStore) path(id UUID) string {
+	return filepath.Join(s.dir, hex.EncodeToString([]byte(id))+".key")
+}
+
+func newKey() ([]byte, error) {
+	key := make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
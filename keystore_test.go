@@ -0,0 +1,132 @@
+// Copyright (c) 2014 - Max Persson <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventhorizon
+
+import (
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+)
+
+var _ = Suite(&MemoryKeyStoreSuite{})
+var _ = Suite(&FileKeyStoreSuite{})
+
+type MemoryKeyStoreSuite struct {
+	store *MemoryKeyStore
+}
+
+func (s *MemoryKeyStoreSuite) SetUpTest(c *C) {
+	s.store = NewMemoryKeyStore()
+}
+
+func (s *MemoryKeyStoreSuite) Test_CreateKey(c *C) {
+	id := NewUUID()
+	key, err := s.store.CreateKey(id)
+	c.Assert(err, Equals, nil)
+	c.Assert(len(key), Equals, 32)
+	key2, err := s.store.CreateKey(id)
+	c.Assert(err, Equals, nil)
+	c.Assert(key2, DeepEquals, key)
+	key3, err := s.store.CreateKey(NewUUID())
+	c.Assert(err, Equals, nil)
+	c.Assert(key3, Not(DeepEquals), key)
+}
+
+func (s *MemoryKeyStoreSuite) Test_Key(c *C) {
+	id := NewUUID()
+	key, err := s.store.Key(id)
+	c.Assert(err, Equals, ErrKeyNotFound)
+	c.Assert(key, IsNil)
+	created, _ := s.store.CreateKey(id)
+	key, err = s.store.Key(id)
+	c.Assert(err, Equals, nil)
+	c.Assert(key, DeepEquals, created)
+}
+
+func (s *MemoryKeyStoreSuite) Test_DeleteKey(c *C) {
+	id := NewUUID()
+	err := s.store.DeleteKey(id)
+	c.Assert(err, Equals, ErrKeyNotFound)
+	s.store.CreateKey(id)
+	err = s.store.DeleteKey(id)
+	c.Assert(err, Equals, nil)
+	_, err = s.store.Key(id)
+	c.Assert(err, Equals, ErrKeyNotFound)
+}
+
+type FileKeyStoreSuite struct {
+	store *FileKeyStore
+}
+
+func (s *FileKeyStoreSuite) SetUpTest(c *C) {
+	var err error
+	s.store, err = NewFileKeyStore(c.MkDir())
+	c.Assert(err, Equals, nil)
+}
+
+func (s *FileKeyStoreSuite) Test_CreateKey(c *C) {
+	id := NewUUID()
+	key, err := s.store.CreateKey(id)
+	c.Assert(err, Equals, nil)
+	c.Assert(len(key), Equals, 32)
+	key2, err := s.store.CreateKey(id)
+	c.Assert(err, Equals, nil)
+	c.Assert(key2, DeepEquals, key)
+}
+
+func (s *FileKeyStoreSuite) Test_CreateKey_Concurrent(c *C) {
+	id := NewUUID()
+	keys := make(chan []byte, 10)
+	for i := 0; i < cap(keys); i++ {
+		go func() {
+			key, err := s.store.CreateKey(id)
+			c.Check(err, Equals, nil)
+			keys <- key
+		}()
+	}
+	first := <-keys
+	for i := 1; i < cap(keys); i++ {
+		c.Assert(<-keys, DeepEquals, first)
+	}
+	key, err := s.store.Key(id)
+	c.Assert(err, Equals, nil)
+	c.Assert(key, DeepEquals, first)
+
+	// No temporary files are left.
+	files, err := filepath.Glob(filepath.Join(s.store.dir, "*"))
+	c.Assert(err, Equals, nil)
+	c.Assert(files, HasLen, 1)
+}
+
+func (s *FileKeyStoreSuite) Test_Key_Reopen(c *C) {
+	id := NewUUID()
+	created, _ := s.store.CreateKey(id)
+	store, err := NewFileKeyStore(s.store.dir)
+	c.Assert(err, Equals, nil)
+	key, err := store.Key(id)
+	c.Assert(err, Equals, nil)
+	c.Assert(key, DeepEquals, created)
+}
+
+func (s *FileKeyStoreSuite) Test_DeleteKey(c *C) {
+	id := NewUUID()
+	err := s.store.DeleteKey(id)
+	c.Assert(err, Equals, ErrKeyNotFound)
+	s.store.CreateKey(id)
+	err = s.store.DeleteKey(id)
+	c.Assert(err, Equals, nil)
+	_, err = s.store.Key(id)
+	c.Assert(err, Equals, ErrKeyNotFound)
+}
@@ -0,0 +1,110 @@
+// Copyright (c) 2014 - Max Persson <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventhorizon
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+)
+
+// Error returned when a command requires an existing aggregate that has no
+// events.
+var ErrAggregateNotFound = errors.New("aggregate not found")
+
+// Error returned when a command creates an aggregate that already has events.
+var ErrAggregateAlreadyExists = errors.New("aggregate already exists")
+
+// Error returned when a command is dispatched to a closed aggregate.
+var ErrAggregateClosed = errors.New("aggregate is closed")
+
+// The lifecycle of aggregates is declared with an aggregate tag on any field
+// of commands and events, often a blank one:
+//
+//	type CreateInvite struct {
+//	    _ struct{} `aggregate:"create"`
+//	    ...
+//	}
+//
+// Commands tagged with "create" create their aggregate and are rejected with
+// ErrAggregateAlreadyExists if it has events. Commands tagged with "existing"
+// are rejected with ErrAggregateNotFound if it has no events. Other commands
+// can be dispatched in both cases.
+//
+// Events tagged with "close" close their aggregate, for example when it is
+// deleted. All further commands for the aggregate are rejected with
+// ErrAggregateClosed.
+const (
+	LifecycleCreate   = "create"
+	LifecycleExisting = "existing"
+	LifecycleClose    = "close"
+)
+
+var lifecycleTags sync.Map // map[reflect.Type]string
+
+// lifecycleCheck checks a command against the events of its aggregate, one
+// at a time as they are loaded.
+type lifecycleCheck struct {
+	command Command
+	events  int
+}
+
+// event checks the next event of the aggregate.
+func (l *lifecycleCheck) event(event Event) error {
+	if lifecycleOf(event) == LifecycleClose {
+		return ErrAggregateClosed
+	}
+	l.events++
+	return nil
+}
+
+// done checks the command after all events of the aggregate.
+func (l *lifecycleCheck) done() error {
+	switch lifecycleOf(l.command) {
+	case LifecycleCreate:
+		if l.events > 0 {
+			return ErrAggregateAlreadyExists
+		}
+	case LifecycleExisting:
+		if l.events == 0 {
+			return ErrAggregateNotFound
+		}
+	}
+	return nil
+}
+
+// lifecycleOf returns the aggregate tag of a command or event type.
+func lifecycleOf(value interface{}) string {
+	t := reflect.TypeOf(value)
+	if tag, ok := lifecycleTags.Load(t); ok {
+		return tag.(string)
+	}
+
+	tag := ""
+	st := t
+	if st.Kind() == reflect.Ptr {
+		st = st.Elem()
+	}
+	if st.Kind() == reflect.Struct {
+		for i := 0; i < st.NumField(); i++ {
+			if value, ok := st.Field(i).Tag.Lookup("aggregate"); ok {
+				tag = value
+				break
+			}
+		}
+	}
+	lifecycleTags.Store(t, tag)
+	return tag
+}
@@ -0,0 +1,142 @@
+// Copyright (c) 2014 - Max Persson <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventhorizon
+
+import (
+	"errors"
+
+	. "gopkg.in/check.v1"
+)
+
+var _ = Suite(&LifecycleSuite{})
+
+type LifecycleSuite struct {
+	store *MemoryEventStore
+}
+
+type TestCreateCommand struct {
+	_ struct{} `aggregate:"create"`
+
+	TestID UUID
+}
+
+func (t TestCreateCommand) AggregateID() UUID { return t.TestID }
+
+type TestExistingCommand struct {
+	_ struct{} `aggregate:"existing"`
+
+	TestID  UUID
+	Content string
+}
+
+func (t TestExistingCommand) AggregateID() UUID { return t.TestID }
+
+type TestCloseCommand struct {
+	TestID UUID
+}
+
+func (t TestCloseCommand) AggregateID() UUID { return t.TestID }
+
+type TestClosedEvent struct {
+	_ struct{} `aggregate:"close"`
+
+	TestID UUID
+}
+
+func (t TestClosedEvent) AggregateID() UUID { return t.TestID }
+
+type TestLifecycleAggregate struct {
+	Aggregate
+}
+
+func (t *TestLifecycleAggregate) HandleCommand(command Command) ([]Event, error) {
+	switch command := command.(type) {
+	case TestCreateCommand:
+		return []Event{TestEvent{command.TestID, "created"}}, nil
+	case TestExistingCommand:
+		return []Event{TestEvent{command.TestID, command.Content}}, nil
+	case TestCloseCommand:
+		return []Event{TestClosedEvent{TestID: command.TestID}}, nil
+	}
+	return nil, errors.New("couldn't handle command")
+}
+
+func (t *TestLifecycleAggregate) HandleEvent(event Event) {}
+
+func (t *TestLifecycleAggregate) HandleTestCreateCommand(command TestCreateCommand) ([]Event, error) {
+	return t.HandleCommand(command)
+}
+
+func (t *TestLifecycleAggregate) HandleTestExistingCommand(command TestExistingCommand) ([]Event, error) {
+	return t.HandleCommand(command)
+}
+
+func (t *TestLifecycleAggregate) HandleTestCloseCommand(command TestCloseCommand) ([]Event, error) {
+	return t.HandleCommand(command)
+}
+
+func (s *LifecycleSuite) SetUpTest(c *C) {
+	s.store = NewMemoryEventStore()
+}
+
+func (s *LifecycleSuite) Test_DelegateDispatcher(c *C) {
+	disp := NewDelegateDispatcher(s.store, &MockEventBus{})
+	disp.AddHandler(&TestLifecycleAggregate{}, TestCreateCommand{})
+	disp.AddHandler(&TestLifecycleAggregate{}, TestExistingCommand{})
+	disp.AddHandler(&TestLifecycleAggregate{}, TestCloseCommand{})
+	s.checkLifecycle(c, disp)
+}
+
+func (s *LifecycleSuite) Test_ReflectDispatcher(c *C) {
+	disp := NewReflectDispatcher(s.store, &MockEventBus{})
+	disp.AddAllHandlers(&TestLifecycleAggregate{})
+	s.checkLifecycle(c, disp)
+}
+
+func (s *LifecycleSuite) checkLifecycle(c *C, disp Dispatcher) {
+	id := NewUUID()
+	err := disp.Dispatch(TestExistingCommand{TestID: id, Content: "command1"})
+	c.Assert(err, Equals, ErrAggregateNotFound)
+	err = disp.Dispatch(TestCreateCommand{TestID: id})
+	c.Assert(err, Equals, nil)
+	err = disp.Dispatch(TestCreateCommand{TestID: id})
+	c.Assert(err, Equals, ErrAggregateAlreadyExists)
+	err = disp.Dispatch(TestExistingCommand{TestID: id, Content: "command2"})
+	c.Assert(err, Equals, nil)
+
+	err = disp.Dispatch(TestCloseCommand{id})
+	c.Assert(err, Equals, nil)
+	err = disp.Dispatch(TestExistingCommand{TestID: id, Content: "command3"})
+	c.Assert(err, Equals, ErrAggregateClosed)
+	err = disp.Dispatch(TestCloseCommand{id})
+	c.Assert(err, Equals, ErrAggregateClosed)
+
+	events, _ := s.store.Load(id)
+	c.Assert(events, HasLen, 3)
+}
+
+func (s *LifecycleSuite) Test_LoadError(c *C) {
+	disp := NewDelegateDispatcher(&ErrorEventStore{errors.New("load error")}, &MockEventBus{})
+	disp.AddHandler(&TestLifecycleAggregate{}, TestCreateCommand{})
+	err := disp.Dispatch(TestCreateCommand{TestID: NewUUID()})
+	c.Assert(err, ErrorMatches, "load error")
+}
+
+func (s *LifecycleSuite) Test_LifecycleOf(c *C) {
+	c.Assert(lifecycleOf(TestCreateCommand{}), Equals, LifecycleCreate)
+	c.Assert(lifecycleOf(TestExistingCommand{}), Equals, LifecycleExisting)
+	c.Assert(lifecycleOf(&TestClosedEvent{}), Equals, LifecycleClose)
+	c.Assert(lifecycleOf(TestCommand{}), Equals, "")
+}
@@ -0,0 +1,48 @@
+// Copyright (c) 2014 - Max Persson <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventhorizon
+
+import (
+	"log/slog"
+)
+
+// Logger is a structured logger used to emit log records.
+//
+// The arguments are alternating keys and values, as for *slog.Logger from the
+// standard library which implements this interface. Logging can be silenced
+// by setting a logger with a discarding handler.
+type Logger interface {
+	Debug(msg string, args ...any)
+	Info(msg string, args ...any)
+	Warn(msg string, args ...any)
+	Error(msg string, args ...any)
+}
+
+// Keys of the fields in the log records.
+const (
+	LogKeyAggregateID = "aggregate_id"
+	LogKeyCommandType = "command_type"
+	LogKeyEventType   = "event_type"
+	LogKeyHandlerType = "handler_type"
+	LogKeySubscribers = "subscribers"
+	LogKeyDuration    = "duration"
+	LogKeyError       = "error"
+)
+
+// defaultLogger returns the logger used when none has been set, which is the
+// default logger of the slog package.
+func defaultLogger() Logger {
+	return slog.Default()
+}
@@ -0,0 +1,48 @@
+// Copyright (c) 2014 - Max Persson <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventhorizon
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+
+	. "gopkg.in/check.v1"
+)
+
+var _ = Suite(&LoggerSuite{})
+
+type LoggerSuite struct{}
+
+func (s *LoggerSuite) Test_DefaultLogger(c *C) {
+	c.Assert(defaultLogger(), Equals, slog.Default())
+}
+
+func (s *LoggerSuite) Test_SlogLogger(c *C) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	bus := NewHandlerEventBus()
+	bus.SetLogger(logger)
+	event1 := TestEvent{NewUUID(), "event1"}
+	bus.PublishEvent(event1)
+
+	var record map[string]interface{}
+	err := json.Unmarshal(buf.Bytes(), &record)
+	c.Assert(err, Equals, nil)
+	c.Assert(record["level"], Equals, "DEBUG")
+	c.Assert(record["msg"], Equals, "event published")
+	c.Assert(record[LogKeyAggregateID], Equals, event1.TestID.String())
+	c.Assert(record[LogKeyEventType], Equals, "eventhorizon.TestEvent")
+}
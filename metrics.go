@@ -0,0 +1,159 @@
+// Copyright (c) 2014 - Max Persson <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventhorizon
+
+import (
+	"iter"
+	"reflect"
+	"time"
+)
+
+// Metrics is a hook for recording metrics from dispatchers, stores and buses.
+//
+// Labels use the same keys as the fields in the log records, for example
+// LogKeyCommandType and LogKeyEventType.
+type Metrics interface {
+	// IncCounter increments a counter by one.
+	IncCounter(name string, labels Labels)
+
+	// ObserveHistogram adds an observation to a histogram.
+	ObserveHistogram(name string, value float64, labels Labels)
+}
+
+// Labels are the label names and values of a metric.
+type Labels map[string]string
+
+// Names of the metrics. Durations are observed in seconds.
+const (
+	MetricCommands                 = "eventhorizon_commands_total"
+	MetricCommandErrors            = "eventhorizon_command_errors_total"
+	MetricCommandDuration          = "eventhorizon_command_duration_seconds"
+	MetricEventsAppended           = "eventhorizon_events_appended_total"
+	MetricEventStoreErrors         = "eventhorizon_event_store_errors_total"
+	MetricEventStoreAppendDuration = "eventhorizon_event_store_append_duration_seconds"
+	MetricEventStoreLoadDuration   = "eventhorizon_event_store_load_duration_seconds"
+	MetricEventsPublished          = "eventhorizon_events_published_total"
+	MetricPublishDuration          = "eventhorizon_publish_duration_seconds"
+	MetricHandleDuration           = "eventhorizon_handle_duration_seconds"
+	MetricSubscriberLag            = "eventhorizon_subscriber_lag_seconds"
+)
+
+// nopMetrics is used when no metrics has been set.
+type nopMetrics struct{}
+
+func (nopMetrics) IncCounter(string, Labels)                {}
+func (nopMetrics) ObserveHistogram(string, float64, Labels) {}
+
+// recordDispatch records the metrics of dispatching a command.
+func recordDispatch(metrics Metrics, command Command, start time.Time, err error) {
+	labels := Labels{LogKeyCommandType: reflect.TypeOf(command).String()}
+	metrics.IncCounter(MetricCommands, labels)
+	if err != nil {
+		metrics.IncCounter(MetricCommandErrors, labels)
+	}
+	metrics.ObserveHistogram(MetricCommandDuration, time.Since(start).Seconds(), labels)
+}
+
+// MetricsEventStore wraps an EventStore and records metrics of its use.
+type MetricsEventStore struct {
+	eventStore EventStore
+	metrics    Metrics
+}
+
+// NewMetricsEventStore creates a new MetricsEventStore.
+func NewMetricsEventStore(eventStore EventStore, metrics Metrics) *MetricsEventStore {
+	s := &MetricsEventStore{
+		eventStore: eventStore,
+		metrics:    metrics,
+	}
+	return s
+}
+
+// Append appends all events to the base store and counts them per event type.
+// Returns ErrNoEventStoreDefined if no event store could be found.
+func (s *MetricsEventStore) Append(events []Event) error {
+	if s.eventStore == nil {
+		return ErrNoEventStoreDefined
+	}
+
+	start := time.Now()
+	err := s.eventStore.Append(events)
+	s.metrics.ObserveHistogram(MetricEventStoreAppendDuration, time.Since(start).Seconds(), Labels{})
+	if err != nil {
+		s.metrics.IncCounter(MetricEventStoreErrors, Labels{"operation": "append"})
+		return err
+	}
+
+	for _, event := range events {
+		s.metrics.IncCounter(MetricEventsAppended, Labels{
+			LogKeyEventType: reflect.TypeOf(event).String(),
+		})
+	}
+	return nil
+}
+
+// Load loads all events for the aggregate id from the base store.
+// Returns ErrNoEventStoreDefined if no event store could be found.
+func (s *MetricsEventStore) Load(id UUID) ([]Event, error) {
+	if s.eventStore == nil {
+		return nil, ErrNoEventStoreDefined
+	}
+
+	start := time.Now()
+	events, err := s.eventStore.Load(id)
+	s.metrics.ObserveHistogram(MetricEventStoreLoadDuration, time.Since(start).Seconds(), Labels{})
+	if err != nil && err != ErrNoEventsFound {
+		s.metrics.IncCounter(MetricEventStoreErrors, Labels{"operation": "load"})
+	}
+	return events, err
+}
+
+// LoadAll loads all events from the base store, which must be a
+// GlobalEventStore.
+func (s *MetricsEventStore) LoadAll() ([]Event, error) {
+	return loadAllEvents(s.eventStore)
+}
+
+// StreamAll streams all events from the base store, which must be a
+// GlobalEventStore.
+func (s *MetricsEventStore) StreamAll() iter.Seq2[Event, error] {
+	return streamAllEvents(s.eventStore)
+}
+
+// Stream streams the events for the aggregate id from the base store. The
+// load duration, without the time spent by the consumer, is observed when the
+// iteration ends.
+// Returns ErrNoEventStoreDefined if no event store could be found.
+func (s *MetricsEventStore) Stream(id UUID) iter.Seq2[Event, error] {
+	return func(yield func(Event, error) bool) {
+		if s.eventStore == nil {
+			yield(nil, ErrNoEventStoreDefined)
+			return
+		}
+
+		var elapsed time.Duration
+		defer func() {
+			s.metrics.ObserveHistogram(MetricEventStoreLoadDuration, elapsed.Seconds(), Labels{})
+		}()
+		for event, err := range timeEvents(StreamEvents(s.eventStore, id), &elapsed) {
+			if err != nil {
+				s.metrics.IncCounter(MetricEventStoreErrors, Labels{"operation": "load"})
+			}
+			if !yield(event, err) || err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,155 @@
+// Copyright (c) 2014 - Max Persson <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventhorizon
+
+import (
+	"errors"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+var _ = Suite(&MetricsSuite{})
+var _ = Suite(&MetricsEventStoreSuite{})
+
+type MetricsSuite struct{}
+
+func (s *MetricsSuite) Test_DelegateDispatcher(c *C) {
+	metrics := &MockMetrics{}
+	disp := NewDelegateDispatcher(&MockEventStore{}, &MockEventBus{})
+	disp.SetMetrics(metrics)
+	disp.AddHandler(&TestDelegateDispatcherAggregate{}, TestCommand{})
+	disp.Dispatch(TestCommand{NewUUID(), "command1"})
+	disp.Dispatch(TestCommand{NewUUID(), "error"})
+	labels := Labels{LogKeyCommandType: "eventhorizon.TestCommand"}
+	c.Assert(metrics.counters[MetricCommands], DeepEquals, []Labels{labels, labels})
+	c.Assert(metrics.counters[MetricCommandErrors], DeepEquals, []Labels{labels})
+	c.Assert(metrics.histograms[MetricCommandDuration], DeepEquals, []Labels{labels, labels})
+}
+
+func (s *MetricsSuite) Test_ReflectDispatcher(c *C) {
+	metrics := &MockMetrics{}
+	disp := NewReflectDispatcher(&MockEventStore{}, &MockEventBus{})
+	disp.SetMetrics(metrics)
+	disp.Dispatch(TestCommand{NewUUID(), "command1"})
+	labels := Labels{LogKeyCommandType: "eventhorizon.TestCommand"}
+	c.Assert(metrics.counters[MetricCommands], DeepEquals, []Labels{labels})
+	c.Assert(metrics.counters[MetricCommandErrors], DeepEquals, []Labels{labels})
+}
+
+func (s *MetricsSuite) Test_HandlerEventBus(c *C) {
+	metrics := &MockMetrics{}
+	bus := NewHandlerEventBus()
+	bus.SetMetrics(metrics)
+	bus.PublishEvent(TestEvent{NewUUID(), "event1"})
+	labels := Labels{LogKeyEventType: "eventhorizon.TestEvent"}
+	c.Assert(metrics.counters[MetricEventsPublished], DeepEquals, []Labels{labels})
+	c.Assert(metrics.histograms[MetricPublishDuration], DeepEquals, []Labels{labels})
+}
+
+func (s *MetricsSuite) Test_HandlerEventBus_Subscribers(c *C) {
+	metrics := &MockMetrics{}
+	bus := NewHandlerEventBus()
+	bus.SetMetrics(metrics)
+	bus.AddGlobalSubscriber(&MockEventHandler{})
+	bus.PublishEvent(TestTracedEvent{TestID: NewUUID()})
+	event := withTimestamp([]Event{TestTracedEvent{TestID: NewUUID()}}, time.Now())[0]
+	bus.PublishEvent(event)
+	labels := Labels{
+		LogKeyEventType:   "eventhorizon.TestTracedEvent",
+		LogKeyHandlerType: "*eventhorizon.MockEventHandler",
+	}
+	c.Assert(metrics.histograms[MetricHandleDuration], DeepEquals, []Labels{labels, labels})
+	// Only events with a timestamp have a lag.
+	c.Assert(metrics.histograms[MetricSubscriberLag], DeepEquals, []Labels{labels})
+}
+
+type MetricsEventStoreSuite struct {
+	baseStore *MemoryEventStore
+	metrics   *MockMetrics
+	store     *MetricsEventStore
+}
+
+func (s *MetricsEventStoreSuite) SetUpTest(c *C) {
+	s.baseStore = NewMemoryEventStore()
+	s.metrics = &MockMetrics{}
+	s.store = NewMetricsEventStore(s.baseStore, s.metrics)
+}
+
+func (s *MetricsEventStoreSuite) Test_Append(c *C) {
+	event1 := TestEvent{NewUUID(), "event1"}
+	event2 := TestEventOther{event1.TestID, "event2"}
+	err := s.store.Append([]Event{event1, event2})
+	c.Assert(err, Equals, nil)
+	c.Assert(s.baseStore.events[event1.TestID], DeepEquals, []Event{event1, event2})
+	c.Assert(s.metrics.counters[MetricEventsAppended], DeepEquals, []Labels{
+		{LogKeyEventType: "eventhorizon.TestEvent"},
+		{LogKeyEventType: "eventhorizon.TestEventOther"},
+	})
+	c.Assert(len(s.metrics.histograms[MetricEventStoreAppendDuration]), Equals, 1)
+}
+
+func (s *MetricsEventStoreSuite) Test_Append_Error(c *C) {
+	store := NewMetricsEventStore(&ErrorEventStore{errors.New("append error")}, s.metrics)
+	err := store.Append([]Event{TestEvent{NewUUID(), "event1"}})
+	c.Assert(err, ErrorMatches, "append error")
+	c.Assert(s.metrics.counters[MetricEventStoreErrors], DeepEquals, []Labels{{"operation": "append"}})
+	c.Assert(len(s.metrics.counters[MetricEventsAppended]), Equals, 0)
+}
+
+func (s *MetricsEventStoreSuite) Test_Load(c *C) {
+	event1 := TestEvent{NewUUID(), "event1"}
+	s.baseStore.Append([]Event{event1})
+	events, err := s.store.Load(event1.TestID)
+	c.Assert(err, Equals, nil)
+	c.Assert(events, DeepEquals, []Event{event1})
+	_, err = s.store.Load(NewUUID())
+	c.Assert(err, Equals, ErrNoEventsFound)
+	c.Assert(len(s.metrics.histograms[MetricEventStoreLoadDuration]), Equals, 2)
+	c.Assert(len(s.metrics.counters[MetricEventStoreErrors]), Equals, 0)
+}
+
+func (s *MetricsEventStoreSuite) Test_LoadAll(c *C) {
+	event1 := TestEvent{NewUUID(), "event1"}
+	event2 := TestEvent{NewUUID(), "event2"}
+	s.baseStore.Append([]Event{event1, event2})
+	events, err := s.store.LoadAll()
+	c.Assert(err, Equals, nil)
+	c.Assert(events, DeepEquals, []Event{event1, event2})
+	events = nil
+	for event, err := range s.store.StreamAll() {
+		c.Assert(err, Equals, nil)
+		events = append(events, event)
+	}
+	c.Assert(events, DeepEquals, []Event{event1, event2})
+
+	store := NewMetricsEventStore(&MockEventStore{}, s.metrics)
+	_, err = store.LoadAll()
+	c.Assert(err, Equals, ErrLoadAllNotSupported)
+}
+
+func (s *MetricsEventStoreSuite) Test_NoBaseStore(c *C) {
+	store := NewMetricsEventStore(nil, s.metrics)
+	c.Assert(store.Append(nil), Equals, ErrNoEventStoreDefined)
+	_, err := store.Load(NewUUID())
+	c.Assert(err, Equals, ErrNoEventStoreDefined)
+}
+
+type ErrorEventStore struct {
+	err error
+}
+
+func (e *ErrorEventStore) Append(events []Event) error   { return e.err }
+func (e *ErrorEventStore) Load(id UUID) ([]Event, error) { return nil, e.err }
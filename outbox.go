@@ -0,0 +1,365 @@
+// Copyright (c) 2014 - Max Persson <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventhorizon
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// FileOutboxName is the name of the file in the directory of a
+// FileOutboxStore that the published publications are stored in.
+const FileOutboxName = "published.jsonl"
+
+// Publication is an appended event that is pending publication.
+type Publication struct {
+	ID    uint64
+	Event Event
+}
+
+// OutboxStore is an EventStore that records every appended event as a pending
+// publication, atomically with appending the events. The publications are
+// published by an OutboxRelay.
+type OutboxStore interface {
+	EventStore
+
+	// PendingPublications returns the pending publications in the order the
+	// events were appended.
+	PendingPublications() ([]Publication, error)
+
+	// MarkPublished marks a publication as published.
+	MarkPublished(id uint64) error
+}
+
+// MemoryOutboxStore is a MemoryEventStore with an outbox.
+type MemoryOutboxStore struct {
+	*MemoryEventStore
+
+	pending []Publication
+	nextID  uint64
+	mu      sync.Mutex
+}
+
+// NewMemoryOutboxStore creates a new MemoryOutboxStore.
+func NewMemoryOutboxStore() *MemoryOutboxStore {
+	s := &MemoryOutboxStore{
+		MemoryEventStore: NewMemoryEventStore(),
+		pending:          make([]Publication, 0),
+	}
+	return s
+}
+
+// Append appends the events and records them as pending publications.
+func (s *MemoryOutboxStore) Append(events []Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.MemoryEventStore.Append(events); err != nil {
+		return err
+	}
+	for _, event := range events {
+		s.nextID++
+		s.pending = append(s.pending, Publication{s.nextID, event})
+	}
+	return nil
+}
+
+// Load loads all events for the aggregate id from the memory store.
+func (s *MemoryOutboxStore) Load(id UUID) ([]Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.MemoryEventStore.Load(id)
+}
+
+// LoadAll loads all events from the memory store.
+func (s *MemoryOutboxStore) LoadAll() ([]Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.MemoryEventStore.LoadAll()
+}
+
+// PendingPublications returns the pending publications.
+func (s *MemoryOutboxStore) PendingPublications() ([]Publication, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Publication(nil), s.pending...), nil
+}
+
+// MarkPublished removes a publication from the pending publications.
+func (s *MemoryOutboxStore) MarkPublished(id uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, p := range s.pending {
+		if p.ID == id {
+			s.pending = append(s.pending[:i], s.pending[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+// FileOutboxStore is an OutboxStore that stores the events as records in a
+// FileRecordStore in a directory, so that pending publications survive a
+// restart. The records in the file are the outbox: the ID of a publication is
+// the position of its record in the file, which is shared by the events of an
+// upcasted record, and the IDs of published records are appended to a second
+// file in the directory. The pending publications are read from the store
+// when it is created and then kept in memory, so the store must be the only
+// writer of the directory.
+type FileOutboxStore struct {
+	*CodecEventStore
+
+	path string
+	// published is the number of events that are all published, followed by
+	// the other published IDs.
+	published int
+	marked    map[uint64]bool
+	pending   []Publication
+	records   uint64
+	mu        sync.Mutex
+}
+
+// NewFileOutboxStore creates a new FileOutboxStore, creating the directory if
+// it does not exist and loading the pending publications.
+func NewFileOutboxStore(dir string, codec *EventCodec) (*FileOutboxStore, error) {
+	records, err := NewFileRecordStore(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &FileOutboxStore{
+		CodecEventStore: NewCodecEventStore(records, codec),
+		path:            filepath.Join(dir, FileOutboxName),
+		marked:          make(map[uint64]bool),
+		pending:         make([]Publication, 0),
+	}
+	if err := s.loadPublished(); err != nil {
+		return nil, err
+	}
+	if err := s.loadPending(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Append appends the events and records them as pending publications, with
+// the positions of their records as IDs.
+func (s *FileOutboxStore) Append(events []Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.CodecEventStore.Append(events); err != nil {
+		return err
+	}
+	for _, event := range events {
+		s.records++
+		s.pending = append(s.pending, Publication{s.records, event})
+	}
+	return nil
+}
+
+// PendingPublications returns the events of the records that have not been
+// marked as published, in the order they were appended.
+func (s *FileOutboxStore) PendingPublications() ([]Publication, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Publication(nil), s.pending...), nil
+}
+
+// MarkPublished appends the ID of a publication to the published file.
+func (s *FileOutboxStore) MarkPublished(id uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if id <= uint64(s.published) || s.marked[id] {
+		return nil
+	}
+
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(strconv.FormatUint(id, 10) + "\n"); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	s.mark(id)
+
+	pending := s.pending[:0]
+	for _, p := range s.pending {
+		if p.ID != id {
+			pending = append(pending, p)
+		}
+	}
+	s.pending = pending
+	return nil
+}
+
+// loadPublished loads the published IDs from the file. A missing file has no
+// published IDs.
+func (s *FileOutboxStore) loadPublished() error {
+	f, err := os.Open(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		id, err := strconv.ParseUint(scanner.Text(), 10, 64)
+		if err != nil {
+			return fmt.Errorf("%s:%d: %w", s.path, line, err)
+		}
+		s.mark(id)
+	}
+	return scanner.Err()
+}
+
+// loadPending decodes the records that are not published into the pending
+// publications.
+func (s *FileOutboxStore) loadPending() error {
+	for record, err := range streamRecords(s.recordStore) {
+		if err != nil {
+			return err
+		}
+		s.records++
+		if s.records <= uint64(s.published) || s.marked[s.records] {
+			continue
+		}
+		events, err := s.codec.Decode(record)
+		if err != nil {
+			return err
+		}
+		for _, event := range events {
+			s.pending = append(s.pending, Publication{s.records, event})
+		}
+	}
+	return nil
+}
+
+// mark marks an ID as published and advances the count of events that are
+// all published.
+func (s *FileOutboxStore) mark(id uint64) {
+	s.marked[id] = true
+	for s.marked[uint64(s.published)+1] {
+		s.published++
+		delete(s.marked, uint64(s.published))
+	}
+}
+
+// OutboxRelay publishes the pending publications of an OutboxStore on an
+// event bus. Each publication is marked as published after it has been
+// published, which gives at least once delivery: an event can be published
+// again if the process crashes before it is marked.
+//
+// Dispatchers in outbox mode, see SetOutboxRelay, relay directly after
+// appending events. Relay should also be called on startup to recover the
+// publications that were left pending by a crash.
+type OutboxRelay struct {
+	store  OutboxStore
+	bus    EventBus
+	mu     sync.Mutex
+	logger Logger
+}
+
+// NewOutboxRelay creates an OutboxRelay.
+func NewOutboxRelay(store OutboxStore, bus EventBus) *OutboxRelay {
+	r := &OutboxRelay{
+		store:  store,
+		bus:    bus,
+		logger: defaultLogger(),
+	}
+	return r
+}
+
+// SetLogger sets the logger used to log relay errors.
+func (r *OutboxRelay) SetLogger(logger Logger) {
+	r.logger = logger
+}
+
+// Relay publishes all pending publications in order and returns the number of
+// events that were published and marked as published. Publications that share
+// an ID are marked after all of them have been published.
+func (r *OutboxRelay) Relay() (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	publications, err := r.store.PendingPublications()
+	if err != nil {
+		return 0, err
+	}
+	published := 0
+	for i, p := range publications {
+		r.bus.PublishEvent(p.Event)
+		if i+1 < len(publications) && publications[i+1].ID == p.ID {
+			continue
+		}
+		if err := r.store.MarkPublished(p.ID); err != nil {
+			return published, err
+		}
+		published = i + 1
+	}
+	return published, nil
+}
+
+// Run relays pending publications at an interval until the context is done.
+func (r *OutboxRelay) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := r.Relay(); err != nil {
+			r.logger.Error("could not relay outbox",
+				LogKeyError, err,
+			)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// publishEvents publishes events on the bus, or through the outbox relay for
+// dispatchers in outbox mode. A failed relay is retried by the next relay, so
+// it is only logged.
+func publishEvents(bus EventBus, relay *OutboxRelay, logger Logger, events []Event) {
+	if relay == nil {
+		for _, event := range events {
+			bus.PublishEvent(event)
+		}
+		return
+	}
+
+	if _, err := relay.Relay(); err != nil {
+		logger.Warn("could not relay outbox",
+			LogKeyError, err,
+		)
+	}
+}
@@ -0,0 +1,307 @@
+// Copyright (c) 2014 - Max Persson <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventhorizon
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+var _ = Suite(&OutboxSuite{})
+
+type OutboxSuite struct {
+	store *MemoryOutboxStore
+	bus   *MockEventBus
+	relay *OutboxRelay
+}
+
+type ErrorOutboxStore struct {
+	*MemoryOutboxStore
+}
+
+func (s ErrorOutboxStore) MarkPublished(id uint64) error {
+	return errors.New("mark error")
+}
+
+// SharedIDOutboxStore is an outbox with publications that share IDs, as the
+// events of an upcasted record, and that fails to mark an ID.
+type SharedIDOutboxStore struct {
+	*MemoryOutboxStore
+	publications []Publication
+	marked       []uint64
+	fail         uint64
+}
+
+func (s *SharedIDOutboxStore) PendingPublications() ([]Publication, error) {
+	return s.publications, nil
+}
+
+func (s *SharedIDOutboxStore) MarkPublished(id uint64) error {
+	if id == s.fail {
+		return errors.New("mark error")
+	}
+	s.marked = append(s.marked, id)
+	return nil
+}
+
+func (s *OutboxSuite) SetUpTest(c *C) {
+	s.store = NewMemoryOutboxStore()
+	s.bus = &MockEventBus{
+		events: make([]Event, 0),
+	}
+	s.relay = NewOutboxRelay(s.store, s.bus)
+}
+
+func (s *OutboxSuite) Test_Append(c *C) {
+	event1 := TestEvent{NewUUID(), "event1"}
+	event2 := TestEvent{NewUUID(), "event2"}
+	err := s.store.Append([]Event{event1, event2})
+	c.Assert(err, Equals, nil)
+	events, _ := s.store.Load(event1.TestID)
+	c.Assert(events, DeepEquals, []Event{event1})
+	pending, err := s.store.PendingPublications()
+	c.Assert(err, Equals, nil)
+	c.Assert(pending, DeepEquals, []Publication{{1, event1}, {2, event2}})
+}
+
+func (s *OutboxSuite) Test_Relay(c *C) {
+	event1 := TestEvent{NewUUID(), "event1"}
+	event2 := TestEvent{NewUUID(), "event2"}
+	s.store.Append([]Event{event1, event2})
+	n, err := s.relay.Relay()
+	c.Assert(err, Equals, nil)
+	c.Assert(n, Equals, 2)
+	c.Assert(s.bus.events, DeepEquals, []Event{event1, event2})
+	pending, _ := s.store.PendingPublications()
+	c.Assert(len(pending), Equals, 0)
+
+	n, err = s.relay.Relay()
+	c.Assert(err, Equals, nil)
+	c.Assert(n, Equals, 0)
+	c.Assert(len(s.bus.events), Equals, 2)
+}
+
+func (s *OutboxSuite) Test_Relay_MarkError(c *C) {
+	relay := NewOutboxRelay(ErrorOutboxStore{s.store}, s.bus)
+	event1 := TestEvent{NewUUID(), "event1"}
+	s.store.Append([]Event{event1})
+	n, err := relay.Relay()
+	c.Assert(err, ErrorMatches, "mark error")
+	c.Assert(n, Equals, 0)
+
+	// The event is published again by the next relay.
+	s.relay.Relay()
+	c.Assert(s.bus.events, DeepEquals, []Event{event1, event1})
+}
+
+func (s *OutboxSuite) Test_Relay_SharedID(c *C) {
+	event1 := TestEvent{NewUUID(), "event1"}
+	event2 := TestEvent{NewUUID(), "event2"}
+	event3 := TestEvent{NewUUID(), "event3"}
+	event4 := TestEvent{NewUUID(), "event4"}
+	store := &SharedIDOutboxStore{
+		publications: []Publication{{1, event1}, {1, event2}, {2, event3}, {2, event4}},
+		fail:         2,
+	}
+	n, err := NewOutboxRelay(store, s.bus).Relay()
+	c.Assert(err, ErrorMatches, "mark error")
+	c.Assert(n, Equals, 2)
+	c.Assert(store.marked, DeepEquals, []uint64{1})
+	c.Assert(s.bus.events, DeepEquals, []Event{event1, event2, event3, event4})
+}
+
+func (s *OutboxSuite) Test_Dispatcher(c *C) {
+	disp := NewDelegateDispatcher(s.store, s.bus)
+	disp.SetOutboxRelay(s.relay)
+	disp.AddHandler(&TestDelegateDispatcherAggregate{}, TestCommand{})
+	command1 := TestCommand{NewUUID(), "command1"}
+	err := disp.Dispatch(command1)
+	c.Assert(err, Equals, nil)
+	c.Assert(s.bus.events, DeepEquals, []Event{TestEvent{command1.TestID, "command1"}})
+	pending, _ := s.store.PendingPublications()
+	c.Assert(len(pending), Equals, 0)
+}
+
+func (s *OutboxSuite) Test_Recover(c *C) {
+	// A dispatcher that crashes after appending leaves the events pending.
+	event1 := TestEvent{NewUUID(), "event1"}
+	s.store.Append([]Event{event1})
+
+	// Recover on startup.
+	n, err := NewOutboxRelay(s.store, s.bus).Relay()
+	c.Assert(err, Equals, nil)
+	c.Assert(n, Equals, 1)
+	c.Assert(s.bus.events, DeepEquals, []Event{event1})
+}
+
+func (s *OutboxSuite) Test_Validate(c *C) {
+	disp := NewDelegateDispatcher(NewMemoryOutboxStore(), s.bus)
+	disp.SetOutboxRelay(s.relay)
+	disp.AddHandler(&TestDelegateDispatcherAggregate{}, TestCommand{})
+	c.Assert(disp.Validate(), Equals, ErrOutboxStoreMismatch)
+
+	disp = NewDelegateDispatcher(s.store, s.bus)
+	disp.SetOutboxRelay(s.relay)
+	disp.AddHandler(&TestDelegateDispatcherAggregate{}, TestCommand{})
+	c.Assert(disp.Validate(), Equals, nil)
+}
+
+// TaggedOutboxStore is an outbox store that can not be compared.
+type TaggedOutboxStore struct {
+	*MemoryOutboxStore
+	tags []string
+}
+
+func (s *OutboxSuite) Test_Validate_NotComparable(c *C) {
+	store := TaggedOutboxStore{s.store, []string{"tag"}}
+	disp := NewDelegateDispatcher(store, s.bus)
+	disp.SetOutboxRelay(NewOutboxRelay(store, s.bus))
+	disp.AddHandler(&TestDelegateDispatcherAggregate{}, TestCommand{})
+	c.Assert(disp.Validate(), Equals, nil)
+}
+
+func (s *OutboxSuite) Test_Run(c *C) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.relay.Run(ctx, time.Millisecond)
+		close(done)
+	}()
+
+	event1 := TestEvent{NewUUID(), "event1"}
+	s.store.Append([]Event{event1})
+	for i := 0; i < 100; i++ {
+		if pending, _ := s.store.PendingPublications(); len(pending) == 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+	c.Assert(s.bus.events, DeepEquals, []Event{event1})
+}
+
+var _ = Suite(&FileOutboxStoreSuite{})
+
+type FileOutboxStoreSuite struct {
+	dir   string
+	codec *EventCodec
+	store *FileOutboxStore
+}
+
+func (s *FileOutboxStoreSuite) SetUpTest(c *C) {
+	s.dir = c.MkDir()
+	s.codec = NewEventCodec()
+	s.codec.RegisterEvent(TestEvent{}, 1)
+	var err error
+	s.store, err = NewFileOutboxStore(s.dir, s.codec)
+	c.Assert(err, Equals, nil)
+}
+
+func (s *FileOutboxStoreSuite) Test_Relay(c *C) {
+	event1 := TestEvent{NewUUID(), "event1"}
+	event2 := TestEvent{NewUUID(), "event2"}
+	c.Assert(s.store.Append([]Event{event1, event2}), Equals, nil)
+	pending, err := s.store.PendingPublications()
+	c.Assert(err, Equals, nil)
+	c.Assert(pending, DeepEquals, []Publication{{1, event1}, {2, event2}})
+
+	bus := &MockEventBus{events: make([]Event, 0)}
+	n, err := NewOutboxRelay(s.store, bus).Relay()
+	c.Assert(err, Equals, nil)
+	c.Assert(n, Equals, 2)
+	c.Assert(bus.events, DeepEquals, []Event{event1, event2})
+	pending, _ = s.store.PendingPublications()
+	c.Assert(len(pending), Equals, 0)
+}
+
+func (s *FileOutboxStoreSuite) Test_Reopen(c *C) {
+	event1 := TestEvent{NewUUID(), "event1"}
+	event2 := TestEvent{NewUUID(), "event2"}
+	event3 := TestEvent{NewUUID(), "event3"}
+	s.store.Append([]Event{event1, event2, event3})
+	c.Assert(s.store.MarkPublished(1), Equals, nil)
+	c.Assert(s.store.MarkPublished(3), Equals, nil)
+
+	// The publications are still pending after a restart.
+	store, err := NewFileOutboxStore(s.dir, s.codec)
+	c.Assert(err, Equals, nil)
+	pending, err := store.PendingPublications()
+	c.Assert(err, Equals, nil)
+	c.Assert(pending, DeepEquals, []Publication{{2, event2}})
+	c.Assert(store.published, Equals, 1)
+	c.Assert(store.MarkPublished(2), Equals, nil)
+	c.Assert(store.published, Equals, 3)
+	c.Assert(len(store.marked), Equals, 0)
+}
+
+func (s *FileOutboxStoreSuite) Test_PendingInMemory(c *C) {
+	event1 := TestEvent{NewUUID(), "event1"}
+	c.Assert(s.store.Append([]Event{event1}), Equals, nil)
+
+	// The pending publications are not read from the records.
+	c.Assert(os.Remove(filepath.Join(s.dir, FileRecordStoreName)), Equals, nil)
+	pending, err := s.store.PendingPublications()
+	c.Assert(err, Equals, nil)
+	c.Assert(pending, DeepEquals, []Publication{{1, event1}})
+	c.Assert(s.store.MarkPublished(1), Equals, nil)
+	pending, _ = s.store.PendingPublications()
+	c.Assert(len(pending), Equals, 0)
+}
+
+func (s *FileOutboxStoreSuite) Test_Relay_Upcasted(c *C) {
+	// A stored record that is upcasted to two events.
+	id := NewUUID()
+	records, err := NewFileRecordStore(s.dir)
+	c.Assert(err, Equals, nil)
+	c.Assert(records.AppendRecords([]EventRecord{{
+		AggregateID: id,
+		Type:        "TestEventPair",
+		Version:     1,
+		Data:        []byte(`{"TestID":"` + id.String() + `"}`),
+	}}), Equals, nil)
+	s.codec.AddUpcaster("TestEventPair", 1, func(r EventRecord) ([]EventRecord, error) {
+		return []EventRecord{
+			{AggregateID: r.AggregateID, Type: "TestEvent", Version: 1, Data: r.Data},
+			{AggregateID: r.AggregateID, Type: "TestEvent", Version: 1, Data: r.Data},
+		}, nil
+	})
+	store, err := NewFileOutboxStore(s.dir, s.codec)
+	c.Assert(err, Equals, nil)
+	event2 := TestEvent{NewUUID(), "event2"}
+	c.Assert(store.Append([]Event{event2}), Equals, nil)
+	pending, _ := store.PendingPublications()
+	c.Assert(pending, DeepEquals, []Publication{{1, TestEvent{id, ""}}, {1, TestEvent{id, ""}}, {2, event2}})
+
+	bus := &MockEventBus{events: make([]Event, 0)}
+	n, err := NewOutboxRelay(store, bus).Relay()
+	c.Assert(err, Equals, nil)
+	c.Assert(n, Equals, 3)
+	data, err := os.ReadFile(filepath.Join(s.dir, FileOutboxName))
+	c.Assert(err, Equals, nil)
+	c.Assert(string(data), Equals, "1\n2\n")
+}
+
+func (s *FileOutboxStoreSuite) Test_Reopen_Invalid(c *C) {
+	err := os.WriteFile(filepath.Join(s.dir, FileOutboxName), []byte("x\n"), 0600)
+	c.Assert(err, Equals, nil)
+	_, err = NewFileOutboxStore(s.dir, s.codec)
+	c.Assert(err, ErrorMatches, ".*published.jsonl:1: .*invalid syntax")
+}
@@ -0,0 +1,178 @@
+// Copyright (c) 2014 - Max Persson <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventhorizon
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are the histogram buckets used by PrometheusMetrics, in
+// seconds. They are the same as the default buckets of the Prometheus clients.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// PrometheusMetrics implements Metrics by keeping the metrics in memory, and
+// is a http.Handler that serves them in the Prometheus text exposition format.
+type PrometheusMetrics struct {
+	mu         sync.Mutex
+	buckets    []float64
+	counters   map[string]map[string]float64
+	histograms map[string]map[string]*histogram
+}
+
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// NewPrometheusMetrics creates a new PrometheusMetrics using DefaultBuckets.
+func NewPrometheusMetrics() *PrometheusMetrics {
+	m := &PrometheusMetrics{
+		buckets:    DefaultBuckets,
+		counters:   make(map[string]map[string]float64),
+		histograms: make(map[string]map[string]*histogram),
+	}
+	return m
+}
+
+// IncCounter increments a counter by one.
+func (m *PrometheusMetrics) IncCounter(name string, labels Labels) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	series, ok := m.counters[name]
+	if !ok {
+		series = make(map[string]float64)
+		m.counters[name] = series
+	}
+	series[formatLabels(labels)]++
+}
+
+// ObserveHistogram adds an observation to a histogram.
+func (m *PrometheusMetrics) ObserveHistogram(name string, value float64, labels Labels) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	series, ok := m.histograms[name]
+	if !ok {
+		series = make(map[string]*histogram)
+		m.histograms[name] = series
+	}
+	key := formatLabels(labels)
+	h, ok := series[key]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(m.buckets))}
+		series[key] = h
+	}
+
+	for i, bound := range m.buckets {
+		if value <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += value
+	h.count++
+}
+
+// ServeHTTP serves the metrics in the Prometheus text exposition format.
+func (m *PrometheusMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	m.WriteTo(w)
+}
+
+// WriteTo writes the metrics in the Prometheus text exposition format.
+func (m *PrometheusMetrics) WriteTo(w io.Writer) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var b strings.Builder
+	for _, name := range sortedKeys(m.counters) {
+		fmt.Fprintf(&b, "# TYPE %s counter\n", name)
+		series := m.counters[name]
+		for _, labels := range sortedKeys(series) {
+			fmt.Fprintf(&b, "%s%s %s\n", name, wrapLabels(labels), formatFloat(series[labels]))
+		}
+	}
+
+	for _, name := range sortedKeys(m.histograms) {
+		fmt.Fprintf(&b, "# TYPE %s histogram\n", name)
+		series := m.histograms[name]
+		for _, labels := range sortedKeys(series) {
+			h := series[labels]
+			for i, bound := range m.buckets {
+				le := fmt.Sprintf(`le="%s"`, formatFloat(bound))
+				fmt.Fprintf(&b, "%s_bucket%s %d\n", name, wrapLabels(joinLabels(labels, le)), h.counts[i])
+			}
+			fmt.Fprintf(&b, "%s_bucket%s %d\n", name, wrapLabels(joinLabels(labels, `le="+Inf"`)), h.count)
+			fmt.Fprintf(&b, "%s_sum%s %s\n", name, wrapLabels(labels), formatFloat(h.sum))
+			fmt.Fprintf(&b, "%s_count%s %d\n", name, wrapLabels(labels), h.count)
+		}
+	}
+
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+// formatLabels formats labels sorted by name, without surrounding braces.
+func formatLabels(labels Labels) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = fmt.Sprintf(`%s="%s"`, name, escapeLabelValue(labels[name]))
+	}
+	return strings.Join(pairs, ",")
+}
+
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+func joinLabels(labels, label string) string {
+	if labels == "" {
+		return label
+	}
+	return labels + "," + label
+}
+
+func wrapLabels(labels string) string {
+	if labels == "" {
+		return ""
+	}
+	return "{" + labels + "}"
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,80 @@
+// Copyright (c) 2014 - Max Persson <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventhorizon
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+var _ = Suite(&PrometheusMetricsSuite{})
+
+type PrometheusMetricsSuite struct {
+	metrics *PrometheusMetrics
+}
+
+func (s *PrometheusMetricsSuite) SetUpTest(c *C) {
+	s.metrics = NewPrometheusMetrics()
+}
+
+func (s *PrometheusMetricsSuite) Test_Counter(c *C) {
+	s.metrics.IncCounter("test_total", Labels{"b": "2", "a": "1"})
+	s.metrics.IncCounter("test_total", Labels{"a": "1", "b": "2"})
+	s.metrics.IncCounter("test_total", Labels{"a": `"quoted"`})
+	s.metrics.IncCounter("other_total", nil)
+	var b strings.Builder
+	s.metrics.WriteTo(&b)
+	c.Assert(b.String(), Equals, `# TYPE other_total counter
+other_total 1
+# TYPE test_total counter
+test_total{a="1",b="2"} 2
+test_total{a="\"quoted\""} 1
+`)
+}
+
+func (s *PrometheusMetricsSuite) Test_Histogram(c *C) {
+	s.metrics.buckets = []float64{0.1, 1}
+	s.metrics.ObserveHistogram("test_seconds", 0.05, Labels{"a": "1"})
+	s.metrics.ObserveHistogram("test_seconds", 0.5, Labels{"a": "1"})
+	s.metrics.ObserveHistogram("test_seconds", 5, Labels{"a": "1"})
+	var b strings.Builder
+	s.metrics.WriteTo(&b)
+	c.Assert(b.String(), Equals, `# TYPE test_seconds histogram
+test_seconds_bucket{a="1",le="0.1"} 1
+test_seconds_bucket{a="1",le="1"} 2
+test_seconds_bucket{a="1",le="+Inf"} 3
+test_seconds_sum{a="1"} 5.55
+test_seconds_count{a="1"} 3
+`)
+}
+
+func (s *PrometheusMetricsSuite) Test_ServeHTTP(c *C) {
+	bus := NewHandlerEventBus()
+	bus.SetMetrics(s.metrics)
+	bus.PublishEvent(TestEvent{NewUUID(), "event1"})
+
+	server := httptest.NewServer(s.metrics)
+	defer server.Close()
+	resp, err := server.Client().Get(server.URL)
+	c.Assert(err, Equals, nil)
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	c.Assert(resp.Header.Get("Content-Type"), Equals, "text/plain; version=0.0.4")
+	c.Assert(string(body), Matches, `(?s).*eventhorizon_events_published_total\{event_type="eventhorizon.TestEvent"\} 1.*`)
+	c.Assert(string(body), Matches, `(?s).*eventhorizon_publish_duration_seconds_count\{event_type="eventhorizon.TestEvent"\} 1.*`)
+}
@@ -0,0 +1,218 @@
+// Copyright (c) 2014 - Max Persson <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventhorizon
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Paging limits of the QueryHandler.
+const (
+	DefaultPageLimit = 20
+	MaxPageLimit     = 100
+)
+
+// VersionedModel is a read model with a version, which the QueryHandler uses
+// as ETag. The version should change every time the model changes.
+type VersionedModel interface {
+	ModelVersion() int
+}
+
+// QueryHandler is a http.Handler that serves read models from repositories.
+//
+// Each repository is added with a name and served as:
+//
+//	GET /{name}/{id}                  the model with id
+//	GET /{name}?offset=0&limit=20     a page of all models
+//
+// Responses have an ETag, based on the version of VersionedModels or else on
+// the content, and conditional requests with If-None-Match are supported.
+type QueryHandler struct {
+	repositories map[string]Repository
+}
+
+// QueryPage is the response body of listing models.
+type QueryPage struct {
+	Items  []interface{} `json:"items"`
+	Offset int           `json:"offset"`
+	Limit  int           `json:"limit"`
+	Total  int           `json:"total"`
+}
+
+// NewQueryHandler creates a QueryHandler.
+func NewQueryHandler() *QueryHandler {
+	h := &QueryHandler{
+		repositories: make(map[string]Repository),
+	}
+	return h
+}
+
+// AddRepository adds a repository to be served with a name.
+func (h *QueryHandler) AddRepository(name string, repository Repository) {
+	h.repositories[name] = repository
+}
+
+// ServeHTTP serves a model or a page of models.
+func (h *QueryHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		writeGatewayError(w, http.StatusMethodNotAllowed,
+			GatewayError{Code: "method_not_allowed", Message: "only GET and HEAD are allowed"})
+		return
+	}
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	repository, ok := h.repositories[parts[0]]
+	if !ok || len(parts) > 2 {
+		writeGatewayError(w, http.StatusNotFound,
+			GatewayError{Code: "not_found", Message: "unknown read model"})
+		return
+	}
+
+	if len(parts) == 1 {
+		h.servePage(w, r, repository)
+	} else {
+		h.serveModel(w, r, repository, parts[1])
+	}
+}
+
+func (h *QueryHandler) serveModel(w http.ResponseWriter, r *http.Request, repository Repository, rawID string) {
+	id, err := ParseUUID(rawID)
+	if err != nil {
+		writeGatewayError(w, http.StatusBadRequest,
+			GatewayError{Code: "invalid_id", Message: err.Error()})
+		return
+	}
+
+	// Models of other tenants are not found, to not reveal that they exist.
+	model, err := repository.Find(id)
+	if errors.Is(err, ErrModelNotFound) || errors.Is(err, ErrCrossTenant) {
+		writeGatewayError(w, http.StatusNotFound,
+			GatewayError{Code: "not_found", Message: ErrModelNotFound.Error()})
+		return
+	} else if err != nil {
+		writeInternalError(w)
+		return
+	}
+
+	body, err := json.Marshal(model)
+	if err != nil {
+		writeInternalError(w)
+		return
+	}
+
+	etag := contentETag(body)
+	if m, ok := model.(VersionedModel); ok {
+		etag = fmt.Sprintf(`"%s-%d"`, id, m.ModelVersion())
+	}
+	writeQueryResponse(w, r, etag, body)
+}
+
+func (h *QueryHandler) servePage(w http.ResponseWriter, r *http.Request, repository Repository) {
+	offset, err := queryInt(r, "offset", 0)
+	if err != nil || offset < 0 {
+		writeGatewayError(w, http.StatusBadRequest,
+			GatewayError{Code: "invalid_offset", Message: "offset must be a positive integer"})
+		return
+	}
+	limit, err := queryInt(r, "limit", DefaultPageLimit)
+	if err != nil || limit < 1 || limit > MaxPageLimit {
+		writeGatewayError(w, http.StatusBadRequest,
+			GatewayError{Code: "invalid_limit", Message: fmt.Sprintf("limit must be between 1 and %d", MaxPageLimit)})
+		return
+	}
+
+	models, err := repository.FindAll()
+	if err != nil {
+		writeInternalError(w)
+		return
+	}
+
+	page := QueryPage{
+		Items:  []interface{}{},
+		Offset: offset,
+		Limit:  limit,
+		Total:  len(models),
+	}
+	if offset < len(models) {
+		end := offset + limit
+		if end > len(models) {
+			end = len(models)
+		}
+		page.Items = models[offset:end]
+	}
+
+	body, err := json.Marshal(page)
+	if err != nil {
+		writeInternalError(w)
+		return
+	}
+	writeQueryResponse(w, r, contentETag(body), body)
+}
+
+// writeInternalError writes an error of the repositories, which is not for
+// the client.
+func writeInternalError(w http.ResponseWriter) {
+	writeGatewayError(w, http.StatusInternalServerError,
+		GatewayError{Code: "internal_error", Message: "internal error"})
+}
+
+func writeQueryResponse(w http.ResponseWriter, r *http.Request, etag string, body []byte) {
+	w.Header().Set("ETag", etag)
+	if matchETag(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(http.StatusOK)
+	if r.Method != http.MethodHead {
+		w.Write(body)
+	}
+}
+
+// matchETag checks an If-None-Match header using weak comparison.
+func matchETag(header, etag string) bool {
+	if header == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+func contentETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return fmt.Sprintf(`W/"%x"`, sum[:8])
+}
+
+func queryInt(r *http.Request, name string, defaultValue int) (int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+	return strconv.Atoi(value)
+}
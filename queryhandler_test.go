@@ -0,0 +1,188 @@
+// Copyright (c) 2014 - Max Persson <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventhorizon
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+var _ = Suite(&QueryHandlerSuite{})
+
+type QueryHandlerSuite struct {
+	repo    *MemoryRepository
+	handler *QueryHandler
+}
+
+type TestModel struct {
+	ID      UUID
+	Content string
+}
+
+type TestVersionedModel struct {
+	ID      UUID
+	Version int
+}
+
+func (t *TestVersionedModel) ModelVersion() int { return t.Version }
+
+// errorRepository is a Repository that fails with an error.
+type errorRepository struct {
+	err error
+}
+
+func (r *errorRepository) Save(UUID, interface{})          {}
+func (r *errorRepository) Find(UUID) (interface{}, error)  { return nil, r.err }
+func (r *errorRepository) FindAll() ([]interface{}, error) { return nil, r.err }
+func (r *errorRepository) Remove(UUID) error               { return r.err }
+
+func (s *QueryHandlerSuite) SetUpTest(c *C) {
+	s.repo = NewMemoryRepository()
+	s.handler = NewQueryHandler()
+	s.handler.AddRepository("models", s.repo)
+}
+
+func (s *QueryHandlerSuite) get(path, etag string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", path, nil)
+	if etag != "" {
+		r.Header.Set("If-None-Match", etag)
+	}
+	w := httptest.NewRecorder()
+	s.handler.ServeHTTP(w, r)
+	return w
+}
+
+func (s *QueryHandlerSuite) Test_Find(c *C) {
+	model := &TestModel{NewUUID(), "model1"}
+	s.repo.Save(model.ID, model)
+	w := s.get("/models/"+model.ID.String(), "")
+	c.Assert(w.Code, Equals, http.StatusOK)
+	c.Assert(w.Header().Get("Content-Type"), Equals, "application/json")
+	var result TestModel
+	err := json.Unmarshal(w.Body.Bytes(), &result)
+	c.Assert(err, Equals, nil)
+	c.Assert(result, Equals, *model)
+	c.Assert(w.Header().Get("ETag"), Matches, `W/"[0-9a-f]{16}"`)
+}
+
+func (s *QueryHandlerSuite) Test_Find_NotFound(c *C) {
+	w := s.get("/models/"+NewUUID().String(), "")
+	c.Assert(w.Code, Equals, http.StatusNotFound)
+	w = s.get("/unknown/"+NewUUID().String(), "")
+	c.Assert(w.Code, Equals, http.StatusNotFound)
+	w = s.get("/models/invalid", "")
+	c.Assert(w.Code, Equals, http.StatusBadRequest)
+}
+
+func (s *QueryHandlerSuite) Test_Find_OtherTenant(c *C) {
+	repository := NewMultiTenantRepository(func(string) Repository {
+		return NewMemoryRepository()
+	})
+	model := &TestModel{NewUUID(), "model1"}
+	repository.ForTenant("tenant1").Save(model.ID, model)
+	s.handler.AddRepository("tenant2", repository.ForTenant("tenant2"))
+	w := s.get("/tenant2/"+model.ID.String(), "")
+	c.Assert(w.Code, Equals, http.StatusNotFound)
+	c.Assert(w.Body.String(), Equals, `{"error":{"code":"not_found","message":"could not find model"}}`+"\n")
+}
+
+func (s *QueryHandlerSuite) Test_Find_InternalError(c *C) {
+	s.handler.AddRepository("errors", &errorRepository{errors.New("connection refused: db1")})
+	w := s.get("/errors/"+NewUUID().String(), "")
+	c.Assert(w.Code, Equals, http.StatusInternalServerError)
+	c.Assert(w.Body.String(), Equals, `{"error":{"code":"internal_error","message":"internal error"}}`+"\n")
+	w = s.get("/errors", "")
+	c.Assert(w.Code, Equals, http.StatusInternalServerError)
+	c.Assert(strings.Contains(w.Body.String(), "db1"), Equals, false)
+}
+
+func (s *QueryHandlerSuite) Test_Find_ConditionalGet(c *C) {
+	model := &TestModel{NewUUID(), "model1"}
+	s.repo.Save(model.ID, model)
+	w := s.get("/models/"+model.ID.String(), "")
+	etag := w.Header().Get("ETag")
+	w = s.get("/models/"+model.ID.String(), etag)
+	c.Assert(w.Code, Equals, http.StatusNotModified)
+	c.Assert(w.Body.Len(), Equals, 0)
+
+	model.Content = "changed"
+	w = s.get("/models/"+model.ID.String(), etag)
+	c.Assert(w.Code, Equals, http.StatusOK)
+	c.Assert(w.Header().Get("ETag"), Not(Equals), etag)
+}
+
+func (s *QueryHandlerSuite) Test_Find_VersionedModel(c *C) {
+	model := &TestVersionedModel{NewUUID(), 3}
+	s.repo.Save(model.ID, model)
+	w := s.get("/models/"+model.ID.String(), "")
+	etag := `"` + model.ID.String() + `-3"`
+	c.Assert(w.Header().Get("ETag"), Equals, etag)
+	w = s.get("/models/"+model.ID.String(), `"other", `+etag)
+	c.Assert(w.Code, Equals, http.StatusNotModified)
+	model.Version++
+	w = s.get("/models/"+model.ID.String(), etag)
+	c.Assert(w.Code, Equals, http.StatusOK)
+}
+
+func (s *QueryHandlerSuite) Test_List(c *C) {
+	for i := 0; i < 5; i++ {
+		model := &TestModel{NewUUID(), "model"}
+		s.repo.Save(model.ID, model)
+	}
+	all, _ := s.repo.FindAll()
+
+	w := s.get("/models?offset=1&limit=3", "")
+	c.Assert(w.Code, Equals, http.StatusOK)
+	var page struct {
+		Items  []TestModel
+		Offset int
+		Limit  int
+		Total  int
+	}
+	err := json.Unmarshal(w.Body.Bytes(), &page)
+	c.Assert(err, Equals, nil)
+	c.Assert(page.Offset, Equals, 1)
+	c.Assert(page.Limit, Equals, 3)
+	c.Assert(page.Total, Equals, 5)
+	c.Assert(len(page.Items), Equals, 3)
+	c.Assert(page.Items[0], Equals, *all[1].(*TestModel))
+
+	w = s.get("/models?offset=10", "")
+	c.Assert(w.Code, Equals, http.StatusOK)
+	c.Assert(w.Body.String(), Equals, `{"items":[],"offset":10,"limit":20,"total":5}`)
+
+	etag := w.Header().Get("ETag")
+	w = s.get("/models?offset=10", etag)
+	c.Assert(w.Code, Equals, http.StatusNotModified)
+}
+
+func (s *QueryHandlerSuite) Test_List_InvalidPaging(c *C) {
+	c.Assert(s.get("/models?offset=-1", "").Code, Equals, http.StatusBadRequest)
+	c.Assert(s.get("/models?limit=0", "").Code, Equals, http.StatusBadRequest)
+	c.Assert(s.get("/models?limit=1000", "").Code, Equals, http.StatusBadRequest)
+	c.Assert(s.get("/models?limit=x", "").Code, Equals, http.StatusBadRequest)
+}
+
+func (s *QueryHandlerSuite) Test_MethodNotAllowed(c *C) {
+	r := httptest.NewRequest("POST", "/models", nil)
+	w := httptest.NewRecorder()
+	s.handler.ServeHTTP(w, r)
+	c.Assert(w.Code, Equals, http.StatusMethodNotAllowed)
+}
@@ -0,0 +1,391 @@
+// Copyright (c) 2014 - Max Persson <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventhorizon
+
+import (
+	"errors"
+	"fmt"
+	"iter"
+	"sync"
+)
+
+// RecordStore is a storage for serialized event records.
+type RecordStore interface {
+	// AppendRecords appends records to the store.
+	AppendRecords([]EventRecord) error
+
+	// LoadRecords loads all records for the aggregate id from the store.
+	LoadRecords(UUID) ([]EventRecord, error)
+
+	// LoadAllRecords loads all records from the store in the order they were
+	// appended.
+	LoadAllRecords() ([]EventRecord, error)
+}
+
+// RecordStreamer is a RecordStore that can also stream all records, without
+// loading all of them into memory.
+type RecordStreamer interface {
+	RecordStore
+
+	// StreamAllRecords returns an iterator over all records in the order they
+	// were appended. The iteration stops after an error.
+	StreamAllRecords() iter.Seq2[EventRecord, error]
+}
+
+// AggregateRecordStreamer is a RecordStore that can also stream the records
+// of an aggregate, without loading all of them into memory.
+type AggregateRecordStreamer interface {
+	RecordStore
+
+	// StreamRecords returns an iterator over the records for the aggregate
+	// id. An aggregate without records has an empty stream. The iteration
+	// stops after an error.
+	StreamRecords(UUID) iter.Seq2[EventRecord, error]
+}
+
+// MemoryRecordStore implements RecordStore as an in memory structure. It is
+// safe for concurrent use.
+type MemoryRecordStore struct {
+	records []EventRecord
+	streams map[UUID][]int
+	mu      sync.RWMutex
+}
+
+// NewMemoryRecordStore creates a new MemoryRecordStore.
+func NewMemoryRecordStore() *MemoryRecordStore {
+	s := &MemoryRecordStore{
+		records: make([]EventRecord, 0),
+		streams: make(map[UUID][]int),
+	}
+	return s
+}
+
+// AppendRecords appends records to the memory store.
+func (s *MemoryRecordStore) AppendRecords(records []EventRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, record := range records {
+		s.streams[record.AggregateID] = append(s.streams[record.AggregateID], len(s.records))
+		s.records = append(s.records, record)
+	}
+	return nil
+}
+
+// LoadRecords loads all records for the aggregate id from the memory store.
+// Returns ErrNoEventsFound if no records can be found.
+func (s *MemoryRecordStore) LoadRecords(id UUID) ([]EventRecord, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	stream, ok := s.streams[id]
+	if !ok {
+		return nil, ErrNoEventsFound
+	}
+
+	records := make([]EventRecord, len(stream))
+	for i, index := range stream {
+		records[i] = s.records[index]
+	}
+	return records, nil
+}
+
+// StreamRecords returns an iterator over the records for the aggregate id
+// that are in the memory store when it is called.
+func (s *MemoryRecordStore) StreamRecords(id UUID) iter.Seq2[EventRecord, error] {
+	s.mu.RLock()
+	// Records are only appended, so the slices can be read without the lock.
+	records, stream := s.records, s.streams[id]
+	s.mu.RUnlock()
+	return func(yield func(EventRecord, error) bool) {
+		for _, index := range stream {
+			if !yield(records[index], nil) {
+				return
+			}
+		}
+	}
+}
+
+// LoadAllRecords loads all records from the memory store.
+func (s *MemoryRecordStore) LoadAllRecords() ([]EventRecord, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	records := make([]EventRecord, len(s.records))
+	copy(records, s.records)
+	return records, nil
+}
+
+// StreamAllRecords returns an iterator over the records that are in the
+// memory store when it is called.
+func (s *MemoryRecordStore) StreamAllRecords() iter.Seq2[EventRecord, error] {
+	s.mu.RLock()
+	// Records are only appended, so the slice can be read without the lock.
+	records := s.records
+	s.mu.RUnlock()
+	return func(yield func(EventRecord, error) bool) {
+		for _, record := range records {
+			if !yield(record, nil) {
+				return
+			}
+		}
+	}
+}
+
+// streamAggregateRecords streams the records for the aggregate id of a
+// RecordStore, or loads all of them if it is not an AggregateRecordStreamer.
+func streamAggregateRecords(recordStore RecordStore, id UUID) iter.Seq2[EventRecord, error] {
+	if streamer, ok := recordStore.(AggregateRecordStreamer); ok {
+		return streamer.StreamRecords(id)
+	}
+	return func(yield func(EventRecord, error) bool) {
+		records, err := recordStore.LoadRecords(id)
+		if errors.Is(err, ErrNoEventsFound) {
+			return
+		} else if err != nil {
+			yield(EventRecord{}, err)
+			return
+		}
+		for _, record := range records {
+			if !yield(record, nil) {
+				return
+			}
+		}
+	}
+}
+
+// streamRecords streams the records of a RecordStore, or loads all of them if
+// it is not a RecordStreamer.
+func streamRecords(recordStore RecordStore) iter.Seq2[EventRecord, error] {
+	if streamer, ok := recordStore.(RecordStreamer); ok {
+		return streamer.StreamAllRecords()
+	}
+	return func(yield func(EventRecord, error) bool) {
+		records, err := recordStore.LoadAllRecords()
+		if err != nil {
+			yield(EventRecord{}, err)
+			return
+		}
+		for _, record := range records {
+			if !yield(record, nil) {
+				return
+			}
+		}
+	}
+}
+
+// CodecEventStore implements EventStore by storing events as records in a
+// RecordStore, using an EventCodec to encode and decode them.
+//
+// Records of older schema versions are upcasted when loaded, which allows
+// event types to evolve without rewriting the stored history.
+type CodecEventStore struct {
+	recordStore RecordStore
+	codec       *EventCodec
+	logger      Logger
+}
+
+// NewCodecEventStore creates a new CodecEventStore.
+func NewCodecEventStore(recordStore RecordStore, codec *EventCodec) *CodecEventStore {
+	s := &CodecEventStore{
+		recordStore: recordStore,
+		codec:       codec,
+		logger:      defaultLogger(),
+	}
+	return s
+}
+
+// SetLogger sets the logger used to log appended events.
+func (s *CodecEventStore) SetLogger(logger Logger) {
+	s.logger = logger
+}
+
+// Append encodes the events and appends them to the record store. No events
+// are appended if any of them fails to encode.
+func (s *CodecEventStore) Append(events []Event) error {
+	records := make([]EventRecord, len(events))
+	for i, event := range events {
+		record, err := s.codec.Encode(event)
+		if err != nil {
+			return err
+		}
+		records[i] = record
+	}
+
+	if err := s.recordStore.AppendRecords(records); err != nil {
+		return err
+	}
+
+	for _, record := range records {
+		s.logger.Debug("event appended",
+			LogKeyAggregateID, record.AggregateID.String(),
+			LogKeyEventType, record.Type,
+		)
+	}
+	return nil
+}
+
+// Load loads and decodes all events for the aggregate id.
+// Returns ErrNoEventsFound if no events can be found.
+func (s *CodecEventStore) Load(id UUID) ([]Event, error) {
+	records, err := s.recordStore.LoadRecords(id)
+	if err != nil {
+		return nil, err
+	}
+	return s.decode(records)
+}
+
+// Stream returns an iterator that decodes the records for the aggregate id
+// one at a time, if the record store is an AggregateRecordStreamer.
+func (s *CodecEventStore) Stream(id UUID) iter.Seq2[Event, error] {
+	return s.decodeStream(streamAggregateRecords(s.recordStore, id))
+}
+
+// LoadAll loads and decodes all events in the order they were appended.
+func (s *CodecEventStore) LoadAll() ([]Event, error) {
+	records, err := s.recordStore.LoadAllRecords()
+	if err != nil {
+		return nil, err
+	}
+	return s.decode(records)
+}
+
+// StreamAll returns an iterator that decodes the records one at a time, if
+// the record store is a RecordStreamer.
+func (s *CodecEventStore) StreamAll() iter.Seq2[Event, error] {
+	return s.decodeStream(streamRecords(s.recordStore))
+}
+
+func (s *CodecEventStore) decodeStream(records iter.Seq2[EventRecord, error]) iter.Seq2[Event, error] {
+	return func(yield func(Event, error) bool) {
+		for record, err := range records {
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			events, err := s.codec.Decode(record)
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			for _, event := range events {
+				if !yield(event, nil) {
+					return
+				}
+			}
+		}
+	}
+}
+
+func (s *CodecEventStore) decode(records []EventRecord) ([]Event, error) {
+	events := make([]Event, 0, len(records))
+	for _, record := range records {
+		decoded, err := s.codec.Decode(record)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, decoded...)
+	}
+	return events, nil
+}
+
+// RawEvent is an event kept as its record, for tools that handle events
+// without their Go types.
+type RawEvent struct {
+	Record EventRecord
+}
+
+// AggregateID returns the aggregate ID of the record.
+func (e RawEvent) AggregateID() UUID {
+	return e.Record.AggregateID
+}
+
+// RawEventStore is an EventStore of RawEvents on top of a RecordStore, that
+// needs no codec with registered event types.
+type RawEventStore struct {
+	recordStore RecordStore
+}
+
+// NewRawEventStore creates a new RawEventStore.
+func NewRawEventStore(recordStore RecordStore) *RawEventStore {
+	s := &RawEventStore{
+		recordStore: recordStore,
+	}
+	return s
+}
+
+// Append appends the records of the events, which must be RawEvents.
+// Returns ErrEventNotRegistered for other events.
+func (s *RawEventStore) Append(events []Event) error {
+	records := make([]EventRecord, len(events))
+	for i, event := range events {
+		raw, ok := event.(RawEvent)
+		if !ok {
+			return fmt.Errorf("%w: %T", ErrEventNotRegistered, event)
+		}
+		records[i] = raw.Record
+	}
+	return s.recordStore.AppendRecords(records)
+}
+
+// Load loads all events for the aggregate id.
+// Returns ErrNoEventsFound if no events can be found.
+func (s *RawEventStore) Load(id UUID) ([]Event, error) {
+	records, err := s.recordStore.LoadRecords(id)
+	if err != nil {
+		return nil, err
+	}
+	return rawEvents(records), nil
+}
+
+// Stream returns an iterator over the events for the aggregate id, that
+// streams the records if the record store is an AggregateRecordStreamer.
+func (s *RawEventStore) Stream(id UUID) iter.Seq2[Event, error] {
+	return rawEventStream(streamAggregateRecords(s.recordStore, id))
+}
+
+// LoadAll loads all events in the order they were appended.
+func (s *RawEventStore) LoadAll() ([]Event, error) {
+	records, err := s.recordStore.LoadAllRecords()
+	if err != nil {
+		return nil, err
+	}
+	return rawEvents(records), nil
+}
+
+// StreamAll returns an iterator over all events, that streams the records if
+// the record store is a RecordStreamer.
+func (s *RawEventStore) StreamAll() iter.Seq2[Event, error] {
+	return rawEventStream(streamRecords(s.recordStore))
+}
+
+func rawEventStream(records iter.Seq2[EventRecord, error]) iter.Seq2[Event, error] {
+	return func(yield func(Event, error) bool) {
+		for record, err := range records {
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			if !yield(RawEvent{record}, nil) {
+				return
+			}
+		}
+	}
+}
+
+func rawEvents(records []EventRecord) []Event {
+	events := make([]Event, len(records))
+	for i, record := range records {
+		events[i] = RawEvent{record}
+	}
+	return events
+}
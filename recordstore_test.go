@@ -0,0 +1,164 @@
+// Copyright (c) 2014 - Max Persson <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventhorizon
+
+import (
+	"encoding/json"
+
+	. "gopkg.in/check.v1"
+)
+
+var _ = Suite(&MemoryRecordStoreSuite{})
+var _ = Suite(&CodecEventStoreSuite{})
+var _ = Suite(&RawEventStoreSuite{})
+
+type MemoryRecordStoreSuite struct {
+	store *MemoryRecordStore
+}
+
+func (s *MemoryRecordStoreSuite) SetUpTest(c *C) {
+	s.store = NewMemoryRecordStore()
+}
+
+func (s *MemoryRecordStoreSuite) Test_NewMemoryRecordStore(c *C) {
+	store := NewMemoryRecordStore()
+	c.Assert(store, Not(Equals), nil)
+	c.Assert(store.streams, Not(Equals), nil)
+	c.Assert(len(store.records), Equals, 0)
+}
+
+func (s *MemoryRecordStoreSuite) Test_LoadRecords_NoRecords(c *C) {
+	records, err := s.store.LoadRecords(NewUUID())
+	c.Assert(err, Equals, ErrNoEventsFound)
+	c.Assert(records, DeepEquals, []EventRecord(nil))
+}
+
+func (s *MemoryRecordStoreSuite) Test_AppendRecords_DifferentAggregates(c *C) {
+	record1 := EventRecord{AggregateID: NewUUID(), Type: "record1"}
+	record2 := EventRecord{AggregateID: NewUUID(), Type: "record2"}
+	record3 := EventRecord{AggregateID: record1.AggregateID, Type: "record3"}
+	err := s.store.AppendRecords([]EventRecord{record1, record2, record3})
+	c.Assert(err, Equals, nil)
+
+	records, err := s.store.LoadRecords(record1.AggregateID)
+	c.Assert(err, Equals, nil)
+	c.Assert(records, DeepEquals, []EventRecord{record1, record3})
+
+	records, err = s.store.LoadAllRecords()
+	c.Assert(err, Equals, nil)
+	c.Assert(records, DeepEquals, []EventRecord{record1, record2, record3})
+}
+
+type CodecEventStoreSuite struct {
+	recordStore *MemoryRecordStore
+	codec       *EventCodec
+	store       *CodecEventStore
+}
+
+func (s *CodecEventStoreSuite) SetUpTest(c *C) {
+	s.recordStore = NewMemoryRecordStore()
+	s.codec = NewEventCodec()
+	s.codec.RegisterEvent(TestEvent{}, 2)
+	s.codec.AddUpcaster("TestEvent", 1, func(r EventRecord) ([]EventRecord, error) {
+		var data map[string]interface{}
+		if err := json.Unmarshal(r.Data, &data); err != nil {
+			return nil, err
+		}
+		data["Content"] = data["Text"]
+		delete(data, "Text")
+		r.Data, _ = json.Marshal(data)
+		r.Version = 2
+		return []EventRecord{r}, nil
+	})
+	s.store = NewCodecEventStore(s.recordStore, s.codec)
+}
+
+func (s *CodecEventStoreSuite) Test_Append_NotRegistered(c *C) {
+	event1 := TestEvent{NewUUID(), "event1"}
+	event2 := TestEventOther{event1.TestID, "event2"}
+	err := s.store.Append([]Event{event1, event2})
+	c.Assert(err, ErrorMatches, "event type not registered: .*")
+	c.Assert(len(s.recordStore.records), Equals, 0)
+}
+
+func (s *CodecEventStoreSuite) Test_Load_NoEvents(c *C) {
+	events, err := s.store.Load(NewUUID())
+	c.Assert(err, Equals, ErrNoEventsFound)
+	c.Assert(events, DeepEquals, []Event(nil))
+}
+
+func (s *CodecEventStoreSuite) Test_Load_MixedVersions(c *C) {
+	id := NewUUID()
+	data, _ := json.Marshal(map[string]interface{}{"TestID": id, "Text": "event1"})
+	s.recordStore.AppendRecords([]EventRecord{{id, "TestEvent", 1, data}})
+	event2 := TestEvent{id, "event2"}
+	err := s.store.Append([]Event{event2})
+	c.Assert(err, Equals, nil)
+
+	events, err := s.store.Load(id)
+	c.Assert(err, Equals, nil)
+	c.Assert(events, DeepEquals, []Event{TestEvent{id, "event1"}, event2})
+}
+
+func (s *CodecEventStoreSuite) Test_LoadAll_MixedVersions(c *C) {
+	id1 := NewUUID()
+	id2 := NewUUID()
+	data, _ := json.Marshal(map[string]interface{}{"TestID": id1, "Text": "event1"})
+	s.recordStore.AppendRecords([]EventRecord{{id1, "TestEvent", 1, data}})
+	event2 := TestEvent{id2, "event2"}
+	event3 := TestEvent{id1, "event3"}
+	s.store.Append([]Event{event2, event3})
+
+	events, err := s.store.LoadAll()
+	c.Assert(err, Equals, nil)
+	c.Assert(events, DeepEquals, []Event{TestEvent{id1, "event1"}, event2, event3})
+}
+
+type RawEventStoreSuite struct {
+	recordStore *MemoryRecordStore
+	store       *RawEventStore
+}
+
+func (s *RawEventStoreSuite) SetUpTest(c *C) {
+	s.recordStore = NewMemoryRecordStore()
+	s.store = NewRawEventStore(s.recordStore)
+}
+
+func (s *RawEventStoreSuite) Test_Append(c *C) {
+	id := NewUUID()
+	record1 := EventRecord{id, "TestEvent", 1, []byte(`{"Content":"event1"}`)}
+	record2 := EventRecord{NewUUID(), "TestEvent", 1, []byte(`{"Content":"event2"}`)}
+	err := s.store.Append([]Event{RawEvent{record1}, RawEvent{record2}})
+	c.Assert(err, Equals, nil)
+	c.Assert(s.recordStore.records, DeepEquals, []EventRecord{record1, record2})
+
+	events, err := s.store.Load(id)
+	c.Assert(err, Equals, nil)
+	c.Assert(events, DeepEquals, []Event{RawEvent{record1}})
+	c.Assert(events[0].AggregateID(), Equals, id)
+	events, err = s.store.LoadAll()
+	c.Assert(err, Equals, nil)
+	c.Assert(events, DeepEquals, []Event{RawEvent{record1}, RawEvent{record2}})
+
+	events, err = s.store.Load(NewUUID())
+	c.Assert(err, Equals, ErrNoEventsFound)
+	c.Assert(events, IsNil)
+}
+
+func (s *RawEventStoreSuite) Test_Append_NotRaw(c *C) {
+	err := s.store.Append([]Event{TestEvent{NewUUID(), "event1"}})
+	c.Assert(err, ErrorMatches, "event type not registered: eventhorizon.TestEvent")
+	c.Assert(s.recordStore.records, HasLen, 0)
+}
@@ -0,0 +1,170 @@
+// Copyright (c) 2014 - Max Persson <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventhorizon
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// Error returned when a handler is added for a command that already has one.
+var ErrHandlerAlreadyAdded = errors.New("handler already added")
+
+// Error returned when a handler can not handle a command.
+var ErrInvalidHandler = errors.New("invalid handler")
+
+// Error returned by Validate when a dispatcher has no handlers.
+var ErrNoHandlers = errors.New("no handlers added")
+
+// Error returned by Validate when a dispatcher has no event bus.
+var ErrNoEventBusDefined = errors.New("no event bus defined")
+
+// Error returned by Validate when a dispatcher in outbox mode does not append
+// to the store of its outbox relay.
+var ErrOutboxStoreMismatch = errors.New("event store is not the outbox store")
+
+// HandlerError is returned when registering a handler for a command fails.
+type HandlerError struct {
+	Handler string
+	Command string
+	Reason  string
+	Err     error
+}
+
+func (e HandlerError) Error() string {
+	if e.Command == "" {
+		return fmt.Sprintf("%s: %s: %s", e.Err, e.Handler, e.Reason)
+	}
+	return fmt.Sprintf("%s: %s for %s: %s", e.Err, e.Handler, e.Command, e.Reason)
+}
+
+// Unwrap returns ErrHandlerAlreadyAdded or ErrInvalidHandler.
+func (e HandlerError) Unwrap() error {
+	return e.Err
+}
+
+var (
+	aggregateInterface = reflect.TypeOf((*Aggregate)(nil)).Elem()
+	errorInterface     = reflect.TypeOf((*error)(nil)).Elem()
+	eventsType         = reflect.TypeOf([]Event(nil))
+)
+
+// handlerError returns an error for adding a handler for a command.
+func handlerError(handler interface{}, command Command, err error, reason string) error {
+	e := HandlerError{
+		Handler: fmt.Sprintf("%T", handler),
+		Reason:  reason,
+		Err:     err,
+	}
+	if command != nil {
+		e.Command = fmt.Sprintf("%T", command)
+	}
+	return e
+}
+
+// checkAggregateType checks that a handler is a pointer to a struct with an
+// embedded Aggregate field, which the dispatchers set when creating
+// aggregates.
+func checkAggregateType(handler interface{}, command Command) error {
+	t := reflect.TypeOf(handler)
+	if t == nil || t.Kind() != reflect.Ptr || t.Elem().Kind() != reflect.Struct {
+		return handlerError(handler, command, ErrInvalidHandler, "not a pointer to a struct")
+	}
+	field, ok := t.Elem().FieldByName("Aggregate")
+	if !ok || !field.Anonymous || len(field.Index) != 1 || field.Type != aggregateInterface {
+		return handlerError(handler, command, ErrInvalidHandler, "no embedded Aggregate field")
+	}
+	return nil
+}
+
+// checkCommandType checks that a command is a struct, which the dispatchers
+// check the fields of and the handler methods take by value.
+func checkCommandType(handler interface{}, command Command) error {
+	if command == nil {
+		return handlerError(handler, command, ErrInvalidHandler, "command is nil")
+	}
+	if reflect.TypeOf(command).Kind() != reflect.Struct {
+		return handlerError(handler, command, ErrInvalidHandler, "command is not a struct")
+	}
+	return nil
+}
+
+// checkHandlerMethod checks that a handler has a method with the signature
+// Handle<Command>(<Command>) ([]Event, error).
+func checkHandlerMethod(handler interface{}, command Command) (reflect.Method, error) {
+	commandType := reflect.TypeOf(command)
+	name := "Handle" + commandType.Name()
+	method, ok := reflect.TypeOf(handler).MethodByName(name)
+	if !ok {
+		return method, handlerError(handler, command, ErrInvalidHandler, "no method "+name)
+	}
+	methodType := method.Type
+	if methodType.NumIn() != 2 || methodType.In(1) != commandType {
+		return method, handlerError(handler, command, ErrInvalidHandler,
+			fmt.Sprintf("method %s must take only a %s", name, commandType))
+	}
+	if methodType.NumOut() != 2 || methodType.Out(0) != eventsType || methodType.Out(1) != errorInterface {
+		return method, handlerError(handler, command, ErrInvalidHandler,
+			fmt.Sprintf("method %s must return ([]Event, error)", name))
+	}
+	return method, nil
+}
+
+// checkCommandTag checks the aggregate tag of a registered command.
+func checkCommandTag(command Command) error {
+	switch tag := lifecycleOf(command); tag {
+	case "", LifecycleCreate, LifecycleExisting:
+		return nil
+	default:
+		return fmt.Errorf("%w: %T has unknown aggregate tag %q",
+			ErrInvalidHandler, command, tag)
+	}
+}
+
+// sameEventStore checks if two event stores are the same. Stores of types that
+// can not be compared, like structs with slices, are assumed to be the same.
+func sameEventStore(a, b EventStore) bool {
+	if !reflect.TypeOf(a).Comparable() || !reflect.TypeOf(b).Comparable() {
+		return true
+	}
+	return a == b
+}
+
+// validateDispatcher checks the parts that are common to all dispatchers.
+func validateDispatcher(store EventStore, bus EventBus, relay *OutboxRelay, commands []Command) error {
+	var errs []error
+	if store == nil {
+		errs = append(errs, ErrNoEventStoreDefined)
+	}
+	if bus == nil && relay == nil {
+		errs = append(errs, ErrNoEventBusDefined)
+	}
+	if store != nil && relay != nil && !sameEventStore(store, relay.store) {
+		errs = append(errs, ErrOutboxStoreMismatch)
+	}
+	if len(commands) == 0 {
+		errs = append(errs, ErrNoHandlers)
+	}
+	for _, command := range commands {
+		if err := checkCommandTag(command); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) == 1 {
+		return errs[0]
+	}
+	return errors.Join(errs...)
+}
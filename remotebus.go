@@ -0,0 +1,369 @@
+// Copyright (c) 2014 - Max Persson <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventhorizon
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// Error returned when a received frame is larger than MaxFrameSize.
+var ErrFrameTooLarge = errors.New("frame too large")
+
+// MaxFrameSize is the maximum size of a frame sent between a Broker and a
+// RemoteEventBus.
+const MaxFrameSize = 16 << 20
+
+// DefaultReconnectDelay is the delay before a RemoteEventBus reconnects.
+const DefaultReconnectDelay = time.Second
+
+// writeTimeout is the time limit for writing a frame.
+const writeTimeout = 10 * time.Second
+
+// FrameCodec encodes the messages in the frames sent between a Broker and its
+// RemoteEventBuses. Each frame is a message prefixed with its length as a
+// 32 bit big endian integer.
+type FrameCodec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// JSONFrameCodec is a FrameCodec that encodes messages as JSON.
+type JSONFrameCodec struct{}
+
+// Marshal encodes a message as JSON.
+func (JSONFrameCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+// Unmarshal decodes a message from JSON.
+func (JSONFrameCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// The kinds of messages sent between a Broker and its RemoteEventBuses.
+const (
+	busHello   = "hello"
+	busPublish = "publish"
+	busDeliver = "deliver"
+	busAck     = "ack"
+)
+
+type busMessage struct {
+	Kind   string       `json:"kind"`
+	ID     uint64       `json:"id,omitempty"`
+	Name   string       `json:"name,omitempty"`
+	Record *EventRecord `json:"record,omitempty"`
+}
+
+// RemoteEventBus is an EventBus that publishes events through a Broker, for
+// deploying event handlers in other processes than the command handlers.
+//
+// Published events are sent to the broker, which delivers them to the
+// subscribers of all buses with a subscription name, including this one. The
+// bus reconnects automatically if the connection is lost. Delivery is at least
+// once: published events are sent again until the broker has acknowledged
+// them, and delivered events are acknowledged to the broker only after all
+// subscribers have handled them. The bus keeps at most a maximum number of
+// unacknowledged events, dropping the oldest ones when it is full, see
+// SetMaxPending.
+type RemoteEventBus struct {
+	*HandlerEventBus
+
+	network        string
+	address        string
+	name           string
+	codec          *EventCodec
+	frames         FrameCodec
+	conn           net.Conn
+	pending        []busMessage
+	maxPending     int
+	nextID         uint64
+	started        bool
+	connMu         sync.Mutex
+	reconnectDelay time.Duration
+	logger         Logger
+	closed         chan struct{}
+	closeOnce      sync.Once
+	done           chan struct{}
+}
+
+// NewRemoteEventBus creates a RemoteEventBus for a broker listening on the
+// network address, with "tcp" or "unix" as network. Subscribers of the bus
+// receive events only if it has a subscription name; the broker keeps events
+// for the name while the bus is disconnected. The codec is used to encode and
+// decode the events and must have all event types registered.
+func NewRemoteEventBus(network, address, name string, codec *EventCodec) *RemoteEventBus {
+	b := &RemoteEventBus{
+		HandlerEventBus: NewHandlerEventBus(),
+		network:         network,
+		address:         address,
+		name:            name,
+		codec:           codec,
+		frames:          JSONFrameCodec{},
+		maxPending:      DefaultMaxPending,
+		reconnectDelay:  DefaultReconnectDelay,
+		logger:          defaultLogger(),
+		closed:          make(chan struct{}),
+		done:            make(chan struct{}),
+	}
+	return b
+}
+
+// SetFrameCodec sets the codec used to encode messages to the broker. It must
+// be the same as the codec of the broker.
+func (b *RemoteEventBus) SetFrameCodec(codec FrameCodec) {
+	b.frames = codec
+}
+
+// SetMaxPending sets the number of events that are kept until the broker has
+// acknowledged them.
+func (b *RemoteEventBus) SetMaxPending(max int) {
+	b.maxPending = max
+}
+
+// SetReconnectDelay sets the delay before reconnecting to the broker.
+func (b *RemoteEventBus) SetReconnectDelay(delay time.Duration) {
+	b.reconnectDelay = delay
+}
+
+// SetLogger sets the logger used to log published events and connection
+// errors.
+func (b *RemoteEventBus) SetLogger(logger Logger) {
+	b.logger = logger
+	b.HandlerEventBus.SetLogger(logger)
+}
+
+// Start connects to the broker in the background. Starting a started bus does
+// nothing.
+func (b *RemoteEventBus) Start() {
+	b.connMu.Lock()
+	defer b.connMu.Unlock()
+	if b.started {
+		return
+	}
+	b.started = true
+	go b.run()
+}
+
+// Close disconnects from the broker. Events that have not been acknowledged by
+// the broker are lost. Closing a closed bus does nothing.
+func (b *RemoteEventBus) Close() {
+	b.closeOnce.Do(func() {
+		b.connMu.Lock()
+		close(b.closed)
+		if b.conn != nil {
+			b.conn.Close()
+		}
+		started := b.started
+		b.connMu.Unlock()
+		if started {
+			<-b.done
+		}
+	})
+}
+
+// PublishEvent sends an event to the broker. If the bus is disconnected the
+// event is sent when it has reconnected.
+func (b *RemoteEventBus) PublishEvent(event Event) {
+	record, err := b.codec.Encode(event)
+	if err != nil {
+		b.logger.Error("could not encode published event",
+			LogKeyEventType, reflect.TypeOf(event).String(),
+			LogKeyError, err,
+		)
+		return
+	}
+
+	b.connMu.Lock()
+	b.nextID++
+	m := busMessage{Kind: busPublish, ID: b.nextID, Record: &record}
+	if b.maxPending > 0 && len(b.pending) >= b.maxPending {
+		b.pending = append(b.pending[:0], b.pending[1:]...)
+		b.logger.Warn("too many unacknowledged events, dropping oldest event",
+			LogKeyEventType, record.Type,
+		)
+	}
+	b.pending = append(b.pending, m)
+	conn := b.conn
+	b.connMu.Unlock()
+
+	// Frames are written with a single write, which is safe to do
+	// concurrently with the writes of other publishers and acks.
+	if conn != nil {
+		if err := writeFrame(conn, b.frames, m); err != nil {
+			// Reconnect and send all pending events again.
+			conn.Close()
+		}
+	}
+}
+
+func (b *RemoteEventBus) run() {
+	defer close(b.done)
+	for {
+		conn, err := net.Dial(b.network, b.address)
+		if err == nil {
+			err = b.serve(conn)
+		}
+
+		select {
+		case <-b.closed:
+			return
+		default:
+		}
+		b.logger.Warn("disconnected from broker",
+			LogKeyError, err,
+		)
+
+		select {
+		case <-b.closed:
+			return
+		case <-time.After(b.reconnectDelay):
+		}
+	}
+}
+
+func (b *RemoteEventBus) serve(conn net.Conn) error {
+	defer conn.Close()
+
+	if err := writeFrame(conn, b.frames, busMessage{Kind: busHello, Name: b.name}); err != nil {
+		return err
+	}
+
+	// Send the pending events until none are left that were published
+	// before the connection is used by PublishEvent. Their IDs are in the
+	// order they were published.
+	var sent uint64
+	for {
+		b.connMu.Lock()
+		select {
+		case <-b.closed:
+			b.connMu.Unlock()
+			return nil
+		default:
+		}
+		var unsent []busMessage
+		for _, m := range b.pending {
+			if m.ID > sent {
+				unsent = append(unsent, m)
+			}
+		}
+		if len(unsent) == 0 {
+			b.conn = conn
+			b.connMu.Unlock()
+			break
+		}
+		b.connMu.Unlock()
+
+		for _, m := range unsent {
+			if err := writeFrame(conn, b.frames, m); err != nil {
+				return err
+			}
+			sent = m.ID
+		}
+	}
+
+	defer func() {
+		b.connMu.Lock()
+		b.conn = nil
+		b.connMu.Unlock()
+	}()
+
+	reader := bufio.NewReader(conn)
+	for {
+		var m busMessage
+		if err := readFrame(reader, b.frames, &m); err != nil {
+			return err
+		}
+
+		switch m.Kind {
+		case busAck:
+			b.ack(m.ID)
+		case busDeliver:
+			if err := b.deliver(conn, m); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (b *RemoteEventBus) ack(id uint64) {
+	b.connMu.Lock()
+	defer b.connMu.Unlock()
+	for i, m := range b.pending {
+		if m.ID == id {
+			b.pending = append(b.pending[:i], b.pending[i+1:]...)
+			return
+		}
+	}
+}
+
+// deliver publishes a delivered event to the local subscribers and then
+// acknowledges it to the broker.
+func (b *RemoteEventBus) deliver(conn net.Conn, m busMessage) error {
+	if m.Record != nil {
+		events, err := b.codec.Decode(*m.Record)
+		if err != nil {
+			// The event can never be decoded, acknowledge it anyway.
+			b.logger.Error("could not decode delivered event",
+				LogKeyEventType, m.Record.Type,
+				LogKeyError, err,
+			)
+		}
+		for _, event := range events {
+			b.HandlerEventBus.PublishEvent(event)
+		}
+	}
+
+	return writeFrame(conn, b.frames, busMessage{Kind: busAck, ID: m.ID})
+}
+
+func writeFrame(conn net.Conn, codec FrameCodec, v interface{}) error {
+	data, err := codec.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	frame := make([]byte, 4+len(data))
+	binary.BigEndian.PutUint32(frame, uint32(len(data)))
+	copy(frame[4:], data)
+	conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	_, err = conn.Write(frame)
+	return err
+}
+
+func readFrame(r io.Reader, codec FrameCodec, v interface{}) error {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return err
+	}
+	size := binary.BigEndian.Uint32(header[:])
+	if size > MaxFrameSize {
+		return ErrFrameTooLarge
+	}
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return err
+	}
+	return codec.Unmarshal(data, v)
+}
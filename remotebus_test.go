@@ -0,0 +1,297 @@
+// Copyright (c) 2014 - Max Persson <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventhorizon
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"path/filepath"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+var _ = Suite(&RemoteEventBusSuite{})
+
+type RemoteEventBusSuite struct {
+	codec    *EventCodec
+	broker   *Broker
+	listener net.Listener
+	buses    []*RemoteEventBus
+}
+
+type ChanEventHandler struct {
+	events chan Event
+}
+
+func (h *ChanEventHandler) HandleEvent(event Event) {
+	h.events <- event
+}
+
+func (s *RemoteEventBusSuite) SetUpTest(c *C) {
+	s.codec = NewEventCodec()
+	s.codec.RegisterEvent(TestEvent{}, 1)
+	s.broker = NewBroker()
+	s.buses = nil
+	s.listen(c, "tcp", "127.0.0.1:0")
+}
+
+func (s *RemoteEventBusSuite) TearDownTest(c *C) {
+	for _, bus := range s.buses {
+		bus.Close()
+	}
+	s.broker.Close()
+}
+
+func (s *RemoteEventBusSuite) listen(c *C, network, address string) {
+	var err error
+	s.listener, err = net.Listen(network, address)
+	c.Assert(err, Equals, nil)
+	go s.broker.Serve(s.listener)
+}
+
+func (s *RemoteEventBusSuite) newBus(name string) (*RemoteEventBus, *ChanEventHandler) {
+	bus := NewRemoteEventBus(s.listener.Addr().Network(), s.listener.Addr().String(), name, s.codec)
+	bus.SetReconnectDelay(10 * time.Millisecond)
+	handler := &ChanEventHandler{make(chan Event, 10)}
+	bus.AddGlobalSubscriber(handler)
+	bus.Start()
+	s.buses = append(s.buses, bus)
+	return bus, handler
+}
+
+// waitConnected waits until the broker has a connection for a subscription.
+func (s *RemoteEventBusSuite) waitConnected(c *C, name string) {
+	for i := 0; i < 100; i++ {
+		s.broker.mu.Lock()
+		sub, ok := s.broker.subscriptions[name]
+		s.broker.mu.Unlock()
+		if ok && sub.attached() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Fatal("bus did not connect")
+}
+
+func (sub *brokerSubscription) attached() bool {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	return sub.conn != nil
+}
+
+func (b *Broker) pending(name string) int {
+	b.mu.Lock()
+	sub := b.subscriptions[name]
+	b.mu.Unlock()
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	return len(sub.pending)
+}
+
+func receiveEvent(c *C, handler *ChanEventHandler) Event {
+	select {
+	case event := <-handler.events:
+		return event
+	case <-time.After(time.Second):
+		c.Fatal("no event received")
+	}
+	return nil
+}
+
+func (s *RemoteEventBusSuite) Test_PublishEvent(c *C) {
+	publisher, _ := s.newBus("")
+	_, handler1 := s.newBus("projector1")
+	_, handler2 := s.newBus("projector2")
+	s.waitConnected(c, "projector1")
+	s.waitConnected(c, "projector2")
+
+	event1 := TestEvent{NewUUID(), "event1"}
+	publisher.PublishEvent(event1)
+	c.Assert(receiveEvent(c, handler1), Equals, event1)
+	c.Assert(receiveEvent(c, handler2), Equals, event1)
+}
+
+func (s *RemoteEventBusSuite) Test_PublishEvent_Unix(c *C) {
+	s.broker.Close()
+	s.broker = NewBroker()
+	s.listen(c, "unix", filepath.Join(c.MkDir(), "broker.sock"))
+
+	bus, handler := s.newBus("projector")
+	s.waitConnected(c, "projector")
+	event1 := TestEvent{NewUUID(), "event1"}
+	bus.PublishEvent(event1)
+	c.Assert(receiveEvent(c, handler), Equals, event1)
+}
+
+func (s *RemoteEventBusSuite) Test_Reconnect(c *C) {
+	s.broker.AddSubscription("projector")
+	publisher, _ := s.newBus("")
+	_, handler := s.newBus("projector")
+	s.waitConnected(c, "projector")
+
+	// Restart the broker and publish while it is down.
+	address := s.listener.Addr().String()
+	s.broker.Close()
+	event1 := TestEvent{NewUUID(), "event1"}
+	publisher.PublishEvent(event1)
+	s.broker = NewBroker()
+	s.broker.AddSubscription("projector")
+	s.listen(c, "tcp", address)
+
+	c.Assert(receiveEvent(c, handler), Equals, event1)
+}
+
+func (s *RemoteEventBusSuite) Test_Redelivery(c *C) {
+	s.broker.AddSubscription("projector")
+	publisher, _ := s.newBus("")
+	event1 := TestEvent{NewUUID(), "event1"}
+	publisher.PublishEvent(event1)
+
+	// Receive the event without acknowledging it.
+	conn, err := net.Dial("tcp", s.listener.Addr().String())
+	c.Assert(err, Equals, nil)
+	err = writeFrame(conn, JSONFrameCodec{}, busMessage{Kind: busHello, Name: "projector"})
+	c.Assert(err, Equals, nil)
+	var m busMessage
+	err = readFrame(bufio.NewReader(conn), JSONFrameCodec{}, &m)
+	c.Assert(err, Equals, nil)
+	c.Assert(m.Kind, Equals, busDeliver)
+	c.Assert(m.Record.Type, Equals, "TestEvent")
+	conn.Close()
+
+	_, handler := s.newBus("projector")
+	c.Assert(receiveEvent(c, handler), Equals, event1)
+
+	// The event is acknowledged and not delivered again.
+	for i := 0; i < 100; i++ {
+		if s.broker.pending("projector") == 0 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Fatal("event was not acknowledged")
+}
+
+func (s *RemoteEventBusSuite) Test_SlowSubscriber(c *C) {
+	// The pipe blocks writes until the subscriber reads.
+	server, client := net.Pipe()
+	defer client.Close()
+	conn := &brokerConn{conn: server, frames: JSONFrameCodec{}}
+	sub := s.broker.attach("slow", conn)
+	done := make(chan struct{})
+	defer close(done)
+	go s.broker.deliver(sub, conn, done)
+
+	published := make(chan struct{})
+	go func() {
+		s.broker.publish(&EventRecord{Type: "TestEvent", AggregateID: NewUUID()})
+		s.broker.publish(&EventRecord{Type: "TestEvent", AggregateID: NewUUID()})
+		close(published)
+	}()
+	select {
+	case <-published:
+	case <-time.After(time.Second):
+		c.Fatal("publish blocked on a slow subscriber")
+	}
+
+	reader := bufio.NewReader(client)
+	for id := uint64(1); id <= 2; id++ {
+		var m busMessage
+		c.Assert(readFrame(reader, JSONFrameCodec{}, &m), Equals, nil)
+		c.Assert(m.ID, Equals, id)
+	}
+}
+
+func (s *RemoteEventBusSuite) Test_MaxPending(c *C) {
+	s.broker.SetMaxPending(2)
+	s.broker.AddSubscription("projector")
+	for i := 0; i < 3; i++ {
+		s.broker.publish(&EventRecord{Type: "TestEvent", AggregateID: NewUUID()})
+	}
+	c.Assert(s.broker.pending("projector"), Equals, 2)
+	sub := s.broker.subscriptions["projector"]
+	c.Assert(sub.pending[0].ID, Equals, uint64(2))
+}
+
+func (s *RemoteEventBusSuite) Test_Close(c *C) {
+	// A bus that was never started closes without waiting.
+	bus := NewRemoteEventBus("tcp", s.listener.Addr().String(), "", s.codec)
+	bus.Close()
+	bus.Close()
+
+	bus, _ = s.newBus("")
+	bus.Start()
+	bus.Close()
+	bus.Close()
+}
+
+func (s *RemoteEventBusSuite) Test_PublishEvent_SlowBroker(c *C) {
+	// The pipe blocks writes until the broker reads.
+	server, client := net.Pipe()
+	defer server.Close()
+	bus := NewRemoteEventBus("tcp", s.listener.Addr().String(), "", s.codec)
+	bus.conn = client
+
+	go bus.PublishEvent(TestEvent{NewUUID(), "event1"})
+	locked := make(chan struct{})
+	go func() {
+		for {
+			bus.connMu.Lock()
+			n := len(bus.pending)
+			bus.connMu.Unlock()
+			if n == 1 {
+				close(locked)
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	select {
+	case <-locked:
+	case <-time.After(time.Second):
+		c.Fatal("publish held the lock while writing")
+	}
+
+	var m busMessage
+	c.Assert(readFrame(bufio.NewReader(server), JSONFrameCodec{}, &m), Equals, nil)
+	c.Assert(m.ID, Equals, uint64(1))
+}
+
+func (s *RemoteEventBusSuite) Test_PublishEvent_MaxPending(c *C) {
+	logger := &MockLogger{}
+	bus := NewRemoteEventBus("tcp", s.listener.Addr().String(), "", s.codec)
+	bus.SetLogger(logger)
+	bus.SetMaxPending(2)
+	for i := 0; i < 3; i++ {
+		bus.PublishEvent(TestEvent{NewUUID(), "event"})
+	}
+	c.Assert(len(bus.pending), Equals, 2)
+	c.Assert(bus.pending[0].ID, Equals, uint64(2))
+	c.Assert(logger.records[0].level, Equals, "warn")
+}
+
+func (s *RemoteEventBusSuite) Test_Frames(c *C) {
+	server, client := net.Pipe()
+	go writeFrame(client, JSONFrameCodec{}, busMessage{Kind: busAck, ID: 3})
+	var m busMessage
+	err := readFrame(server, JSONFrameCodec{}, &m)
+	c.Assert(err, Equals, nil)
+	c.Assert(m, DeepEquals, busMessage{Kind: busAck, ID: 3})
+
+	err = readFrame(bytes.NewReader([]byte{0xff, 0xff, 0xff, 0xff}), JSONFrameCodec{}, &m)
+	c.Assert(err, Equals, ErrFrameTooLarge)
+}
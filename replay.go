@@ -0,0 +1,332 @@
+// Copyright (c) 2014 - Max Persson <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventhorizon
+
+import (
+	"errors"
+	"fmt"
+	"iter"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// Error returned when replaying an aggregate type that is not registered.
+var ErrAggregateNotRegistered = errors.New("aggregate not registered")
+
+// Error returned when replaying to a version after the last event.
+var ErrVersionNotFound = errors.New("version not found")
+
+// Error returned when replaying to a time and an event has no timestamp.
+var ErrNoTimestamp = errors.New("event has no timestamp")
+
+// MetadataTimestamp is the metadata key of the time an event was created,
+// formatted as RFC 3339 with nanoseconds. The dispatchers add it to the
+// resulting events.
+const MetadataTimestamp = "timestamp"
+
+// TimestampOf returns the timestamp in the metadata of an event, and false if
+// it has none.
+func TimestampOf(value interface{}) (time.Time, bool) {
+	return metadataTimestamp(MetadataOf(value))
+}
+
+func metadataTimestamp(metadata Metadata) (time.Time, bool) {
+	t, err := time.Parse(time.RFC3339Nano, metadata[MetadataTimestamp])
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// withTimestamp adds the timestamp to the metadata of the events.
+func withTimestamp(events []Event, t time.Time) []Event {
+	timestamp := t.UTC().Format(time.RFC3339Nano)
+	for i, event := range events {
+		events[i] = WithMetadata(event, MetadataTimestamp, timestamp)
+	}
+	return events
+}
+
+// Replayer hydrates aggregates from an event store at a point in their
+// history, to see what they looked like at a version or time and how their
+// state changed event by event.
+//
+// Aggregates are hydrated in the same way as by the dispatchers: aggregates
+// that implement CommandHandler apply events with HandleEvent, other
+// aggregates with their ApplyMyEvent methods.
+type Replayer struct {
+	eventStore EventStore
+	aggregates map[string]reflect.Type
+}
+
+// ReplayStep is the state of an aggregate after applying an event.
+type ReplayStep struct {
+	// Version is the number of events applied, starting at 1.
+	Version int
+
+	Event Event
+	State []StateField
+
+	// Changes are the fields of the aggregate changed by the event.
+	Changes []FieldChange
+}
+
+// FieldChange is a changed field of an aggregate, with the old and new values
+// formatted as text.
+type FieldChange struct {
+	Field string
+	Old   string
+	New   string
+}
+
+// NewReplayer creates a Replayer for an event store.
+func NewReplayer(store EventStore) *Replayer {
+	r := &Replayer{
+		eventStore: store,
+		aggregates: make(map[string]reflect.Type),
+	}
+	return r
+}
+
+// RegisterAggregate registers an aggregate type by the name of its Go type.
+// The aggregate must be a pointer to a struct with an embedded Aggregate
+// field, like the handlers of the dispatchers.
+func (r *Replayer) RegisterAggregate(aggregate interface{}) error {
+	if err := checkAggregateType(aggregate, nil); err != nil {
+		return err
+	}
+	t := reflect.TypeOf(aggregate)
+	r.aggregates[t.Elem().Name()] = t
+	return nil
+}
+
+// Aggregates returns the names of the registered aggregate types, in order.
+func (r *Replayer) Aggregates() []string {
+	names := make([]string, 0, len(r.aggregates))
+	for name := range r.aggregates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Replay returns the aggregate after all its events.
+func (r *Replayer) Replay(name string, id UUID) (interface{}, error) {
+	aggregate, _, err := r.stream(name, id, func(aggregate Aggregate, event Event) (bool, error) {
+		aggregate.ApplyEvent(event)
+		return true, nil
+	})
+	return aggregate, err
+}
+
+// ReplayVersion returns the aggregate after its first version events, where
+// version 0 is the aggregate before any events. Returns ErrVersionNotFound if
+// the aggregate has fewer events.
+func (r *Replayer) ReplayVersion(name string, id UUID, version int) (interface{}, error) {
+	if version < 0 {
+		return nil, fmt.Errorf("%w: %d", ErrVersionNotFound, version)
+	}
+	applied := 0
+	aggregate, streamed, err := r.stream(name, id, func(aggregate Aggregate, event Event) (bool, error) {
+		if applied == version {
+			return false, nil
+		}
+		aggregate.ApplyEvent(event)
+		applied++
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if applied < version {
+		return nil, fmt.Errorf("%w: %d of %d", ErrVersionNotFound, version, streamed)
+	}
+	return aggregate, nil
+}
+
+// ReplayTime returns the aggregate after all its events created at or before
+// t. Returns ErrNoTimestamp if an event has no timestamp.
+func (r *Replayer) ReplayTime(name string, id UUID, t time.Time) (interface{}, error) {
+	aggregate, _, err := r.stream(name, id, func(aggregate Aggregate, event Event) (bool, error) {
+		timestamp, ok := TimestampOf(event)
+		if !ok {
+			return false, fmt.Errorf("%w: %T", ErrNoTimestamp, event)
+		}
+		if timestamp.After(t) {
+			return false, nil
+		}
+		aggregate.ApplyEvent(event)
+		return true, nil
+	})
+	return aggregate, err
+}
+
+// History returns an iterator over the state of the aggregate after each of
+// its events, with the fields changed by the event. The events are streamed
+// and applied to a single aggregate, so the iteration can stop at any version
+// without reading the rest of the events.
+func (r *Replayer) History(name string, id UUID) iter.Seq2[ReplayStep, error] {
+	return func(yield func(ReplayStep, error) bool) {
+		aggregate, err := r.newAggregate(name, id)
+		if err != nil {
+			yield(ReplayStep{}, err)
+			return
+		}
+
+		before := StateFields(aggregate)
+		version := 0
+		for event, err := range StreamEvents(r.eventStore, id) {
+			if err != nil {
+				yield(ReplayStep{}, err)
+				return
+			}
+			aggregate.(Aggregate).ApplyEvent(event)
+			version++
+			after := StateFields(aggregate)
+			step := ReplayStep{
+				Version: version,
+				Event:   event,
+				State:   after,
+				Changes: diffFields(before, after),
+			}
+			if !yield(step, nil) {
+				return
+			}
+			before = after
+		}
+		if version == 0 {
+			yield(ReplayStep{}, ErrNoEventsFound)
+		}
+	}
+}
+
+// stream creates an aggregate and streams its events to apply, until apply
+// returns false. Returns the aggregate and the number of streamed events, or
+// ErrNoEventsFound if the aggregate has no events.
+func (r *Replayer) stream(name string, id UUID, apply func(Aggregate, Event) (bool, error)) (interface{}, int, error) {
+	aggregate, err := r.newAggregate(name, id)
+	if err != nil {
+		return nil, 0, err
+	}
+	streamed := 0
+	for event, err := range StreamEvents(r.eventStore, id) {
+		if err != nil {
+			return nil, streamed, err
+		}
+		streamed++
+		ok, err := apply(aggregate.(Aggregate), event)
+		if err != nil {
+			return nil, streamed, err
+		} else if !ok {
+			break
+		}
+	}
+	if streamed == 0 {
+		return nil, 0, ErrNoEventsFound
+	}
+	return aggregate, streamed, nil
+}
+
+// newAggregate creates an aggregate of a registered type.
+func (r *Replayer) newAggregate(name string, id UUID) (interface{}, error) {
+	t, ok := r.aggregates[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrAggregateNotRegistered, name)
+	}
+
+	obj := reflect.New(t.Elem())
+	var base Aggregate
+	if _, ok := obj.Interface().(CommandHandler); ok {
+		base = NewDelegateAggregate(id, obj.Interface().(EventHandler))
+	} else {
+		base = NewReflectAggregate(id, obj.Interface())
+	}
+	obj.Elem().FieldByName("Aggregate").Set(reflect.ValueOf(base))
+	return obj.Interface(), nil
+}
+
+// StateField is a field of an aggregate with its value formatted as text.
+// Fields of nested structs are named by their path, like "Address.City".
+type StateField struct {
+	Name  string
+	Value string
+}
+
+// StateFields returns the fields of an aggregate, leaving out the embedded
+// Aggregate. Unexported fields are included, as aggregates usually keep their
+// state in them.
+func StateFields(aggregate interface{}) []StateField {
+	v := reflect.ValueOf(aggregate)
+	if v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return []StateField{{"", fmt.Sprintf("%+v", aggregate)}}
+	}
+	return appendStateFields(nil, "", v)
+}
+
+func appendStateFields(fields []StateField, prefix string, v reflect.Value) []StateField {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Anonymous && f.Type == aggregateInterface {
+			continue
+		}
+		name := prefix + f.Name
+		value := v.Field(i)
+		for value.Kind() == reflect.Ptr && !value.IsNil() {
+			value = value.Elem()
+		}
+		if value.Kind() == reflect.Struct && value.Type() != timeType {
+			fields = appendStateFields(fields, name+".", value)
+			continue
+		}
+		fields = append(fields, StateField{name, fmt.Sprintf("%+v", value)})
+	}
+	return fields
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// DiffState returns the fields that differ between two aggregates of the same
+// type, by comparing their formatted values. Fields that only exist in one of
+// them have an empty old or new value.
+func DiffState(before, after interface{}) []FieldChange {
+	return diffFields(StateFields(before), StateFields(after))
+}
+
+func diffFields(before, after []StateField) []FieldChange {
+	old := make(map[string]string, len(before))
+	for _, f := range before {
+		old[f.Name] = f.Value
+	}
+	var changes []FieldChange
+	for _, f := range after {
+		if value, ok := old[f.Name]; !ok || value != f.Value {
+			changes = append(changes, FieldChange{f.Name, value, f.Value})
+		}
+		delete(old, f.Name)
+	}
+
+	// Fields can disappear when a nil pointer to a struct is set.
+	for _, f := range before {
+		if value, ok := old[f.Name]; ok {
+			changes = append(changes, FieldChange{f.Name, value, ""})
+		}
+	}
+	return changes
+}
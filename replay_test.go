@@ -0,0 +1,215 @@
+// Copyright (c) 2014 - Max Persson <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventhorizon
+
+import (
+	"errors"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+var _ = Suite(&ReplaySuite{})
+
+type ReplaySuite struct {
+	store    *MemoryEventStore
+	replayer *Replayer
+	id       UUID
+	start    time.Time
+}
+
+type TestReplayAddress struct {
+	City string
+}
+
+// TestReplayAggregate is a delegate aggregate that keeps the last content of
+// traced events.
+type TestReplayAggregate struct {
+	Aggregate
+
+	count   int
+	content string
+	Address *TestReplayAddress
+}
+
+func (a *TestReplayAggregate) HandleCommand(command Command) ([]Event, error) {
+	return nil, nil
+}
+
+func (a *TestReplayAggregate) HandleEvent(event Event) {
+	switch event := event.(type) {
+	case TestTracedEvent:
+		a.count++
+		a.content = event.Content
+		if event.Content == "moved" {
+			a.Address = &TestReplayAddress{"Stockholm"}
+		}
+	}
+}
+
+// TestReflectReplayAggregate is a reflect aggregate.
+type TestReflectReplayAggregate struct {
+	Aggregate
+
+	content string
+}
+
+func (a *TestReflectReplayAggregate) ApplyTestTracedEvent(event TestTracedEvent) {
+	a.content = event.Content
+}
+
+func (s *ReplaySuite) SetUpTest(c *C) {
+	s.store = NewMemoryEventStore()
+	s.replayer = NewReplayer(s.store)
+	c.Assert(s.replayer.RegisterAggregate(&TestReplayAggregate{}), IsNil)
+	c.Assert(s.replayer.RegisterAggregate(&TestReflectReplayAggregate{}), IsNil)
+
+	s.id = NewUUID()
+	s.start = time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	for i, content := range []string{"created", "moved", "renamed"} {
+		event := TestTracedEvent{TestID: s.id, Content: content}
+		events := withTimestamp([]Event{event}, s.start.Add(time.Duration(i)*time.Hour))
+		c.Assert(s.store.Append(events), IsNil)
+	}
+}
+
+func (s *ReplaySuite) Test_RegisterAggregate(c *C) {
+	c.Assert(s.replayer.Aggregates(), DeepEquals, []string{"TestReflectReplayAggregate", "TestReplayAggregate"})
+	err := s.replayer.RegisterAggregate(&EmptyAggregate{})
+	c.Assert(errors.Is(err, ErrInvalidHandler), Equals, true)
+	c.Assert(err, ErrorMatches, "invalid handler: \\*eventhorizon.EmptyAggregate: no embedded Aggregate field")
+}
+
+func (s *ReplaySuite) Test_Replay(c *C) {
+	aggregate, err := s.replayer.Replay("TestReplayAggregate", s.id)
+	c.Assert(err, IsNil)
+	c.Assert(aggregate.(*TestReplayAggregate).count, Equals, 3)
+	c.Assert(aggregate.(*TestReplayAggregate).content, Equals, "renamed")
+}
+
+func (s *ReplaySuite) Test_ReplayVersion(c *C) {
+	aggregate, err := s.replayer.ReplayVersion("TestReplayAggregate", s.id, 2)
+	c.Assert(err, IsNil)
+	a := aggregate.(*TestReplayAggregate)
+	c.Assert(a.AggregateID(), Equals, s.id)
+	c.Assert(a.count, Equals, 2)
+	c.Assert(a.content, Equals, "moved")
+
+	aggregate, err = s.replayer.ReplayVersion("TestReplayAggregate", s.id, 0)
+	c.Assert(err, IsNil)
+	c.Assert(aggregate.(*TestReplayAggregate).count, Equals, 0)
+
+	aggregate, err = s.replayer.ReplayVersion("TestReflectReplayAggregate", s.id, 3)
+	c.Assert(err, IsNil)
+	c.Assert(aggregate.(*TestReflectReplayAggregate).content, Equals, "renamed")
+}
+
+func (s *ReplaySuite) Test_ReplayVersion_Errors(c *C) {
+	_, err := s.replayer.ReplayVersion("TestReplayAggregate", s.id, 4)
+	c.Assert(errors.Is(err, ErrVersionNotFound), Equals, true)
+	c.Assert(err, ErrorMatches, "version not found: 4 of 3")
+	_, err = s.replayer.ReplayVersion("TestReplayAggregate", NewUUID(), 0)
+	c.Assert(err, Equals, ErrNoEventsFound)
+	_, err = s.replayer.ReplayVersion("Unknown", s.id, 1)
+	c.Assert(errors.Is(err, ErrAggregateNotRegistered), Equals, true)
+}
+
+func (s *ReplaySuite) Test_ReplayTime(c *C) {
+	aggregate, err := s.replayer.ReplayTime("TestReplayAggregate", s.id, s.start.Add(90*time.Minute))
+	c.Assert(err, IsNil)
+	c.Assert(aggregate.(*TestReplayAggregate).content, Equals, "moved")
+
+	aggregate, err = s.replayer.ReplayTime("TestReplayAggregate", s.id, s.start.Add(-time.Minute))
+	c.Assert(err, IsNil)
+	c.Assert(aggregate.(*TestReplayAggregate).count, Equals, 0)
+
+	// Events without a timestamp can not be replayed to a time.
+	id := NewUUID()
+	c.Assert(s.store.Append([]Event{TestEvent{id, "event1"}}), IsNil)
+	_, err = s.replayer.ReplayTime("TestReplayAggregate", id, s.start)
+	c.Assert(errors.Is(err, ErrNoTimestamp), Equals, true)
+}
+
+func (s *ReplaySuite) Test_History(c *C) {
+	var steps []ReplayStep
+	for step, err := range s.replayer.History("TestReplayAggregate", s.id) {
+		c.Assert(err, IsNil)
+		steps = append(steps, step)
+	}
+	c.Assert(steps, HasLen, 3)
+	c.Assert(steps[0].Version, Equals, 1)
+	c.Assert(steps[0].Changes, DeepEquals, []FieldChange{
+		{"count", "0", "1"},
+		{"content", "", "created"},
+	})
+	c.Assert(steps[1].Changes, DeepEquals, []FieldChange{
+		{"count", "1", "2"},
+		{"content", "created", "moved"},
+		{"Address.City", "", "Stockholm"},
+		{"Address", "<nil>", ""},
+	})
+	c.Assert(steps[2].Changes, DeepEquals, []FieldChange{
+		{"count", "2", "3"},
+		{"content", "moved", "renamed"},
+	})
+	c.Assert(steps[1].State, DeepEquals, []StateField{
+		{"count", "2"},
+		{"content", "moved"},
+		{"Address.City", "Stockholm"},
+	})
+	c.Assert(steps[2].Event.(TestTracedEvent).Content, Equals, "renamed")
+
+	// The history stops when the iteration does.
+	for step, err := range s.replayer.History("TestReplayAggregate", s.id) {
+		c.Assert(err, IsNil)
+		c.Assert(step.Version, Equals, 1)
+		break
+	}
+	for _, err := range s.replayer.History("TestReplayAggregate", NewUUID()) {
+		c.Assert(err, Equals, ErrNoEventsFound)
+	}
+	for _, err := range s.replayer.History("Unknown", s.id) {
+		c.Assert(errors.Is(err, ErrAggregateNotRegistered), Equals, true)
+	}
+}
+
+func (s *ReplaySuite) Test_StateFields(c *C) {
+	a := &TestReplayAggregate{count: 1, Address: &TestReplayAddress{"Lund"}}
+	c.Assert(StateFields(a), DeepEquals, []StateField{
+		{"count", "1"},
+		{"content", ""},
+		{"Address.City", "Lund"},
+	})
+	b := &TestReplayAggregate{count: 1, Address: &TestReplayAddress{"Malmö"}}
+	c.Assert(DiffState(a, b), DeepEquals, []FieldChange{{"Address.City", "Lund", "Malmö"}})
+	c.Assert(DiffState(a, a), IsNil)
+}
+
+func (s *ReplaySuite) Test_Dispatch_Timestamp(c *C) {
+	store := &MockEventStore{}
+	disp := NewDelegateDispatcher(store, &MockEventBus{})
+	disp.AddHandler(&TestTracedAggregate{}, TestTracedCommand{})
+	before := time.Now()
+	err := disp.Dispatch(TestTracedCommand{TestID: NewUUID(), Content: "command1"})
+	c.Assert(err, IsNil)
+	c.Assert(store.events, HasLen, 1)
+	timestamp, ok := TimestampOf(store.events[0])
+	c.Assert(ok, Equals, true)
+	c.Assert(timestamp.Before(before), Equals, false)
+	c.Assert(timestamp.After(time.Now()), Equals, false)
+
+	_, ok = TimestampOf(TestEvent{})
+	c.Assert(ok, Equals, false)
+}
@@ -16,6 +16,8 @@ package eventhorizon
 
 import (
 	"errors"
+	"sort"
+	"sync"
 )
 
 // Error returned when a model could not be found.
@@ -36,9 +38,11 @@ type Repository interface {
 	Remove(UUID) error
 }
 
-// MemoryRepository implements an in memory repository of read models.
+// MemoryRepository implements an in memory repository of read models. It is
+// safe for concurrent use.
 type MemoryRepository struct {
 	data map[UUID]interface{}
+	mu   sync.RWMutex
 }
 
 // NewMemoryRepository creates a new MemoryRepository.
@@ -51,26 +55,36 @@ func NewMemoryRepository() *MemoryRepository {
 
 // Save saves a read model with id to the repository.
 func (r *MemoryRepository) Save(id UUID, model interface{}) {
-	// log.Printf("read model: saving %#v", model)
+	r.mu.Lock()
+	defer r.mu.Unlock()
 	r.data[id] = model
 }
 
 // Find returns one read model with using an id. Returns
 // ErrModelNotFound if no model could be found.
 func (r *MemoryRepository) Find(id UUID) (interface{}, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 	if model, ok := r.data[id]; ok {
-		// log.Printf("read model: found %#v", model)
 		return model, nil
 	}
 
 	return nil, ErrModelNotFound
 }
 
-// FindAll returns all read models in the repository.
+// FindAll returns all read models in the repository, ordered by id.
 func (r *MemoryRepository) FindAll() ([]interface{}, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	ids := make([]UUID, 0, len(r.data))
+	for id := range r.data {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
 	models := []interface{}{}
-	for _, model := range r.data {
-		models = append(models, model)
+	for _, id := range ids {
+		models = append(models, r.data[id])
 	}
 	return models, nil
 }
@@ -78,9 +92,10 @@ func (r *MemoryRepository) FindAll() ([]interface{}, error) {
 // Remove removes a read model with id from the repository. Returns
 // ErrModelNotFound if no model could be found.
 func (r *MemoryRepository) Remove(id UUID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 	if _, ok := r.data[id]; ok {
 		delete(r.data, id)
-		// log.Printf("read model: removed %#v", model)
 		return nil
 	}
 
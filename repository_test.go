@@ -17,7 +17,7 @@ package eventhorizon
 import (
 	. "gopkg.in/check.v1"
 
-	t "github.com/looplab/eventhorizon/testing"
+	t "github.com/looplab/eventhorizon/internal/checkers"
 )
 
 type MemoryRepositorySuite struct{}
@@ -88,6 +88,15 @@ func (s *MemoryRepositorySuite) TestFindAll(c *C) {
 	c.Assert(result, t.Contains, 42)
 	c.Assert(result, t.Contains, 43)
 
+	// Find ordered by id.
+	repo = NewMemoryRepository()
+	repo.data[UUID("b")] = 42
+	repo.data[UUID("c")] = 43
+	repo.data[UUID("a")] = 44
+	result, err = repo.FindAll()
+	c.Assert(err, Equals, nil)
+	c.Assert(result, DeepEquals, []interface{}{44, 42, 43})
+
 	// Find none.
 	repo = NewMemoryRepository()
 	result, err = repo.FindAll()
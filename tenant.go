@@ -0,0 +1,490 @@
+// Copyright (c) 2014 - Max Persson <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventhorizon
+
+import (
+	"context"
+	"errors"
+	"iter"
+	"sync"
+)
+
+// Error returned when a command or event has no tenant.
+var ErrNoTenant = errors.New("no tenant")
+
+// Error returned when a tenant accesses an aggregate or model of another
+// tenant.
+var ErrCrossTenant = errors.New("cross-tenant access")
+
+// Error returned when an event store can not load all its events.
+var ErrLoadAllNotSupported = errors.New("event store can not load all events")
+
+// MetadataTenant is the metadata key of the tenant of a command or event. The
+// dispatchers add the tenant of a command to the metadata of the resulting
+// events.
+const MetadataTenant = "tenant"
+
+// TenantOf returns the tenant in the metadata of a command or event, or an
+// empty string.
+func TenantOf(value interface{}) string {
+	return MetadataOf(value)[MetadataTenant]
+}
+
+// withTenant adds the tenant to the metadata of the events.
+func withTenant(events []Event, tenant string) []Event {
+	if tenant == "" {
+		return events
+	}
+
+	for i, event := range events {
+		events[i] = WithMetadata(event, MetadataTenant, tenant)
+	}
+	return events
+}
+
+// TenantDispatcher dispatches commands with a separate dispatcher for the
+// tenant of each command. The dispatcher of a tenant is created by a factory
+// when the tenant dispatches its first command, typically with the event store,
+// event bus and repositories for the tenant.
+//
+// Commands dispatched with a context carrying a Principal, for example by a
+// CommandGateway behind an authenticating middleware, belong to the tenant of
+// the principal. The tenant is added to the metadata of such commands, and
+// commands with the tenant of another principal in their metadata are
+// rejected, so that callers can not choose their tenant.
+type TenantDispatcher struct {
+	dispatchers *tenantValues[Dispatcher]
+}
+
+// NewTenantDispatcher creates a TenantDispatcher.
+func NewTenantDispatcher(factory func(tenant string) Dispatcher) *TenantDispatcher {
+	d := &TenantDispatcher{
+		dispatchers: newTenantValues(func(tenant string) (Dispatcher, error) {
+			return factory(tenant), nil
+		}),
+	}
+	return d
+}
+
+// Dispatch dispatches a command with the dispatcher of its tenant.
+// Returns ErrNoTenant if the command has no tenant.
+func (d *TenantDispatcher) Dispatch(command Command) error {
+	return d.DispatchContext(context.Background(), command)
+}
+
+// DispatchContext dispatches a command with a context with the dispatcher of
+// its tenant, which is the tenant of the principal of the context if there is
+// one. The context is passed on if the dispatcher is a ContextDispatcher.
+// Returns ErrNoTenant if the command or principal has no tenant, and
+// ErrCrossTenant if the command has another tenant than the principal.
+func (d *TenantDispatcher) DispatchContext(ctx context.Context, command Command) error {
+	tenant := TenantOf(command)
+	if principal, ok := PrincipalFrom(ctx); ok {
+		if principal.Tenant == "" {
+			return ErrNoTenant
+		} else if tenant != "" && tenant != principal.Tenant {
+			return ErrCrossTenant
+		}
+		// Commands without metadata can not carry the tenant.
+		command = WithMetadata(command, MetadataTenant, principal.Tenant)
+		tenant = TenantOf(command)
+	}
+	if tenant == "" {
+		return ErrNoTenant
+	}
+
+	dispatcher := d.dispatchers.get(tenant)
+	if cd, ok := dispatcher.(ContextDispatcher); ok {
+		return cd.DispatchContext(ctx, command)
+	}
+	return dispatcher.Dispatch(command)
+}
+
+// MultiTenantEventStore partitions events into a separate event store for each
+// tenant, created by a factory. Each aggregate belongs to the first tenant that
+// appends events for it, and the store of a tenant returns ErrCrossTenant for
+// aggregates of other tenants.
+//
+// The owners of aggregates are kept in memory, and indexed from the store of a
+// tenant when it is created if it is a GlobalEventStore. A store that fails to
+// be indexed is not used, and the error is returned by every use of it until it
+// is indexed. Stores that keep their events elsewhere than in memory should be
+// opened with OpenTenants on startup, so that the aggregates of all tenants
+// are owned before any command is dispatched.
+type MultiTenantEventStore struct {
+	stores *tenantValues[EventStore]
+	owners map[UUID]string
+	mu     sync.Mutex
+}
+
+// NewMultiTenantEventStore creates a MultiTenantEventStore.
+func NewMultiTenantEventStore(factory func(tenant string) EventStore) *MultiTenantEventStore {
+	s := &MultiTenantEventStore{
+		owners: make(map[UUID]string),
+	}
+	s.stores = newTenantValues(func(tenant string) (EventStore, error) {
+		store := factory(tenant)
+		if err := s.index(tenant, store); err != nil {
+			return nil, err
+		}
+		return store, nil
+	})
+	return s
+}
+
+// OpenTenants creates the event stores of the tenants and indexes the owners
+// of their aggregates. Returns ErrLoadAllNotSupported if the store of a tenant
+// is not a GlobalEventStore, or the error of loading its events.
+func (s *MultiTenantEventStore) OpenTenants(tenants ...string) error {
+	for _, tenant := range tenants {
+		store, err := s.stores.open(tenant)
+		if err != nil {
+			return err
+		}
+		if _, ok := store.(GlobalEventStore); !ok {
+			return ErrLoadAllNotSupported
+		}
+	}
+	return nil
+}
+
+// ForTenant returns the event store of a tenant.
+func (s *MultiTenantEventStore) ForTenant(tenant string) EventStore {
+	return &tenantEventStore{s, tenant}
+}
+
+// Tenants returns the tenants with an event store, in order.
+func (s *MultiTenantEventStore) Tenants() []string {
+	return s.stores.list()
+}
+
+// Export loads all events of a tenant in the order they were appended.
+// Returns ErrLoadAllNotSupported if the store of the tenant is not a
+// GlobalEventStore.
+func (s *MultiTenantEventStore) Export(tenant string) ([]Event, error) {
+	store, err := s.stores.open(tenant)
+	if err != nil {
+		return nil, err
+	}
+	global, ok := store.(GlobalEventStore)
+	if !ok {
+		return nil, ErrLoadAllNotSupported
+	}
+	return global.LoadAll()
+}
+
+// DeleteTenant removes the event store of a tenant and the ownership of its
+// aggregates. Stores that keep their events elsewhere than in memory should
+// be deleted by the caller.
+func (s *MultiTenantEventStore) DeleteTenant(tenant string) {
+	s.stores.remove(tenant)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, owner := range s.owners {
+		if owner == tenant {
+			delete(s.owners, id)
+		}
+	}
+}
+
+// index makes the tenant the owner of the aggregates in its store, if the store
+// is a GlobalEventStore.
+func (s *MultiTenantEventStore) index(tenant string, store EventStore) error {
+	if _, ok := store.(GlobalEventStore); !ok {
+		return nil
+	}
+	for event, err := range streamAllEvents(store) {
+		if err != nil {
+			return err
+		}
+		s.mu.Lock()
+		s.owners[event.AggregateID()] = tenant
+		s.mu.Unlock()
+	}
+	return nil
+}
+
+// claim makes the tenant the owner of the aggregates without an owner.
+// Returns ErrCrossTenant if another tenant owns any of the aggregates.
+func (s *MultiTenantEventStore) claim(tenant string, events []Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, event := range events {
+		if owner, ok := s.owners[event.AggregateID()]; ok && owner != tenant {
+			return ErrCrossTenant
+		}
+	}
+	for _, event := range events {
+		s.owners[event.AggregateID()] = tenant
+	}
+	return nil
+}
+
+func (s *MultiTenantEventStore) check(tenant string, id UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if owner, ok := s.owners[id]; ok && owner != tenant {
+		return ErrCrossTenant
+	}
+	return nil
+}
+
+type tenantEventStore struct {
+	store  *MultiTenantEventStore
+	tenant string
+}
+
+func (s *tenantEventStore) Append(events []Event) error {
+	for _, event := range events {
+		if tenant := TenantOf(event); tenant != "" && tenant != s.tenant {
+			return ErrCrossTenant
+		}
+	}
+	store, err := s.store.stores.open(s.tenant)
+	if err != nil {
+		return err
+	}
+	if err := s.store.claim(s.tenant, events); err != nil {
+		return err
+	}
+	return store.Append(events)
+}
+
+func (s *tenantEventStore) Load(id UUID) ([]Event, error) {
+	store, err := s.store.stores.open(s.tenant)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.store.check(s.tenant, id); err != nil {
+		return nil, err
+	}
+	return store.Load(id)
+}
+
+func (s *tenantEventStore) Stream(id UUID) iter.Seq2[Event, error] {
+	store, err := s.store.stores.open(s.tenant)
+	if err == nil {
+		err = s.store.check(s.tenant, id)
+	}
+	if err != nil {
+		return func(yield func(Event, error) bool) {
+			yield(nil, err)
+		}
+	}
+	return StreamEvents(store, id)
+}
+
+// MultiTenantRepository partitions read models into a separate repository for
+// each tenant, created by a factory. Each model belongs to the first tenant
+// that saves it, and the repository of a tenant returns ErrCrossTenant when
+// finding or removing models of other tenants and ignores saving them.
+type MultiTenantRepository struct {
+	repositories *tenantValues[Repository]
+	owners       map[UUID]string
+	mu           sync.Mutex
+	logger       Logger
+}
+
+// NewMultiTenantRepository creates a MultiTenantRepository.
+func NewMultiTenantRepository(factory func(tenant string) Repository) *MultiTenantRepository {
+	r := &MultiTenantRepository{
+		repositories: newTenantValues(func(tenant string) (Repository, error) {
+			return factory(tenant), nil
+		}),
+		owners: make(map[UUID]string),
+		logger: defaultLogger(),
+	}
+	return r
+}
+
+// SetLogger sets the logger used to log ignored cross-tenant saves.
+func (r *MultiTenantRepository) SetLogger(logger Logger) {
+	r.logger = logger
+}
+
+// ForTenant returns the repository of a tenant.
+func (r *MultiTenantRepository) ForTenant(tenant string) Repository {
+	return &tenantRepository{r, tenant}
+}
+
+// Tenants returns the tenants with a repository, in order.
+func (r *MultiTenantRepository) Tenants() []string {
+	return r.repositories.list()
+}
+
+// Export returns all read models of a tenant.
+func (r *MultiTenantRepository) Export(tenant string) ([]interface{}, error) {
+	return r.repositories.get(tenant).FindAll()
+}
+
+// DeleteTenant removes the repository of a tenant and the ownership of its
+// models.
+func (r *MultiTenantRepository) DeleteTenant(tenant string) {
+	r.repositories.remove(tenant)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for id, owner := range r.owners {
+		if owner == tenant {
+			delete(r.owners, id)
+		}
+	}
+}
+
+func (r *MultiTenantRepository) check(tenant string, id UUID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if owner, ok := r.owners[id]; ok && owner != tenant {
+		return ErrCrossTenant
+	}
+	return nil
+}
+
+type tenantRepository struct {
+	repository *MultiTenantRepository
+	tenant     string
+}
+
+func (r *tenantRepository) Save(id UUID, model interface{}) {
+	r.repository.mu.Lock()
+	if owner, ok := r.repository.owners[id]; ok && owner != r.tenant {
+		r.repository.mu.Unlock()
+		r.repository.logger.Warn("ignored saving model of other tenant",
+			LogKeyAggregateID, id.String(),
+		)
+		return
+	}
+	r.repository.owners[id] = r.tenant
+	r.repository.mu.Unlock()
+
+	r.repository.repositories.get(r.tenant).Save(id, model)
+}
+
+func (r *tenantRepository) Find(id UUID) (interface{}, error) {
+	if err := r.repository.check(r.tenant, id); err != nil {
+		return nil, err
+	}
+	return r.repository.repositories.get(r.tenant).Find(id)
+}
+
+func (r *tenantRepository) FindAll() ([]interface{}, error) {
+	return r.repository.repositories.get(r.tenant).FindAll()
+}
+
+func (r *tenantRepository) Remove(id UUID) error {
+	if err := r.repository.check(r.tenant, id); err != nil {
+		return err
+	}
+	return r.repository.repositories.get(r.tenant).Remove(id)
+}
+
+// MultiTenantEventBus publishes events on a separate event bus for the tenant
+// of each event, created by a factory. Events without a tenant are dropped.
+type MultiTenantEventBus struct {
+	buses  *tenantValues[EventBus]
+	logger Logger
+}
+
+// NewMultiTenantEventBus creates a MultiTenantEventBus.
+func NewMultiTenantEventBus(factory func(tenant string) EventBus) *MultiTenantEventBus {
+	b := &MultiTenantEventBus{
+		buses: newTenantValues(func(tenant string) (EventBus, error) {
+			return factory(tenant), nil
+		}),
+		logger: defaultLogger(),
+	}
+	return b
+}
+
+// SetLogger sets the logger used to log dropped events.
+func (b *MultiTenantEventBus) SetLogger(logger Logger) {
+	b.logger = logger
+}
+
+// ForTenant returns the event bus of a tenant.
+func (b *MultiTenantEventBus) ForTenant(tenant string) EventBus {
+	return b.buses.get(tenant)
+}
+
+// Tenants returns the tenants with an event bus, in order.
+func (b *MultiTenantEventBus) Tenants() []string {
+	return b.buses.list()
+}
+
+// DeleteTenant removes the event bus of a tenant.
+func (b *MultiTenantEventBus) DeleteTenant(tenant string) {
+	b.buses.remove(tenant)
+}
+
+// PublishEvent publishes an event on the bus of its tenant.
+func (b *MultiTenantEventBus) PublishEvent(event Event) {
+	tenant := TenantOf(event)
+	if tenant == "" {
+		b.logger.Warn("dropped event without tenant",
+			LogKeyAggregateID, event.AggregateID().String(),
+		)
+		return
+	}
+	b.buses.get(tenant).PublishEvent(event)
+}
+
+// tenantValues holds a value for each tenant, created by a factory on first
+// use. A value that fails to be created is created again on the next use.
+type tenantValues[T any] struct {
+	factory func(string) (T, error)
+	values  map[string]T
+	mu      sync.Mutex
+}
+
+func newTenantValues[T any](factory func(string) (T, error)) *tenantValues[T] {
+	return &tenantValues[T]{
+		factory: factory,
+		values:  make(map[string]T),
+	}
+}
+
+// open returns the value of a tenant, or the error of creating it.
+func (t *tenantValues[T]) open(tenant string) (T, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	value, ok := t.values[tenant]
+	if !ok {
+		var err error
+		if value, err = t.factory(tenant); err != nil {
+			return value, err
+		}
+		t.values[tenant] = value
+	}
+	return value, nil
+}
+
+// get returns the value of a tenant, for factories that can not fail.
+func (t *tenantValues[T]) get(tenant string) T {
+	value, _ := t.open(tenant)
+	return value
+}
+
+func (t *tenantValues[T]) list() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return sortedKeys(t.values)
+}
+
+func (t *tenantValues[T]) remove(tenant string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.values, tenant)
+}
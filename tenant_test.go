@@ -0,0 +1,274 @@
+// Copyright (c) 2014 - Max Persson <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventhorizon
+
+import (
+	"context"
+	"errors"
+	"iter"
+
+	. "gopkg.in/check.v1"
+)
+
+var _ = Suite(&TenantSuite{})
+
+type TenantSuite struct {
+	stores map[string]*MemoryEventStore
+	store  *MultiTenantEventStore
+	buses  map[string]*MockEventBus
+	bus    *MultiTenantEventBus
+	repo   *MultiTenantRepository
+}
+
+func (s *TenantSuite) SetUpTest(c *C) {
+	s.stores = make(map[string]*MemoryEventStore)
+	s.store = NewMultiTenantEventStore(func(tenant string) EventStore {
+		store := NewMemoryEventStore()
+		s.stores[tenant] = store
+		return store
+	})
+	s.buses = make(map[string]*MockEventBus)
+	s.bus = NewMultiTenantEventBus(func(tenant string) EventBus {
+		bus := &MockEventBus{}
+		s.buses[tenant] = bus
+		return bus
+	})
+	s.repo = NewMultiTenantRepository(func(tenant string) Repository {
+		return NewMemoryRepository()
+	})
+}
+
+func tenantCommand(tenant string, id UUID, content string) TestTracedCommand {
+	return TestTracedCommand{Metadata{MetadataTenant: tenant}, id, content}
+}
+
+func (s *TenantSuite) Test_TenantOf(c *C) {
+	c.Assert(TenantOf(tenantCommand("a", NewUUID(), "command1")), Equals, "a")
+	c.Assert(TenantOf(TestTracedCommand{}), Equals, "")
+	c.Assert(TenantOf(TestCommand{}), Equals, "")
+}
+
+func (s *TenantSuite) Test_TenantDispatcher(c *C) {
+	disp := NewTenantDispatcher(func(tenant string) Dispatcher {
+		d := NewDelegateDispatcher(s.store.ForTenant(tenant), s.bus)
+		d.AddHandler(&TestTracedAggregate{}, TestTracedCommand{})
+		return d
+	})
+
+	id := NewUUID()
+	err := disp.Dispatch(tenantCommand("a", id, "command1"))
+	c.Assert(err, Equals, nil)
+	events, _ := s.stores["a"].Load(id)
+	c.Assert(len(events), Equals, 1)
+	c.Assert(TenantOf(events[0]), Equals, "a")
+	c.Assert(s.buses["a"].events, DeepEquals, events)
+
+	// Other tenants can not use the aggregate.
+	err = disp.Dispatch(tenantCommand("b", id, "command2"))
+	c.Assert(err, Equals, ErrCrossTenant)
+	c.Assert(s.stores["b"].events, HasLen, 0)
+
+	err = disp.Dispatch(TestTracedCommand{TestID: id, Content: "command3"})
+	c.Assert(err, Equals, ErrNoTenant)
+}
+
+func (s *TenantSuite) Test_TenantDispatcher_Principal(c *C) {
+	disp := NewTenantDispatcher(func(tenant string) Dispatcher {
+		d := NewDelegateDispatcher(s.store.ForTenant(tenant), s.bus)
+		d.AddHandler(&TestTracedAggregate{}, TestTracedCommand{})
+		return d
+	})
+	ctx := WithPrincipal(context.Background(), Principal{ID: "user1", Tenant: "a"})
+
+	// The tenant of the principal is added to the command.
+	id := NewUUID()
+	err := disp.DispatchContext(ctx, TestTracedCommand{TestID: id, Content: "command1"})
+	c.Assert(err, Equals, nil)
+	events, _ := s.stores["a"].Load(id)
+	c.Assert(len(events), Equals, 1)
+	c.Assert(TenantOf(events[0]), Equals, "a")
+
+	// The principal can not choose another tenant.
+	err = disp.DispatchContext(ctx, tenantCommand("b", NewUUID(), "command2"))
+	c.Assert(err, Equals, ErrCrossTenant)
+	c.Assert(s.stores["b"], IsNil)
+
+	err = disp.DispatchContext(ctx, TestCommand{NewUUID(), "command3"})
+	c.Assert(err, Equals, ErrNoTenant)
+	ctx = WithPrincipal(context.Background(), Principal{ID: "user2"})
+	err = disp.DispatchContext(ctx, tenantCommand("a", id, "command4"))
+	c.Assert(err, Equals, ErrNoTenant)
+}
+
+func (s *TenantSuite) Test_EventStore(c *C) {
+	event1 := TestEvent{NewUUID(), "event1"}
+	err := s.store.ForTenant("a").Append([]Event{event1})
+	c.Assert(err, Equals, nil)
+	events, err := s.store.ForTenant("a").Load(event1.TestID)
+	c.Assert(err, Equals, nil)
+	c.Assert(events, DeepEquals, []Event{event1})
+
+	_, err = s.store.ForTenant("b").Load(event1.TestID)
+	c.Assert(err, Equals, ErrCrossTenant)
+	err = s.store.ForTenant("b").Append([]Event{TestEvent{event1.TestID, "event2"}})
+	c.Assert(err, Equals, ErrCrossTenant)
+
+	event3 := TestTracedEvent{Metadata{MetadataTenant: "a"}, NewUUID(), "event3"}
+	err = s.store.ForTenant("b").Append([]Event{event3})
+	c.Assert(err, Equals, ErrCrossTenant)
+}
+
+func (s *TenantSuite) Test_EventStore_IndexOwners(c *C) {
+	event1 := TestEvent{NewUUID(), "event1"}
+	existing := NewMemoryEventStore()
+	existing.Append([]Event{event1})
+	store := NewMultiTenantEventStore(func(tenant string) EventStore {
+		if tenant == "a" {
+			return existing
+		}
+		return NewMemoryEventStore()
+	})
+
+	store.ForTenant("a").Load(NewUUID())
+	_, err := store.ForTenant("b").Load(event1.TestID)
+	c.Assert(err, Equals, ErrCrossTenant)
+}
+
+func (s *TenantSuite) Test_EventStore_OpenTenants(c *C) {
+	// The stores of both tenants exist before a restart.
+	event1 := TestEvent{NewUUID(), "event1"}
+	existing := map[string]*MemoryEventStore{"a": NewMemoryEventStore(), "b": NewMemoryEventStore()}
+	existing["a"].Append([]Event{event1})
+	store := NewMultiTenantEventStore(func(tenant string) EventStore {
+		return existing[tenant]
+	})
+
+	c.Assert(store.OpenTenants("a", "b"), Equals, nil)
+	err := store.ForTenant("b").Append([]Event{TestEvent{event1.TestID, "event2"}})
+	c.Assert(err, Equals, ErrCrossTenant)
+
+	store = NewMultiTenantEventStore(func(tenant string) EventStore {
+		return &MockEventStore{}
+	})
+	c.Assert(store.OpenTenants("a"), Equals, ErrLoadAllNotSupported)
+}
+
+// IndexErrorEventStore is a MemoryEventStore that fails to stream all events.
+type IndexErrorEventStore struct {
+	*MemoryEventStore
+	err error
+}
+
+func (s *IndexErrorEventStore) LoadAll() ([]Event, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	return s.MemoryEventStore.LoadAll()
+}
+
+func (s *IndexErrorEventStore) StreamAll() iter.Seq2[Event, error] {
+	if s.err != nil {
+		return func(yield func(Event, error) bool) {
+			yield(nil, s.err)
+		}
+	}
+	return s.MemoryEventStore.StreamAll()
+}
+
+func (s *TenantSuite) Test_EventStore_IndexError(c *C) {
+	event1 := TestEvent{NewUUID(), "event1"}
+	existing := &IndexErrorEventStore{NewMemoryEventStore(), errors.New("index error")}
+	existing.MemoryEventStore.Append([]Event{event1})
+	store := NewMultiTenantEventStore(func(tenant string) EventStore {
+		if tenant == "a" {
+			return existing
+		}
+		return NewMemoryEventStore()
+	})
+
+	// The store of a tenant that could not be indexed is not used, so that
+	// its aggregates can not be claimed by other tenants.
+	c.Assert(store.OpenTenants("a"), ErrorMatches, "index error")
+	err := store.ForTenant("a").Append([]Event{TestEvent{NewUUID(), "event2"}})
+	c.Assert(err, ErrorMatches, "index error")
+	_, err = store.ForTenant("a").Load(event1.TestID)
+	c.Assert(err, ErrorMatches, "index error")
+	c.Assert(store.Tenants(), DeepEquals, []string{})
+
+	existing.err = nil
+	c.Assert(store.OpenTenants("a"), Equals, nil)
+	err = store.ForTenant("b").Append([]Event{TestEvent{event1.TestID, "event3"}})
+	c.Assert(err, Equals, ErrCrossTenant)
+}
+
+func (s *TenantSuite) Test_EventStore_Tenants(c *C) {
+	event1 := TestEvent{NewUUID(), "event1"}
+	event2 := TestEvent{NewUUID(), "event2"}
+	s.store.ForTenant("b").Append([]Event{event1})
+	s.store.ForTenant("a").Append([]Event{event2})
+	c.Assert(s.store.Tenants(), DeepEquals, []string{"a", "b"})
+
+	events, err := s.store.Export("b")
+	c.Assert(err, Equals, nil)
+	c.Assert(events, DeepEquals, []Event{event1})
+
+	s.store.DeleteTenant("b")
+	c.Assert(s.store.Tenants(), DeepEquals, []string{"a"})
+	err = s.store.ForTenant("a").Append([]Event{TestEvent{event1.TestID, "event3"}})
+	c.Assert(err, Equals, nil)
+
+	store := NewMultiTenantEventStore(func(tenant string) EventStore {
+		return &MockEventStore{}
+	})
+	_, err = store.Export("a")
+	c.Assert(err, Equals, ErrLoadAllNotSupported)
+}
+
+func (s *TenantSuite) Test_Repository(c *C) {
+	model1 := &TestModel{NewUUID(), "model1"}
+	s.repo.ForTenant("a").Save(model1.ID, model1)
+	model, err := s.repo.ForTenant("a").Find(model1.ID)
+	c.Assert(err, Equals, nil)
+	c.Assert(model, Equals, model1)
+
+	_, err = s.repo.ForTenant("b").Find(model1.ID)
+	c.Assert(err, Equals, ErrCrossTenant)
+	err = s.repo.ForTenant("b").Remove(model1.ID)
+	c.Assert(err, Equals, ErrCrossTenant)
+	s.repo.ForTenant("b").Save(model1.ID, &TestModel{model1.ID, "model2"})
+	models, _ := s.repo.ForTenant("b").FindAll()
+	c.Assert(models, HasLen, 0)
+
+	c.Assert(s.repo.Tenants(), DeepEquals, []string{"a", "b"})
+	models, err = s.repo.Export("a")
+	c.Assert(err, Equals, nil)
+	c.Assert(models, DeepEquals, []interface{}{model1})
+	s.repo.DeleteTenant("a")
+	_, err = s.repo.ForTenant("b").Find(model1.ID)
+	c.Assert(err, Equals, ErrModelNotFound)
+}
+
+func (s *TenantSuite) Test_EventBus(c *C) {
+	logger := &MockLogger{}
+	s.bus.SetLogger(logger)
+	event1 := TestTracedEvent{Metadata{MetadataTenant: "a"}, NewUUID(), "event1"}
+	s.bus.PublishEvent(event1)
+	c.Assert(s.buses["a"].events, DeepEquals, []Event{event1})
+	c.Assert(s.bus.ForTenant("a"), Equals, s.buses["a"])
+
+	s.bus.PublishEvent(TestEvent{NewUUID(), "event2"})
+	c.Assert(s.bus.Tenants(), DeepEquals, []string{"a"})
+	c.Assert(logger.records[0].msg, Equals, "dropped event without tenant")
+}
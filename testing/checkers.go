@@ -12,75 +12,158 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
+// Package testing contains fixtures and gocheck checkers for testing
+// aggregates and other parts of applications built with eventhorizon.
 package testing
 
 import (
+	"fmt"
 	"reflect"
+	"sort"
 
 	"gopkg.in/check.v1"
+
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/internal/checkers"
 )
 
 // Contains is a checker for gocheck that asserts if an item is in a slice.
-var Contains = &contains{}
+var Contains = checkers.Contains
 
 // HasKey is a checker for gocheck that asserts if a map has a key.
-var HasKey = &hasKey{}
+var HasKey = checkers.HasKey
+
+// EventsEqual is a checker for gocheck that asserts that two slices of
+// events are equal, showing a diff of the events if not. Metadata added by event
+// stores and dispatchers, like the sequence and timestamp, is ignored.
+var EventsEqual = &eventsEqual{}
+
+// ContainsEventOfType is a checker for gocheck that asserts that a slice of
+// events has an event of the same type as an example event.
+var ContainsEventOfType = &containsEventOfType{}
+
+// EventMatches is a checker for gocheck that asserts that the fields of an
+// event match Fields.
+var EventMatches = &eventMatches{}
 
-type contains struct{}
+// Fields maps the names of event fields to the values expected by
+// EventMatches. Values of type func(interface{}) bool are called as
+// predicates with the field value, other values must be deeply equal.
+type Fields map[string]interface{}
 
-func (c *contains) Check(params []interface{}, names []string) (bool, string) {
+// NotZero is a field predicate that matches non-zero values.
+func NotZero(value interface{}) bool {
+	return value != nil && !reflect.ValueOf(value).IsZero()
+}
+
+type eventsEqual struct{}
+
+func (e *eventsEqual) Check(params []interface{}, names []string) (bool, string) {
 	if len(params) != 2 {
-		return false, "Contains takes 2 arguments: a slice and an item"
+		return false, "EventsEqual takes 2 arguments: obtained and expected events"
 	}
 
-	slice, ok := params[0].([]interface{})
+	obtained, ok := params[0].([]eh.Event)
 	if !ok {
-		return false, "first parameter is not a []interface{}"
+		return false, "obtained value is not a []Event"
 	}
-	value, ok := params[1].(interface{})
+	expected, ok := params[1].([]eh.Event)
 	if !ok {
-		return false, "second parameter is not an interface"
+		return false, "expected value is not a []Event"
 	}
 
-	for _, v := range slice {
-		if v == value {
+	if diff := DiffEvents(expected, obtained); diff != "" {
+		return false, "events differ (- expected, + obtained):\n" + diff
+	}
+	return true, ""
+}
+
+func (e *eventsEqual) Info() *check.CheckerInfo {
+	return &check.CheckerInfo{
+		Name:   "EventsEqual",
+		Params: []string{"obtained", "expected"},
+	}
+}
+
+type containsEventOfType struct{}
+
+func (c *containsEventOfType) Check(params []interface{}, names []string) (bool, string) {
+	if len(params) != 2 {
+		return false, "ContainsEventOfType takes 2 arguments: events and an event"
+	}
+
+	events, ok := params[0].([]eh.Event)
+	if !ok {
+		return false, "first parameter is not a []Event"
+	}
+	if params[1] == nil {
+		return false, "second parameter is nil"
+	}
+
+	eventType := reflect.TypeOf(params[1])
+	for _, event := range events {
+		if reflect.TypeOf(event) == eventType {
 			return true, ""
 		}
 	}
-
 	return false, ""
 }
 
-func (c *contains) Info() *check.CheckerInfo {
+func (c *containsEventOfType) Info() *check.CheckerInfo {
 	return &check.CheckerInfo{
-		Name:   "Contains",
-		Params: []string{"slice", "item"},
+		Name:   "ContainsEventOfType",
+		Params: []string{"events", "event"},
 	}
 }
 
-type hasKey struct{}
+type eventMatches struct{}
 
-func (h *hasKey) Check(params []interface{}, names []string) (bool, string) {
+func (e *eventMatches) Check(params []interface{}, names []string) (bool, string) {
 	if len(params) != 2 {
-		return false, "HasKey takes 2 arguments: a map and a key"
+		return false, "EventMatches takes 2 arguments: an event and fields"
 	}
 
-	mapValue := reflect.ValueOf(params[0])
-	if mapValue.Kind() != reflect.Map {
-		return false, "first argument to HasKey must be a map"
+	fields, ok := params[1].(Fields)
+	if !ok {
+		return false, "second parameter is not Fields"
+	}
+	v := reflect.Indirect(reflect.ValueOf(params[0]))
+	if v.Kind() != reflect.Struct {
+		return false, "first parameter is not a struct event"
 	}
 
-	keyValue := reflect.ValueOf(params[1])
-	if !keyValue.Type().AssignableTo(mapValue.Type().Key()) {
-		return false, "second argument must be assignable to the map key type"
+	names = make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
 	}
+	sort.Strings(names)
 
-	return mapValue.MapIndex(keyValue).IsValid(), ""
+	for _, name := range names {
+		field := v.FieldByName(name)
+		if !field.IsValid() {
+			return false, fmt.Sprintf("event has no field %s", name)
+		}
+		if !field.CanInterface() {
+			return false, fmt.Sprintf("field %s is not exported", name)
+		}
+		value := field.Interface()
+		switch expected := fields[name].(type) {
+		case func(interface{}) bool:
+			if !expected(value) {
+				return false, fmt.Sprintf("field %s does not match: %v", name, value)
+			}
+		default:
+			if !reflect.DeepEqual(value, expected) {
+				return false, fmt.Sprintf("field %s is %v, expected %v", name, value, expected)
+			}
+		}
+	}
+	return true, ""
 }
 
-func (h *hasKey) Info() *check.CheckerInfo {
+func (e *eventMatches) Info() *check.CheckerInfo {
 	return &check.CheckerInfo{
-		Name:   "HasKey",
-		Params: []string{"map", "key"},
+		Name:   "EventMatches",
+		Params: []string{"event", "fields"},
 	}
 }
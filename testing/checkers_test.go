@@ -0,0 +1,93 @@
+// Copyright (c) 2014 - Max Persson <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package testing
+
+import (
+	. "gopkg.in/check.v1"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+var _ = Suite(&CheckersSuite{})
+
+type CheckersSuite struct{}
+
+func (s *CheckersSuite) Test_EventsEqual(c *C) {
+	id := eh.NewUUID()
+	events := []eh.Event{DoorOpened{id}, DoorLocked{id, "1234"}}
+	c.Assert(events, EventsEqual, []eh.Event{DoorOpened{id}, DoorLocked{id, "1234"}})
+	c.Assert([]eh.Event{}, EventsEqual, []eh.Event(nil))
+
+	ok, msg := EventsEqual.Check([]interface{}{events, []eh.Event{DoorOpened{id}}}, nil)
+	c.Assert(ok, Equals, false)
+	c.Assert(msg, Equals, "events differ (- expected, + obtained):\n"+
+		"  testing.DoorOpened{ID:"+id.String()+"}\n"+
+		"+ testing.DoorLocked{ID:"+id.String()+" Code:1234}\n")
+
+	ok, msg = EventsEqual.Check([]interface{}{DoorOpened{id}, events}, nil)
+	c.Assert(ok, Equals, false)
+	c.Assert(msg, Equals, "obtained value is not a []Event")
+}
+
+func (s *CheckersSuite) Test_EventsEqual_Metadata(c *C) {
+	id := eh.NewUUID()
+	store := eh.NewMemoryEventStore()
+	event := eh.WithMetadata(DoorTagged{eh.Metadata{"tag": "front"}, id}, eh.MetadataTimestamp, "2024-01-01T00:00:00Z")
+	c.Assert(store.Append([]eh.Event{event, &DoorTagged{ID: id}}), IsNil)
+	events, err := store.Load(id)
+	c.Assert(err, IsNil)
+	c.Assert(eh.MetadataOf(events[0]), HasKey, eh.MetadataSequence)
+	c.Assert(events, EventsEqual, []eh.Event{DoorTagged{eh.Metadata{"tag": "front"}, id}, &DoorTagged{ID: id}})
+	c.Assert(events, Not(EventsEqual), []eh.Event{DoorTagged{eh.Metadata{"tag": "back"}, id}, &DoorTagged{ID: id}})
+}
+
+func (s *CheckersSuite) Test_ContainsEventOfType(c *C) {
+	id := eh.NewUUID()
+	events := []eh.Event{DoorOpened{id}, DoorLocked{id, "1234"}}
+	c.Assert(events, ContainsEventOfType, DoorLocked{})
+	c.Assert(events, Not(ContainsEventOfType), &DoorLocked{})
+	c.Assert([]eh.Event{DoorOpened{id}}, Not(ContainsEventOfType), DoorLocked{})
+}
+
+func (s *CheckersSuite) Test_EventMatches(c *C) {
+	id := eh.NewUUID()
+	event := DoorLocked{id, "1234"}
+	c.Assert(event, EventMatches, Fields{"ID": id, "Code": "1234"})
+	c.Assert(&event, EventMatches, Fields{"ID": NotZero})
+	c.Assert(event, EventMatches, Fields{"Code": func(v interface{}) bool {
+		return len(v.(string)) == 4
+	}})
+
+	ok, msg := EventMatches.Check([]interface{}{event, Fields{"Code": "4321"}}, nil)
+	c.Assert(ok, Equals, false)
+	c.Assert(msg, Equals, "field Code is 1234, expected 4321")
+	ok, msg = EventMatches.Check([]interface{}{DoorLocked{}, Fields{"ID": NotZero}}, nil)
+	c.Assert(ok, Equals, false)
+	c.Assert(msg, Equals, "field ID does not match: ")
+	ok, msg = EventMatches.Check([]interface{}{event, Fields{"Missing": 1}}, nil)
+	c.Assert(ok, Equals, false)
+	c.Assert(msg, Equals, "event has no field Missing")
+}
+
+func (s *CheckersSuite) Test_RecordingEventBus(c *C) {
+	id := eh.NewUUID()
+	bus := NewRecordingEventBus()
+	c.Assert(bus.Events(), HasLen, 0)
+	bus.PublishEvent(DoorOpened{id})
+	bus.PublishEvent(DoorLocked{id, "1234"})
+	c.Assert(bus.Events(), EventsEqual, []eh.Event{DoorOpened{id}, DoorLocked{id, "1234"}})
+	bus.Reset()
+	c.Assert(bus.Events(), HasLen, 0)
+}
@@ -0,0 +1,124 @@
+// Copyright (c) 2014 - Max Persson <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package testing
+
+import (
+	"sync"
+	"time"
+
+	"gopkg.in/check.v1"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+// The conformance suites test that implementations of EventStore, EventBus
+// and Repository follow the contract of the interfaces. Each suite takes a
+// factory and is registered with gocheck in the tests of the implementation,
+// for example:
+//
+//	var _ = Suite(&testing.EventStoreSuite{
+//		NewEventStore: func() eventhorizon.EventStore { return NewMyEventStore() },
+//	})
+//
+// A new value is created for each test, and closed after it if it has a
+// Close method. Implementations that encode events, such as CodecEventStore,
+// must register ConformanceEvent and ConformanceOtherEvent.
+
+// DefaultConformanceVolume is the default number of events or models used by
+// the large volume tests of the conformance suites.
+const DefaultConformanceVolume = 10000
+
+// DefaultConformanceTimeout is the default time that EventBusSuite waits for
+// events to be delivered.
+const DefaultConformanceTimeout = 5 * time.Second
+
+// conformanceWorkers is the number of goroutines of the concurrency tests,
+// each handling conformancePerWorker events or models.
+const (
+	conformanceWorkers   = 8
+	conformancePerWorker = 100
+)
+
+// ConformanceEvent is an event used by the conformance suites.
+type ConformanceEvent struct {
+	ID     eh.UUID
+	Number int
+}
+
+// AggregateID returns the ID of the aggregate of the event.
+func (e ConformanceEvent) AggregateID() eh.UUID { return e.ID }
+
+// ConformanceOtherEvent is a second event type used by the conformance suites.
+type ConformanceOtherEvent struct {
+	ID     eh.UUID
+	Number int
+}
+
+// AggregateID returns the ID of the aggregate of the event.
+func (e ConformanceOtherEvent) AggregateID() eh.UUID { return e.ID }
+
+// ConformanceModel is a read model used by the conformance suites.
+type ConformanceModel struct {
+	ID     eh.UUID
+	Number int
+}
+
+// closeValue closes a value created by a factory if it has a Close method.
+func closeValue(value interface{}) {
+	switch value := value.(type) {
+	case interface{ Close() error }:
+		value.Close()
+	case interface{ Close() }:
+		value.Close()
+	}
+}
+
+// volume returns the volume of a suite, or the default.
+func volume(v int) int {
+	if v > 0 {
+		return v
+	}
+	return DefaultConformanceVolume
+}
+
+// eventRecorder is an EventHandler that records the events it handles.
+type eventRecorder struct {
+	events []eh.Event
+	mu     sync.Mutex
+}
+
+func (r *eventRecorder) HandleEvent(event eh.Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, event)
+}
+
+// wait waits until at least n events has been handled, or fails the test
+// after the timeout.
+func (r *eventRecorder) wait(c *check.C, n int, timeout time.Duration) []eh.Event {
+	deadline := time.Now().Add(timeout)
+	for {
+		r.mu.Lock()
+		events := append([]eh.Event(nil), r.events...)
+		r.mu.Unlock()
+		if len(events) >= n {
+			return events
+		}
+		if time.Now().After(deadline) {
+			c.Fatalf("timeout waiting for %d events, got %d", n, len(events))
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
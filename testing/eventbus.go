@@ -0,0 +1,54 @@
+// Copyright (c) 2014 - Max Persson <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package testing
+
+import (
+	"sync"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+// RecordingEventBus is a fake EventBus that records all published events.
+type RecordingEventBus struct {
+	events []eh.Event
+	mu     sync.Mutex
+}
+
+// NewRecordingEventBus creates a RecordingEventBus.
+func NewRecordingEventBus() *RecordingEventBus {
+	b := &RecordingEventBus{}
+	return b
+}
+
+// PublishEvent records an event.
+func (b *RecordingEventBus) PublishEvent(event eh.Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.events = append(b.events, event)
+}
+
+// Events returns the published events in the order they were published.
+func (b *RecordingEventBus) Events() []eh.Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]eh.Event(nil), b.events...)
+}
+
+// Reset forgets the published events.
+func (b *RecordingEventBus) Reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.events = nil
+}
@@ -0,0 +1,176 @@
+// Copyright (c) 2014 - Max Persson <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package testing
+
+import (
+	"sync"
+	"time"
+
+	"gopkg.in/check.v1"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+// SubscribableEventBus is an EventBus that EventHandlers can subscribe to,
+// like HandlerEventBus.
+type SubscribableEventBus interface {
+	eh.EventBus
+
+	// AddSubscriber adds a subscriber of an event type.
+	AddSubscriber(eh.EventHandler, eh.Event)
+
+	// AddGlobalSubscriber adds a subscriber of all events.
+	AddGlobalSubscriber(eh.EventHandler)
+}
+
+// EventBusSuite is a conformance test suite for EventBus implementations.
+// Events can be delivered asynchronously, but must be delivered in the order
+// they were published by each publisher.
+type EventBusSuite struct {
+	// NewEventBus creates the event bus of each test.
+	NewEventBus func() SubscribableEventBus
+
+	// Volume is the number of events of the large volume test, by default
+	// DefaultConformanceVolume.
+	Volume int
+
+	// Timeout is the time to wait for events to be delivered, by default
+	// DefaultConformanceTimeout.
+	Timeout time.Duration
+
+	bus SubscribableEventBus
+}
+
+// SetUpTest creates the event bus.
+func (s *EventBusSuite) SetUpTest(c *check.C) {
+	s.bus = s.NewEventBus()
+}
+
+// TearDownTest closes the event bus.
+func (s *EventBusSuite) TearDownTest(c *check.C) {
+	closeValue(s.bus)
+}
+
+func (s *EventBusSuite) timeout() time.Duration {
+	if s.Timeout > 0 {
+		return s.Timeout
+	}
+	return DefaultConformanceTimeout
+}
+
+func (s *EventBusSuite) Test_PublishEvent_Subscriber(c *check.C) {
+	subscriber := &eventRecorder{}
+	s.bus.AddSubscriber(subscriber, ConformanceEvent{})
+	id := eh.NewUUID()
+	s.bus.PublishEvent(ConformanceOtherEvent{id, 1})
+	s.bus.PublishEvent(ConformanceEvent{id, 2})
+	s.bus.PublishEvent(ConformanceEvent{id, 3})
+
+	// Events are delivered in order, so other events would have been
+	// delivered before the last one.
+	events := subscriber.wait(c, 2, s.timeout())
+	c.Assert(events, EventsEqual, []eh.Event{ConformanceEvent{id, 2}, ConformanceEvent{id, 3}})
+}
+
+func (s *EventBusSuite) Test_PublishEvent_GlobalSubscriber(c *check.C) {
+	subscriber := &eventRecorder{}
+	s.bus.AddGlobalSubscriber(subscriber)
+	id := eh.NewUUID()
+	published := []eh.Event{
+		ConformanceEvent{id, 1},
+		ConformanceOtherEvent{id, 2},
+		ConformanceEvent{eh.NewUUID(), 3},
+	}
+	for _, event := range published {
+		s.bus.PublishEvent(event)
+	}
+
+	events := subscriber.wait(c, len(published), s.timeout())
+	c.Assert(events, EventsEqual, published)
+}
+
+func (s *EventBusSuite) Test_PublishEvent_MultipleSubscribers(c *check.C) {
+	subscriber1 := &eventRecorder{}
+	subscriber2 := &eventRecorder{}
+	global := &eventRecorder{}
+	s.bus.AddSubscriber(subscriber1, ConformanceEvent{})
+	s.bus.AddSubscriber(subscriber2, ConformanceEvent{})
+	s.bus.AddSubscriber(subscriber2, ConformanceOtherEvent{})
+	s.bus.AddGlobalSubscriber(global)
+	id := eh.NewUUID()
+	s.bus.PublishEvent(ConformanceOtherEvent{id, 1})
+	s.bus.PublishEvent(ConformanceEvent{id, 2})
+
+	c.Assert(subscriber1.wait(c, 1, s.timeout()), EventsEqual, []eh.Event{ConformanceEvent{id, 2}})
+	both := []eh.Event{ConformanceOtherEvent{id, 1}, ConformanceEvent{id, 2}}
+	c.Assert(subscriber2.wait(c, 2, s.timeout()), EventsEqual, both)
+	c.Assert(global.wait(c, 2, s.timeout()), EventsEqual, both)
+}
+
+func (s *EventBusSuite) Test_PublishEvent_NoSubscribers(c *check.C) {
+	id := eh.NewUUID()
+	s.bus.PublishEvent(ConformanceEvent{id, 1})
+
+	subscriber := &eventRecorder{}
+	s.bus.AddGlobalSubscriber(subscriber)
+	s.bus.PublishEvent(ConformanceEvent{id, 2})
+	events := subscriber.wait(c, 1, s.timeout())
+	c.Assert(events[len(events)-1], check.Equals, eh.Event(ConformanceEvent{id, 2}))
+}
+
+func (s *EventBusSuite) Test_Concurrent(c *check.C) {
+	subscriber := &eventRecorder{}
+	s.bus.AddGlobalSubscriber(subscriber)
+	ids := make([]eh.UUID, conformanceWorkers)
+	var wg sync.WaitGroup
+	for w := range ids {
+		ids[w] = eh.NewUUID()
+		wg.Add(1)
+		go func(id eh.UUID) {
+			defer wg.Done()
+			for i := 0; i < conformancePerWorker; i++ {
+				s.bus.PublishEvent(ConformanceEvent{id, i})
+			}
+		}(ids[w])
+	}
+	wg.Wait()
+
+	// The events of each publisher must be delivered in order.
+	events := subscriber.wait(c, conformanceWorkers*conformancePerWorker, s.timeout())
+	c.Assert(events, check.HasLen, conformanceWorkers*conformancePerWorker)
+	next := make(map[eh.UUID]int)
+	for _, event := range events {
+		e := event.(ConformanceEvent)
+		c.Assert(e.Number, check.Equals, next[e.ID])
+		next[e.ID]++
+	}
+	c.Assert(next, check.HasLen, conformanceWorkers)
+}
+
+func (s *EventBusSuite) Test_LargeVolume(c *check.C) {
+	n := volume(s.Volume)
+	subscriber := &eventRecorder{}
+	s.bus.AddSubscriber(subscriber, ConformanceEvent{})
+	id := eh.NewUUID()
+	for i := 0; i < n; i++ {
+		s.bus.PublishEvent(ConformanceEvent{id, i})
+	}
+
+	events := subscriber.wait(c, n, s.timeout())
+	c.Assert(events, check.HasLen, n)
+	for i, event := range events {
+		c.Assert(event, check.Equals, eh.Event(ConformanceEvent{id, i}))
+	}
+}
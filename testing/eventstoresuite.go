@@ -0,0 +1,245 @@
+// Copyright (c) 2014 - Max Persson <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package testing
+
+import (
+	"errors"
+	"sync"
+
+	"gopkg.in/check.v1"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+// EventStoreSuite is a conformance test suite for EventStore implementations.
+type EventStoreSuite struct {
+	// NewEventStore creates the event store of each test.
+	NewEventStore func() eh.EventStore
+
+	// Volume is the number of events of the large volume test, by default
+	// DefaultConformanceVolume.
+	Volume int
+
+	store eh.EventStore
+}
+
+// SetUpTest creates the event store.
+func (s *EventStoreSuite) SetUpTest(c *check.C) {
+	s.store = s.NewEventStore()
+}
+
+// TearDownTest closes the event store.
+func (s *EventStoreSuite) TearDownTest(c *check.C) {
+	closeValue(s.store)
+}
+
+func (s *EventStoreSuite) Test_Load_NotFound(c *check.C) {
+	events, err := s.store.Load(eh.NewUUID())
+	c.Assert(errors.Is(err, eh.ErrNoEventsFound), check.Equals, true, check.Commentf("error: %v", err))
+	c.Assert(events, check.HasLen, 0)
+}
+
+func (s *EventStoreSuite) Test_Append_Empty(c *check.C) {
+	c.Assert(s.store.Append(nil), check.IsNil)
+	c.Assert(s.store.Append([]eh.Event{}), check.IsNil)
+}
+
+func (s *EventStoreSuite) Test_Append_Order(c *check.C) {
+	id1, id2 := eh.NewUUID(), eh.NewUUID()
+	event1 := ConformanceEvent{id1, 1}
+	event2 := ConformanceOtherEvent{id1, 2}
+	event3 := ConformanceEvent{id1, 3}
+	other := ConformanceEvent{id2, 1}
+	c.Assert(s.store.Append([]eh.Event{event1, event2}), check.IsNil)
+	c.Assert(s.store.Append([]eh.Event{other}), check.IsNil)
+	c.Assert(s.store.Append([]eh.Event{event3}), check.IsNil)
+
+	events, err := s.store.Load(id1)
+	c.Assert(err, check.IsNil)
+	c.Assert(events, EventsEqual, []eh.Event{event1, event2, event3})
+	events, err = s.store.Load(id2)
+	c.Assert(err, check.IsNil)
+	c.Assert(events, EventsEqual, []eh.Event{other})
+}
+
+func (s *EventStoreSuite) Test_Append_MixedAggregates(c *check.C) {
+	id1, id2 := eh.NewUUID(), eh.NewUUID()
+	c.Assert(s.store.Append([]eh.Event{
+		ConformanceEvent{id1, 1},
+		ConformanceEvent{id2, 1},
+		ConformanceEvent{id1, 2},
+		ConformanceEvent{id2, 2},
+	}), check.IsNil)
+
+	events, err := s.store.Load(id1)
+	c.Assert(err, check.IsNil)
+	c.Assert(events, EventsEqual, []eh.Event{ConformanceEvent{id1, 1}, ConformanceEvent{id1, 2}})
+	events, err = s.store.Load(id2)
+	c.Assert(err, check.IsNil)
+	c.Assert(events, EventsEqual, []eh.Event{ConformanceEvent{id2, 1}, ConformanceEvent{id2, 2}})
+}
+
+func (s *EventStoreSuite) Test_Load_Isolated(c *check.C) {
+	id := eh.NewUUID()
+	c.Assert(s.store.Append([]eh.Event{ConformanceEvent{id, 1}}), check.IsNil)
+
+	// Changing loaded events must not change the store.
+	events, err := s.store.Load(id)
+	c.Assert(err, check.IsNil)
+	events[0] = ConformanceEvent{id, 2}
+
+	c.Assert(s.store.Append([]eh.Event{ConformanceEvent{id, 3}}), check.IsNil)
+	events, err = s.store.Load(id)
+	c.Assert(err, check.IsNil)
+	c.Assert(events, EventsEqual, []eh.Event{ConformanceEvent{id, 1}, ConformanceEvent{id, 3}})
+}
+
+func (s *EventStoreSuite) Test_Stream(c *check.C) {
+	id1, id2 := eh.NewUUID(), eh.NewUUID()
+	appended := []eh.Event{
+		ConformanceEvent{id1, 1},
+		ConformanceEvent{id2, 1},
+		ConformanceOtherEvent{id1, 2},
+	}
+	c.Assert(s.store.Append(appended), check.IsNil)
+
+	// Streams are read with StreamEvents, which loads the events of stores
+	// that are not AggregateStreamers.
+	var events []eh.Event
+	for event, err := range eh.StreamEvents(s.store, id1) {
+		c.Assert(err, check.IsNil)
+		events = append(events, event)
+	}
+	c.Assert(events, EventsEqual, []eh.Event{appended[0], appended[2]})
+
+	// The iteration can be stopped.
+	events = nil
+	for event, err := range eh.StreamEvents(s.store, id1) {
+		c.Assert(err, check.IsNil)
+		events = append(events, event)
+		break
+	}
+	c.Assert(events, EventsEqual, appended[:1])
+
+	for _, err := range eh.StreamEvents(s.store, eh.NewUUID()) {
+		c.Fatalf("streamed an aggregate without events: %v", err)
+	}
+}
+
+func (s *EventStoreSuite) Test_LoadAll(c *check.C) {
+	global, ok := s.store.(eh.GlobalEventStore)
+	if !ok {
+		c.Skip("not a GlobalEventStore")
+	}
+
+	id1, id2 := eh.NewUUID(), eh.NewUUID()
+	appended := []eh.Event{
+		ConformanceEvent{id1, 1},
+		ConformanceEvent{id2, 1},
+		ConformanceOtherEvent{id1, 2},
+	}
+	for _, event := range appended {
+		c.Assert(s.store.Append([]eh.Event{event}), check.IsNil)
+	}
+	events, err := global.LoadAll()
+	c.Assert(err, check.IsNil)
+	c.Assert(events, EventsEqual, appended)
+}
+
+func (s *EventStoreSuite) Test_Concurrent(c *check.C) {
+	shared := eh.NewUUID()
+	ids := make([]eh.UUID, conformanceWorkers)
+	errs := make(chan error, conformanceWorkers*conformancePerWorker*3)
+	var wg sync.WaitGroup
+	for w := range ids {
+		ids[w] = eh.NewUUID()
+		wg.Add(1)
+		go func(id eh.UUID, w int) {
+			defer wg.Done()
+			for i := 0; i < conformancePerWorker; i++ {
+				errs <- s.store.Append([]eh.Event{ConformanceEvent{id, i}})
+				errs <- s.store.Append([]eh.Event{ConformanceOtherEvent{shared, w*conformancePerWorker + i}})
+				_, err := s.store.Load(shared)
+				errs <- err
+			}
+		}(ids[w], w)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		c.Assert(err, check.IsNil)
+	}
+
+	for _, id := range ids {
+		events, err := s.store.Load(id)
+		c.Assert(err, check.IsNil)
+		c.Assert(events, check.HasLen, conformancePerWorker)
+		for i, event := range events {
+			c.Assert(event, check.Equals, eh.Event(ConformanceEvent{id, i}))
+		}
+	}
+
+	// The events of each worker must be in order in the shared aggregate.
+	events, err := s.store.Load(shared)
+	c.Assert(err, check.IsNil)
+	c.Assert(events, check.HasLen, conformanceWorkers*conformancePerWorker)
+	next := make([]int, conformanceWorkers)
+	for _, event := range events {
+		number := event.(ConformanceOtherEvent).Number
+		w := number / conformancePerWorker
+		c.Assert(number, check.Equals, w*conformancePerWorker+next[w])
+		next[w]++
+	}
+}
+
+func (s *EventStoreSuite) Test_LargeVolume(c *check.C) {
+	n := volume(s.Volume)
+	ids := make([]eh.UUID, 10)
+	for i := range ids {
+		ids[i] = eh.NewUUID()
+	}
+	const batchSize = 100
+	batch := make([]eh.Event, 0, batchSize)
+	for i := 0; i < n; i++ {
+		batch = append(batch, ConformanceEvent{ids[i%len(ids)], i})
+		if len(batch) == batchSize || i == n-1 {
+			c.Assert(s.store.Append(batch), check.IsNil)
+			batch = make([]eh.Event, 0, batchSize)
+		}
+	}
+
+	total := 0
+	for j, id := range ids {
+		events, err := s.store.Load(id)
+		if err != nil {
+			c.Assert(errors.Is(err, eh.ErrNoEventsFound), check.Equals, true, check.Commentf("error: %v", err))
+			continue
+		}
+		for k, event := range events {
+			c.Assert(event, check.Equals, eh.Event(ConformanceEvent{id, j + k*len(ids)}))
+		}
+		total += len(events)
+	}
+	c.Assert(total, check.Equals, n)
+
+	if global, ok := s.store.(eh.GlobalEventStore); ok {
+		events, err := global.LoadAll()
+		c.Assert(err, check.IsNil)
+		c.Assert(events, check.HasLen, n)
+		for i, event := range events {
+			c.Assert(event.(ConformanceEvent).Number, check.Equals, i)
+		}
+	}
+}
@@ -0,0 +1,251 @@
+// Copyright (c) 2014 - Max Persson <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package testing
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+// T is the part of *testing.T and *check.C that fixtures report failures to.
+type T interface {
+	Errorf(format string, args ...interface{})
+}
+
+// AggregateFixture tests an aggregate by dispatching a command to it after
+// a history of events, and asserting the events or error that result:
+//
+//	testing.NewAggregateFixture(t, &InvitationAggregate{}).
+//		Given(InviteCreated{id, "Athena", 42}).
+//		When(AcceptInvite{id}).
+//		Then(InviteAccepted{id})
+//
+// Both delegate aggregates, that implement CommandHandler, and reflect
+// aggregates, with HandleMyCommand methods, can be tested.
+type AggregateFixture struct {
+	t         T
+	aggregate interface{}
+	given     []eh.Event
+	events    []eh.Event
+	err       error
+	setupErr  error
+}
+
+// NewAggregateFixture creates a fixture for an aggregate, reporting failures
+// to t.
+func NewAggregateFixture(t T, aggregate interface{}) *AggregateFixture {
+	f := &AggregateFixture{
+		t:         t,
+		aggregate: aggregate,
+	}
+	return f
+}
+
+// Given sets the events that have happened to the aggregate before the
+// command is dispatched.
+func (f *AggregateFixture) Given(events ...eh.Event) *AggregateFixture {
+	f.given = events
+	return f
+}
+
+// When dispatches a command to the aggregate after the given events.
+func (f *AggregateFixture) When(command eh.Command) *AggregateFixture {
+	bus := NewRecordingEventBus()
+	store := eh.NewMemoryEventStore()
+	f.events, f.err, f.setupErr = nil, nil, nil
+	if len(f.given) > 0 {
+		if err := store.Append(f.given); err != nil {
+			f.setupErr = fmt.Errorf("could not append given events: %v", err)
+			return f
+		}
+	}
+
+	var dispatcher eh.Dispatcher
+	var err error
+	if handler, ok := f.aggregate.(eh.CommandHandler); ok {
+		d := eh.NewDelegateDispatcher(store, bus)
+		err = d.AddHandler(handler, command)
+		dispatcher = d
+	} else {
+		d := eh.NewReflectDispatcher(store, bus)
+		err = d.AddHandler(f.aggregate, command)
+		dispatcher = d
+	}
+	if err != nil {
+		f.setupErr = fmt.Errorf("could not add aggregate: %v", err)
+		return f
+	}
+
+	f.err = dispatcher.Dispatch(command)
+	f.events = bus.Events()
+	return f
+}
+
+// Then asserts that the command succeeded and resulted in the events.
+func (f *AggregateFixture) Then(events ...eh.Event) {
+	if h, ok := f.t.(interface{ Helper() }); ok {
+		h.Helper()
+	}
+	if f.setupErr != nil {
+		f.t.Errorf("%v", f.setupErr)
+		return
+	}
+	if f.err != nil {
+		f.t.Errorf("expected events but got error: %v", f.err)
+		return
+	}
+	if diff := DiffEvents(events, f.events); diff != "" {
+		f.t.Errorf("events differ (- expected, + actual):\n%s", diff)
+	}
+}
+
+// ThenError asserts that the command failed with the error, either matched
+// with errors.Is or by its message.
+func (f *AggregateFixture) ThenError(err error) {
+	if h, ok := f.t.(interface{ Helper() }); ok {
+		h.Helper()
+	}
+	if f.setupErr != nil {
+		f.t.Errorf("%v", f.setupErr)
+		return
+	}
+	if f.err == nil {
+		f.t.Errorf("expected error %q but got events:\n%s", err, formatEvents(f.events))
+		return
+	}
+	if !errors.Is(f.err, err) && f.err.Error() != err.Error() {
+		f.t.Errorf("expected error %q but got %q", err, f.err)
+	}
+}
+
+// Events returns the events that resulted from the command.
+func (f *AggregateFixture) Events() []eh.Event {
+	return f.events
+}
+
+// Err returns the error that resulted from the command.
+func (f *AggregateFixture) Err() error {
+	if f.setupErr != nil {
+		return f.setupErr
+	}
+	return f.err
+}
+
+// DiffEvents returns a readable diff of expected and actual events, with a
+// line per event, or an empty string if they are equal. Metadata added by event
+// stores and dispatchers is ignored.
+func DiffEvents(expected, actual []eh.Event) string {
+	equal := len(expected) == len(actual)
+	for i := 0; equal && i < len(expected); i++ {
+		equal = equalEvents(expected[i], actual[i])
+	}
+	if equal {
+		return ""
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(expected) || i < len(actual); i++ {
+		switch {
+		case i >= len(actual):
+			fmt.Fprintf(&b, "- %s\n", formatEvent(expected[i]))
+		case i >= len(expected):
+			fmt.Fprintf(&b, "+ %s\n", formatEvent(actual[i]))
+		case equalEvents(expected[i], actual[i]):
+			fmt.Fprintf(&b, "  %s\n", formatEvent(actual[i]))
+		default:
+			fmt.Fprintf(&b, "- %s\n+ %s\n", formatEvent(expected[i]), formatEvent(actual[i]))
+		}
+	}
+	return b.String()
+}
+
+// storeMetadata are the metadata keys that event stores and dispatchers add to
+// events, which are ignored when comparing events.
+var storeMetadata = []string{
+	eh.MetadataSequence,
+	eh.MetadataTimestamp,
+	eh.MetadataTraceParent,
+	eh.MetadataTenant,
+}
+
+var metadataType = reflect.TypeOf(eh.Metadata(nil))
+
+// equalEvents checks if two events are deeply equal, ignoring the metadata
+// added by event stores and dispatchers.
+func equalEvents(a, b eh.Event) bool {
+	return reflect.DeepEqual(withoutStoreMetadata(a), withoutStoreMetadata(b))
+}
+
+// withoutStoreMetadata returns a copy of an event without the metadata added
+// by event stores and dispatchers, or the event if it has no such metadata.
+func withoutStoreMetadata(event eh.Event) interface{} {
+	v := reflect.ValueOf(event)
+	if v.Kind() == reflect.Ptr && !v.IsNil() {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return event
+	}
+
+	copy := reflect.New(v.Type()).Elem()
+	copy.Set(v)
+	stripped := false
+	for i := 0; i < v.NumField(); i++ {
+		field := copy.Field(i)
+		if field.Type() != metadataType || !field.CanSet() || field.Len() == 0 {
+			continue
+		}
+		var metadata eh.Metadata
+		for k, value := range field.Interface().(eh.Metadata) {
+			if !slices.Contains(storeMetadata, k) {
+				if metadata == nil {
+					metadata = make(eh.Metadata)
+				}
+				metadata[k] = value
+			}
+		}
+		field.Set(reflect.ValueOf(metadata))
+		stripped = true
+	}
+	if !stripped {
+		return event
+	}
+	if reflect.ValueOf(event).Kind() == reflect.Ptr {
+		return copy.Addr().Interface()
+	}
+	return copy.Interface()
+}
+
+// formatEvent formats an event with its type and field names.
+func formatEvent(event eh.Event) string {
+	return fmt.Sprintf("%T%+v", event, event)
+}
+
+// formatEvents formats events with a line per event.
+func formatEvents(events []eh.Event) string {
+	if len(events) == 0 {
+		return "  (no events)\n"
+	}
+	var b strings.Builder
+	for _, event := range events {
+		fmt.Fprintf(&b, "  %s\n", formatEvent(event))
+	}
+	return b.String()
+}
@@ -0,0 +1,235 @@
+// Copyright (c) 2014 - Max Persson <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package testing
+
+import (
+	"errors"
+	"fmt"
+	gotesting "testing"
+
+	. "gopkg.in/check.v1"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+func Test(t *gotesting.T) { TestingT(t) }
+
+var _ = Suite(&AggregateFixtureSuite{})
+
+type AggregateFixtureSuite struct{}
+
+// Both *testing.T and *check.C can be used with fixtures.
+var _ T = (*gotesting.T)(nil)
+var _ T = (*C)(nil)
+
+var ErrAlreadyOpen = errors.New("already open")
+
+type OpenDoor struct {
+	ID eh.UUID
+}
+
+func (c OpenDoor) AggregateID() eh.UUID { return c.ID }
+
+type DoorOpened struct {
+	ID eh.UUID
+}
+
+func (e DoorOpened) AggregateID() eh.UUID { return e.ID }
+
+type DoorLocked struct {
+	ID   eh.UUID
+	Code string
+}
+
+func (e DoorLocked) AggregateID() eh.UUID { return e.ID }
+
+type TagDoor struct {
+	ID  eh.UUID
+	Tag string
+}
+
+func (c TagDoor) AggregateID() eh.UUID { return c.ID }
+
+// DoorTagged embeds metadata, that the event store and dispatcher add to.
+type DoorTagged struct {
+	eh.Metadata
+	ID eh.UUID
+}
+
+func (e DoorTagged) AggregateID() eh.UUID { return e.ID }
+
+// DelegateDoor is a delegate aggregate.
+type DelegateDoor struct {
+	eh.Aggregate
+
+	open bool
+}
+
+func (d *DelegateDoor) HandleCommand(command eh.Command) ([]eh.Event, error) {
+	switch command := command.(type) {
+	case OpenDoor:
+		if d.open {
+			return nil, ErrAlreadyOpen
+		}
+		return []eh.Event{DoorOpened{command.ID}}, nil
+	case TagDoor:
+		return []eh.Event{DoorTagged{eh.Metadata{"tag": command.Tag}, command.ID}}, nil
+	}
+	return nil, fmt.Errorf("couldn't handle command")
+}
+
+func (d *DelegateDoor) HandleEvent(event eh.Event) {
+	switch event.(type) {
+	case DoorOpened:
+		d.open = true
+	}
+}
+
+// ReflectDoor is a reflect aggregate.
+type ReflectDoor struct {
+	eh.Aggregate
+
+	open bool
+}
+
+func (d *ReflectDoor) HandleOpenDoor(command OpenDoor) ([]eh.Event, error) {
+	if d.open {
+		return nil, ErrAlreadyOpen
+	}
+	return []eh.Event{DoorOpened{command.ID}}, nil
+}
+
+func (d *ReflectDoor) ApplyDoorOpened(event DoorOpened) {
+	d.open = true
+}
+
+// MockT records reported failures.
+type MockT struct {
+	errors []string
+}
+
+func (m *MockT) Errorf(format string, args ...interface{}) {
+	m.errors = append(m.errors, fmt.Sprintf(format, args...))
+}
+
+func (s *AggregateFixtureSuite) Test_Then(c *C) {
+	for _, aggregate := range []interface{}{&DelegateDoor{}, &ReflectDoor{}} {
+		id := eh.NewUUID()
+		mock := &MockT{}
+		f := NewAggregateFixture(mock, aggregate).
+			When(OpenDoor{id})
+		f.Then(DoorOpened{id})
+		c.Assert(mock.errors, IsNil)
+		c.Assert(f.Err(), IsNil)
+		c.Assert(f.Events(), DeepEquals, []eh.Event{DoorOpened{id}})
+
+		f.Then(DoorOpened{id}, DoorLocked{id, "1234"})
+		c.Assert(mock.errors, HasLen, 1)
+		c.Assert(mock.errors[0], Equals, "events differ (- expected, + actual):\n"+
+			"  testing.DoorOpened{ID:"+id.String()+"}\n"+
+			"- testing.DoorLocked{ID:"+id.String()+" Code:1234}\n")
+	}
+}
+
+func (s *AggregateFixtureSuite) Test_Then_Metadata(c *C) {
+	id := eh.NewUUID()
+	mock := &MockT{}
+	f := NewAggregateFixture(mock, &DelegateDoor{}).
+		Given(DoorOpened{id}).
+		When(TagDoor{id, "front"})
+	c.Assert(eh.MetadataOf(f.Events()[0]), HasKey, eh.MetadataSequence)
+	f.Then(DoorTagged{eh.Metadata{"tag": "front"}, id})
+	c.Assert(mock.errors, IsNil)
+
+	f.Then(DoorTagged{eh.Metadata{"tag": "back"}, id})
+	c.Assert(mock.errors, HasLen, 1)
+}
+
+func (s *AggregateFixtureSuite) Test_Then_Error(c *C) {
+	id := eh.NewUUID()
+	mock := &MockT{}
+	NewAggregateFixture(mock, &ReflectDoor{}).
+		Given(DoorOpened{id}).
+		When(OpenDoor{id}).
+		Then(DoorOpened{id})
+	c.Assert(mock.errors, DeepEquals, []string{"expected events but got error: already open"})
+}
+
+func (s *AggregateFixtureSuite) Test_Then_Diff(c *C) {
+	id := eh.NewUUID()
+	other := eh.NewUUID()
+	mock := &MockT{}
+	NewAggregateFixture(mock, &DelegateDoor{}).
+		When(OpenDoor{id}).
+		Then(DoorOpened{other})
+	c.Assert(mock.errors, DeepEquals, []string{"events differ (- expected, + actual):\n" +
+		"- testing.DoorOpened{ID:" + other.String() + "}\n" +
+		"+ testing.DoorOpened{ID:" + id.String() + "}\n"})
+
+	mock = &MockT{}
+	NewAggregateFixture(mock, &DelegateDoor{}).
+		When(OpenDoor{id}).
+		Then()
+	c.Assert(mock.errors, DeepEquals, []string{"events differ (- expected, + actual):\n" +
+		"+ testing.DoorOpened{ID:" + id.String() + "}\n"})
+}
+
+func (s *AggregateFixtureSuite) Test_ThenError(c *C) {
+	for _, aggregate := range []interface{}{&DelegateDoor{}, &ReflectDoor{}} {
+		id := eh.NewUUID()
+		mock := &MockT{}
+		f := NewAggregateFixture(mock, aggregate).
+			Given(DoorOpened{id}).
+			When(OpenDoor{id})
+		f.ThenError(ErrAlreadyOpen)
+		f.ThenError(errors.New("already open"))
+		c.Assert(mock.errors, IsNil)
+		c.Assert(f.Events(), IsNil)
+
+		f.ThenError(errors.New("locked"))
+		c.Assert(mock.errors, DeepEquals, []string{`expected error "locked" but got "already open"`})
+	}
+}
+
+func (s *AggregateFixtureSuite) Test_ThenError_NoError(c *C) {
+	id := eh.NewUUID()
+	mock := &MockT{}
+	NewAggregateFixture(mock, &ReflectDoor{}).
+		When(OpenDoor{id}).
+		ThenError(ErrAlreadyOpen)
+	c.Assert(mock.errors, DeepEquals, []string{"expected error \"already open\" but got events:\n" +
+		"  testing.DoorOpened{ID:" + id.String() + "}\n"})
+}
+
+func (s *AggregateFixtureSuite) Test_InvalidAggregate(c *C) {
+	mock := &MockT{}
+	f := NewAggregateFixture(mock, &struct{}{}).
+		When(OpenDoor{eh.NewUUID()})
+	f.Then()
+	c.Assert(mock.errors, HasLen, 1)
+	c.Assert(mock.errors[0], Matches, "could not add aggregate: invalid handler: .*")
+	c.Assert(f.Err(), ErrorMatches, "could not add aggregate: .*")
+}
+
+func (s *AggregateFixtureSuite) Test_WithGocheck(c *C) {
+	id := eh.NewUUID()
+	NewAggregateFixture(c, &ReflectDoor{}).
+		When(OpenDoor{id}).
+		Then(DoorOpened{id})
+	NewAggregateFixture(c, &DelegateDoor{}).
+		Given(DoorOpened{id}).
+		When(OpenDoor{id}).
+		ThenError(ErrAlreadyOpen)
+}
@@ -0,0 +1,123 @@
+// Copyright (c) 2014 - Max Persson <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package testing
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+// ProjectorFixture tests a projector by handling events with it and
+// asserting the read models it saves in its repository:
+//
+//	repository := eventhorizon.NewMemoryRepository()
+//	testing.NewProjectorFixture(t, NewInvitationProjector(repository), repository).
+//		When(InviteCreated{id, "Athena", 42}, InviteAccepted{id}).
+//		ThenModel(id, &Invitation{ID: id, Name: "Athena", Status: "accepted"})
+type ProjectorFixture struct {
+	t          T
+	handler    eh.EventHandler
+	repository eh.Repository
+}
+
+// NewProjectorFixture creates a fixture for a projector that saves its read
+// models in repository, reporting failures to t.
+func NewProjectorFixture(t T, handler eh.EventHandler, repository eh.Repository) *ProjectorFixture {
+	f := &ProjectorFixture{
+		t:          t,
+		handler:    handler,
+		repository: repository,
+	}
+	return f
+}
+
+// When handles the events with the projector, in order.
+func (f *ProjectorFixture) When(events ...eh.Event) *ProjectorFixture {
+	for _, event := range events {
+		f.handler.HandleEvent(event)
+	}
+	return f
+}
+
+// ThenModel asserts that the repository has a model equal to model for id.
+func (f *ProjectorFixture) ThenModel(id eh.UUID, model interface{}) *ProjectorFixture {
+	if h, ok := f.t.(interface{ Helper() }); ok {
+		h.Helper()
+	}
+	actual, err := f.repository.Find(id)
+	if err != nil {
+		f.t.Errorf("could not find model %s: %v", id, err)
+		return f
+	}
+	if !reflect.DeepEqual(actual, model) {
+		f.t.Errorf("model %s differs:\n- %s\n+ %s", id, formatModel(model), formatModel(actual))
+	}
+	return f
+}
+
+// ThenNotFound asserts that the repository has no model for id.
+func (f *ProjectorFixture) ThenNotFound(id eh.UUID) *ProjectorFixture {
+	if h, ok := f.t.(interface{ Helper() }); ok {
+		h.Helper()
+	}
+	if actual, err := f.repository.Find(id); err == nil {
+		f.t.Errorf("expected no model %s but found %s", id, formatModel(actual))
+	}
+	return f
+}
+
+// ThenModels asserts that the repository has exactly the models, in the
+// order returned by FindAll.
+func (f *ProjectorFixture) ThenModels(models ...interface{}) *ProjectorFixture {
+	if h, ok := f.t.(interface{ Helper() }); ok {
+		h.Helper()
+	}
+	actual, err := f.repository.FindAll()
+	if err != nil {
+		f.t.Errorf("could not find models: %v", err)
+		return f
+	}
+	if len(actual) == 0 && len(models) == 0 {
+		return f
+	}
+	if !reflect.DeepEqual(actual, models) {
+		f.t.Errorf("models differ:\nexpected:\n%sactual:\n%s", formatModels(models), formatModels(actual))
+	}
+	return f
+}
+
+// formatModel formats a read model with its type and field names.
+func formatModel(model interface{}) string {
+	v := reflect.ValueOf(model)
+	if v.Kind() == reflect.Ptr && !v.IsNil() {
+		return fmt.Sprintf("&%T%+v", v.Elem().Interface(), v.Elem().Interface())
+	}
+	return fmt.Sprintf("%T%+v", model, model)
+}
+
+// formatModels formats read models with a line per model.
+func formatModels(models []interface{}) string {
+	if len(models) == 0 {
+		return "  (no models)\n"
+	}
+	var b strings.Builder
+	for _, model := range models {
+		fmt.Fprintf(&b, "  %s\n", formatModel(model))
+	}
+	return b.String()
+}
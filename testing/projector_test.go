@@ -0,0 +1,93 @@
+// Copyright (c) 2014 - Max Persson <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package testing
+
+import (
+	. "gopkg.in/check.v1"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+var _ = Suite(&ProjectorFixtureSuite{})
+
+type ProjectorFixtureSuite struct{}
+
+type Door struct {
+	ID     eh.UUID
+	Open   bool
+	Locked bool
+}
+
+type DoorProjector struct {
+	repository eh.Repository
+}
+
+func (p *DoorProjector) HandleEvent(event eh.Event) {
+	switch event := event.(type) {
+	case DoorOpened:
+		p.repository.Save(event.ID, &Door{ID: event.ID, Open: true})
+	case DoorLocked:
+		m, _ := p.repository.Find(event.ID)
+		d := m.(*Door)
+		d.Locked = true
+		p.repository.Save(event.ID, d)
+	}
+}
+
+func (s *ProjectorFixtureSuite) Test_ThenModel(c *C) {
+	id := eh.NewUUID()
+	other := eh.NewUUID()
+	repository := eh.NewMemoryRepository()
+	mock := &MockT{}
+	f := NewProjectorFixture(mock, &DoorProjector{repository}, repository).
+		When(DoorOpened{id}, DoorLocked{id, "1234"}).
+		ThenModel(id, &Door{ID: id, Open: true, Locked: true}).
+		ThenNotFound(other).
+		ThenModels(&Door{ID: id, Open: true, Locked: true})
+	c.Assert(mock.errors, IsNil)
+
+	f.ThenModel(id, &Door{ID: id, Open: true}).
+		ThenModel(other, &Door{}).
+		ThenNotFound(id)
+	c.Assert(mock.errors, DeepEquals, []string{
+		"model " + id.String() + " differs:\n" +
+			"- &testing.Door{ID:" + id.String() + " Open:true Locked:false}\n" +
+			"+ &testing.Door{ID:" + id.String() + " Open:true Locked:true}",
+		"could not find model " + other.String() + ": could not find model",
+		"expected no model " + id.String() + " but found &testing.Door{ID:" + id.String() + " Open:true Locked:true}",
+	})
+}
+
+func (s *ProjectorFixtureSuite) Test_ThenModels(c *C) {
+	id := eh.NewUUID()
+	repository := eh.NewMemoryRepository()
+	mock := &MockT{}
+	f := NewProjectorFixture(mock, &DoorProjector{repository}, repository).
+		ThenModels()
+	c.Assert(mock.errors, IsNil)
+
+	f.When(DoorOpened{id}).ThenModels()
+	c.Assert(mock.errors, DeepEquals, []string{"models differ:\n" +
+		"expected:\n  (no models)\n" +
+		"actual:\n  &testing.Door{ID:" + id.String() + " Open:true Locked:false}\n"})
+}
+
+func (s *ProjectorFixtureSuite) Test_WithGocheck(c *C) {
+	id := eh.NewUUID()
+	repository := eh.NewMemoryRepository()
+	NewProjectorFixture(c, &DoorProjector{repository}, repository).
+		When(DoorOpened{id}).
+		ThenModel(id, &Door{ID: id, Open: true})
+}
@@ -0,0 +1,314 @@
+// Copyright (c) 2014 - Max Persson <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package testing
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"strings"
+	"time"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+// DefaultPropertyRuns is the default number of command sequences generated by
+// a PropertyTest.
+const DefaultPropertyRuns = 100
+
+// DefaultPropertySteps is the default number of commands in each generated
+// sequence.
+const DefaultPropertySteps = 20
+
+// CommandGenerator generates a random command for the aggregate with the id.
+// It must not return nil.
+type CommandGenerator func(r *rand.Rand, id eh.UUID) eh.Command
+
+// Invariant checks an aggregate hydrated from its events, and returns an
+// error if it is in an invalid state.
+type Invariant func(aggregate interface{}) error
+
+// Model is a simplified model of an aggregate for model-based testing, that
+// predicts the outcome of each command.
+type Model interface {
+	// Next returns nil if the command should succeed, after updating the
+	// state of the model, or the error that it should fail with.
+	Next(command eh.Command) error
+}
+
+// PropertyTest tests an aggregate by dispatching random sequences of commands
+// to it and checking invariants on the aggregate after every command:
+//
+//	testing.NewPropertyTest(t, &InvitationAggregate{}).
+//		Commands(genAccept, genDecline).
+//		Invariant("not accepted and declined", func(a interface{}) error {
+//			if i := a.(*InvitationAggregate); i.accepted && i.declined {
+//				return errors.New("both accepted and declined")
+//			}
+//			return nil
+//		}).
+//		Run()
+//
+// Commands that fail are part of the sequence, as they must not change the
+// aggregate. A sequence that violates an invariant, or where a command
+// panics, is shrunk to the shortest sequence that still fails and reported
+// with the seed that reproduces it.
+type PropertyTest struct {
+	t          T
+	aggregate  interface{}
+	generators []CommandGenerator
+	invariants []namedInvariant
+	model      func() Model
+	runs       int
+	steps      int
+	seed       int64
+}
+
+type namedInvariant struct {
+	name  string
+	check Invariant
+}
+
+// propertyFailure is the first failing step of a command sequence.
+type propertyFailure struct {
+	step    int
+	message string
+	setup   bool
+}
+
+// panicError is the error of a recovered panic.
+type panicError struct {
+	value interface{}
+}
+
+func (e panicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.value)
+}
+
+// NewPropertyTest creates a property test for an aggregate, reporting
+// failures to t. Both delegate and reflect aggregates can be tested.
+func NewPropertyTest(t T, aggregate interface{}) *PropertyTest {
+	p := &PropertyTest{
+		t:         t,
+		aggregate: aggregate,
+		runs:      DefaultPropertyRuns,
+		steps:     DefaultPropertySteps,
+		seed:      time.Now().UnixNano(),
+	}
+	return p
+}
+
+// SetRuns sets the number of command sequences to generate.
+func (p *PropertyTest) SetRuns(runs int) {
+	p.runs = runs
+}
+
+// SetSteps sets the number of commands in each sequence.
+func (p *PropertyTest) SetSteps(steps int) {
+	p.steps = steps
+}
+
+// SetSeed sets the seed of the random generator, to reproduce a failure.
+func (p *PropertyTest) SetSeed(seed int64) {
+	p.seed = seed
+}
+
+// Commands adds generators of commands. Each step uses one of them at random.
+func (p *PropertyTest) Commands(generators ...CommandGenerator) *PropertyTest {
+	p.generators = append(p.generators, generators...)
+	return p
+}
+
+// Invariant adds an invariant that is checked after every command.
+func (p *PropertyTest) Invariant(name string, invariant Invariant) *PropertyTest {
+	p.invariants = append(p.invariants, namedInvariant{name, invariant})
+	return p
+}
+
+// Model sets a factory of models. A new model is created for each sequence,
+// and every command must succeed or fail as the model predicts.
+func (p *PropertyTest) Model(model func() Model) *PropertyTest {
+	p.model = model
+	return p
+}
+
+// Run generates and checks the command sequences. It returns the shrunk
+// sequence of the first failure, or nil if all sequences passed.
+func (p *PropertyTest) Run() []eh.Command {
+	if h, ok := p.t.(interface{ Helper() }); ok {
+		h.Helper()
+	}
+	if len(p.generators) == 0 {
+		p.t.Errorf("no command generators")
+		return nil
+	}
+
+	r := rand.New(rand.NewSource(p.seed))
+	for run := 0; run < p.runs; run++ {
+		id := eh.NewUUID()
+		commands := make([]eh.Command, p.steps)
+		for i := range commands {
+			generator := p.generators[r.Intn(len(p.generators))]
+			commands[i] = generator(r, id)
+		}
+
+		failure := p.execute(commands)
+		if failure == nil {
+			continue
+		}
+		if failure.setup {
+			p.t.Errorf("%s", failure.message)
+			return commands[:failure.step+1]
+		}
+		original := failure.step + 1
+		shrunk, failure := p.shrink(commands[:original], failure)
+		p.t.Errorf("%s after %d commands (shrunk from %d, seed %d, run %d):\n%s",
+			failure.message, len(shrunk), original, p.seed, run, formatCommands(shrunk))
+		return shrunk
+	}
+	return nil
+}
+
+// shrink removes commands from a failing sequence for as long as it still
+// fails, first in large chunks and then one by one.
+func (p *PropertyTest) shrink(commands []eh.Command, failure *propertyFailure) ([]eh.Command, *propertyFailure) {
+	for size := len(commands) / 2; size > 0; size /= 2 {
+		for i := 0; i+size <= len(commands); {
+			candidate := append(append([]eh.Command{}, commands[:i]...), commands[i+size:]...)
+			if f := p.execute(candidate); f != nil && !f.setup {
+				commands, failure = candidate[:f.step+1], f
+				continue
+			}
+			i += size
+		}
+	}
+	return commands, failure
+}
+
+// execute dispatches a sequence of commands to a new aggregate and returns
+// the first failure, or nil.
+func (p *PropertyTest) execute(commands []eh.Command) *propertyFailure {
+	store := eh.NewMemoryEventStore()
+	dispatcher, addHandler := p.dispatcher(store)
+	added := make(map[reflect.Type]bool)
+	var model Model
+	if p.model != nil {
+		model = p.model()
+	}
+
+	for step, command := range commands {
+		if commandType := reflect.TypeOf(command); !added[commandType] {
+			if err := addHandler(command); err != nil {
+				return &propertyFailure{step, fmt.Sprintf("could not add aggregate: %v", err), true}
+			}
+			added[commandType] = true
+		}
+
+		err := protect(func() error { return dispatcher.Dispatch(command) })
+		var panicked panicError
+		if errors.As(err, &panicked) {
+			return &propertyFailure{step: step, message: fmt.Sprintf("command %s: %v", formatCommand(command), err)}
+		}
+		if model != nil {
+			if message := checkModel(model, command, err); message != "" {
+				return &propertyFailure{step: step, message: message}
+			}
+		}
+
+		aggregate, err := p.hydrate(store, command.AggregateID())
+		if err != nil {
+			return &propertyFailure{step, fmt.Sprintf("could not load aggregate: %v", err), true}
+		}
+		for _, invariant := range p.invariants {
+			if err := protect(func() error { return invariant.check(aggregate) }); err != nil {
+				return &propertyFailure{step: step, message: fmt.Sprintf("invariant %q violated: %v", invariant.name, err)}
+			}
+		}
+	}
+	return nil
+}
+
+// dispatcher creates a dispatcher for the aggregate and a function that adds
+// the aggregate as the handler of a command.
+func (p *PropertyTest) dispatcher(store eh.EventStore) (eh.Dispatcher, func(eh.Command) error) {
+	bus := eh.NewHandlerEventBus()
+	if handler, ok := p.aggregate.(eh.CommandHandler); ok {
+		d := eh.NewDelegateDispatcher(store, bus)
+		return d, func(command eh.Command) error { return d.AddHandler(handler, command) }
+	}
+	d := eh.NewReflectDispatcher(store, bus)
+	return d, func(command eh.Command) error { return d.AddHandler(p.aggregate, command) }
+}
+
+// hydrate creates a new aggregate of the tested type and applies its events,
+// in the same way as the dispatchers.
+func (p *PropertyTest) hydrate(store eh.EventStore, id eh.UUID) (interface{}, error) {
+	events, err := store.Load(id)
+	if err != nil && !errors.Is(err, eh.ErrNoEventsFound) {
+		return nil, err
+	}
+
+	obj := reflect.New(reflect.TypeOf(p.aggregate).Elem())
+	var base eh.Aggregate
+	if _, ok := p.aggregate.(eh.CommandHandler); ok {
+		base = eh.NewDelegateAggregate(id, obj.Interface().(eh.EventHandler))
+	} else {
+		base = eh.NewReflectAggregate(id, obj.Interface())
+	}
+	obj.Elem().FieldByName("Aggregate").Set(reflect.ValueOf(base))
+	aggregate := obj.Interface()
+	aggregate.(eh.Aggregate).ApplyEvents(events)
+	return aggregate, nil
+}
+
+// checkModel returns a message if the result of a command differs from the
+// prediction of the model.
+func checkModel(model Model, command eh.Command, err error) string {
+	expected := model.Next(command)
+	switch {
+	case expected == nil && err != nil:
+		return fmt.Sprintf("command %s: expected success but got error %q", formatCommand(command), err)
+	case expected != nil && err == nil:
+		return fmt.Sprintf("command %s: expected error %q but it succeeded", formatCommand(command), expected)
+	case expected != nil && !errors.Is(err, expected) && err.Error() != expected.Error():
+		return fmt.Sprintf("command %s: expected error %q but got %q", formatCommand(command), expected, err)
+	}
+	return ""
+}
+
+// protect calls f and returns a panicError if it panics.
+func protect(f func() error) (err error) {
+	defer func() {
+		if value := recover(); value != nil {
+			err = panicError{value}
+		}
+	}()
+	return f()
+}
+
+// formatCommand formats a command with its type and field names.
+func formatCommand(command eh.Command) string {
+	return fmt.Sprintf("%T%+v", command, command)
+}
+
+// formatCommands formats commands with a line per command.
+func formatCommands(commands []eh.Command) string {
+	var b strings.Builder
+	for _, command := range commands {
+		fmt.Fprintf(&b, "  %s\n", formatCommand(command))
+	}
+	return b.String()
+}
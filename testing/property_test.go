@@ -0,0 +1,282 @@
+// Copyright (c) 2014 - Max Persson <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package testing
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+
+	. "gopkg.in/check.v1"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+var _ = Suite(&PropertyTestSuite{})
+
+type PropertyTestSuite struct{}
+
+var (
+	ErrNotCreated     = errors.New("not created")
+	ErrAlreadyCreated = errors.New("already created")
+	ErrAlreadyAnswer  = errors.New("already answered")
+)
+
+type CreateInvite struct {
+	ID eh.UUID
+}
+
+func (c CreateInvite) AggregateID() eh.UUID { return c.ID }
+
+type AcceptInvite struct {
+	ID eh.UUID
+}
+
+func (c AcceptInvite) AggregateID() eh.UUID { return c.ID }
+
+type DeclineInvite struct {
+	ID eh.UUID
+}
+
+func (c DeclineInvite) AggregateID() eh.UUID { return c.ID }
+
+type InviteCreated struct {
+	ID eh.UUID
+}
+
+func (e InviteCreated) AggregateID() eh.UUID { return e.ID }
+
+type InviteAccepted struct {
+	ID eh.UUID
+}
+
+func (e InviteAccepted) AggregateID() eh.UUID { return e.ID }
+
+type InviteDeclined struct {
+	ID eh.UUID
+}
+
+func (e InviteDeclined) AggregateID() eh.UUID { return e.ID }
+
+// Invite is a delegate aggregate that can not be both accepted and declined.
+type Invite struct {
+	eh.Aggregate
+
+	created, accepted, declined bool
+}
+
+func (i *Invite) HandleCommand(command eh.Command) ([]eh.Event, error) {
+	switch command := command.(type) {
+	case CreateInvite:
+		if i.created {
+			return nil, ErrAlreadyCreated
+		}
+		return []eh.Event{InviteCreated{command.ID}}, nil
+	case AcceptInvite:
+		if !i.created {
+			return nil, ErrNotCreated
+		}
+		if i.accepted || i.declined {
+			return nil, ErrAlreadyAnswer
+		}
+		return []eh.Event{InviteAccepted{command.ID}}, nil
+	case DeclineInvite:
+		if !i.created {
+			return nil, ErrNotCreated
+		}
+		if i.accepted || i.declined {
+			return nil, ErrAlreadyAnswer
+		}
+		return []eh.Event{InviteDeclined{command.ID}}, nil
+	}
+	return nil, fmt.Errorf("couldn't handle command")
+}
+
+func (i *Invite) HandleEvent(event eh.Event) {
+	switch event.(type) {
+	case InviteCreated:
+		i.created = true
+	case InviteAccepted:
+		i.accepted = true
+	case InviteDeclined:
+		i.declined = true
+	}
+}
+
+// BuggyInvite is a reflect aggregate that can be declined after it has been
+// accepted.
+type BuggyInvite struct {
+	eh.Aggregate
+
+	created, accepted, declined bool
+}
+
+func (i *BuggyInvite) HandleCreateInvite(command CreateInvite) ([]eh.Event, error) {
+	if i.created {
+		return nil, ErrAlreadyCreated
+	}
+	return []eh.Event{InviteCreated{command.ID}}, nil
+}
+
+func (i *BuggyInvite) HandleAcceptInvite(command AcceptInvite) ([]eh.Event, error) {
+	if !i.created {
+		return nil, ErrNotCreated
+	}
+	if i.accepted || i.declined {
+		return nil, ErrAlreadyAnswer
+	}
+	return []eh.Event{InviteAccepted{command.ID}}, nil
+}
+
+func (i *BuggyInvite) HandleDeclineInvite(command DeclineInvite) ([]eh.Event, error) {
+	if !i.created {
+		return nil, ErrNotCreated
+	}
+	if i.declined {
+		return nil, ErrAlreadyAnswer
+	}
+	return []eh.Event{InviteDeclined{command.ID}}, nil
+}
+
+func (i *BuggyInvite) ApplyInviteCreated(event InviteCreated) {
+	i.created = true
+}
+
+func (i *BuggyInvite) ApplyInviteAccepted(event InviteAccepted) {
+	i.accepted = true
+}
+
+func (i *BuggyInvite) ApplyInviteDeclined(event InviteDeclined) {
+	i.declined = true
+}
+
+// InviteModel predicts the results of the invite commands.
+type InviteModel struct {
+	created, answered bool
+}
+
+func (m *InviteModel) Next(command eh.Command) error {
+	switch command.(type) {
+	case CreateInvite:
+		if m.created {
+			return ErrAlreadyCreated
+		}
+		m.created = true
+	case AcceptInvite, DeclineInvite:
+		if !m.created {
+			return ErrNotCreated
+		}
+		if m.answered {
+			return ErrAlreadyAnswer
+		}
+		m.answered = true
+	}
+	return nil
+}
+
+func genCreate(r *rand.Rand, id eh.UUID) eh.Command  { return CreateInvite{id} }
+func genAccept(r *rand.Rand, id eh.UUID) eh.Command  { return AcceptInvite{id} }
+func genDecline(r *rand.Rand, id eh.UUID) eh.Command { return DeclineInvite{id} }
+
+func notAcceptedAndDeclined(aggregate interface{}) error {
+	var accepted, declined bool
+	switch i := aggregate.(type) {
+	case *Invite:
+		accepted, declined = i.accepted, i.declined
+	case *BuggyInvite:
+		accepted, declined = i.accepted, i.declined
+	}
+	if accepted && declined {
+		return errors.New("both accepted and declined")
+	}
+	return nil
+}
+
+func (s *PropertyTestSuite) Test_Run(c *C) {
+	mock := &MockT{}
+	p := NewPropertyTest(mock, &Invite{}).
+		Commands(genCreate, genAccept, genDecline).
+		Invariant("not accepted and declined", notAcceptedAndDeclined).
+		Model(func() Model { return &InviteModel{} })
+	failing := p.Run()
+	c.Assert(mock.errors, IsNil)
+	c.Assert(failing, IsNil)
+}
+
+func (s *PropertyTestSuite) Test_Run_Shrink(c *C) {
+	mock := &MockT{}
+	p := NewPropertyTest(mock, &BuggyInvite{}).
+		Commands(genCreate, genAccept, genDecline).
+		Invariant("not accepted and declined", notAcceptedAndDeclined)
+	p.SetSeed(1)
+	p.SetSteps(50)
+	failing := p.Run()
+	c.Assert(failing, HasLen, 3)
+	id := failing[0].AggregateID()
+	c.Assert(failing, DeepEquals, []eh.Command{CreateInvite{id}, AcceptInvite{id}, DeclineInvite{id}})
+	c.Assert(mock.errors, HasLen, 1)
+	c.Assert(mock.errors[0], Matches, `invariant "not accepted and declined" violated: both accepted and declined `+
+		`after 3 commands \(shrunk from [0-9]+, seed 1, run [0-9]+\):\n`+
+		`  testing.CreateInvite{ID:.*}\n`+
+		`  testing.AcceptInvite{ID:.*}\n`+
+		`  testing.DeclineInvite{ID:.*}\n`)
+}
+
+func (s *PropertyTestSuite) Test_Run_Model(c *C) {
+	mock := &MockT{}
+	failing := NewPropertyTest(mock, &BuggyInvite{}).
+		Commands(genCreate, genAccept, genDecline).
+		Model(func() Model { return &InviteModel{} }).
+		Run()
+	c.Assert(failing, HasLen, 3)
+	c.Assert(mock.errors, HasLen, 1)
+	c.Assert(mock.errors[0], Matches, `(?s)command testing.DeclineInvite{ID:.*}: expected error "already answered" but it succeeded after 3 commands .*`)
+}
+
+func (s *PropertyTestSuite) Test_Run_Panic(c *C) {
+	mock := &MockT{}
+	failing := NewPropertyTest(mock, &Invite{}).
+		Commands(genCreate, genAccept).
+		Invariant("panics", func(aggregate interface{}) error {
+			if aggregate.(*Invite).accepted {
+				panic("accepted")
+			}
+			return nil
+		}).
+		Run()
+	c.Assert(failing, HasLen, 2)
+	c.Assert(mock.errors, HasLen, 1)
+	c.Assert(mock.errors[0], Matches, `(?s)invariant "panics" violated: panic: accepted after 2 commands .*`)
+}
+
+func (s *PropertyTestSuite) Test_Run_InvalidAggregate(c *C) {
+	mock := &MockT{}
+	NewPropertyTest(mock, &struct{}{}).
+		Commands(genCreate).
+		Run()
+	c.Assert(mock.errors, HasLen, 1)
+	c.Assert(mock.errors[0], Matches, "could not add aggregate: invalid handler: .*")
+
+	mock = &MockT{}
+	NewPropertyTest(mock, &Invite{}).Run()
+	c.Assert(mock.errors, DeepEquals, []string{"no command generators"})
+}
+
+func (s *PropertyTestSuite) Test_WithGocheck(c *C) {
+	NewPropertyTest(c, &Invite{}).
+		Commands(genCreate, genAccept, genDecline).
+		Invariant("not accepted and declined", notAcceptedAndDeclined).
+		Run()
+}
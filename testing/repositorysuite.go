@@ -0,0 +1,172 @@
+// Copyright (c) 2014 - Max Persson <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package testing
+
+import (
+	"errors"
+	"sort"
+	"sync"
+
+	"gopkg.in/check.v1"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+// RepositorySuite is a conformance test suite for Repository implementations.
+// The suite saves *ConformanceModel values.
+type RepositorySuite struct {
+	// NewRepository creates the repository of each test.
+	NewRepository func() eh.Repository
+
+	// Volume is the number of models of the large volume test, by default
+	// DefaultConformanceVolume.
+	Volume int
+
+	repository eh.Repository
+}
+
+// SetUpTest creates the repository.
+func (s *RepositorySuite) SetUpTest(c *check.C) {
+	s.repository = s.NewRepository()
+}
+
+// TearDownTest closes the repository.
+func (s *RepositorySuite) TearDownTest(c *check.C) {
+	closeValue(s.repository)
+}
+
+// findAll returns all models sorted by ID, as FindAll has no defined order.
+func (s *RepositorySuite) findAll(c *check.C) []*ConformanceModel {
+	all, err := s.repository.FindAll()
+	c.Assert(err, check.IsNil)
+	models := make([]*ConformanceModel, len(all))
+	for i, model := range all {
+		m, ok := model.(*ConformanceModel)
+		c.Assert(ok, check.Equals, true, check.Commentf("model: %#v", model))
+		models[i] = m
+	}
+	sort.Slice(models, func(i, j int) bool { return models[i].ID < models[j].ID })
+	return models
+}
+
+func (s *RepositorySuite) Test_Find_NotFound(c *check.C) {
+	model, err := s.repository.Find(eh.NewUUID())
+	c.Assert(errors.Is(err, eh.ErrModelNotFound), check.Equals, true, check.Commentf("error: %v", err))
+	c.Assert(model, check.IsNil)
+}
+
+func (s *RepositorySuite) Test_Save_Find(c *check.C) {
+	model1 := &ConformanceModel{eh.NewUUID(), 1}
+	model2 := &ConformanceModel{eh.NewUUID(), 2}
+	s.repository.Save(model1.ID, model1)
+	s.repository.Save(model2.ID, model2)
+
+	model, err := s.repository.Find(model1.ID)
+	c.Assert(err, check.IsNil)
+	c.Assert(model, check.DeepEquals, model1)
+	model, err = s.repository.Find(model2.ID)
+	c.Assert(err, check.IsNil)
+	c.Assert(model, check.DeepEquals, model2)
+}
+
+func (s *RepositorySuite) Test_Save_Overwrite(c *check.C) {
+	id := eh.NewUUID()
+	s.repository.Save(id, &ConformanceModel{id, 1})
+	s.repository.Save(id, &ConformanceModel{id, 2})
+
+	model, err := s.repository.Find(id)
+	c.Assert(err, check.IsNil)
+	c.Assert(model, check.DeepEquals, &ConformanceModel{id, 2})
+	c.Assert(s.findAll(c), check.HasLen, 1)
+}
+
+func (s *RepositorySuite) Test_FindAll(c *check.C) {
+	c.Assert(s.findAll(c), check.HasLen, 0)
+
+	expected := []*ConformanceModel{}
+	for i := 0; i < 3; i++ {
+		model := &ConformanceModel{eh.NewUUID(), i}
+		s.repository.Save(model.ID, model)
+		expected = append(expected, model)
+	}
+	sort.Slice(expected, func(i, j int) bool { return expected[i].ID < expected[j].ID })
+	c.Assert(s.findAll(c), check.DeepEquals, expected)
+}
+
+func (s *RepositorySuite) Test_Remove(c *check.C) {
+	model1 := &ConformanceModel{eh.NewUUID(), 1}
+	model2 := &ConformanceModel{eh.NewUUID(), 2}
+	s.repository.Save(model1.ID, model1)
+	s.repository.Save(model2.ID, model2)
+
+	c.Assert(s.repository.Remove(model1.ID), check.IsNil)
+	_, err := s.repository.Find(model1.ID)
+	c.Assert(errors.Is(err, eh.ErrModelNotFound), check.Equals, true, check.Commentf("error: %v", err))
+	c.Assert(s.findAll(c), check.DeepEquals, []*ConformanceModel{model2})
+}
+
+func (s *RepositorySuite) Test_Remove_NotFound(c *check.C) {
+	err := s.repository.Remove(eh.NewUUID())
+	c.Assert(errors.Is(err, eh.ErrModelNotFound), check.Equals, true, check.Commentf("error: %v", err))
+}
+
+func (s *RepositorySuite) Test_Concurrent(c *check.C) {
+	ids := make([]eh.UUID, conformanceWorkers)
+	errs := make(chan error, conformanceWorkers*conformancePerWorker)
+	var wg sync.WaitGroup
+	for w := range ids {
+		ids[w] = eh.NewUUID()
+		wg.Add(1)
+		go func(id eh.UUID) {
+			defer wg.Done()
+			for i := 0; i < conformancePerWorker; i++ {
+				s.repository.Save(id, &ConformanceModel{id, i})
+				model, err := s.repository.Find(id)
+				if err == nil && model.(*ConformanceModel).Number != i {
+					err = errors.New("model was not saved")
+				}
+				errs <- err
+				s.repository.FindAll()
+			}
+		}(ids[w])
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		c.Assert(err, check.IsNil)
+	}
+
+	for _, id := range ids {
+		model, err := s.repository.Find(id)
+		c.Assert(err, check.IsNil)
+		c.Assert(model, check.DeepEquals, &ConformanceModel{id, conformancePerWorker - 1})
+	}
+}
+
+func (s *RepositorySuite) Test_LargeVolume(c *check.C) {
+	n := volume(s.Volume)
+	ids := make([]eh.UUID, n)
+	for i := range ids {
+		ids[i] = eh.NewUUID()
+		s.repository.Save(ids[i], &ConformanceModel{ids[i], i})
+	}
+
+	for i, id := range ids {
+		model, err := s.repository.Find(id)
+		c.Assert(err, check.IsNil)
+		c.Assert(model.(*ConformanceModel).Number, check.Equals, i)
+	}
+	c.Assert(s.findAll(c), check.HasLen, n)
+}
@@ -0,0 +1,316 @@
+// Copyright (c) 2014 - Max Persson <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventhorizon
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Error returned when a trace parent can not be parsed.
+var ErrInvalidTraceParent = errors.New("invalid trace parent")
+
+// Names of the spans created by dispatchers and buses.
+const (
+	SpanCommand       = "eventhorizon.command"
+	SpanLoad          = "eventhorizon.load"
+	SpanApply         = "eventhorizon.apply"
+	SpanHandleCommand = "eventhorizon.handle_command"
+	SpanAppend        = "eventhorizon.append"
+	SpanPublish       = "eventhorizon.publish"
+	SpanHandleEvent   = "eventhorizon.handle_event"
+)
+
+// Attributes of the load span with the time spent reading events from the
+// event store and applying them to the aggregate. Reading and applying are
+// interleaved, so the times are added up over all events. The apply span is a
+// child of the load span with the apply duration as well.
+const (
+	AttributeReadDuration  = "read_duration"
+	AttributeApplyDuration = "apply_duration"
+)
+
+// MetadataTraceParent is the metadata key of the trace context, formatted as
+// a W3C traceparent header.
+const MetadataTraceParent = "traceparent"
+
+// Metadata is additional data carried by events and commands, such as the
+// trace context or the tenant. It is added to an event or command by embedding it:
+//
+//	type InviteCreated struct {
+//	    eventhorizon.Metadata
+//
+//	    InvitationID eventhorizon.UUID
+//	}
+//
+// The dispatchers add the trace context and tenant of the command, and a
+// timestamp, to the metadata of the resulting events, so that asynchronous
+// handlers can continue the trace.
+type Metadata map[string]string
+
+var metadataType = reflect.TypeOf(Metadata(nil))
+
+// MetadataOf returns the metadata embedded in an event or command, or nil.
+func MetadataOf(value interface{}) Metadata {
+	v := reflect.ValueOf(value)
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	if index, ok := metadataIndex(v.Type()); ok {
+		return v.Field(index).Interface().(Metadata)
+	}
+	return nil
+}
+
+// WithMetadata returns the event with a metadata value set. Events passed by
+// value are copied, events passed by pointer are changed in place. Events
+// without embedded metadata are returned unchanged.
+func WithMetadata(event Event, key, value string) Event {
+	v := reflect.ValueOf(event)
+	isPtr := v.Kind() == reflect.Ptr
+	if isPtr {
+		v = v.Elem()
+	}
+	index, ok := metadataIndex(v.Type())
+	if !ok {
+		return event
+	}
+
+	if !isPtr {
+		copy := reflect.New(v.Type()).Elem()
+		copy.Set(v)
+		v = copy
+	}
+
+	// Never change the map of the original event.
+	metadata := make(Metadata)
+	for k, v := range v.Field(index).Interface().(Metadata) {
+		metadata[k] = v
+	}
+	metadata[key] = value
+	v.Field(index).Set(reflect.ValueOf(metadata))
+
+	if isPtr {
+		return event
+	}
+	return v.Interface().(Event)
+}
+
+// withTraceContext adds the span context to the metadata of the events.
+func withTraceContext(events []Event, sc SpanContext) []Event {
+	if !sc.IsValid() {
+		return events
+	}
+
+	traceParent := sc.TraceParent()
+	for i, event := range events {
+		events[i] = WithMetadata(event, MetadataTraceParent, traceParent)
+	}
+	return events
+}
+
+// endSpan records the error, if any, and ends the span.
+func endSpan(span Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+	}
+	span.End()
+}
+
+func metadataIndex(t reflect.Type) (int, bool) {
+	if t.Kind() != reflect.Struct {
+		return 0, false
+	}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Anonymous && field.Type == metadataType {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// SpanContext identifies a span in a trace, compatible with OpenTelemetry and
+// W3C Trace Context.
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+}
+
+// IsValid returns true if the span context has a trace and span ID.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != [16]byte{} && sc.SpanID != [8]byte{}
+}
+
+// TraceParent formats the span context as a W3C traceparent header.
+func (sc SpanContext) TraceParent() string {
+	return fmt.Sprintf("00-%x-%x-01", sc.TraceID[:], sc.SpanID[:])
+}
+
+// ParseTraceParent parses a W3C traceparent header.
+func ParseTraceParent(s string) (SpanContext, error) {
+	var sc SpanContext
+	parts := strings.Split(s, "-")
+	if len(parts) != 4 || len(parts[0]) != 2 ||
+		len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return sc, ErrInvalidTraceParent
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return sc, ErrInvalidTraceParent
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return sc, ErrInvalidTraceParent
+	}
+	if !sc.IsValid() {
+		return sc, ErrInvalidTraceParent
+	}
+	return sc, nil
+}
+
+// SpanContextOf returns the span context in the metadata of an event or
+// command. Returns an invalid span context if there is none.
+func SpanContextOf(value interface{}) SpanContext {
+	sc, _ := ParseTraceParent(MetadataOf(value)[MetadataTraceParent])
+	return sc
+}
+
+// Tracer starts spans. It can be implemented by an adapter to OpenTelemetry.
+type Tracer interface {
+	// Start starts a span as a child of parent. An invalid parent starts a
+	// new trace.
+	Start(parent SpanContext, name string) Span
+}
+
+// Span is a timed operation in a trace.
+type Span interface {
+	// SpanContext returns the span context of the span.
+	SpanContext() SpanContext
+
+	// SetAttribute sets an attribute of the span.
+	SetAttribute(key, value string)
+
+	// RecordError records an error of the operation.
+	RecordError(err error)
+
+	// End ends the span.
+	End()
+}
+
+// nopTracer is used when no tracer has been set.
+type nopTracer struct{}
+
+func (nopTracer) Start(parent SpanContext, name string) Span { return nopSpan{parent} }
+
+type nopSpan struct {
+	sc SpanContext
+}
+
+func (s nopSpan) SpanContext() SpanContext     { return s.sc }
+func (nopSpan) SetAttribute(key, value string) {}
+func (nopSpan) RecordError(err error)          {}
+func (nopSpan) End()                           {}
+
+// MemoryTracer implements Tracer by keeping ended spans in memory.
+type MemoryTracer struct {
+	mu    sync.Mutex
+	spans []*MemorySpan
+}
+
+// MemorySpan is a span created by a MemoryTracer.
+type MemorySpan struct {
+	Name       string
+	Context    SpanContext
+	Parent     SpanContext
+	Attributes map[string]string
+	Err        error
+	StartTime  time.Time
+	EndTime    time.Time
+
+	tracer *MemoryTracer
+}
+
+// NewMemoryTracer creates a new MemoryTracer.
+func NewMemoryTracer() *MemoryTracer {
+	t := &MemoryTracer{
+		spans: make([]*MemorySpan, 0),
+	}
+	return t
+}
+
+// Start starts a span as a child of parent.
+func (t *MemoryTracer) Start(parent SpanContext, name string) Span {
+	sc := SpanContext{TraceID: parent.TraceID}
+	if !parent.IsValid() {
+		rand.Read(sc.TraceID[:])
+	}
+	rand.Read(sc.SpanID[:])
+
+	return &MemorySpan{
+		Name:       name,
+		Context:    sc,
+		Parent:     parent,
+		Attributes: make(map[string]string),
+		StartTime:  time.Now(),
+		tracer:     t,
+	}
+}
+
+// GetSpans returns the ended spans in the order they ended.
+func (t *MemoryTracer) GetSpans() []*MemorySpan {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	spans := make([]*MemorySpan, len(t.spans))
+	copy(spans, t.spans)
+	return spans
+}
+
+// Reset removes all ended spans.
+func (t *MemoryTracer) Reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.spans = make([]*MemorySpan, 0)
+}
+
+// SpanContext returns the span context of the span.
+func (s *MemorySpan) SpanContext() SpanContext {
+	return s.Context
+}
+
+// SetAttribute sets an attribute of the span.
+func (s *MemorySpan) SetAttribute(key, value string) {
+	s.Attributes[key] = value
+}
+
+// RecordError records an error of the operation.
+func (s *MemorySpan) RecordError(err error) {
+	s.Err = err
+}
+
+// End ends the span and exports it to the tracer.
+func (s *MemorySpan) End() {
+	s.EndTime = time.Now()
+	s.tracer.mu.Lock()
+	defer s.tracer.mu.Unlock()
+	s.tracer.spans = append(s.tracer.spans, s)
+}
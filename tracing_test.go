@@ -0,0 +1,215 @@
+// Copyright (c) 2014 - Max Persson <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventhorizon
+
+import (
+	"errors"
+
+	. "gopkg.in/check.v1"
+)
+
+var _ = Suite(&TracingSuite{})
+
+type TracingSuite struct {
+	tracer *MemoryTracer
+}
+
+func (s *TracingSuite) SetUpTest(c *C) {
+	s.tracer = NewMemoryTracer()
+}
+
+type TestTracedEvent struct {
+	Metadata
+
+	TestID  UUID
+	Content string
+}
+
+func (t TestTracedEvent) AggregateID() UUID { return t.TestID }
+
+type TestTracedCommand struct {
+	Metadata
+
+	TestID  UUID
+	Content string
+}
+
+func (t TestTracedCommand) AggregateID() UUID { return t.TestID }
+
+type TestTracedAggregate struct {
+	Aggregate
+}
+
+func (t *TestTracedAggregate) HandleCommand(command Command) ([]Event, error) {
+	switch command := command.(type) {
+	case TestTracedCommand:
+		if command.Content == "error" {
+			return nil, errors.New("command error")
+		}
+		return []Event{TestTracedEvent{TestID: command.TestID, Content: command.Content}}, nil
+	}
+	return nil, errors.New("couldn't handle command")
+}
+
+func (t *TestTracedAggregate) HandleEvent(event Event) {}
+
+func (t *TestTracedAggregate) HandleTestTracedCommand(command TestTracedCommand) ([]Event, error) {
+	return t.HandleCommand(command)
+}
+
+func (s *TracingSuite) Test_TraceParent(c *C) {
+	span := s.tracer.Start(SpanContext{}, "test")
+	sc := span.SpanContext()
+	c.Assert(sc.IsValid(), Equals, true)
+	parsed, err := ParseTraceParent(sc.TraceParent())
+	c.Assert(err, Equals, nil)
+	c.Assert(parsed, Equals, sc)
+
+	parsed, err = ParseTraceParent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	c.Assert(err, Equals, nil)
+	c.Assert(parsed.TraceParent(), Equals, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+	_, err = ParseTraceParent("00-00000000000000000000000000000000-00f067aa0ba902b7-01")
+	c.Assert(err, Equals, ErrInvalidTraceParent)
+	_, err = ParseTraceParent("invalid")
+	c.Assert(err, Equals, ErrInvalidTraceParent)
+}
+
+func (s *TracingSuite) Test_MemoryTracer(c *C) {
+	root := s.tracer.Start(SpanContext{}, "root")
+	child := s.tracer.Start(root.SpanContext(), "child")
+	child.SetAttribute("key", "value")
+	child.RecordError(errors.New("error"))
+	child.End()
+	root.End()
+
+	spans := s.tracer.GetSpans()
+	c.Assert(len(spans), Equals, 2)
+	c.Assert(spans[0].Name, Equals, "child")
+	c.Assert(spans[0].Parent, Equals, root.SpanContext())
+	c.Assert(spans[0].Context.TraceID, Equals, root.SpanContext().TraceID)
+	c.Assert(spans[0].Attributes, DeepEquals, map[string]string{"key": "value"})
+	c.Assert(spans[0].Err, ErrorMatches, "error")
+	c.Assert(spans[1].Parent.IsValid(), Equals, false)
+
+	s.tracer.Reset()
+	c.Assert(len(s.tracer.GetSpans()), Equals, 0)
+}
+
+func (s *TracingSuite) Test_WithMetadata(c *C) {
+	event1 := TestTracedEvent{TestID: NewUUID(), Content: "event1"}
+	event2 := WithMetadata(event1, "key", "value")
+	c.Assert(event1.Metadata, IsNil)
+	c.Assert(MetadataOf(event2), DeepEquals, Metadata{"key": "value"})
+	event3 := WithMetadata(event2, "key2", "value2")
+	c.Assert(MetadataOf(event2), DeepEquals, Metadata{"key": "value"})
+	c.Assert(MetadataOf(event3), DeepEquals, Metadata{"key": "value", "key2": "value2"})
+
+	event4 := &TestEventOther2{NewUUID()}
+	c.Assert(WithMetadata(event4, "key", "value"), Equals, event4)
+	c.Assert(MetadataOf(event4), IsNil)
+}
+
+func (s *TracingSuite) Test_DelegateDispatcher(c *C) {
+	store := &MockEventStore{}
+	disp := NewDelegateDispatcher(store, &MockEventBus{})
+	disp.SetTracer(s.tracer)
+	disp.AddHandler(&TestTracedAggregate{}, TestTracedCommand{})
+	err := disp.Dispatch(TestTracedCommand{TestID: NewUUID(), Content: "command1"})
+	c.Assert(err, Equals, nil)
+	s.checkCommandSpans(c, store)
+}
+
+func (s *TracingSuite) Test_ReflectDispatcher(c *C) {
+	store := &MockEventStore{}
+	disp := NewReflectDispatcher(store, &MockEventBus{})
+	disp.SetTracer(s.tracer)
+	disp.AddHandler(&TestTracedAggregate{}, TestTracedCommand{})
+	err := disp.Dispatch(TestTracedCommand{TestID: NewUUID(), Content: "command1"})
+	c.Assert(err, Equals, nil)
+	s.checkCommandSpans(c, store)
+}
+
+func (s *TracingSuite) checkCommandSpans(c *C, store *MockEventStore) {
+	spans := s.tracer.GetSpans()
+	c.Assert(len(spans), Equals, 6)
+	names := []string{SpanApply, SpanLoad, SpanHandleCommand, SpanAppend, SpanPublish, SpanCommand}
+	root := spans[5]
+	for i, span := range spans {
+		c.Assert(span.Name, Equals, names[i])
+		if i > 0 && i < 5 {
+			c.Assert(span.Parent, Equals, root.Context)
+		}
+	}
+	// The apply span is a child of the load span.
+	c.Assert(spans[0].Parent, Equals, spans[1].Context)
+	c.Assert(spans[0].Attributes[AttributeApplyDuration], Not(Equals), "")
+	c.Assert(spans[1].Attributes[AttributeReadDuration], Not(Equals), "")
+	c.Assert(spans[1].Attributes[AttributeApplyDuration], Equals, spans[0].Attributes[AttributeApplyDuration])
+	c.Assert(root.Attributes[LogKeyCommandType], Equals, "eventhorizon.TestTracedCommand")
+
+	// The stored event continues the trace of the command.
+	c.Assert(len(store.events), Equals, 1)
+	c.Assert(SpanContextOf(store.events[0]), Equals, root.Context)
+}
+
+func (s *TracingSuite) Test_Dispatch_ContinueTrace(c *C) {
+	disp := NewDelegateDispatcher(&MockEventStore{}, &MockEventBus{})
+	disp.SetTracer(s.tracer)
+	disp.AddHandler(&TestTracedAggregate{}, TestTracedCommand{})
+	parent := s.tracer.Start(SpanContext{}, "request")
+	command := TestTracedCommand{
+		Metadata: Metadata{MetadataTraceParent: parent.SpanContext().TraceParent()},
+		TestID:   NewUUID(),
+		Content:  "error",
+	}
+	err := disp.Dispatch(command)
+	c.Assert(err, ErrorMatches, "command error")
+
+	spans := s.tracer.GetSpans()
+	c.Assert(len(spans), Equals, 4)
+	c.Assert(spans[2].Name, Equals, SpanHandleCommand)
+	c.Assert(spans[2].Err, ErrorMatches, "command error")
+	c.Assert(spans[3].Name, Equals, SpanCommand)
+	c.Assert(spans[3].Parent, Equals, parent.SpanContext())
+	c.Assert(spans[3].Err, ErrorMatches, "command error")
+}
+
+func (s *TracingSuite) Test_HandlerEventBus(c *C) {
+	bus := NewHandlerEventBus()
+	bus.SetTracer(s.tracer)
+	handler := &MockEventHandler{}
+	bus.AddSubscriber(handler, TestTracedEvent{})
+	bus.AddGlobalSubscriber(&MockEventHandler{})
+	parent := s.tracer.Start(SpanContext{}, "command")
+	event := WithMetadata(TestTracedEvent{TestID: NewUUID()},
+		MetadataTraceParent, parent.SpanContext().TraceParent())
+	bus.PublishEvent(event)
+
+	c.Assert(handler.events, DeepEquals, []Event{event})
+	spans := s.tracer.GetSpans()
+	c.Assert(len(spans), Equals, 2)
+	for _, span := range spans {
+		c.Assert(span.Name, Equals, SpanHandleEvent)
+		c.Assert(span.Parent, Equals, parent.SpanContext())
+		c.Assert(span.Attributes[LogKeyEventType], Equals, "eventhorizon.TestTracedEvent")
+		c.Assert(span.Attributes[LogKeyHandlerType], Equals, "*eventhorizon.MockEventHandler")
+	}
+}
+
+func (s *TracingSuite) Test_CheckCommand_Metadata(c *C) {
+	err := checkCommand(TestTracedCommand{TestID: NewUUID(), Content: "command1"})
+	c.Assert(err, Equals, nil)
+}